@@ -0,0 +1,51 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"forum/pkg/accounts"
+	"forum/pkg/models"
+)
+
+func purgeAccounts(db *sql.DB, args []string) error {
+	// Deleting accounts can't be undone, so the default follows the server's
+	// ACCOUNT_DELETION_DELAY rather than only the built in one
+	defaultDelay := accounts.DefaultDelay
+	if value := os.Getenv("ACCOUNT_DELETION_DELAY"); value != "" {
+		delay, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid ACCOUNT_DELETION_DELAY %q", value)
+		}
+		defaultDelay = delay
+	}
+
+	fs := flag.NewFlagSet("purge-accounts", flag.ExitOnError)
+	delay := fs.Duration("delay", defaultDelay, "how long after the user asked an account is deleted")
+	dryRun := fs.Bool("dry-run", false, "list the accounts that would be deleted without deleting them")
+	fs.Parse(args)
+
+	policy, err := models.GetDeletedContentPolicy(db)
+	if err != nil {
+		return err
+	}
+
+	purged, err := accounts.Purge(db, *delay, *dryRun)
+
+	verb := "deleted"
+	if *dryRun {
+		verb = "would delete"
+	}
+	for _, user := range purged {
+		fmt.Printf("%s %s <%s>, asked %s\n", verb, user.Name, user.Email, user.DeletionRequestedAt.Time.Format("2006-01-02 15:04"))
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s %d account(s), content: %s\n", verb, len(purged), policy)
+	return nil
+}
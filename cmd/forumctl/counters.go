@@ -0,0 +1,27 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+
+	"forum/pkg/models"
+)
+
+func repairCounters(db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("repair-counters", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "report the counters that are off without fixing them")
+	fs.Parse(args)
+
+	repaired, err := models.RepairCounters(db, *dryRun)
+	if err != nil {
+		return err
+	}
+
+	verb := "repaired"
+	if *dryRun {
+		verb = "would repair"
+	}
+	fmt.Printf("%s the counters of %d post(s) and %d comment(s)\n", verb, repaired["posts"], repaired["comments"])
+	return nil
+}
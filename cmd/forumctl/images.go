@@ -0,0 +1,61 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+
+	"forum/pkg/models"
+	"forum/pkg/uploads"
+)
+
+// backfillImages makes resized variants for post images uploaded before
+// variants existed, including those still in ui/static/img/uploads/post.
+func backfillImages(db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("backfill-images", flag.ExitOnError)
+	storeFlags := addStoreFlags(fs, "store", "upload-dir")
+	fs.Parse(args)
+
+	store, err := storeFlags.open()
+	if err != nil {
+		return err
+	}
+
+	urls, err := models.GetImagesWithoutVariants(db)
+	if err != nil {
+		return err
+	}
+
+	done, skipped := 0, 0
+	for _, url := range urls {
+		data, ok, err := uploads.Read(store, url)
+		if !ok {
+			fmt.Printf("skipping %s: not an uploaded image\n", url)
+			skipped++
+			continue
+		}
+		if err != nil {
+			fmt.Printf("skipping %s: %v\n", url, err)
+			skipped++
+			continue
+		}
+
+		variants, err := uploads.SaveVariants(db, store, url, data)
+		if err != nil {
+			fmt.Printf("skipping %s: %v\n", url, err)
+			skipped++
+			continue
+		}
+		if len(variants) == 0 {
+			fmt.Printf("skipping %s: animated images have no variants\n", url)
+			skipped++
+			continue
+		}
+
+		fmt.Printf("%s: %d variants\n", url, len(variants))
+		done++
+	}
+
+	fmt.Printf("made variants for %d image(s), skipped %d\n", done, skipped)
+	return nil
+}
@@ -0,0 +1,139 @@
+// Command forumctl runs maintenance tasks against the forum database. Run it
+// from the repository root, like the web server, so the database path resolves.
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+
+	"forum/logger"
+	"forum/pkg/models"
+	"forum/pkg/models/sqlite"
+	"forum/pkg/uploads"
+	"forum/utils"
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(db *sql.DB, args []string) error
+}
+
+var commands = []command{
+	{"role", "role <email> <user|moderator|admin>    set the role of a user", setRole},
+	{"quota", "quota <email> <size|default|unlimited>    set the attachment storage quota of a user, e.g. 500MB", setQuota},
+	{"backfill-images", "backfill-images [-store fs|s3] [-upload-dir dir]    make resized variants for post images that have none", backfillImages},
+	{"migrate-uploads", "migrate-uploads -from fs|s3 -to fs|s3 [-from-dir dir] [-to-dir dir] [-dry-run]    copy uploads between stores", migrateUploads},
+	{"gc-uploads", "gc-uploads [-store fs|s3] [-upload-dir dir] [-grace 24h] [-dry-run]    delete uploads no post uses", gcUploads},
+	{"purge-accounts", "purge-accounts [-delay 336h] [-dry-run]    delete accounts whose deletion was asked for at least delay ago", purgeAccounts},
+	{"repair-counters", "repair-counters [-dry-run]    recount the like, dislike and comment counters of posts and comments", repairCounters},
+}
+
+func main() {
+	logger.InitLogger()
+	loadConfig()
+
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	for _, cmd := range commands {
+		if cmd.name != os.Args[1] {
+			continue
+		}
+
+		db, err := sqlite.ConnectDB()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error connecting to the database: %v\n", err)
+			os.Exit(1)
+		}
+		defer db.Close()
+
+		if err := cmd.run(db, os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", cmd.name, err)
+			db.Close()
+			os.Exit(1)
+		}
+		return
+	}
+
+	usage()
+	os.Exit(2)
+}
+
+// loadConfig reads the same config file as the web server, if there is one,
+// so that commands see its UPLOAD_STORE and S3 settings.
+func loadConfig() {
+	file, err := os.Open("configs/config/" + utils.GetEnvironment() + ".env")
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	if err := utils.SetEnvironmentVariables(file); err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading config file: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: forumctl <command> [arguments]")
+	fmt.Fprintln(os.Stderr)
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %s\n", cmd.usage)
+	}
+}
+
+func setRole(db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("role", flag.ExitOnError)
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		return fmt.Errorf("expected an email and a role")
+	}
+
+	email, role := fs.Arg(0), fs.Arg(1)
+	if err := models.SetUserRole(db, email, role); err != nil {
+		return err
+	}
+
+	fmt.Printf("%s is now %s\n", email, role)
+	return nil
+}
+
+func setQuota(db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("quota", flag.ExitOnError)
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		return fmt.Errorf("expected an email and a size")
+	}
+
+	email, value := fs.Arg(0), fs.Arg(1)
+	var quota int64
+	switch value {
+	case "default":
+		quota = models.QuotaDefault
+	case "unlimited":
+		quota = models.QuotaUnlimited
+	default:
+		size, err := uploads.ParseSize(value)
+		if err != nil {
+			return err
+		}
+		if size == 0 {
+			return fmt.Errorf("a quota of 0 would be the default, use \"default\"")
+		}
+		quota = size
+	}
+
+	if err := models.SetUploadQuota(db, email, quota); err != nil {
+		return err
+	}
+
+	fmt.Printf("%s now has a %s quota\n", email, value)
+	return nil
+}
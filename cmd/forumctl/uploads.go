@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"forum/pkg/blobstore"
+	"forum/pkg/uploads"
+)
+
+// storeFlags selects a blob store on the command line. The store kind
+// defaults to UPLOAD_STORE and the directory of the fs store to UPLOAD_DIR,
+// as in the web server.
+type storeFlags struct {
+	kind *string
+	dir  *string
+}
+
+func addStoreFlags(fs *flag.FlagSet, name, dirName string) storeFlags {
+	return storeFlags{
+		kind: fs.String(name, os.Getenv("UPLOAD_STORE"), "upload store, fs or s3"),
+		dir:  fs.String(dirName, os.Getenv("UPLOAD_DIR"), "directory uploads are stored in by the fs store"),
+	}
+}
+
+func (f storeFlags) open() (blobstore.BlobStore, error) {
+	if (*f.kind == "" || *f.kind == blobstore.KindFS) && *f.dir != "" {
+		return blobstore.NewFS(*f.dir), nil
+	}
+	return blobstore.Open(*f.kind)
+}
+
+// migrateUploads copies every upload from one store to another, e.g. from
+// local disk to S3 before switching UPLOAD_STORE. Blobs are named after their
+// content, so running it again only copies what is missing.
+func migrateUploads(db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("migrate-uploads", flag.ExitOnError)
+	from := addStoreFlags(fs, "from", "from-dir")
+	to := addStoreFlags(fs, "to", "to-dir")
+	dryRun := fs.Bool("dry-run", false, "list what would be copied without copying it")
+	fs.Parse(args)
+
+	if *from.kind == *to.kind && *from.dir == *to.dir {
+		return fmt.Errorf("-from and -to are the same store")
+	}
+
+	src, err := from.open()
+	if err != nil {
+		return fmt.Errorf("source: %v", err)
+	}
+	dst, err := to.open()
+	if err != nil {
+		return fmt.Errorf("destination: %v", err)
+	}
+
+	copied, existing := 0, 0
+	err = src.List(context.Background(), "", func(key string) error {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+
+		if _, err := dst.Stat(ctx, key); err == nil {
+			existing++
+			return nil
+		} else if !errors.Is(err, blobstore.ErrNotFound) {
+			return fmt.Errorf("%s: %v", key, err)
+		}
+
+		if *dryRun {
+			fmt.Printf("would copy %s\n", key)
+			copied++
+			return nil
+		}
+
+		r, info, err := src.Get(ctx, key)
+		if err != nil {
+			return fmt.Errorf("%s: %v", key, err)
+		}
+		data, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %v", key, err)
+		}
+
+		if err := dst.Put(ctx, key, data, info.ContentType); err != nil {
+			return fmt.Errorf("%s: %v", key, err)
+		}
+		fmt.Printf("copied %s\n", key)
+		copied++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if *dryRun {
+		fmt.Printf("would copy %d upload(s), %d already there\n", copied, existing)
+	} else {
+		fmt.Printf("copied %d upload(s), %d already there\n", copied, existing)
+	}
+	return nil
+}
+
+// gcUploads deletes uploads that no post has used for the grace period, as
+// the web server does on a schedule. With -dry-run it only reports them.
+func gcUploads(db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("gc-uploads", flag.ExitOnError)
+	storeFlags := addStoreFlags(fs, "store", "upload-dir")
+	grace := fs.Duration("grace", uploads.DefaultGrace, "how long an unused upload is kept")
+	dryRun := fs.Bool("dry-run", false, "report what would be deleted without deleting it")
+	fs.Parse(args)
+
+	store, err := storeFlags.open()
+	if err != nil {
+		return err
+	}
+
+	report, err := uploads.Collect(db, store, *grace, *dryRun)
+	if err != nil {
+		return err
+	}
+
+	for _, upload := range report.Untracked {
+		fmt.Printf("untracked %s (%d bytes, used by %d post(s))\n", upload.URL, upload.Size, upload.RefCount)
+	}
+
+	verb := "deleted"
+	if *dryRun {
+		verb = "would delete"
+	}
+	for _, upload := range report.Collected {
+		fmt.Printf("%s %s (%s, %d bytes, unused since %s)\n", verb, upload.URL, upload.MimeType, upload.Size,
+			upload.UnreferencedAt.Time.Format("2006-01-02 15:04"))
+	}
+
+	if *dryRun {
+		fmt.Printf("would start tracking %d upload(s) and delete %d (%d bytes)\n", len(report.Untracked), len(report.Collected), report.Bytes)
+	} else {
+		fmt.Printf("started tracking %d upload(s), deleted %d (%d bytes)\n", len(report.Untracked), len(report.Collected), report.Bytes)
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"forum/logger"
+	"forum/pkg/accounts"
+	"forum/pkg/models"
+)
+
+// accountPurgeInterval is how often accounts past their cooling-off period are
+// deleted.
+const accountPurgeInterval = time.Hour
+
+// loadAccountDeletionDelay reads ACCOUNT_DELETION_DELAY, the cooling-off
+// period before a deleted account is gone for good (default 336h, 14 days).
+func loadAccountDeletionDelay() (time.Duration, error) {
+	value := os.Getenv("ACCOUNT_DELETION_DELAY")
+	if value == "" {
+		return accounts.DefaultDelay, nil
+	}
+
+	delay, err := time.ParseDuration(value)
+	if value == "0" {
+		delay, err = 0, nil
+	}
+	if err != nil || delay < 0 {
+		return 0, fmt.Errorf("invalid ACCOUNT_DELETION_DELAY %q", value)
+	}
+	return delay, nil
+}
+
+// purgeAccounts deletes accounts whose cooling-off period is over every
+// accountPurgeInterval until the process exits.
+func (app *application) purgeAccounts() {
+	ticker := time.NewTicker(accountPurgeInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		purged, err := accounts.Purge(app.db, app.accountDeletionDelay, false)
+		for _, user := range purged {
+			logger.InfoLogger.Printf("Deleted account %s\n", user.ID)
+		}
+		if err != nil {
+			logger.ErrorLogger.Printf("Error deleting accounts: %v\n", err)
+		}
+	}
+}
+
+// deletionDate returns when a pending deletion will happen.
+func (app *application) deletionDate(user models.User) time.Time {
+	return user.DeletionRequestedAt.Time.Add(app.accountDeletionDelay)
+}
+
+func (app *application) renderAccountSettings(w http.ResponseWriter, r *http.Request, formErrors map[string]string) {
+	loggedInUser, isLoggedIn := app.GetUserFromSession(r)
+
+	policy, err := models.GetDeletedContentPolicy(app.db)
+	if err != nil {
+		logger.ErrorLogger.Printf("Error getting deleted content policy: %v\n", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	data := &templateData{
+		IsLoggedIn:     isLoggedIn,
+		LoggedInUser:   loggedInUser,
+		FormErrors:     formErrors,
+		DeletedContent: policy,
+		DeletionDelay:  app.accountDeletionDelay,
+	}
+	if loggedInUser.DeletionRequestedAt.Valid {
+		data.DeletionDate = app.deletionDate(loggedInUser)
+	}
+
+	status := http.StatusOK
+	if len(formErrors) > 0 {
+		status = http.StatusUnprocessableEntity
+	}
+
+	if err := app.renderTemplateWithStatus(w, r, status, "usersettings.account.page.html", data); err != nil {
+		logger.ErrorLogger.Printf("Error rendering template: %v\n", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// accountSettings lets users download their data and delete their account.
+func (app *application) accountSettings(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/user/settings/account" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	app.renderAccountSettings(w, r, nil)
+}
+
+// exportAccount sends the user a zip of their data.
+func (app *application) exportAccount(w http.ResponseWriter, r *http.Request) {
+	loggedInUser, _ := app.GetUserFromSession(r)
+
+	if r.URL.Path != "/user/settings/account/export" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	fileName := fmt.Sprintf("forum-data-%s.zip", time.Now().Format("2006-01-02"))
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+fileName+`"`)
+	w.Header().Set("Cache-Control", "no-store")
+
+	// Once the zip has started the status can't change, so a failure part way
+	// leaves the download truncated, which the user's zip tool will report
+	if err := accounts.Export(w, app.db, app.blobs, loggedInUser.ID); err != nil {
+		logger.ErrorLogger.Printf("Error exporting data of user %s: %v\n", loggedInUser.ID, err)
+		return
+	}
+
+	logger.InfoLogger.Printf("User %s exported their data\n", loggedInUser.ID)
+}
+
+// deleteAccount schedules the account for deletion after the cooling-off
+// period and signs the user out.
+func (app *application) deleteAccount(w http.ResponseWriter, r *http.Request) {
+	loggedInUser, _ := app.GetUserFromSession(r)
+
+	if r.URL.Path != "/user/settings/account/delete" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		logger.ErrorLogger.Printf("Error parsing a form: %s\n", err)
+		http.Error(w, "Unable to parse form", http.StatusBadRequest)
+		return
+	}
+
+	if r.PostForm.Get("confirm") != loggedInUser.Name {
+		app.renderAccountSettings(w, r, map[string]string{"confirm": "Type your username to confirm"})
+		return
+	}
+
+	if err := models.RequestAccountDeletion(app.db, loggedInUser.ID); err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	logger.InfoLogger.Printf("User %s asked for their account to be deleted\n", loggedInUser.ID)
+
+	// The sessions are already gone from the database, so only the cookie is
+	// left to clear
+	http.SetCookie(w, &http.Cookie{
+		Name:     "session",
+		Value:    "",
+		MaxAge:   -1,
+		Path:     "/",
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	data := &templateData{DeletionDate: time.Now().Add(app.accountDeletionDelay)}
+	if err := app.renderTemplate(w, r, "account.deleted.page.html", data); err != nil {
+		logger.ErrorLogger.Printf("Error rendering template: %v\n", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+func (app *application) cancelAccountDeletion(w http.ResponseWriter, r *http.Request) {
+	loggedInUser, _ := app.GetUserFromSession(r)
+
+	if r.URL.Path != "/user/settings/account/cancel" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := models.CancelAccountDeletion(app.db, loggedInUser.ID); err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	logger.InfoLogger.Printf("User %s cancelled the deletion of their account\n", loggedInUser.ID)
+	http.Redirect(w, r, "/user/settings/account", http.StatusSeeOther)
+}
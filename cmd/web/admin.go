@@ -0,0 +1,137 @@
+package main
+
+import (
+	"net/http"
+
+	"forum/logger"
+	"forum/pkg/models"
+)
+
+// admin security settings, which roles must use two-factor authentication and
+// what happens to the content of deleted accounts
+func (app *application) adminSecurity(w http.ResponseWriter, r *http.Request) {
+	loggedInUser, isLoggedIn := app.GetUserFromSession(r)
+
+	if r.URL.Path != "/admin/security" {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		roles, err := models.GetRolesRequiring2FA(app.db)
+		if err != nil {
+			logger.ErrorLogger.Printf("Error getting 2FA settings: %v\n", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		staff, err := models.GetStaffUsers(app.db)
+		if err != nil {
+			logger.ErrorLogger.Printf("Error getting staff users: %v\n", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		policy, err := models.GetDeletedContentPolicy(app.db)
+		if err != nil {
+			logger.ErrorLogger.Printf("Error getting deleted content policy: %v\n", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		data := &templateData{
+			IsLoggedIn:     isLoggedIn,
+			LoggedInUser:   loggedInUser,
+			RequiredRoles:  roles,
+			Users:          staff,
+			DeletedContent: policy,
+			DeletionDelay:  app.accountDeletionDelay,
+		}
+
+		if err := app.renderTemplate(w, r, "admin.security.page.html", data); err != nil {
+			logger.ErrorLogger.Printf("Error rendering template: %v\n", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+
+	case http.MethodPost:
+		if err := r.ParseForm(); err != nil {
+			logger.ErrorLogger.Printf("Error parsing a form: %s\n", err)
+			http.Error(w, "Unable to parse form", http.StatusBadRequest)
+			return
+		}
+
+		if err := models.SetRolesRequiring2FA(app.db, r.PostForm["require_2fa"]); err != nil {
+			logger.ErrorLogger.Printf("Error saving 2FA settings: %v\n", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if policy := r.PostForm.Get("deleted_content"); policy != "" {
+			if err := models.SetDeletedContentPolicy(app.db, policy); err != nil {
+				logger.ErrorLogger.Printf("Error saving deleted content policy: %v\n", err)
+				http.Error(w, "Invalid deleted content policy", http.StatusBadRequest)
+				return
+			}
+		}
+
+		logger.InfoLogger.Printf("Admin %s set roles requiring 2FA to %v and deleted content to %s\n", loggedInUser.Name, r.PostForm["require_2fa"], r.PostForm.Get("deleted_content"))
+		http.Redirect(w, r, "/admin/security", http.StatusSeeOther)
+
+	default:
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// accounts locked after too many failed logins
+func (app *application) adminLockouts(w http.ResponseWriter, r *http.Request) {
+	loggedInUser, isLoggedIn := app.GetUserFromSession(r)
+
+	if r.URL.Path != "/admin/lockouts" {
+		http.NotFound(w, r)
+		return
+	}
+
+	accounts, err := models.GetLockedAccounts(app.db)
+	if err != nil {
+		logger.ErrorLogger.Printf("Error getting locked accounts: %v\n", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	data := &templateData{
+		IsLoggedIn:     isLoggedIn,
+		LoggedInUser:   loggedInUser,
+		LockedAccounts: accounts,
+	}
+
+	if err := app.renderTemplate(w, r, "admin.lockouts.page.html", data); err != nil {
+		logger.ErrorLogger.Printf("Error rendering template: %v\n", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+func (app *application) unlockAccount(w http.ResponseWriter, r *http.Request) {
+	loggedInUser, _ := app.GetUserFromSession(r)
+
+	if r.URL.Path != "/admin/lockouts/unlock" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	key := r.PostFormValue("key")
+	if err := models.ClearLoginFailures(app.db, models.ThrottleAccount, key); err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	logger.InfoLogger.Printf("Admin %s unlocked account %s\n", loggedInUser.Name, key)
+	http.Redirect(w, r, "/admin/lockouts", http.StatusSeeOther)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"forum/logger"
+	"forum/pkg/models"
+)
+
+// loadAnswer tells the post page whether the post is a question, and puts
+// its accepted answer first among the comments.
+func (app *application) loadAnswer(data *templateData) error {
+	question, err := models.IsQuestion(app.db, data.Post)
+	if err != nil {
+		return err
+	}
+	data.Question = question
+
+	for i, comment := range data.Comments {
+		if comment.ID != data.Post.AcceptedCommentID {
+			continue
+		}
+		comment.Accepted = true
+		copy(data.Comments[1:i+1], data.Comments[:i])
+		data.Comments[0] = comment
+		break
+	}
+
+	return nil
+}
+
+// acceptAnswer lets the author of a question, or a moderator, accept one of
+// its comments as the answer or, without comment_id, take the acceptance
+// back.
+func (app *application) acceptAnswer(w http.ResponseWriter, r *http.Request) {
+	loggedInUser, _ := app.GetUserFromSession(r)
+
+	if r.URL.Path != "/post/answer" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		logger.ErrorLogger.Printf("Error parsing a form: %s\n", err)
+		http.Error(w, "Unable to parse form", http.StatusBadRequest)
+		return
+	}
+
+	postID := r.PostForm.Get("post_id")
+	commentID := r.PostForm.Get("comment_id")
+
+	post, err := models.GetPostByID(app.db, postID)
+	if err != nil || post.Status != models.StatusPublished {
+		http.NotFound(w, r)
+		return
+	}
+
+	if post.UserID != loggedInUser.ID && !loggedInUser.IsStaff() {
+		http.Error(w, "Only the author of the question or a moderator can accept an answer", http.StatusForbidden)
+		return
+	}
+
+	if post.Archived() {
+		http.Error(w, "This thread is archived", http.StatusForbidden)
+		return
+	}
+
+	question, err := models.IsQuestion(app.db, post)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !question {
+		http.Error(w, "Only posts in Q&A categories have accepted answers", http.StatusBadRequest)
+		return
+	}
+
+	err = models.AcceptAnswer(app.db, post.ID, commentID)
+	if errors.Is(err, models.ErrNotAnswer) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if commentID == "" {
+		http.Redirect(w, r, "/post?id="+post.ID, http.StatusSeeOther)
+		return
+	}
+
+	if commentID != post.AcceptedCommentID {
+		app.notifyAnswerer(post, commentID, loggedInUser.ID)
+	}
+
+	http.Redirect(w, r, "/post?id="+post.ID+"#comment-"+commentID, http.StatusSeeOther)
+}
+
+// notifyAnswerer tells the author of a comment that it was accepted as the
+// answer to a question, unless they accepted it themselves.
+func (app *application) notifyAnswerer(post models.Post, commentID, acceptedBy string) {
+	comments, err := models.GetAllCommentsByPostID(app.db, post.ID)
+	if err != nil {
+		logger.ErrorLogger.Printf("Error getting comments of post %s: %v\n", post.ID, err)
+		return
+	}
+
+	for _, comment := range comments {
+		if comment.ID != commentID || comment.UserID == acceptedBy {
+			continue
+		}
+		notification := models.Notification{
+			UserID:  comment.UserID,
+			Kind:    models.NotificationAnswer,
+			Message: fmt.Sprintf("Your comment was accepted as the answer to %q.", post.Title),
+			Link:    "/post?id=" + post.ID + "#comment-" + comment.ID,
+		}
+		if _, err := models.CreateNotification(app.db, notification); err != nil {
+			logger.ErrorLogger.Printf("Error notifying user %s about answer: %v\n", comment.UserID, err)
+		}
+	}
+}
+
+// adminQA chooses the categories that run in Q&A mode.
+func (app *application) adminQA(w http.ResponseWriter, r *http.Request) {
+	loggedInUser, isLoggedIn := app.GetUserFromSession(r)
+
+	if r.URL.Path != "/admin/qa" {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		qa, err := models.GetQACategories(app.db)
+		if err != nil {
+			logger.ErrorLogger.Printf("Error getting Q&A categories: %v\n", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		data := &templateData{
+			IsLoggedIn:   isLoggedIn,
+			LoggedInUser: loggedInUser,
+			Categories:   postCategories,
+			QACategories: qa,
+		}
+
+		if err := app.renderTemplate(w, r, "admin.qa.page.html", data); err != nil {
+			logger.ErrorLogger.Printf("Error rendering template: %v\n", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+
+	case http.MethodPost:
+		if err := r.ParseForm(); err != nil {
+			logger.ErrorLogger.Printf("Error parsing a form: %s\n", err)
+			http.Error(w, "Unable to parse form", http.StatusBadRequest)
+			return
+		}
+
+		var qa []string
+		for _, category := range r.PostForm["qa"] {
+			if contains(postCategories, category) && !contains(qa, category) {
+				qa = append(qa, category)
+			}
+		}
+
+		if err := models.SetQACategories(app.db, qa); err != nil {
+			logger.ErrorLogger.Printf("Error saving Q&A categories: %v\n", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		logger.InfoLogger.Printf("Admin %s set Q&A categories to %v\n", loggedInUser.Name, qa)
+		http.Redirect(w, r, "/admin/qa", http.StatusSeeOther)
+
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+
+	"forum/logger"
+	"forum/pkg/images"
+	"forum/pkg/models"
+	"forum/pkg/uploads"
+
+	"github.com/google/uuid"
+)
+
+const (
+	maxAttachments  = 10
+	maxDocumentSize = 10 << 20
+
+	// defaultUploadQuota applies to users without a quota of their own when
+	// UPLOAD_QUOTA is not set.
+	defaultUploadQuota = 100 << 20
+)
+
+// loadUploadQuota reads UPLOAD_QUOTA, a size such as 250MB or "unlimited".
+func loadUploadQuota() (int64, error) {
+	value := os.Getenv("UPLOAD_QUOTA")
+	switch value {
+	case "":
+		return defaultUploadQuota, nil
+	case "unlimited":
+		return models.QuotaUnlimited, nil
+	}
+
+	quota, err := uploads.ParseSize(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid UPLOAD_QUOTA %q", value)
+	}
+	return quota, nil
+}
+
+// pendingAttachment is an uploaded file that has been checked, and for images
+// re-encoded, but not stored yet.
+type pendingAttachment struct {
+	models.Attachment
+	name string
+	data []byte
+}
+
+// readAttachments checks every file against the allowlist and size limits and
+// re-encodes images. It returns a message for the form if a file is refused.
+func readAttachments(files []*multipart.FileHeader) ([]pendingAttachment, string) {
+	var pending []pendingAttachment
+	for _, fh := range files {
+		a, message := readAttachment(fh)
+		if message != "" {
+			return nil, uploads.CleanFileName(fh.Filename, "") + ": " + message
+		}
+		pending = append(pending, a)
+	}
+	return pending, ""
+}
+
+func readAttachment(fh *multipart.FileHeader) (pendingAttachment, string) {
+	f, err := fh.Open()
+	if err != nil {
+		logger.ErrorLogger.Printf("Error opening attachment: %v\n", err)
+		return pendingAttachment{}, "Unable to read the file"
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, maxImageSize+1))
+	if err != nil {
+		logger.ErrorLogger.Printf("Error reading attachment: %v\n", err)
+		return pendingAttachment{}, "Unable to read the file"
+	}
+
+	// The client's Content-Type and file name are not trusted
+	if format := images.Sniff(data); format != "" {
+		if len(data) > maxImageSize {
+			return pendingAttachment{}, "Images must not exceed 20MB"
+		}
+
+		clean, format, err := images.Process(data, images.DefaultLimits)
+		switch {
+		case errors.Is(err, images.ErrTooLarge):
+			return pendingAttachment{}, "The image is too large, it must be under 40 megapixels"
+		case err != nil:
+			logger.ErrorLogger.Printf("Error processing image: %v\n", err)
+			return pendingAttachment{}, "The image could not be read"
+		}
+
+		extension := images.Extension(format)
+		return newPendingAttachment(models.AttachmentImage, fh.Filename, extension, clean), ""
+	}
+
+	extension, err := uploads.SniffDocument(data)
+	if err != nil {
+		return pendingAttachment{}, "Only JPEG, PNG and GIF images, PDF documents and plain text files are supported"
+	}
+	if len(data) > maxDocumentSize {
+		return pendingAttachment{}, "Documents must not exceed 10MB"
+	}
+
+	return newPendingAttachment(models.AttachmentDocument, fh.Filename, extension, data), ""
+}
+
+func newPendingAttachment(kind, fileName, extension string, data []byte) pendingAttachment {
+	name := uploads.Name(data, extension)
+	return pendingAttachment{
+		Attachment: models.Attachment{
+			ID:       uuid.New().String(),
+			Kind:     kind,
+			URL:      uploads.URL(name),
+			FileName: uploads.CleanFileName(fileName, extension),
+			MimeType: uploads.ContentType(name),
+			Size:     int64(len(data)),
+		},
+		name: name,
+		data: data,
+	}
+}
+
+// effectiveQuota returns the user's quota in bytes, or models.QuotaUnlimited.
+func (app *application) effectiveQuota(userID string) (int64, error) {
+	quota, err := models.GetUploadQuota(app.db, userID)
+	if err != nil {
+		return 0, err
+	}
+	if quota == models.QuotaDefault {
+		return app.uploadQuota, nil
+	}
+	return quota, nil
+}
+
+// checkQuota returns a message for the form if the attachments would take the
+// user over their quota. Files the user has attached before are not counted
+// again.
+func (app *application) checkQuota(userID string, pending []pendingAttachment) (string, error) {
+	quota, err := app.effectiveQuota(userID)
+	if err != nil || quota == models.QuotaUnlimited {
+		return "", err
+	}
+
+	used, stored, err := models.GetStorageUsage(app.db, userID)
+	if err != nil {
+		return "", err
+	}
+
+	total := used
+	for _, a := range pending {
+		if !stored[a.URL] {
+			stored[a.URL] = true
+			total += a.Size
+		}
+	}
+
+	if total > quota {
+		return fmt.Sprintf("These files need %s but only %s of your %s storage is left",
+			uploads.FormatSize(total-used), uploads.FormatSize(max64(quota-used, 0)), uploads.FormatSize(quota)), nil
+	}
+	return "", nil
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// storeAttachments stores the files, along with resized variants of images,
+// and returns the attachments to save with the post.
+func (app *application) storeAttachments(pending []pendingAttachment) ([]models.Attachment, error) {
+	var attachments []models.Attachment
+	for _, a := range pending {
+		// Tracking comes first so that a collection running now can't delete
+		// the files once they are stored
+		if err := uploads.Track(app.db, a.name, a.data); err != nil {
+			logger.ErrorLogger.Printf("Error tracking upload %v: %v\n", a.name, err)
+			return nil, err
+		}
+
+		if err := uploads.Put(app.blobs, a.name, a.data); err != nil {
+			logger.ErrorLogger.Printf("Error storing file: %v\n", err)
+			return nil, err
+		}
+
+		logger.InfoLogger.Printf("Successfully uploaded file %v\n", a.name)
+
+		// The original is enough to show the post, so a failure here is only logged
+		if a.IsImage() {
+			if _, err := uploads.SaveVariants(app.db, app.blobs, a.URL, a.data); err != nil {
+				logger.ErrorLogger.Printf("Error making image variants for %v: %v\n", a.name, err)
+			}
+		}
+
+		attachments = append(attachments, a.Attachment)
+	}
+	return attachments, nil
+}
+
+// attachmentFiles returns the files of the create form. "image" is the field
+// used before posts could have several attachments.
+func attachmentFiles(r *http.Request) []*multipart.FileHeader {
+	if r.MultipartForm == nil {
+		return nil
+	}
+
+	var files []*multipart.FileHeader
+	for _, field := range []string{"attachments", "image"} {
+		for _, fh := range r.MultipartForm.File[field] {
+			// An empty file input still sends a part with no name
+			if fh.Filename != "" || fh.Size > 0 {
+				files = append(files, fh)
+			}
+		}
+	}
+	return files
+}
+
+// storageSettings shows how much of their quota a user's attachments use.
+func (app *application) storageSettings(w http.ResponseWriter, r *http.Request) {
+	loggedInUser, isLoggedIn := app.GetUserFromSession(r)
+
+	if r.URL.Path != "/user/settings/storage" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	attachments, err := models.GetAttachmentsByUserID(app.db, loggedInUser.ID)
+	if err != nil {
+		logger.ErrorLogger.Printf("Error getting attachments: %v\n", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	used, _, err := models.GetStorageUsage(app.db, loggedInUser.ID)
+	if err != nil {
+		logger.ErrorLogger.Printf("Error getting storage usage: %v\n", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	quota, err := app.effectiveQuota(loggedInUser.ID)
+	if err != nil {
+		logger.ErrorLogger.Printf("Error getting upload quota: %v\n", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	data := &templateData{
+		IsLoggedIn:   isLoggedIn,
+		LoggedInUser: loggedInUser,
+		Attachments:  attachments,
+		StorageUsed:  used,
+		StorageQuota: quota,
+	}
+
+	if err := app.renderTemplate(w, r, "usersettings.storage.page.html", data); err != nil {
+		logger.ErrorLogger.Printf("Error rendering template: %v\n", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
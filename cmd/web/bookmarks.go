@@ -0,0 +1,238 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"forum/logger"
+	"forum/pkg/models"
+	"forum/utils"
+)
+
+// Limits of the text users give their bookmarks.
+const (
+	maxBookmarkNoteLength   = 500
+	maxCollectionNameLength = 50
+)
+
+// loadBookmarks adds the user's bookmarks on a post and its comments, and
+// their collections, to the post page.
+func (app *application) loadBookmarks(data *templateData, userID, postID string) error {
+	bookmarks, err := models.GetBookmarksOnPost(app.db, userID, postID)
+	if err != nil {
+		return err
+	}
+
+	data.Bookmarked = make(map[string]models.Bookmark)
+	for _, b := range bookmarks {
+		data.Bookmarked[b.CommentID] = b
+	}
+
+	data.Collections, err = models.GetCollectionsByUserID(app.db, userID)
+	return err
+}
+
+// saveBookmark bookmarks a post, or a comment when comment_id is set, or
+// updates the collection and note of an existing bookmark.
+func (app *application) saveBookmark(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/bookmark" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		logger.ErrorLogger.Printf("Error parsing a form: %s\n", err)
+		http.Error(w, "Unable to parse form", http.StatusBadRequest)
+		return
+	}
+
+	user, _ := app.GetUserFromSession(r)
+	bookmark := models.Bookmark{
+		UserID:       user.ID,
+		PostID:       r.PostForm.Get("post_id"),
+		CommentID:    r.PostForm.Get("comment_id"),
+		CollectionID: r.PostForm.Get("collection_id"),
+		Note:         strings.TrimSpace(r.PostForm.Get("note")),
+	}
+
+	if utf8.RuneCountInString(bookmark.Note) > maxBookmarkNoteLength {
+		http.Error(w, "Note must not exceed 500 characters", http.StatusBadRequest)
+		return
+	}
+
+	err := models.SaveBookmark(app.db, bookmark)
+	if errors.Is(err, models.ErrBookmarkTarget) || errors.Is(err, models.ErrCollectionNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Unable to save bookmark", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, utils.LocalRedirect(r.PostForm.Get("next"), "/post?id="+bookmark.PostID), http.StatusSeeOther)
+}
+
+// deleteBookmark removes one of the user's bookmarks.
+func (app *application) deleteBookmark(w http.ResponseWriter, r *http.Request) {
+	loggedInUser, _ := app.GetUserFromSession(r)
+
+	if r.URL.Path != "/bookmark/delete" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := models.DeleteBookmark(app.db, r.PostFormValue("id"), loggedInUser.ID); err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, utils.LocalRedirect(r.PostFormValue("next"), "/user/profile/saved"), http.StatusSeeOther)
+}
+
+func (app *application) renderSaved(w http.ResponseWriter, r *http.Request, formErrors map[string]string) {
+	loggedInUser, isLoggedIn := app.GetUserFromSession(r)
+
+	collections, err := models.GetCollectionsByUserID(app.db, loggedInUser.ID)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	var selected models.Collection
+	if id := r.URL.Query().Get("collection"); id != "" {
+		for _, c := range collections {
+			if c.ID == id {
+				selected = c
+			}
+		}
+		if selected.ID == "" {
+			http.NotFound(w, r)
+			return
+		}
+	}
+
+	bookmarks, err := models.GetBookmarksByUserID(app.db, loggedInUser.ID, selected.ID)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	data := &templateData{
+		IsLoggedIn:   isLoggedIn,
+		LoggedInUser: loggedInUser,
+		Bookmarks:    bookmarks,
+		Collections:  collections,
+		Collection:   selected,
+		CurrentPage:  r.URL.RequestURI(),
+		FormErrors:   formErrors,
+		FormData:     r.PostForm,
+	}
+
+	status := http.StatusOK
+	if len(formErrors) > 0 {
+		status = http.StatusUnprocessableEntity
+	}
+
+	if err := app.renderTemplateWithStatus(w, r, status, "userprofile.saved.page.html", data); err != nil {
+		logger.ErrorLogger.Printf("Error rendering template: %v\n", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// userProfileSavedPage lists the user's bookmarks, all of them or those in
+// one collection.
+func (app *application) userProfileSavedPage(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/user/profile/saved" {
+		http.NotFound(w, r)
+		return
+	}
+
+	app.renderSaved(w, r, nil)
+}
+
+// createCollection adds a bookmark collection.
+func (app *application) createCollection(w http.ResponseWriter, r *http.Request) {
+	loggedInUser, _ := app.GetUserFromSession(r)
+
+	if r.URL.Path != "/user/profile/saved/collections" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		logger.ErrorLogger.Printf("Error parsing a form: %s\n", err)
+		http.Error(w, "Unable to parse form", http.StatusBadRequest)
+		return
+	}
+
+	name := strings.TrimSpace(r.PostForm.Get("name"))
+	if name == "" {
+		app.renderSaved(w, r, map[string]string{"name": "Name is required"})
+		return
+	}
+	if utf8.RuneCountInString(name) > maxCollectionNameLength {
+		app.renderSaved(w, r, map[string]string{"name": "Name must not exceed 50 characters"})
+		return
+	}
+
+	id, err := models.CreateCollection(app.db, loggedInUser.ID, name)
+	if errors.Is(err, models.ErrCollectionNameTaken) {
+		app.renderSaved(w, r, map[string]string{"name": "You already have a collection with that name"})
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/user/profile/saved?collection="+id, http.StatusSeeOther)
+}
+
+// deleteCollection deletes a bookmark collection, keeping its bookmarks.
+func (app *application) deleteCollection(w http.ResponseWriter, r *http.Request) {
+	loggedInUser, _ := app.GetUserFromSession(r)
+
+	if r.URL.Path != "/user/profile/saved/collections/delete" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	err := models.DeleteCollection(app.db, r.PostFormValue("id"), loggedInUser.ID)
+	if errors.Is(err, models.ErrCollectionNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/user/profile/saved", http.StatusSeeOther)
+}
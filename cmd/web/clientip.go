@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// loadTrustedProxies parses TRUSTED_PROXIES, a comma separated list of IP
+// addresses or CIDR ranges of reverse proxies in front of the forum. Only
+// requests coming through them may set the client address with X-Forwarded-For.
+func loadTrustedProxies() ([]*net.IPNet, error) {
+	var proxies []*net.IPNet
+
+	for _, entry := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		network, err := parseNetwork(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %v", entry, err)
+		}
+		proxies = append(proxies, network)
+	}
+
+	return proxies, nil
+}
+
+// parseNetwork parses an IP address or a CIDR range. A single address
+// becomes a network containing only that address.
+func parseNetwork(s string) (*net.IPNet, error) {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("not an IP address")
+		}
+		bits := 8 * net.IPv6len
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 8*net.IPv4len
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+
+	_, network, err := net.ParseCIDR(s)
+	return network, err
+}
+
+func (app *application) isTrustedProxy(ip net.IP) bool {
+	for _, network := range app.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the address of the client. When the request comes from a
+// trusted proxy, X-Forwarded-For is read from the right, skipping the proxies
+// themselves, so clients cannot spoof an address by sending the header.
+func (app *application) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || !app.isTrustedProxy(ip) {
+		return host
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop
+		if !app.isTrustedProxy(ip) {
+			break
+		}
+	}
+
+	return ip.String()
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"time"
+
+	"forum/logger"
+)
+
+const (
+	csrfCookieName = "csrf"
+	csrfFieldName  = "csrf_token"
+	csrfHeaderName = "X-CSRF-Token"
+)
+
+type contextKey string
+
+// csrfExemptPaths receive POSTs from the browser itself rather than from our
+// forms, so they cannot carry a token.
+var csrfExemptPaths = map[string]bool{
+	"/csp-report": true,
+}
+
+const csrfTokenContextKey = contextKey("csrfToken")
+
+// Limits on the bodies of state-changing requests, applied before the token
+// is read. Only the post form, which carries attachments, may be larger.
+const (
+	maxFormSize      = 1 << 20
+	maxPostBodySize  = maxAttachments*maxImageSize + 1<<20
+	maxCSRFTokenSize = 256
+)
+
+// uploadPaths take the post form with its attachments.
+var uploadPaths = map[string]bool{
+	"/post/create": true,
+	"/post/edit":   true,
+}
+
+// loadCSRFKey reads the signing key from CSRF_KEY. Without it a random key is
+// generated, which means tokens stop working after a restart.
+func loadCSRFKey() []byte {
+	if key := os.Getenv("CSRF_KEY"); key != "" {
+		return []byte(key)
+	}
+
+	logger.InfoLogger.Println("CSRF_KEY is not set, generating a random key")
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		logger.ErrorLogger.Fatalf("Error generating CSRF key: %v", err)
+	}
+	return key
+}
+
+// csrfProtect issues a token for every request and rejects state-changing
+// requests that don't carry a valid one. The token is bound to the session
+// cookie when the user is logged in and to a separate csrf cookie otherwise,
+// so login and signup forms are protected too.
+func (app *application) csrfProtect(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := csrfSessionKey(w, r)
+		token := app.csrfTokenFor(key)
+
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		default:
+			if csrfExemptPaths[r.URL.Path] {
+				break
+			}
+
+			limit := int64(maxFormSize)
+			if uploadPaths[r.URL.Path] {
+				limit = maxPostBodySize
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+
+			submitted := r.Header.Get(csrfHeaderName)
+			if submitted == "" {
+				var err error
+				submitted, err = submittedCSRFToken(r)
+				var tooLarge *http.MaxBytesError
+				if errors.As(err, &tooLarge) {
+					http.Error(w, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+					return
+				}
+			}
+
+			if !hmac.Equal([]byte(submitted), []byte(token)) {
+				logger.ErrorLogger.Printf("Invalid CSRF token for %s %s from %s\n", r.Method, r.URL.Path, r.RemoteAddr)
+				app.renderError(w, r, http.StatusForbidden, "Your form has expired or could not be verified. Please go back, reload the page and try again.")
+				return
+			}
+		}
+
+		ctx := context.WithValue(r.Context(), csrfTokenContextKey, token)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// submittedCSRFToken reads the token from the request's form. Of a multipart
+// form only the first part is read, which must be the token, so that files
+// aren't parsed before the handler has checked anything. The bytes read are
+// put back in front of the body for the handler to parse.
+func submittedCSRFToken(r *http.Request) (string, error) {
+	mediaType, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		if err := r.ParseForm(); err != nil {
+			return "", err
+		}
+		return r.PostForm.Get(csrfFieldName), nil
+	}
+
+	body := r.Body
+	var read bytes.Buffer
+	defer func() {
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(&read, body), body}
+	}()
+
+	part, err := multipart.NewReader(io.TeeReader(body, &read), params["boundary"]).NextPart()
+	if err != nil {
+		return "", err
+	}
+	if part.FormName() != csrfFieldName {
+		return "", nil
+	}
+
+	token, err := io.ReadAll(io.LimitReader(part, maxCSRFTokenSize))
+	if err != nil {
+		return "", err
+	}
+	return string(token), nil
+}
+
+// csrfSessionKey returns the value the token is bound to, issuing an anonymous
+// csrf cookie when the visitor has no session yet.
+func csrfSessionKey(w http.ResponseWriter, r *http.Request) string {
+	if cookie, err := r.Cookie("session"); err == nil && cookie.Value != "" {
+		return cookie.Value
+	}
+
+	if cookie, err := r.Cookie(csrfCookieName); err == nil && cookie.Value != "" {
+		return cookie.Value
+	}
+
+	value := make([]byte, 32)
+	if _, err := rand.Read(value); err != nil {
+		logger.ErrorLogger.Printf("Error generating CSRF cookie: %v\n", err)
+	}
+
+	cookie := &http.Cookie{
+		Name:     csrfCookieName,
+		Value:    base64.RawURLEncoding.EncodeToString(value),
+		Path:     "/",
+		HttpOnly: true,
+		Expires:  time.Now().Add(24 * time.Hour),
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	http.SetCookie(w, cookie)
+	r.AddCookie(cookie)
+
+	return cookie.Value
+}
+
+func (app *application) csrfTokenFor(key string) string {
+	mac := hmac.New(sha256.New, app.csrfKey)
+	mac.Write([]byte(key))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// csrfToken returns the token issued for the current request.
+func csrfToken(r *http.Request) string {
+	token, _ := r.Context().Value(csrfTokenContextKey).(string)
+	return token
+}
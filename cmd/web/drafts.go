@@ -0,0 +1,485 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"forum/logger"
+	"forum/pkg/models"
+
+	"github.com/google/uuid"
+)
+
+// Values of the post form's submit buttons.
+const (
+	postActionPublish  = "publish"
+	postActionSchedule = "schedule"
+	postActionDraft    = "draft"
+)
+
+// publishAtLayout is the format of a datetime-local input. The time is read
+// in the server's time zone, the one humanDate shows times in.
+const publishAtLayout = "2006-01-02T15:04"
+
+// draftPublishInterval is how often scheduled drafts are checked.
+const draftPublishInterval = time.Minute
+
+// parsePublishAt reads the publish time of a scheduled post. It returns a
+// message for the form if the time is missing or not in the future.
+func parsePublishAt(value string, now time.Time) (time.Time, string) {
+	if value == "" {
+		return time.Time{}, "Choose when to publish the post"
+	}
+
+	publishAt, err := time.ParseInLocation(publishAtLayout, value, time.Local)
+	if err != nil {
+		return time.Time{}, "Enter a valid date and time"
+	}
+	if !publishAt.After(now) {
+		return time.Time{}, "Choose a time in the future"
+	}
+	return publishAt, ""
+}
+
+// renderPostForm shows the create form, for a new post or for editing draft.
+func (app *application) renderPostForm(w http.ResponseWriter, r *http.Request, draft models.Post, formErrors map[string]string, formData url.Values) {
+	loggedInUser, isLoggedIn := app.GetUserFromSession(r)
+
+	data := &templateData{
+		Post:         draft,
+		IsLoggedIn:   isLoggedIn,
+		LoggedInUser: loggedInUser,
+		CurrentPage:  r.URL.Path,
+		FormErrors:   formErrors,
+		FormData:     formData,
+		TimeZone:     time.Now().Format("MST"),
+	}
+
+	status := http.StatusOK
+	if len(formErrors) > 0 {
+		status = http.StatusUnprocessableEntity
+	}
+
+	if err := app.renderTemplateWithStatus(w, r, status, "create.page.html", data); err != nil {
+		logger.ErrorLogger.Printf("Error rendering template: %v\n", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// submitPost handles the create form for a new post, when draft is empty, or
+// for one of the user's drafts. Depending on the button used the post is
+// published, scheduled or kept as a draft.
+func (app *application) submitPost(w http.ResponseWriter, r *http.Request, user models.User, draft models.Post) {
+	// csrfProtect limits the body to maxPostBodySize
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		logger.ErrorLogger.Printf("Error parsing multipart form: %s\n", err)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "Unable to parse form", http.StatusBadRequest)
+		return
+	}
+	action := r.PostForm.Get("action")
+	title := r.PostForm.Get("title")
+	content := r.PostForm.Get("content")
+	categories := r.PostForm["categories"]
+	category := strings.Join(categories, "; ")
+	files := attachmentFiles(r)
+
+	// The draft's attachments that are kept
+	removed := r.PostForm["remove_attachment"]
+	var kept []models.Attachment
+	for _, a := range draft.Attachments {
+		if !contains(removed, a.ID) {
+			kept = append(kept, a)
+		}
+	}
+
+	var formErrors map[string]string
+	var publishAt time.Time
+	switch action {
+	case postActionDraft:
+		formErrors = validateDraftForm(title, content, categories, files)
+	case postActionSchedule:
+		formErrors = validateCreatePostForm(title, content, categories, files)
+		var message string
+		if publishAt, message = parsePublishAt(r.PostForm.Get("publish_at"), time.Now()); message != "" {
+			formErrors["publish_at"] = message
+		}
+	default:
+		action = postActionPublish
+		formErrors = validateCreatePostForm(title, content, categories, files)
+	}
+	tags, message := parseTags(r.PostForm.Get("tags"))
+	if message != "" {
+		formErrors["tags"] = message
+	}
+	poll, message := parsePoll(r.PostForm, publishAt, time.Now())
+	if message != "" {
+		formErrors["poll"] = message
+	}
+	if len(kept)+len(files) > maxAttachments && formErrors["attachments"] == "" {
+		formErrors["attachments"] = fmt.Sprintf("A post can have at most %d attachments", maxAttachments)
+	}
+
+	var pending []pendingAttachment
+	if len(formErrors) == 0 && len(files) > 0 {
+		var message string
+		pending, message = readAttachments(files)
+		if message == "" {
+			var err error
+			message, err = app.checkQuota(user.ID, pending)
+			if err != nil {
+				logger.ErrorLogger.Printf("Error checking upload quota: %v\n", err)
+				http.Error(w, "Unable to create post", http.StatusInternalServerError)
+				return
+			}
+		}
+		if message != "" {
+			formErrors["attachments"] = message
+		}
+	}
+
+	if len(formErrors) > 0 {
+		app.renderPostForm(w, r, draft, formErrors, r.PostForm)
+		return
+	}
+
+	attachments, err := app.storeAttachments(pending)
+	if err != nil {
+		logger.ErrorLogger.Printf("Error creating post with attachments: %s\n", err)
+		http.Error(w, "Unable to create post", http.StatusInternalServerError)
+		return
+	}
+
+	post := draft
+	if post.ID == "" {
+		post.ID = uuid.New().String()
+		post.UserID = user.ID
+		post.CreatedAt = time.Now()
+	}
+	post.Title = title
+	post.Content = content
+	post.Category = category
+	post.Attachments = append(kept, attachments...)
+	post.Poll = poll
+	post.Tags = tags
+	post.PublishAt = sql.NullTime{}
+	if action == postActionSchedule {
+		post.PublishAt = sql.NullTime{Time: publishAt, Valid: true}
+	}
+
+	// The first image is the one shown in lists of posts
+	post.ImageFullPath = ""
+	for _, a := range post.Attachments {
+		if a.IsImage() {
+			post.ImageFullPath = a.URL
+			break
+		}
+	}
+
+	if action == postActionPublish && draft.ID == "" {
+		app.publishNewPost(w, r, user, post)
+		return
+	}
+
+	// Anything else is saved as a draft first, including a draft that is
+	// published now, so its last changes aren't lost if it is held
+	post.Status = models.StatusDraft
+	if draft.ID == "" {
+		_, err = models.CreatePost(app.db, post)
+	} else {
+		err = models.UpdateDraft(app.db, post, removed)
+	}
+	if errors.Is(err, models.ErrNotDraft) {
+		http.Error(w, "This post has already been published", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		logger.ErrorLogger.Printf("Error saving draft: %v\n", err)
+		http.Error(w, "Unable to save post", http.StatusInternalServerError)
+		return
+	}
+
+	switch action {
+	case postActionPublish:
+		held, err := app.publishDraft(post, user)
+		if err != nil {
+			logger.ErrorLogger.Printf("Error publishing draft: %v\n", err)
+			http.Error(w, "Unable to publish post", http.StatusInternalServerError)
+			return
+		}
+		if held {
+			logger.InfoLogger.Printf("Draft held for review: ID=%s, Author=%s\n", post.ID, user.Name)
+		} else {
+			logger.InfoLogger.Printf("Draft published: ID=%s, Title=%s, Author=%s\n", post.ID, post.Title, user.Name)
+		}
+	case postActionSchedule:
+		logger.InfoLogger.Printf("Post scheduled: ID=%s, Author=%s, PublishAt=%s\n", post.ID, user.Name, publishAt)
+	default:
+		logger.InfoLogger.Printf("Draft saved: ID=%s, Author=%s\n", post.ID, user.Name)
+	}
+
+	http.Redirect(w, r, "/post?id="+post.ID, http.StatusSeeOther)
+}
+
+// publishNewPost publishes a post straight from the create form, unless the
+// spam checks hold it for review.
+func (app *application) publishNewPost(w http.ResponseWriter, r *http.Request, user models.User, post models.Post) {
+	result, err := app.scoreContent(user, post.Title+"\n"+post.Content)
+	if err != nil {
+		logger.ErrorLogger.Printf("Error checking post for spam: %v\n", err)
+		http.Error(w, "Unable to create post", http.StatusInternalServerError)
+		return
+	}
+
+	if app.spamRules.Held(result) {
+		if err := models.HoldPost(app.db, post, result.Score, result.Reasons); err != nil {
+			http.Error(w, "Unable to create post", http.StatusInternalServerError)
+			return
+		}
+
+		logger.InfoLogger.Printf("Post held for review: ID=%s, Author=%s, Score=%d\n", post.ID, user.Name, result.Score)
+		http.Redirect(w, r, "/post?id="+post.ID, http.StatusSeeOther)
+		return
+	}
+
+	if _, err := models.CreatePost(app.db, post); err != nil {
+		logger.ErrorLogger.Printf("Error creating post: %v\n", err)
+		http.Error(w, "Unable to create post", http.StatusInternalServerError)
+		return
+	}
+
+	logger.InfoLogger.Printf("Post created: ID=%s, Title=%s, Author=%s\n", post.ID, post.Title, user.Name)
+	http.Redirect(w, r, "/post?id="+post.ID, http.StatusSeeOther)
+}
+
+// publishDraft runs the spam checks on a saved draft and publishes it, or
+// holds it for review and reports that it did.
+func (app *application) publishDraft(post models.Post, author models.User) (bool, error) {
+	result, err := app.scoreContent(author, post.Title+"\n"+post.Content)
+	if err != nil {
+		return false, err
+	}
+
+	now := time.Now()
+	if app.spamRules.Held(result) {
+		return true, models.HoldDraft(app.db, post, now, result.Score, result.Reasons)
+	}
+	return false, models.PublishDraft(app.db, post.ID, now)
+}
+
+// publishScheduledPosts publishes scheduled drafts as their time comes, every
+// draftPublishInterval until the process exits.
+func (app *application) publishScheduledPosts() {
+	ticker := time.NewTicker(draftPublishInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		app.publishDueDrafts()
+	}
+}
+
+func (app *application) publishDueDrafts() {
+	drafts, err := models.GetDueDrafts(app.db, time.Now())
+	if err != nil {
+		logger.ErrorLogger.Printf("Error getting scheduled posts: %v\n", err)
+		return
+	}
+
+	for _, post := range drafts {
+		author, err := models.GetUserByID(app.db, post.UserID)
+		if err != nil {
+			logger.ErrorLogger.Printf("Error getting author of scheduled post %s: %v\n", post.ID, err)
+			continue
+		}
+
+		// Posts of suspended users and of accounts being deleted wait, and
+		// go out if the suspension ends or the deletion is cancelled
+		if author.DeletionRequestedAt.Valid {
+			continue
+		}
+		if _, suspended, err := models.GetActiveSuspension(app.db, author.ID); err != nil || suspended {
+			continue
+		}
+
+		held, err := app.publishDraft(post, author)
+		if errors.Is(err, models.ErrNotDraft) {
+			// published by hand or deleted since it was looked up
+			continue
+		}
+		if err != nil {
+			logger.ErrorLogger.Printf("Error publishing scheduled post %s: %v\n", post.ID, err)
+			continue
+		}
+
+		message := fmt.Sprintf("Your scheduled post %q has been published.", post.Title)
+		if held {
+			message = fmt.Sprintf("Your scheduled post %q is waiting for a moderator before it is published.", post.Title)
+		}
+		notification := models.Notification{
+			UserID:  post.UserID,
+			Kind:    models.NotificationScheduledPost,
+			Message: message,
+			Link:    "/post?id=" + post.ID,
+		}
+		if _, err := models.CreateNotification(app.db, notification); err != nil {
+			logger.ErrorLogger.Printf("Error notifying user %s about scheduled post: %v\n", post.UserID, err)
+		}
+
+		logger.InfoLogger.Printf("Scheduled post published: ID=%s, Held=%t\n", post.ID, held)
+	}
+}
+
+// editDraft shows one of the user's drafts in the create form and saves it.
+func (app *application) editDraft(w http.ResponseWriter, r *http.Request) {
+	loggedInUser, _ := app.GetUserFromSession(r)
+
+	if r.URL.Path != "/post/edit" {
+		http.NotFound(w, r)
+		return
+	}
+
+	draft, err := models.GetPostByID(app.db, r.URL.Query().Get("id"))
+	if err != nil || draft.Status != models.StatusDraft || draft.UserID != loggedInUser.ID {
+		http.NotFound(w, r)
+		return
+	}
+
+	draft.Attachments, err = models.GetAttachmentsByPostID(app.db, draft.ID)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if err := app.loadPoll(&draft, ""); err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	draft.Tags, err = models.GetTagsByPostID(app.db, draft.ID)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		formData := url.Values{
+			"title":   {draft.Title},
+			"content": {draft.Content},
+			"tags":    {strings.Join(draft.Tags, ", ")},
+		}
+		if draft.Category != "" {
+			formData["categories"] = strings.Split(draft.Category, "; ")
+		}
+		if draft.PublishAt.Valid {
+			formData.Set("publish_at", draft.PublishAt.Time.Local().Format(publishAtLayout))
+		}
+		if draft.Poll != nil {
+			pollFormData(formData, *draft.Poll)
+		}
+		app.renderPostForm(w, r, draft, nil, formData)
+
+	case http.MethodPost:
+		app.submitPost(w, r, loggedInUser, draft)
+
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// deleteDraft deletes one of the user's drafts.
+func (app *application) deleteDraft(w http.ResponseWriter, r *http.Request) {
+	loggedInUser, _ := app.GetUserFromSession(r)
+
+	if r.URL.Path != "/post/draft/delete" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		logger.ErrorLogger.Printf("Error parsing a form: %s\n", err)
+		http.Error(w, "Unable to parse form", http.StatusBadRequest)
+		return
+	}
+
+	err := models.DeleteDraft(app.db, r.PostForm.Get("id"), loggedInUser.ID)
+	if errors.Is(err, models.ErrNotDraft) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/user/profile/drafts", http.StatusSeeOther)
+}
+
+// userProfileDraftsPage lists the user's drafts and scheduled posts.
+func (app *application) userProfileDraftsPage(w http.ResponseWriter, r *http.Request) {
+	loggedInUser, isLoggedIn := app.GetUserFromSession(r)
+
+	if r.URL.Path != "/user/profile/drafts" {
+		http.NotFound(w, r)
+		return
+	}
+
+	drafts, err := models.GetDraftsByUserID(app.db, loggedInUser.ID)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	data := &templateData{
+		Posts:        drafts,
+		IsLoggedIn:   isLoggedIn,
+		LoggedInUser: loggedInUser,
+	}
+
+	if err := app.renderTemplate(w, r, "userprofile.drafts.page.html", data); err != nil {
+		logger.ErrorLogger.Printf("Error rendering template: %v\n", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// acceptsReplies reports whether the post can be commented on and reacted
+// to. Only published posts can, not drafts or posts waiting for review.
+func (app *application) acceptsReplies(postID string) bool {
+	post, err := models.GetPostByID(app.db, postID)
+	return err == nil && post.Status == models.StatusPublished
+}
+
+// withoutDrafts leaves the drafts out of a user's posts, which are listed
+// under their own tab.
+func withoutDrafts(posts []models.Post) []models.Post {
+	var published []models.Post
+	for _, post := range posts {
+		if post.Status != models.StatusDraft {
+			published = append(published, post)
+		}
+	}
+	return published
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"forum/logger"
+	"forum/pkg/models"
+	"forum/utils"
+)
+
+// defaultReactions are the emoji users can react with unless REACTIONS lists
+// others.
+var defaultReactions = []string{"👍", "❤️", "😂", "😮", "😢", "🎉"}
+
+// Limits of the REACTIONS setting.
+const (
+	maxReactions    = 12
+	maxReactionSize = 32
+)
+
+// emojiCount sums up the reactions with one emoji on a post or comment, for
+// its button and its list of who reacted.
+type emojiCount struct {
+	Emoji string
+	Count int
+	// Reacted is set if the user viewing the page left this emoji.
+	Reacted bool
+	Users   []string
+}
+
+// emojiBar is what the "emojireactions" template shows under a post or
+// comment: the counts, and when the user may react the emoji to pick from.
+type emojiBar struct {
+	PostID    string
+	CommentID string
+	Counts    []emojiCount
+	Reactions []string
+	CanReact  bool
+	CSRFToken string
+}
+
+// EmojiBar returns the emoji reactions on the post page's post, or on one of
+// its comments.
+func (td *templateData) EmojiBar(commentID string) emojiBar {
+	return emojiBar{
+		PostID:    td.Post.ID,
+		CommentID: commentID,
+		Counts:    td.EmojiCounts[commentID],
+		Reactions: td.Reactions,
+		CanReact:  td.IsLoggedIn && td.Post.Status == models.StatusPublished && !td.Post.Archived(),
+		CSRFToken: td.CSRFToken,
+	}
+}
+
+// loadReactions reads the emoji users can react with from REACTIONS, comma
+// separated, such as "👍,🎉,🚀".
+func loadReactions() ([]string, error) {
+	value := os.Getenv("REACTIONS")
+	if strings.TrimSpace(value) == "" {
+		return defaultReactions, nil
+	}
+
+	var reactions []string
+	for _, emoji := range strings.Split(value, ",") {
+		emoji = strings.TrimSpace(emoji)
+		if emoji == "" || contains(reactions, emoji) {
+			continue
+		}
+		if len(emoji) > maxReactionSize || !utils.IsEmoji(emoji) {
+			return nil, fmt.Errorf("invalid reaction %q in REACTIONS", emoji)
+		}
+		reactions = append(reactions, emoji)
+	}
+
+	if len(reactions) > maxReactions {
+		return nil, fmt.Errorf("REACTIONS lists %d emoji, at most %d are allowed", len(reactions), maxReactions)
+	}
+	return reactions, nil
+}
+
+// loadEmojiReactions adds the emoji reactions on a post and its comments to
+// the post page, keyed by comment ID, "" for the post. The configured emoji
+// come first in their order, then any left before the set was changed.
+func (app *application) loadEmojiReactions(data *templateData, viewerID string) error {
+	reactions, err := models.GetEmojiReactionsOnPost(app.db, data.Post.ID)
+	if err != nil {
+		return err
+	}
+
+	data.Reactions = app.reactions
+	data.EmojiCounts = make(map[string][]emojiCount)
+
+	byTarget := make(map[string]map[string]*emojiCount)
+	var extra []string
+	for _, r := range reactions {
+		counts := byTarget[r.CommentID]
+		if counts == nil {
+			counts = make(map[string]*emojiCount)
+			byTarget[r.CommentID] = counts
+		}
+		count := counts[r.Emoji]
+		if count == nil {
+			count = &emojiCount{Emoji: r.Emoji}
+			counts[r.Emoji] = count
+			if !contains(app.reactions, r.Emoji) && !contains(extra, r.Emoji) {
+				extra = append(extra, r.Emoji)
+			}
+		}
+		count.Count++
+		count.Users = append(count.Users, r.UserName)
+		if r.UserID == viewerID {
+			count.Reacted = true
+		}
+	}
+
+	order := append(append([]string{}, app.reactions...), extra...)
+	for target, counts := range byTarget {
+		for _, emoji := range order {
+			if count := counts[emoji]; count != nil {
+				data.EmojiCounts[target] = append(data.EmojiCounts[target], *count)
+			}
+		}
+	}
+
+	return nil
+}
+
+// toggleEmojiReaction leaves an emoji on a post, or on a comment when
+// comment_id is set, or takes it back if the user already left it.
+func (app *application) toggleEmojiReaction(w http.ResponseWriter, r *http.Request) {
+	loggedInUser, _ := app.GetUserFromSession(r)
+
+	if r.URL.Path != "/post/emoji" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		logger.ErrorLogger.Printf("Error parsing a form: %s\n", err)
+		http.Error(w, "Unable to parse form", http.StatusBadRequest)
+		return
+	}
+
+	reaction := models.EmojiReaction{
+		PostID:    r.PostForm.Get("post_id"),
+		CommentID: r.PostForm.Get("comment_id"),
+		UserID:    loggedInUser.ID,
+		Emoji:     r.PostForm.Get("emoji"),
+	}
+
+	if app.threadArchived(reaction.PostID) {
+		http.Error(w, "This thread is archived", http.StatusForbidden)
+		return
+	}
+
+	// Emoji taken out of the set can still be taken back, but not left
+	if !contains(app.reactions, reaction.Emoji) {
+		left, err := app.hasEmojiReaction(reaction)
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if !left {
+			http.Error(w, "Unknown reaction", http.StatusBadRequest)
+			return
+		}
+	}
+
+	_, err := models.ToggleEmojiReaction(app.db, reaction)
+	if errors.Is(err, models.ErrReactionTarget) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Unable to react", http.StatusInternalServerError)
+		return
+	}
+
+	next := "/post?id=" + reaction.PostID
+	if reaction.CommentID != "" {
+		next += "#comment-" + reaction.CommentID
+	}
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+// hasEmojiReaction reports whether the user already left the reaction.
+func (app *application) hasEmojiReaction(reaction models.EmojiReaction) (bool, error) {
+	reactions, err := models.GetEmojiReactionsOnPost(app.db, reaction.PostID)
+	if err != nil {
+		return false, err
+	}
+	for _, r := range reactions {
+		if r.CommentID == reaction.CommentID && r.UserID == reaction.UserID && r.Emoji == reaction.Emoji {
+			return true, nil
+		}
+	}
+	return false, nil
+}
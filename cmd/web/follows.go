@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"forum/logger"
+	"forum/pkg/models"
+	"forum/utils"
+)
+
+// postCategories are the categories the create form offers.
+var postCategories = []string{"category1", "category2", "category3", "category4", "category5"}
+
+// followingFeedSize is how many posts the Following feed shows.
+const followingFeedSize = 50
+
+// followUser follows or, with action=unfollow, unfollows another user.
+func (app *application) followUser(w http.ResponseWriter, r *http.Request) {
+	loggedInUser, _ := app.GetUserFromSession(r)
+
+	if r.URL.Path != "/user/follow" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		logger.ErrorLogger.Printf("Error parsing a form: %s\n", err)
+		http.Error(w, "Unable to parse form", http.StatusBadRequest)
+		return
+	}
+
+	followee, err := models.GetUserByID(app.db, r.PostForm.Get("user_id"))
+	if err != nil || followee.ID == "" || followee.ID == loggedInUser.ID {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.PostForm.Get("action") == "unfollow" {
+		err = models.Unfollow(app.db, loggedInUser.ID, followee.ID)
+	} else {
+		err = models.Follow(app.db, loggedInUser.ID, followee.ID)
+	}
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, utils.LocalRedirect(r.PostForm.Get("next"), "/user/profile/following"), http.StatusSeeOther)
+}
+
+// subscribeCategory subscribes to or, with action=unsubscribe, unsubscribes
+// from a category.
+func (app *application) subscribeCategory(w http.ResponseWriter, r *http.Request) {
+	loggedInUser, _ := app.GetUserFromSession(r)
+
+	if r.URL.Path != "/category/subscribe" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		logger.ErrorLogger.Printf("Error parsing a form: %s\n", err)
+		http.Error(w, "Unable to parse form", http.StatusBadRequest)
+		return
+	}
+
+	category := r.PostForm.Get("category")
+	if !contains(postCategories, category) {
+		http.NotFound(w, r)
+		return
+	}
+
+	var err error
+	if r.PostForm.Get("action") == "unsubscribe" {
+		err = models.UnsubscribeCategory(app.db, loggedInUser.ID, category)
+	} else {
+		err = models.SubscribeCategory(app.db, loggedInUser.ID, category)
+	}
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, utils.LocalRedirect(r.PostForm.Get("next"), "/user/profile/following"), http.StatusSeeOther)
+}
+
+// watchThread sets whether new comments on a post notify the user: state is
+// "watching", "muted", or empty to go back to the default.
+func (app *application) watchThread(w http.ResponseWriter, r *http.Request) {
+	loggedInUser, _ := app.GetUserFromSession(r)
+
+	if r.URL.Path != "/post/watch" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		logger.ErrorLogger.Printf("Error parsing a form: %s\n", err)
+		http.Error(w, "Unable to parse form", http.StatusBadRequest)
+		return
+	}
+
+	postID := r.PostForm.Get("post_id")
+	state := r.PostForm.Get("state")
+	if state != models.ThreadWatching && state != models.ThreadMuted && state != "" {
+		http.Error(w, "Unknown state", http.StatusBadRequest)
+		return
+	}
+
+	if !app.acceptsReplies(postID) {
+		http.NotFound(w, r)
+		return
+	}
+
+	if err := models.SetThreadWatch(app.db, loggedInUser.ID, postID, state); err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, utils.LocalRedirect(r.PostForm.Get("next"), "/post?id="+postID), http.StatusSeeOther)
+}
+
+// notifyWatchers tells the users watching a post about a new comment on it,
+// once the comment is published.
+func (app *application) notifyWatchers(comment models.Comment, commenterName string) {
+	post, err := models.GetPostByID(app.db, comment.PostID)
+	if err != nil {
+		logger.ErrorLogger.Printf("Error getting post %s to notify watchers: %v\n", comment.PostID, err)
+		return
+	}
+
+	watchers, err := models.GetThreadWatchers(app.db, post.ID, comment.UserID)
+	if err != nil {
+		logger.ErrorLogger.Printf("Error getting watchers of post %s: %v\n", post.ID, err)
+		return
+	}
+
+	for _, userID := range watchers {
+		notification := models.Notification{
+			UserID:  userID,
+			Kind:    models.NotificationComment,
+			Message: fmt.Sprintf("%s commented on %q.", commenterName, post.Title),
+			Link:    "/post?id=" + post.ID + "#comment-" + comment.ID,
+		}
+		if _, err := models.CreateNotification(app.db, notification); err != nil {
+			logger.ErrorLogger.Printf("Error notifying user %s about comment: %v\n", userID, err)
+		}
+	}
+}
+
+// userProfileFollowingPage lists who and what the user follows, and the
+// threads they watch or muted.
+func (app *application) userProfileFollowingPage(w http.ResponseWriter, r *http.Request) {
+	loggedInUser, isLoggedIn := app.GetUserFromSession(r)
+
+	if r.URL.Path != "/user/profile/following" {
+		http.NotFound(w, r)
+		return
+	}
+
+	followed, err := models.GetFollowedUsers(app.db, loggedInUser.ID)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	subscriptions, err := models.GetCategorySubscriptions(app.db, loggedInUser.ID)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	watches, err := models.GetThreadWatchesByUserID(app.db, loggedInUser.ID)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	data := &templateData{
+		IsLoggedIn:    isLoggedIn,
+		LoggedInUser:  loggedInUser,
+		Users:         followed,
+		Categories:    postCategories,
+		Subscriptions: subscriptions,
+		ThreadWatches: watches,
+	}
+
+	if err := app.renderTemplate(w, r, "userprofile.following.page.html", data); err != nil {
+		logger.ErrorLogger.Printf("Error rendering template: %v\n", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
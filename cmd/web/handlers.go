@@ -2,13 +2,12 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
-	"path/filepath"
 	"strconv"
-	"strings"
 	"time"
 
 	"forum/configs"
@@ -26,41 +25,32 @@ func (app *application) home(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	posts, err := models.GetAllPosts(app.db)
+	// ?feed=following shows the posts of followed users and subscribed
+	// categories instead of every post
+	feed := r.URL.Query().Get("feed")
+	if feed != "following" || !isLoggedIn {
+		feed = ""
+	}
+
+	var posts []models.Post
+	var err error
+	if feed == "following" {
+		posts, err = models.GetFollowingFeed(app.db, loggedInUser.ID, followingFeedSize)
+	} else {
+		posts, err = models.GetAllPosts(app.db)
+	}
 	if err != nil {
 		logger.ErrorLogger.Printf("Error getting posts: %v\n", err)
 		http.Error(w, "Post(s) not found", http.StatusNotFound)
 		return
 	}
 
-	for i := range posts {
-		count, err := models.CommentCountByPostID(app.db, posts[i].ID)
-		if err != nil {
-			logger.ErrorLogger.Printf("Error getting comment count: %v\n", err)
-			http.Error(w, "Failed to get comment count", http.StatusInternalServerError)
-			return
-		}
-		posts[i].CommentsCount = count
-	}
-
-	for i := range posts {
-		likes, err := models.PostLikeCountByPostID(app.db, posts[i].ID)
-		if err != nil {
-			logger.ErrorLogger.Printf("Error getting post likes: %v\n", err)
-			http.Error(w, "Like(s) not found", http.StatusNotFound)
-		}
-		posts[i].Likes = likes
-
-		dislikes, err := models.PostDislikeCountByPostID(app.db, posts[i].ID)
-		if err != nil {
-			logger.ErrorLogger.Printf("Error getting post dislikes: %v\n", err)
-			http.Error(w, "Dislike(s) not found", http.StatusNotFound)
-		}
-		posts[i].Dislikes = dislikes
-	}
+	app.attachImageVariants(posts)
+	app.attachTags(posts)
 
 	data := &templateData{
 		Posts:        posts,
+		Feed:         feed,
 		IsLoggedIn:   isLoggedIn,
 		LoggedInUser: loggedInUser,
 	}
@@ -93,6 +83,18 @@ func (app *application) showPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// posts held for review are only shown to their author and to staff
+	if post.Status == models.StatusPending && post.UserID != loggedInUser.ID && !loggedInUser.IsStaff() {
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return
+	}
+
+	// drafts are only shown to their author
+	if post.Status == models.StatusDraft && post.UserID != loggedInUser.ID {
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return
+	}
+
 	comments, err := models.GetAllCommentsByPostID(app.db, post.ID)
 	if err != nil {
 		logger.ErrorLogger.Println("Error getting comments:", err)
@@ -104,53 +106,63 @@ func (app *application) showPost(w http.ResponseWriter, r *http.Request) {
 		comments[i].IsLoggedIn = isLoggedIn
 	}
 
-	for i := range comments {
-		commentLikes, err := models.CommentLikeCountByCommentID(app.db, comments[i].ID)
-		if err != nil {
-			logger.ErrorLogger.Println("Error getting comment likes:", err)
-			http.Error(w, "Like(s) not found", http.StatusNotFound)
-			return
+	post.Attachments, err = models.GetAttachmentsByPostID(app.db, post.ID)
+	if err != nil {
+		logger.ErrorLogger.Println("Error getting attachments:", err)
+	}
+	for i, a := range post.Attachments {
+		if !a.IsImage() {
+			continue
 		}
-		comments[i].Likes = commentLikes
-
-		commentDislikes, err := models.CommentDislikeCountByCommentID(app.db, comments[i].ID)
+		post.Attachments[i].Variants, err = models.GetImageVariants(app.db, a.URL)
 		if err != nil {
-			logger.ErrorLogger.Println("Error getting comment dislikes:", err)
-			http.Error(w, "Dislike(s) not found", http.StatusNotFound)
-			return
+			logger.ErrorLogger.Println("Error getting image variants:", err)
 		}
-		comments[i].Dislikes = commentDislikes
 	}
 
-	commentsCount, err := models.CommentCountByPostID(app.db, id)
-	if err != nil {
-		logger.ErrorLogger.Println("Error getting comments count:", err)
-		http.Error(w, "Post not found", http.StatusNotFound)
-		return
+	if err := app.loadPoll(&post, loggedInUser.ID); err != nil {
+		logger.ErrorLogger.Println("Error getting poll:", err)
 	}
 
-	postLikes, err := models.PostLikeCountByPostID(app.db, post.ID)
+	post.Tags, err = models.GetTagsByPostID(app.db, post.ID)
 	if err != nil {
-		logger.ErrorLogger.Println("Error getting post likes:", err)
-		http.Error(w, "Like(s) not found", http.StatusNotFound)
-		return
+		logger.ErrorLogger.Println("Error getting tags:", err)
 	}
 
-	postDislikes, err := models.PostDislikeCountByPostID(app.db, post.ID)
-	if err != nil {
-		logger.ErrorLogger.Println("Error getting post dislikes:", err)
-		http.Error(w, "Dislike(s) not found", http.StatusNotFound)
-		return
-	}
+	app.renderPostContent(&post)
+	app.renderCommentContents(comments)
 
 	data := &templateData{
 		Post:          post,
 		IsLoggedIn:    isLoggedIn,
 		LoggedInUser:  loggedInUser,
 		Comments:      comments,
-		CommentsCount: commentsCount,
-		PostLikes:     postLikes,
-		PostDislikes:  postDislikes,
+		CommentsCount: post.CommentsCount,
+		PostLikes:     post.Likes,
+		PostDislikes:  post.Dislikes,
+	}
+
+	if err := app.loadAnswer(data); err != nil {
+		logger.ErrorLogger.Println("Error getting answer:", err)
+	}
+
+	if err := app.loadUserReactions(data, loggedInUser.ID); err != nil {
+		logger.ErrorLogger.Println("Error getting reactions:", err)
+	}
+	if err := app.loadEmojiReactions(data, loggedInUser.ID); err != nil {
+		logger.ErrorLogger.Println("Error getting emoji reactions:", err)
+	}
+
+	if isLoggedIn {
+		if err := app.loadBookmarks(data, loggedInUser.ID, post.ID); err != nil {
+			logger.ErrorLogger.Println("Error getting bookmarks:", err)
+		}
+		if data.ThreadWatch, err = models.GetThreadWatchState(app.db, loggedInUser.ID, post); err != nil {
+			logger.ErrorLogger.Println("Error getting thread watch:", err)
+		}
+		if data.FollowingAuthor, err = models.IsFollowing(app.db, loggedInUser.ID, post.UserID); err != nil {
+			logger.ErrorLogger.Println("Error getting follow:", err)
+		}
 	}
 
 	if err := app.renderTemplate(w, r, "show.page.html", data); err != nil {
@@ -194,6 +206,7 @@ func (app *application) userProfilePostsPage(w http.ResponseWriter, r *http.Requ
 		http.Error(w, "Post(s) not found", http.StatusNotFound)
 		return
 	}
+	posts = withoutDrafts(posts)
 
 	data := &templateData{
 		Posts:        posts,
@@ -369,6 +382,7 @@ func (app *application) userActivity(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Post(s) not found", http.StatusNotFound)
 		return
 	}
+	posts = withoutDrafts(posts)
 
 	comments, err := models.GetAllCommentsByUserID(app.db, loggedInUser.ID)
 	if err != nil {
@@ -446,7 +460,7 @@ func (app *application) userActivity(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *application) createPost(w http.ResponseWriter, r *http.Request) {
-	loggedInUser, loggedIn := app.GetUserFromSession(r)
+	loggedInUser, _ := app.GetUserFromSession(r)
 
 	if r.URL.Path != "/post/create" {
 		http.NotFound(w, r)
@@ -455,102 +469,10 @@ func (app *application) createPost(w http.ResponseWriter, r *http.Request) {
 
 	switch r.Method {
 	case http.MethodGet:
-		data := &templateData{
-			IsLoggedIn:   loggedIn,
-			LoggedInUser: loggedInUser,
-			CurrentPage:  r.URL.Path,
-		}
-
-		if err := app.renderTemplate(w, r, "create.page.html", data); err != nil {
-			logger.ErrorLogger.Printf("Error rendering template: %v\n", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-		}
+		app.renderPostForm(w, r, models.Post{}, nil, nil)
 
 	case http.MethodPost:
-		if err := r.ParseMultipartForm(32 << 20); err != nil {
-			logger.ErrorLogger.Printf("Error parsing multipart form: %s\n", err)
-			http.Error(w, "Unable to parse form", http.StatusBadRequest)
-			return
-		}
-		title := r.PostForm.Get("title")
-		content := r.PostForm.Get("content")
-		categories := r.PostForm["categories"]
-		category := strings.Join(categories, "; ")
-
-		// Get the file from the form data
-		image, handler, err := r.FormFile("image")
-		if err != nil {
-			logger.ErrorLogger.Printf("Error getting image from form data: %s\n", err)
-			image = nil
-			handler = nil
-		} else {
-			defer image.Close()
-		}
-
-		var formErrors map[string]string
-		var post models.Post
-
-		if image != nil {
-			// an image was uploaded
-			extension := filepath.Ext(handler.Filename)
-
-			formErrors = validateCreatePostForm(title, content, extension, categories, handler)
-			if len(formErrors) == 0 {
-
-				filePath, err := app.UploadImage(image, extension)
-				if err != nil {
-					logger.ErrorLogger.Printf("Error creating post with image: %s\n", err)
-					http.Error(w, err.Error(), http.StatusInternalServerError)
-					return
-				}
-
-				post = models.Post{
-					ID:            uuid.New().String(),
-					UserID:        loggedInUser.ID,
-					Title:         title,
-					Content:       content,
-					ImageFullPath: filePath,
-					Category:      category,
-					CreatedAt:     time.Now(),
-				}
-			}
-		} else {
-			// no image was uploaded
-			formErrors = validateCreatePostFormWithoutImage(title, content, categories)
-
-			if len(formErrors) == 0 {
-				post = models.Post{
-					ID:        uuid.New().String(),
-					UserID:    loggedInUser.ID,
-					Title:     title,
-					Content:   content,
-					Category:  category,
-					CreatedAt: time.Now(),
-				}
-			}
-		}
-
-		if len(formErrors) > 0 {
-			data := &templateData{
-				FormErrors: formErrors,
-				FormData:   r.PostForm,
-			}
-
-			if err := app.renderTemplate(w, r, "create.page.html", data); err != nil {
-				logger.ErrorLogger.Printf("Error rendering template: %v\n", err)
-				http.Error(w, "Internal server error", http.StatusInternalServerError)
-			}
-			return
-		}
-
-		if _, err := models.CreatePost(app.db, post); err != nil {
-			logger.ErrorLogger.Printf("Error creating post: %v\n", err)
-			http.Error(w, "Unable to create post", http.StatusInternalServerError)
-			return
-		}
-
-		logger.InfoLogger.Printf("Post created: ID=%s, Title=%s, Author=%s\n", post.ID, post.Title, loggedInUser.Name)
-		http.Redirect(w, r, "/post?id="+post.ID, http.StatusSeeOther)
+		app.submitPost(w, r, loggedInUser, models.Post{})
 
 	default:
 		w.Header().Set("Allow", http.MethodPost)
@@ -583,6 +505,16 @@ func (app *application) createComment(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		if !app.acceptsReplies(post_id) {
+			http.NotFound(w, r)
+			return
+		}
+
+		if app.threadClosed(post_id) {
+			http.Error(w, "This thread is closed to new comments", http.StatusForbidden)
+			return
+		}
+
 		comment := r.PostForm.Get("comment")
 		formErrors := validateCreateCommentForm(comment)
 
@@ -604,56 +536,45 @@ func (app *application) createComment(w http.ResponseWriter, r *http.Request) {
 
 			for i := range comments {
 				comments[i].IsLoggedIn = isLoggedIn
-				commentLikes, err := models.CommentLikeCountByCommentID(app.db, comments[i].ID)
-				if err != nil {
-					logger.ErrorLogger.Println("Error getting like:", err)
-					http.Error(w, "Like(s) not found", http.StatusNotFound)
-					return
-				}
-				comments[i].Likes = commentLikes
-
-				commentDislikes, err := models.CommentDislikeCountByCommentID(app.db, comments[i].ID)
-				if err != nil {
-					logger.ErrorLogger.Println("Error getting dislike:", err)
-					http.Error(w, "Dislike(s) not found", http.StatusNotFound)
-					return
-				}
-				comments[i].Dislikes = commentDislikes
 			}
 
-			commentsCount, err := models.CommentCountByPostID(app.db, post.ID)
-			if err != nil {
-				logger.ErrorLogger.Println("Error getting post:", err)
-				http.Error(w, "Post not found", http.StatusNotFound)
-				return
+			if err := app.loadPoll(&post, user.ID); err != nil {
+				logger.ErrorLogger.Println("Error getting poll:", err)
 			}
 
-			postLikes, err := models.PostLikeCountByPostID(app.db, post.ID)
+			post.Tags, err = models.GetTagsByPostID(app.db, post.ID)
 			if err != nil {
-				logger.ErrorLogger.Println("Error getting like:", err)
-				http.Error(w, "Like(s) not found", http.StatusNotFound)
-				return
+				logger.ErrorLogger.Println("Error getting tags:", err)
 			}
 
-			postDislikes, err := models.PostDislikeCountByPostID(app.db, post.ID)
-			if err != nil {
-				logger.ErrorLogger.Println("Error getting dislike:", err)
-				http.Error(w, "Dislike(s) not found", http.StatusNotFound)
-				return
-			}
+			app.renderPostContent(&post)
+			app.renderCommentContents(comments)
 
 			data := &templateData{
 				Post:          post,
 				IsLoggedIn:    isLoggedIn,
 				LoggedInUser:  user,
 				Comments:      comments,
-				CommentsCount: commentsCount,
-				PostLikes:     postLikes,
-				PostDislikes:  postDislikes,
+				CommentsCount: post.CommentsCount,
+				PostLikes:     post.Likes,
+				PostDislikes:  post.Dislikes,
 				FormErrors:    formErrors,
 				FormData:      r.PostForm,
 			}
 
+			if err := app.loadAnswer(data); err != nil {
+				logger.ErrorLogger.Println("Error getting answer:", err)
+			}
+			if err := app.loadUserReactions(data, user.ID); err != nil {
+				logger.ErrorLogger.Println("Error getting reactions:", err)
+			}
+			if err := app.loadEmojiReactions(data, user.ID); err != nil {
+				logger.ErrorLogger.Println("Error getting emoji reactions:", err)
+			}
+			if err := app.loadBookmarks(data, user.ID, post.ID); err != nil {
+				logger.ErrorLogger.Println("Error getting bookmarks:", err)
+			}
+
 			if err := app.renderTemplate(w, r, "show.page.html", data); err != nil {
 				logger.ErrorLogger.Printf("Error rendering template: %v\n", err)
 				http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -669,12 +590,38 @@ func (app *application) createComment(w http.ResponseWriter, r *http.Request) {
 			CreatedAt: time.Now(),
 		}
 
+		result, err := app.scoreContent(user, comment)
+		if err != nil {
+			logger.ErrorLogger.Println("Error checking comment for spam:", err)
+			http.Error(w, "Unable to create comment", http.StatusInternalServerError)
+			return
+		}
+
+		// Commenting on a thread watches it, unless the user chose otherwise
+		if err := models.WatchThreadIfUnset(app.db, user.ID, post_id); err != nil {
+			logger.ErrorLogger.Println("Error watching thread:", err)
+		}
+
+		if app.spamRules.Held(result) {
+			if err := models.HoldComment(app.db, comment_content, result.Score, result.Reasons); err != nil {
+				http.Error(w, "Unable to create comment", http.StatusInternalServerError)
+				return
+			}
+
+			logger.InfoLogger.Printf("Comment held for review: ID=%s, Author=%s, Score=%d\n", comment_content.ID, user.Name, result.Score)
+			app.notifyAuthor(user.ID, "Your comment is waiting for a moderator and will appear once it is approved.", "/post?id="+post_id)
+			http.Redirect(w, r, "/post?id="+post_id, http.StatusSeeOther)
+			return
+		}
+
 		if _, err := models.CreateComment(app.db, comment_content); err != nil {
 			logger.ErrorLogger.Println("Error with creating comment:", err)
 			http.Error(w, "Unable to create comment", http.StatusInternalServerError)
 			return
 		}
 
+		app.notifyWatchers(comment_content, user.Name)
+
 		http.Redirect(w, r, "/post?id="+post_id, http.StatusSeeOther)
 
 	default:
@@ -708,6 +655,21 @@ func (app *application) createPostReaction(w http.ResponseWriter, r *http.Reques
 		post_id := r.FormValue("post_id")
 		reactionType := r.FormValue("reaction_type")
 
+		if reactionType != models.ReactionLike && reactionType != models.ReactionDislike {
+			http.Error(w, "Unknown reaction", http.StatusBadRequest)
+			return
+		}
+
+		if !app.acceptsReplies(post_id) {
+			http.NotFound(w, r)
+			return
+		}
+
+		if app.threadArchived(post_id) {
+			http.Error(w, "This thread is archived", http.StatusForbidden)
+			return
+		}
+
 		reaction := models.PostReaction{
 			ID:           uuid.New().String(),
 			UserID:       user.ID,
@@ -716,7 +678,13 @@ func (app *application) createPostReaction(w http.ResponseWriter, r *http.Reques
 			CreatedAt:    time.Now(),
 		}
 
-		if _, err := models.CreatePostReaction(app.db, reaction); err != nil {
+		// Reacting again with the same type takes the reaction back
+		_, err := models.TogglePostReaction(app.db, reaction)
+		if errors.Is(err, models.ErrReactionTarget) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
 			logger.ErrorLogger.Printf("Error with creating a reaction: %s\n", err)
 			http.Error(w, "Unable to create reaction", http.StatusInternalServerError)
 			return
@@ -756,6 +724,16 @@ func (app *application) createCommentReaction(w http.ResponseWriter, r *http.Req
 		comment_id := r.FormValue("comment_id")
 		reactionType := r.FormValue("reaction_type")
 
+		if reactionType != models.ReactionLike && reactionType != models.ReactionDislike {
+			http.Error(w, "Unknown reaction", http.StatusBadRequest)
+			return
+		}
+
+		if app.threadArchived(post_id) {
+			http.Error(w, "This thread is archived", http.StatusForbidden)
+			return
+		}
+
 		reaction := models.CommentReaction{
 			ID:           uuid.New().String(),
 			UserID:       user.ID,
@@ -765,7 +743,12 @@ func (app *application) createCommentReaction(w http.ResponseWriter, r *http.Req
 			CreatedAt:    time.Now(),
 		}
 
-		if _, err := models.CreateCommentReaction(app.db, reaction); err != nil {
+		_, err := models.ToggleCommentReaction(app.db, reaction)
+		if errors.Is(err, models.ErrReactionTarget) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
 			logger.ErrorLogger.Printf("Error with creating reaction %s\n", err)
 			http.Error(w, "Unable to create reaction", http.StatusInternalServerError)
 			return
@@ -808,31 +791,8 @@ func (app *application) search(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Post(s) not found in search", http.StatusNotFound)
 	}
 
-	for i := range posts {
-		count, err := models.CommentCountByPostID(app.db, posts[i].ID)
-		if err != nil {
-			logger.ErrorLogger.Println("Error getting comment:", err)
-			http.Error(w, "Failed to get comment count", http.StatusInternalServerError)
-			return
-		}
-		posts[i].CommentsCount = count
-	}
-
-	for i := range posts {
-		likes, err := models.PostLikeCountByPostID(app.db, posts[i].ID)
-		if err != nil {
-			logger.ErrorLogger.Println("Error getting like:", err)
-			http.Error(w, "Like(s) not found", http.StatusNotFound)
-		}
-		posts[i].Likes = likes
-
-		dislikes, err := models.PostDislikeCountByPostID(app.db, posts[i].ID)
-		if err != nil {
-			logger.ErrorLogger.Println("Error getting dislike:", err)
-			http.Error(w, "Dislike(s) not found", http.StatusNotFound)
-		}
-		posts[i].Dislikes = dislikes
-	}
+	app.attachImageVariants(posts)
+	app.attachTags(posts)
 
 	data := &templateData{
 		Posts:        posts,
@@ -865,8 +825,10 @@ func (app *application) filter(w http.ResponseWriter, r *http.Request) {
 		}
 
 		categories := r.PostForm["category-filter"]
+		tags := splitTags(r.PostForm.Get("tag-filter"))
 		fromDate := r.FormValue("date-filter")
 		likesStr := r.PostForm["likes-filter"]
+		answers := r.PostForm.Get("answers-filter")
 
 		// Convert likes filter to []int
 		var likes []int
@@ -880,42 +842,21 @@ func (app *application) filter(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// Get filtered posts
-		posts, err := models.GetPostsWithFilters(app.db, categories, fromDate, likes)
+		posts, err := models.GetPostsWithFilters(app.db, categories, tags, fromDate, likes, answers)
 		if err != nil {
 			logger.ErrorLogger.Println("Error getting post:", err)
 			http.Error(w, "Post(s) not found", http.StatusNotFound)
 			return
 		}
 
-		// Update post counts based on filters
-		for i := range posts {
-			count, err := models.CommentCountByPostID(app.db, posts[i].ID)
-			if err != nil {
-				logger.ErrorLogger.Println("Error getting comment:", err)
-				http.Error(w, "Failed to get comment count", http.StatusInternalServerError)
-				return
-			}
-			posts[i].CommentsCount = count
-
-			likes, err := models.PostLikeCountByPostID(app.db, posts[i].ID)
-			if err != nil {
-				logger.ErrorLogger.Println("Error getting like:", err)
-				http.Error(w, "Like(s) not found", http.StatusNotFound)
-			}
-			posts[i].Likes = likes
-
-			dislikes, err := models.PostDislikeCountByPostID(app.db, posts[i].ID)
-			if err != nil {
-				logger.ErrorLogger.Println("Error getting dislike:", err)
-				http.Error(w, "Dislike(s) not found", http.StatusNotFound)
-			}
-			posts[i].Dislikes = dislikes
-		}
+		app.attachImageVariants(posts)
+		app.attachTags(posts)
 
 		data := &templateData{
 			Posts:        posts,
 			IsLoggedIn:   isLoggedIn,
 			LoggedInUser: loggedInUser,
+			FormData:     r.PostForm,
 		}
 
 		if err := app.renderTemplate(w, r, "home.page.html", data); err != nil {
@@ -963,7 +904,7 @@ func (app *application) signup(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		hashedPassword, err := HashPassword(password)
+		hashedPassword, err := app.hashPassword(password)
 		if err != nil {
 			log.Fatal(err)
 			logger.ErrorLogger.Println("Error creating user:", err)
@@ -1020,8 +961,22 @@ func (app *application) login(w http.ResponseWriter, r *http.Request) {
 
 		errors := validateSingInForm(email, password)
 
-		id, err := models.AuthenticateUser(app.db, email, password)
+		if wait, locked := app.loginWait(r, email); wait > 0 {
+			errors["generic"] = throttledLoginMessage(w, wait, locked)
+			data := &templateData{
+				FormErrors: errors,
+				FormData:   r.PostForm,
+			}
+			if err := app.renderTemplateWithStatus(w, r, http.StatusTooManyRequests, "login.page.html", data); err != nil {
+				logger.ErrorLogger.Printf("Error rendering template: %v\n", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		id, err := models.AuthenticateUser(app.db, app.passwords, email, password)
 		if err != nil {
+			app.recordLoginFailure(r, email)
 			errors["generic"] = "Email or Password is incorrect"
 			data := &templateData{
 				FormErrors: errors,
@@ -1043,6 +998,24 @@ func (app *application) login(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		user, err := models.GetUserByID(app.db, id)
+		if err != nil {
+			logger.ErrorLogger.Printf("Error getting user: %v\n", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if msg := app.suspensionMessage(user.ID); msg != "" {
+			app.renderError(w, r, http.StatusForbidden, msg)
+			return
+		}
+
+		if user.TOTPEnabled {
+			app.startTwoFactorChallenge(w, r, user)
+			return
+		}
+
+		app.clearLoginFailures(email)
 		app.SetSession(w, r, id)
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 
@@ -1060,6 +1033,11 @@ func (app *application) logout(w http.ResponseWriter, r *http.Request) {
 		http.NotFound(w, r)
 		return
 	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
 	if !isLoggedIn {
 		http.Redirect(w, r, "/login", http.StatusSeeOther)
 		return
@@ -1111,13 +1089,32 @@ func (app *application) handleGoogleCallback(w http.ResponseWriter, r *http.Requ
 
 	dbUser, _ := models.GetUserByEmail(app.db, GoogleUser.Email)
 	if dbUser.Email == GoogleUser.Email {
+		if msg := app.suspensionMessage(dbUser.ID); msg != "" {
+			app.renderError(w, r, http.StatusForbidden, msg)
+			return
+		}
+
+		// Signing in with Google doesn't skip the second step
+		user, err := models.GetUserByID(app.db, dbUser.ID)
+		if err != nil {
+			logger.ErrorLogger.Printf("Error getting user: %v\n", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if user.TOTPEnabled {
+			app.startTwoFactorChallenge(w, r, user)
+			return
+		}
+
 		// Set the session cookie
 		cookie := http.Cookie{
-			Name:    "session",
-			Value:   token.AccessToken,
-			Expires: time.Now().Add(2 * time.Hour),
-			Path:    "/",
-			Secure:  true,
+			Name:     "session",
+			Value:    token.AccessToken,
+			Expires:  time.Now().Add(2 * time.Hour),
+			Path:     "/",
+			HttpOnly: true,
+			Secure:   true,
+			SameSite: http.SameSiteLaxMode,
 		}
 		http.SetCookie(w, &cookie)
 
@@ -1150,10 +1147,13 @@ func (app *application) handleGoogleCallback(w http.ResponseWriter, r *http.Requ
 
 		// Set the session cookie
 		cookie := http.Cookie{
-			Name:    "session",
-			Value:   token.AccessToken,
-			Expires: time.Now().Add(2 * time.Hour),
-			Path:    "/",
+			Name:     "session",
+			Value:    token.AccessToken,
+			Expires:  time.Now().Add(2 * time.Hour),
+			Path:     "/",
+			HttpOnly: true,
+			Secure:   true,
+			SameSite: http.SameSiteLaxMode,
 		}
 		http.SetCookie(w, &cookie)
 
@@ -1216,13 +1216,31 @@ func (app *application) handleGithubCallback(w http.ResponseWriter, r *http.Requ
 
 	dbUser, _ := models.GetUserByEmail(app.db, GithubUser.UserInfo.Email)
 	if dbUser.Email == GithubUser.UserInfo.Email {
+		if msg := app.suspensionMessage(dbUser.ID); msg != "" {
+			app.renderError(w, r, http.StatusForbidden, msg)
+			return
+		}
+
+		// Signing in with GitHub doesn't skip the second step
+		user, err := models.GetUserByID(app.db, dbUser.ID)
+		if err != nil {
+			logger.ErrorLogger.Printf("Error getting user: %v\n", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if user.TOTPEnabled {
+			app.startTwoFactorChallenge(w, r, user)
+			return
+		}
 
 		cookie := http.Cookie{
-			Name:    "session",
-			Value:   githubAccessToken,
-			Expires: time.Now().Add(2 * time.Hour),
-			Path:    "/",
-			Secure:  true,
+			Name:     "session",
+			Value:    githubAccessToken,
+			Expires:  time.Now().Add(2 * time.Hour),
+			Path:     "/",
+			HttpOnly: true,
+			Secure:   true,
+			SameSite: http.SameSiteLaxMode,
 		}
 		http.SetCookie(w, &cookie)
 
@@ -1254,11 +1272,13 @@ func (app *application) handleGithubCallback(w http.ResponseWriter, r *http.Requ
 		}
 
 		cookie := http.Cookie{
-			Name:    "session",
-			Value:   githubAccessToken,
-			Expires: time.Now().Add(2 * time.Hour),
-			Path:    "/",
-			Secure:  true,
+			Name:     "session",
+			Value:    githubAccessToken,
+			Expires:  time.Now().Add(2 * time.Hour),
+			Path:     "/",
+			HttpOnly: true,
+			Secure:   true,
+			SameSite: http.SameSiteLaxMode,
 		}
 		http.SetCookie(w, &cookie)
 
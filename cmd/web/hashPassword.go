@@ -1,11 +1,59 @@
 package main
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"fmt"
+	"os"
+	"strconv"
 
-func HashPassword(password string) ([]byte, error) {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
-	if err != nil {
-		return nil, err
+	"forum/pkg/password"
+)
+
+// loadPasswordHasher configures how new passwords are hashed. PASSWORD_HASH
+// picks the algorithm (argon2id or bcrypt), ARGON2_MEMORY (KiB),
+// ARGON2_ITERATIONS, ARGON2_PARALLELISM and BCRYPT_COST its cost. Stored hashes
+// made with other settings are upgraded when their owner next logs in.
+func loadPasswordHasher() (password.Hasher, error) {
+	hasher := password.DefaultHasher
+
+	if algorithm := os.Getenv("PASSWORD_HASH"); algorithm != "" {
+		if algorithm != password.Argon2id && algorithm != password.Bcrypt {
+			return hasher, fmt.Errorf("unknown PASSWORD_HASH %q", algorithm)
+		}
+		hasher.Algorithm = algorithm
+	}
+
+	for key, target := range map[string]*uint32{
+		"ARGON2_MEMORY":     &hasher.Argon2.Memory,
+		"ARGON2_ITERATIONS": &hasher.Argon2.Iterations,
+	} {
+		if value := os.Getenv(key); value != "" {
+			n, err := strconv.ParseUint(value, 10, 32)
+			if err != nil || n == 0 {
+				return hasher, fmt.Errorf("invalid %s %q", key, value)
+			}
+			*target = uint32(n)
+		}
+	}
+
+	if value := os.Getenv("ARGON2_PARALLELISM"); value != "" {
+		n, err := strconv.ParseUint(value, 10, 8)
+		if err != nil || n == 0 {
+			return hasher, fmt.Errorf("invalid ARGON2_PARALLELISM %q", value)
+		}
+		hasher.Argon2.Parallelism = uint8(n)
 	}
-	return hashedPassword, nil
+
+	if value := os.Getenv("BCRYPT_COST"); value != "" {
+		cost, err := strconv.Atoi(value)
+		if err != nil || cost < 10 || cost > 31 {
+			return hasher, fmt.Errorf("invalid BCRYPT_COST %q, must be between 10 and 31", value)
+		}
+		hasher.BcryptCost = cost
+	}
+
+	return hasher, nil
+}
+
+func (app *application) hashPassword(plaintext string) ([]byte, error) {
+	return app.passwords.Hash(plaintext)
 }
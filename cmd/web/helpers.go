@@ -2,11 +2,13 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"time"
 
 	"forum/logger"
+	"forum/pkg/models"
 )
 
 func (app *application) addDefaultData(td *templateData, r *http.Request) *templateData {
@@ -14,10 +16,19 @@ func (app *application) addDefaultData(td *templateData, r *http.Request) *templ
 		td = &templateData{}
 	}
 	td.CurrentYear = time.Now().Year()
+	td.CSRFToken = csrfToken(r)
+	td.CSPNonce = cspNonce(r)
+	if td.IsLoggedIn {
+		td.UnreadNotifications, _ = models.CountUnreadNotifications(app.db, td.LoggedInUser.ID)
+	}
 	return td
 }
 
 func (app *application) renderTemplate(w http.ResponseWriter, r *http.Request, name string, td *templateData) error {
+	return app.renderTemplateWithStatus(w, r, http.StatusOK, name, td)
+}
+
+func (app *application) renderTemplateWithStatus(w http.ResponseWriter, r *http.Request, status int, name string, td *templateData) error {
 	ts, ok := app.templateCache[name]
 	if !ok {
 		logger.ErrorLogger.Println("The template does not exist")
@@ -32,6 +43,38 @@ func (app *application) renderTemplate(w http.ResponseWriter, r *http.Request, n
 		return err
 	}
 
+	w.WriteHeader(status)
 	buf.WriteTo(w)
 	return nil
 }
+
+// renderError shows the error page with the given status and a message for the user.
+func (app *application) renderError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	loggedInUser, isLoggedIn := app.GetUserFromSession(r)
+
+	data := &templateData{
+		IsLoggedIn:   isLoggedIn,
+		LoggedInUser: loggedInUser,
+		StatusCode:   status,
+		StatusText:   http.StatusText(status),
+		ErrorMessage: message,
+	}
+
+	if err := app.renderTemplateWithStatus(w, r, status, "error.page.html", data); err != nil {
+		logger.ErrorLogger.Printf("Error rendering template: %v\n", err)
+		http.Error(w, message, status)
+	}
+}
+
+// writeJSON sends v as a JSON response, used by the endpoints called from scripts.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.ErrorLogger.Printf("Error encoding JSON: %v\n", err)
+	}
+}
+
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"database/sql"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"forum/logger"
+	"forum/pkg/models"
+)
+
+// ipBanRefresh is how often the ban list is reloaded, which picks up expired
+// bans and changes made by other instances.
+const ipBanRefresh = time.Minute
+
+// ipBanList caches the active IP bans so the middleware doesn't query the
+// database on every request.
+type ipBanList struct {
+	db *sql.DB
+
+	mu       sync.RWMutex
+	networks []*net.IPNet
+	loadedAt time.Time
+}
+
+func newIPBanList(db *sql.DB) *ipBanList {
+	return &ipBanList{db: db}
+}
+
+// reload reads the active bans from the database.
+func (l *ipBanList) reload() error {
+	bans, err := models.GetActiveIPBans(l.db)
+	if err != nil {
+		return err
+	}
+
+	var networks []*net.IPNet
+	for _, ban := range bans {
+		network, err := parseNetwork(ban.CIDR)
+		if err != nil {
+			logger.ErrorLogger.Printf("Skipping invalid IP ban %s: %v\n", ban.CIDR, err)
+			continue
+		}
+		networks = append(networks, network)
+	}
+
+	l.mu.Lock()
+	l.networks = networks
+	l.loadedAt = time.Now()
+	l.mu.Unlock()
+
+	return nil
+}
+
+func (l *ipBanList) contains(ip net.IP) bool {
+	l.mu.RLock()
+	stale := time.Since(l.loadedAt) > ipBanRefresh
+	l.mu.RUnlock()
+
+	if stale {
+		if err := l.reload(); err != nil {
+			logger.ErrorLogger.Printf("Error reloading IP bans: %v\n", err)
+		}
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	for _, network := range l.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// blockBannedIPs refuses every request from a banned address.
+func (app *application) blockBannedIPs(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ip := net.ParseIP(app.clientIP(r)); ip != nil && app.ipBans.contains(ip) {
+			http.Error(w, "Access from your network has been blocked.", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"forum/logger"
+	"forum/pkg/models"
+)
+
+// Failed logins are counted per account and per IP address. After the free
+// attempts every further failure doubles the wait before the next attempt, and
+// an account is locked for a while after accountLockoutThreshold failures.
+const (
+	accountFreeAttempts     = 3
+	ipFreeAttempts          = 10
+	maxLoginBackoff         = 15 * time.Minute
+	accountLockoutThreshold = 10
+	accountLockoutDuration  = 30 * time.Minute
+	loginFailureWindow      = 24 * time.Hour
+)
+
+// loginBackoff is the wait after failures failed logins.
+func loginBackoff(failures, free int) time.Duration {
+	if failures < free {
+		return 0
+	}
+
+	shift := failures - free
+	if shift > 20 {
+		return maxLoginBackoff
+	}
+
+	backoff := time.Second << shift
+	if backoff > maxLoginBackoff {
+		return maxLoginBackoff
+	}
+	return backoff
+}
+
+// throttleWait is how long the throttle still blocks logins, zero if it does not.
+func throttleWait(throttle models.LoginThrottle, free int, now time.Time) time.Duration {
+	if throttle.LockedUntil.Valid && throttle.LockedUntil.Time.After(now) {
+		return throttle.LockedUntil.Time.Sub(now)
+	}
+
+	if throttle.Failures == 0 {
+		return 0
+	}
+
+	wait := throttle.LastFailureAt.Add(loginBackoff(throttle.Failures, free)).Sub(now)
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+func accountKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// loginWait returns how long the client has to wait before it may try to log
+// in to the account again, and whether the account itself is locked.
+func (app *application) loginWait(r *http.Request, email string) (time.Duration, bool) {
+	now := time.Now()
+
+	account, err := models.GetLoginThrottle(app.db, models.ThrottleAccount, accountKey(email))
+	if err != nil {
+		return 0, false
+	}
+
+	ip, err := models.GetLoginThrottle(app.db, models.ThrottleIP, app.clientIP(r))
+	if err != nil {
+		return 0, false
+	}
+
+	locked := account.LockedUntil.Valid && account.LockedUntil.Time.After(now)
+
+	wait := throttleWait(account, accountFreeAttempts, now)
+	if ipWait := throttleWait(ip, ipFreeAttempts, now); ipWait > wait {
+		wait = ipWait
+	}
+
+	return wait, locked
+}
+
+// recordLoginFailure counts a failed login against the account and the client's
+// IP address, locking the account and notifying its owner when it crosses the
+// lockout threshold.
+func (app *application) recordLoginFailure(r *http.Request, email string) {
+	ip := app.clientIP(r)
+
+	if _, err := models.RecordLoginFailure(app.db, models.ThrottleIP, ip, loginFailureWindow); err != nil {
+		return
+	}
+
+	key := accountKey(email)
+	if key == "" {
+		return
+	}
+
+	account, err := models.RecordLoginFailure(app.db, models.ThrottleAccount, key, loginFailureWindow)
+	if err != nil || account.Failures%accountLockoutThreshold != 0 {
+		return
+	}
+
+	until := time.Now().Add(accountLockoutDuration)
+	if err := models.LockLogin(app.db, models.ThrottleAccount, key, until); err != nil {
+		return
+	}
+	logger.InfoLogger.Printf("Locked account %s after %d failed logins, last from %s\n", key, account.Failures, ip)
+
+	user, err := models.GetUserByEmail(app.db, strings.TrimSpace(email))
+	if err != nil {
+		return
+	}
+
+	notification := models.Notification{
+		UserID: user.ID,
+		Kind:   models.NotificationAccountLocked,
+		Message: fmt.Sprintf("Your account was locked for %s after %d failed login attempts, the last one from %s. If this wasn't you, change your password and turn on two-factor authentication.",
+			formatWait(accountLockoutDuration), account.Failures, ip),
+		Link: "/user/settings/security",
+	}
+	if _, err := models.CreateNotification(app.db, notification); err != nil {
+		logger.ErrorLogger.Printf("Error notifying user %s about lockout: %v\n", user.ID, err)
+	}
+}
+
+func (app *application) clearLoginFailures(email string) {
+	models.ClearLoginFailures(app.db, models.ThrottleAccount, accountKey(email))
+}
+
+// throttledLoginMessage tells the user how long to wait and sets Retry-After.
+func throttledLoginMessage(w http.ResponseWriter, wait time.Duration, locked bool) string {
+	w.Header().Set("Retry-After", strconv.Itoa(int((wait+time.Second-1)/time.Second)))
+
+	if locked {
+		return fmt.Sprintf("This account is temporarily locked after too many failed login attempts. Try again in %s or contact an admin.", formatWait(wait))
+	}
+	return fmt.Sprintf("Too many failed login attempts. Try again in %s.", formatWait(wait))
+}
+
+func formatWait(d time.Duration) string {
+	if d < time.Minute {
+		seconds := int((d + time.Second - 1) / time.Second)
+		if seconds == 1 {
+			return "1 second"
+		}
+		return fmt.Sprintf("%d seconds", seconds)
+	}
+
+	minutes := int((d + time.Minute - 1) / time.Minute)
+	if minutes == 1 {
+		return "1 minute"
+	}
+	return fmt.Sprintf("%d minutes", minutes)
+}
@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"fmt"
 	"html/template"
+	"net"
 	"net/http"
 	"os"
 	"strings"
@@ -12,8 +13,13 @@ import (
 
 	"forum/configs"
 	"forum/logger"
+	"forum/pkg/blobstore"
 	"forum/pkg/models"
 	"forum/pkg/models/sqlite"
+	"forum/pkg/password"
+	"forum/pkg/ratelimit"
+	"forum/pkg/spam"
+	"forum/pkg/webauthn"
 	"forum/utils"
 )
 
@@ -23,12 +29,27 @@ const (
 )
 
 type application struct {
-	templateCache map[string]*template.Template
-	posts         *models.Post
-	comments      *models.Comment
-	users         *models.User
-	session       *models.Session
-	db            *sql.DB
+	templateCache        map[string]*template.Template
+	posts                *models.Post
+	comments             *models.Comment
+	users                *models.User
+	session              *models.Session
+	db                   *sql.DB
+	csrfKey              []byte
+	webauthn             webauthn.Config
+	limiter              *ratelimit.Limiter
+	rateLimits           rateLimitPolicies
+	trustedProxies       []*net.IPNet
+	securityPolicy       securityPolicy
+	passwords            password.Hasher
+	breachedPasswords    *password.BreachedList
+	ipBans               *ipBanList
+	blobs                blobstore.BlobStore
+	uploadGC             uploadGC
+	uploadQuota          int64
+	accountDeletionDelay time.Duration
+	spamRules            spam.Rules
+	reactions            []string
 }
 
 func init() {
@@ -71,6 +92,8 @@ func main() {
 		comments:      &models.Comment{},
 		users:         &models.User{},
 		session:       &models.Session{},
+		csrfKey:       loadCSRFKey(),
+		webauthn:      loadWebAuthnConfig(),
 	}
 
 	app.db, err = sqlite.ConnectDB()
@@ -79,6 +102,73 @@ func main() {
 	}
 	defer app.db.Close()
 
+	app.passwords, err = loadPasswordHasher()
+	if err != nil {
+		logger.ErrorLogger.Fatalf("Error configuring password hashing: %v", err)
+	}
+
+	// BREACHED_PASSWORDS_FILE can point to a larger list, e.g. a Have I Been Pwned download
+	app.breachedPasswords, err = password.LoadBreachedList(os.Getenv("BREACHED_PASSWORDS_FILE"))
+	if err != nil {
+		logger.ErrorLogger.Fatalf("Error loading breached passwords: %v", err)
+	}
+
+	app.ipBans = newIPBanList(app.db)
+	if err := app.ipBans.reload(); err != nil {
+		logger.ErrorLogger.Fatalf("Error loading IP bans: %v", err)
+	}
+
+	app.blobs, err = loadUploadStore()
+	if err != nil {
+		logger.ErrorLogger.Fatalf("Error configuring upload storage: %v", err)
+	}
+	app.securityPolicy = loadSecurityPolicy(app.blobs.Origin())
+
+	app.uploadGC, err = loadUploadGC()
+	if err != nil {
+		logger.ErrorLogger.Fatalf("Error configuring upload collection: %v", err)
+	}
+	go app.collectUploads()
+
+	app.uploadQuota, err = loadUploadQuota()
+	if err != nil {
+		logger.ErrorLogger.Fatalf("Error configuring upload quota: %v", err)
+	}
+
+	app.accountDeletionDelay, err = loadAccountDeletionDelay()
+	if err != nil {
+		logger.ErrorLogger.Fatalf("Error configuring account deletion: %v", err)
+	}
+	go app.purgeAccounts()
+	go app.publishScheduledPosts()
+	go app.archiveThreads()
+
+	app.spamRules, err = loadSpamRules()
+	if err != nil {
+		logger.ErrorLogger.Fatalf("Error loading spam rules: %v", err)
+	}
+
+	app.reactions, err = loadReactions()
+	if err != nil {
+		logger.ErrorLogger.Fatalf("Error loading reactions: %v", err)
+	}
+
+	app.trustedProxies, err = loadTrustedProxies()
+	if err != nil {
+		logger.ErrorLogger.Fatalf("Error loading trusted proxies: %v", err)
+	}
+
+	app.rateLimits, err = loadRateLimitPolicies()
+	if err != nil {
+		logger.ErrorLogger.Fatalf("Error loading rate limits: %v", err)
+	}
+
+	store, err := app.newRateLimitStore()
+	if err != nil {
+		logger.ErrorLogger.Fatalf("Error creating rate limit store: %v", err)
+	}
+	app.limiter = ratelimit.New(store)
+
 	// configs.InsertDummyData(app.db)
 
 	// Configure TLS
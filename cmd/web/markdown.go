@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"forum/logger"
+	"forum/pkg/markdown"
+	"forum/pkg/models"
+)
+
+// maxPreviewBodySize is well above the longest content the forms accept, as
+// the preview shows whatever is typed, even past the limit.
+const maxPreviewBodySize = 64 << 10
+
+// renderPostContent sets post.ContentHTML, rendering the Markdown and caching
+// the result unless the cached HTML is current.
+func (app *application) renderPostContent(post *models.Post) {
+	if post.ContentHTMLVersion == markdown.Version {
+		return
+	}
+
+	post.ContentHTML = markdown.Render(post.Content)
+	post.ContentHTMLVersion = markdown.Version
+	if err := models.SavePostContentHTML(app.db, post.ID, post.Content, post.ContentHTML, markdown.Version); err != nil {
+		// the page can still be shown, the next view will try again
+		logger.ErrorLogger.Printf("Error caching post HTML: %v\n", err)
+	}
+}
+
+// renderCommentContents sets ContentHTML on each comment like
+// renderPostContent.
+func (app *application) renderCommentContents(comments []models.Comment) {
+	for i := range comments {
+		comment := &comments[i]
+		if comment.ContentHTMLVersion == markdown.Version {
+			continue
+		}
+
+		comment.ContentHTML = markdown.Render(comment.Content)
+		comment.ContentHTMLVersion = markdown.Version
+		if err := models.SaveCommentContentHTML(app.db, comment.ID, comment.Content, comment.ContentHTML, markdown.Version); err != nil {
+			logger.ErrorLogger.Printf("Error caching comment HTML: %v\n", err)
+		}
+	}
+}
+
+// markdownPreview renders the Markdown the user is typing in the post and
+// comment forms. Nothing is cached, as the text is still changing.
+func (app *application) markdownPreview(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/post/preview" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body struct {
+		Content string `json:"content"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxPreviewBodySize)).Decode(&body); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Unable to read content")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"html": string(markdown.Render(body.Content))})
+}
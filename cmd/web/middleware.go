@@ -3,60 +3,44 @@ package main
 import (
 	"net/http"
 	"strings"
-	"sync"
-	"time"
 )
 
-func secureHeaders(next http.Handler) http.Handler {
+func (app *application) requireLogin(handler http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("X-XSS-Protection", "1; mode=block")
-		w.Header().Set("X-Frame-Options", "deny")
-
-		next.ServeHTTP(w, r)
-	})
-}
-
-func rateLimiter(next http.Handler) http.Handler {
-	var (
-		mutex   = &sync.Mutex{}
-		counter = make(map[string]int)
-	)
-
-	go func() {
-		for {
-			time.Sleep(1 * time.Second)
-			mutex.Lock()
-			counter = make(map[string]int)
-			mutex.Unlock()
+		user, loggedIn := app.GetUserFromSession(r)
+		if !loggedIn {
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+			return
 		}
-	}()
-
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ip := r.RemoteAddr
 
-		mutex.Lock()
-		counter[ip]++
-		reqCount := counter[ip]
-		mutex.Unlock()
+		// Roles that must use two-factor can only reach the security settings until they enroll
+		if !strings.HasPrefix(r.URL.Path, "/user/settings/") && app.mustEnrollTwoFactor(user) {
+			http.Redirect(w, r, "/user/settings/security", http.StatusSeeOther)
+			return
+		}
 
-		// Max 5 requests are allowed within a second,
-		if reqCount > 5 {
-			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+		// An account waiting to be deleted can only download its data or cancel
+		if !strings.HasPrefix(r.URL.Path, "/user/settings/") && user.DeletionRequestedAt.Valid {
+			http.Redirect(w, r, "/user/settings/account", http.StatusSeeOther)
 			return
 		}
 
-		next.ServeHTTP(w, r)
+		handler.ServeHTTP(w, r)
 	})
 }
 
-func (app *application) requireLogin(handler http.HandlerFunc) http.HandlerFunc {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		_, loggedIn := app.GetUserFromSession(r)
-		if !loggedIn {
-			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
-			return
+// requireRole only lets logged in users with one of the given roles through.
+func (app *application) requireRole(handler http.HandlerFunc, roles ...string) http.HandlerFunc {
+	return app.requireLogin(func(w http.ResponseWriter, r *http.Request) {
+		user, _ := app.GetUserFromSession(r)
+		for _, role := range roles {
+			if user.Role == role {
+				handler.ServeHTTP(w, r)
+				return
+			}
 		}
-		handler.ServeHTTP(w, r)
+
+		app.renderError(w, r, http.StatusForbidden, "You don't have permission to view this page.")
 	})
 }
 
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"forum/logger"
+	"forum/pkg/models"
+)
+
+const maxReasonLength = 500
+
+// banDurations are the lengths moderators can pick for suspensions and IP
+// bans. "permanent" has no expiry.
+var banDurations = map[string]time.Duration{
+	"1d":  24 * time.Hour,
+	"3d":  3 * 24 * time.Hour,
+	"7d":  7 * 24 * time.Hour,
+	"30d": 30 * 24 * time.Hour,
+}
+
+// banExpiry turns a duration from the form into an expiry time.
+func banExpiry(duration string) (sql.NullTime, bool) {
+	if duration == "permanent" {
+		return sql.NullTime{}, true
+	}
+
+	d, ok := banDurations[duration]
+	if !ok {
+		return sql.NullTime{}, false
+	}
+	return sql.NullTime{Time: time.Now().Add(d), Valid: true}, true
+}
+
+func validateReason(reason string) string {
+	if reason == "" {
+		return "A reason is required"
+	}
+	if utf8.RuneCountInString(reason) > maxReasonLength {
+		return "Reason must not exceed 500 characters"
+	}
+	return ""
+}
+
+// suspensionMessage returns what a suspended user is told when they try to
+// log in, or an empty string when the user is not suspended.
+func (app *application) suspensionMessage(userID string) string {
+	suspension, suspended, err := models.GetActiveSuspension(app.db, userID)
+	if err != nil || !suspended {
+		return ""
+	}
+
+	if suspension.Permanent() {
+		return "Your account has been banned. Reason: " + suspension.Reason
+	}
+	return fmt.Sprintf("Your account is suspended until %s. Reason: %s", humanDate(suspension.ExpiresAt.Time), suspension.Reason)
+}
+
+func (app *application) renderSuspensions(w http.ResponseWriter, r *http.Request, formErrors map[string]string) {
+	loggedInUser, isLoggedIn := app.GetUserFromSession(r)
+
+	suspensions, err := models.GetActiveSuspensions(app.db)
+	if err != nil {
+		logger.ErrorLogger.Printf("Error getting suspensions: %v\n", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	data := &templateData{
+		IsLoggedIn:   isLoggedIn,
+		LoggedInUser: loggedInUser,
+		Suspensions:  suspensions,
+		FormErrors:   formErrors,
+		FormData:     r.PostForm,
+	}
+
+	status := http.StatusOK
+	if len(formErrors) > 0 {
+		status = http.StatusUnprocessableEntity
+	}
+
+	if err := app.renderTemplateWithStatus(w, r, status, "moderation.suspensions.page.html", data); err != nil {
+		logger.ErrorLogger.Printf("Error rendering template: %v\n", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// suspensions lists the suspensions in force and suspends users
+func (app *application) suspensions(w http.ResponseWriter, r *http.Request) {
+	loggedInUser, _ := app.GetUserFromSession(r)
+
+	if r.URL.Path != "/moderation/suspensions" {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		app.renderSuspensions(w, r, nil)
+
+	case http.MethodPost:
+		if err := r.ParseForm(); err != nil {
+			logger.ErrorLogger.Printf("Error parsing a form: %s\n", err)
+			http.Error(w, "Unable to parse form", http.StatusBadRequest)
+			return
+		}
+
+		formErrors := make(map[string]string)
+
+		name := strings.TrimSpace(r.PostForm.Get("user"))
+		found, err := models.GetUserByName(app.db, name)
+		if err != nil || found.ID == "" {
+			found, err = models.GetUserByEmail(app.db, name)
+		}
+
+		var target models.User
+		if err == nil && found.ID != "" {
+			target, err = models.GetUserByID(app.db, found.ID)
+		}
+
+		switch {
+		case name == "":
+			formErrors["user"] = "Enter a user name or email"
+		case err != nil || target.ID == "":
+			formErrors["user"] = "No user with that name or email"
+		case target.ID == loggedInUser.ID:
+			formErrors["user"] = "You cannot suspend yourself"
+		case target.IsStaff() && !loggedInUser.IsAdmin():
+			formErrors["user"] = "Only admins can suspend moderators and admins"
+		}
+
+		expiresAt, ok := banExpiry(r.PostForm.Get("duration"))
+		if !ok {
+			formErrors["duration"] = "Choose how long the suspension lasts"
+		}
+
+		reason := strings.TrimSpace(r.PostForm.Get("reason"))
+		if msg := validateReason(reason); msg != "" {
+			formErrors["reason"] = msg
+		}
+
+		if len(formErrors) > 0 {
+			app.renderSuspensions(w, r, formErrors)
+			return
+		}
+
+		suspension := models.Suspension{
+			UserID:    target.ID,
+			Reason:    reason,
+			CreatedBy: loggedInUser.ID,
+			ExpiresAt: expiresAt,
+		}
+		if _, err := models.SuspendUser(app.db, suspension); err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		logger.InfoLogger.Printf("%s %s suspended user %s (%s): %s\n", loggedInUser.Role, loggedInUser.Name, target.Name, r.PostForm.Get("duration"), reason)
+		http.Redirect(w, r, "/moderation/suspensions", http.StatusSeeOther)
+
+	default:
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (app *application) liftSuspension(w http.ResponseWriter, r *http.Request) {
+	loggedInUser, _ := app.GetUserFromSession(r)
+
+	if r.URL.Path != "/moderation/suspensions/lift" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	suspension, err := models.GetSuspensionByID(app.db, r.PostFormValue("id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	target, err := models.GetUserByID(app.db, suspension.UserID)
+	if err == nil && target.IsStaff() && !loggedInUser.IsAdmin() {
+		app.renderError(w, r, http.StatusForbidden, "Only admins can lift suspensions of moderators and admins.")
+		return
+	}
+
+	if err := models.LiftSuspension(app.db, suspension.ID); err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	logger.InfoLogger.Printf("%s %s lifted the suspension of %s\n", loggedInUser.Role, loggedInUser.Name, suspension.UserName)
+	http.Redirect(w, r, "/moderation/suspensions", http.StatusSeeOther)
+}
+
+func (app *application) renderIPBans(w http.ResponseWriter, r *http.Request, formErrors map[string]string) {
+	loggedInUser, isLoggedIn := app.GetUserFromSession(r)
+
+	bans, err := models.GetActiveIPBans(app.db)
+	if err != nil {
+		logger.ErrorLogger.Printf("Error getting IP bans: %v\n", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	data := &templateData{
+		IsLoggedIn:   isLoggedIn,
+		LoggedInUser: loggedInUser,
+		IPBans:       bans,
+		FormErrors:   formErrors,
+		FormData:     r.PostForm,
+	}
+
+	status := http.StatusOK
+	if len(formErrors) > 0 {
+		status = http.StatusUnprocessableEntity
+	}
+
+	if err := app.renderTemplateWithStatus(w, r, status, "admin.ipbans.page.html", data); err != nil {
+		logger.ErrorLogger.Printf("Error rendering template: %v\n", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// IP and CIDR ban list
+func (app *application) adminIPBans(w http.ResponseWriter, r *http.Request) {
+	loggedInUser, _ := app.GetUserFromSession(r)
+
+	if r.URL.Path != "/admin/ipbans" {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		app.renderIPBans(w, r, nil)
+
+	case http.MethodPost:
+		if err := r.ParseForm(); err != nil {
+			logger.ErrorLogger.Printf("Error parsing a form: %s\n", err)
+			http.Error(w, "Unable to parse form", http.StatusBadRequest)
+			return
+		}
+
+		formErrors := make(map[string]string)
+
+		cidr := strings.TrimSpace(r.PostForm.Get("cidr"))
+		network, err := parseNetwork(cidr)
+		if err != nil {
+			formErrors["cidr"] = "Enter an IP address or a CIDR range such as 203.0.113.0/24"
+		} else if network.Contains(net.ParseIP(app.clientIP(r))) {
+			formErrors["cidr"] = "This would ban your own address"
+		}
+
+		expiresAt, ok := banExpiry(r.PostForm.Get("duration"))
+		if !ok {
+			formErrors["duration"] = "Choose how long the ban lasts"
+		}
+
+		reason := strings.TrimSpace(r.PostForm.Get("reason"))
+		if msg := validateReason(reason); msg != "" {
+			formErrors["reason"] = msg
+		}
+
+		if len(formErrors) > 0 {
+			app.renderIPBans(w, r, formErrors)
+			return
+		}
+
+		ban := models.IPBan{
+			CIDR:      network.String(),
+			Reason:    reason,
+			CreatedBy: loggedInUser.ID,
+			ExpiresAt: expiresAt,
+		}
+		if _, err := models.CreateIPBan(app.db, ban); err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if err := app.ipBans.reload(); err != nil {
+			logger.ErrorLogger.Printf("Error reloading IP bans: %v\n", err)
+		}
+
+		logger.InfoLogger.Printf("Admin %s banned %s: %s\n", loggedInUser.Name, ban.CIDR, reason)
+		http.Redirect(w, r, "/admin/ipbans", http.StatusSeeOther)
+
+	default:
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (app *application) deleteIPBan(w http.ResponseWriter, r *http.Request) {
+	loggedInUser, _ := app.GetUserFromSession(r)
+
+	if r.URL.Path != "/admin/ipbans/delete" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := models.DeleteIPBan(app.db, r.PostFormValue("id")); err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if err := app.ipBans.reload(); err != nil {
+		logger.ErrorLogger.Printf("Error reloading IP bans: %v\n", err)
+	}
+
+	logger.InfoLogger.Printf("Admin %s removed an IP ban\n", loggedInUser.Name)
+	http.Redirect(w, r, "/admin/ipbans", http.StatusSeeOther)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+
+	"forum/logger"
+	"forum/pkg/models"
+)
+
+const notificationsPageSize = 50
+
+// notifications lists the user's latest notifications and marks them as read
+func (app *application) notifications(w http.ResponseWriter, r *http.Request) {
+	loggedInUser, isLoggedIn := app.GetUserFromSession(r)
+
+	if r.URL.Path != "/user/notifications" {
+		http.NotFound(w, r)
+		return
+	}
+
+	notifications, err := models.GetNotificationsByUserID(app.db, loggedInUser.ID, notificationsPageSize)
+	if err != nil {
+		logger.ErrorLogger.Printf("Error getting notifications: %v\n", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := models.MarkNotificationsRead(app.db, loggedInUser.ID); err != nil {
+		logger.ErrorLogger.Printf("Error marking notifications read: %v\n", err)
+	}
+
+	data := &templateData{
+		IsLoggedIn:    isLoggedIn,
+		LoggedInUser:  loggedInUser,
+		Notifications: notifications,
+	}
+
+	if err := app.renderTemplate(w, r, "notifications.page.html", data); err != nil {
+		logger.ErrorLogger.Printf("Error rendering template: %v\n", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
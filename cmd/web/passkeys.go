@@ -0,0 +1,368 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"forum/logger"
+	"forum/pkg/models"
+	"forum/pkg/webauthn"
+
+	"github.com/google/uuid"
+)
+
+const (
+	webauthnCookieName   = "webauthn_challenge"
+	webauthnTimeout      = 5 * time.Minute
+	ceremonyRegistration = "registration"
+	ceremonyLogin        = "login"
+	maxPasskeyNameLength = 50
+	maxWebAuthnBodySize  = 64 << 10
+)
+
+// loadWebAuthnConfig reads the relying party from WEBAUTHN_RP_ID and
+// WEBAUTHN_ORIGIN. Passkeys are bound to the RP ID, so it has to stay the same
+// once users have registered them.
+func loadWebAuthnConfig() webauthn.Config {
+	rpID := os.Getenv("WEBAUTHN_RP_ID")
+	if rpID == "" {
+		rpID = strings.TrimPrefix(host, "https://")
+	}
+
+	origin := os.Getenv("WEBAUTHN_ORIGIN")
+	if origin == "" {
+		origin = host + port
+	}
+
+	return webauthn.Config{
+		RPID:    rpID,
+		RPName:  "Forum",
+		Origin:  origin,
+		Timeout: webauthnTimeout,
+	}
+}
+
+func (app *application) startWebAuthnCeremony(w http.ResponseWriter, userID, ceremony string) ([]byte, error) {
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		return nil, err
+	}
+
+	record := models.WebAuthnChallenge{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		Ceremony:  ceremony,
+		Challenge: challenge,
+		ExpiresAt: time.Now().Add(webauthnTimeout),
+	}
+
+	if _, err := models.CreateWebAuthnChallenge(app.db, record); err != nil {
+		return nil, err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     webauthnCookieName,
+		Value:    record.ID,
+		Path:     "/",
+		HttpOnly: true,
+		Expires:  record.ExpiresAt,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	return challenge, nil
+}
+
+func (app *application) finishWebAuthnCeremony(w http.ResponseWriter, r *http.Request, ceremony string) (models.WebAuthnChallenge, error) {
+	cookie, err := r.Cookie(webauthnCookieName)
+	if err != nil {
+		return models.WebAuthnChallenge{}, models.ErrWebAuthnChallengeNotFound
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     webauthnCookieName,
+		Value:    "",
+		MaxAge:   -1,
+		Path:     "/",
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	return models.TakeWebAuthnChallenge(app.db, cookie.Value, ceremony)
+}
+
+// passkey management page
+func (app *application) passkeySettings(w http.ResponseWriter, r *http.Request) {
+	loggedInUser, isLoggedIn := app.GetUserFromSession(r)
+
+	if r.URL.Path != "/user/settings/passkeys" {
+		http.NotFound(w, r)
+		return
+	}
+
+	passkeys, err := models.GetPasskeysByUserID(app.db, loggedInUser.ID)
+	if err != nil {
+		logger.ErrorLogger.Printf("Error getting passkeys: %v\n", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	data := &templateData{
+		IsLoggedIn:   isLoggedIn,
+		LoggedInUser: loggedInUser,
+		Passkeys:     passkeys,
+	}
+
+	if err := app.renderTemplate(w, r, "usersettings.passkeys.page.html", data); err != nil {
+		logger.ErrorLogger.Printf("Error rendering template: %v\n", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// passkeyRegistrationOptions starts registering a passkey for the logged in user
+func (app *application) passkeyRegistrationOptions(w http.ResponseWriter, r *http.Request) {
+	loggedInUser, _ := app.GetUserFromSession(r)
+
+	if r.URL.Path != "/user/settings/passkeys/options" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	passkeys, err := models.GetPasskeysByUserID(app.db, loggedInUser.ID)
+	if err != nil {
+		logger.ErrorLogger.Printf("Error getting passkeys: %v\n", err)
+		writeJSONError(w, http.StatusInternalServerError, "Unable to start passkey registration")
+		return
+	}
+
+	var exclude []webauthn.CredentialDescriptor
+	for _, passkey := range passkeys {
+		descriptor := webauthn.CredentialDescriptor{Type: "public-key", ID: passkey.ID}
+		if passkey.Transports != "" {
+			descriptor.Transports = strings.Split(passkey.Transports, ",")
+		}
+		exclude = append(exclude, descriptor)
+	}
+
+	challenge, err := app.startWebAuthnCeremony(w, loggedInUser.ID, ceremonyRegistration)
+	if err != nil {
+		logger.ErrorLogger.Printf("Error starting passkey registration: %v\n", err)
+		writeJSONError(w, http.StatusInternalServerError, "Unable to start passkey registration")
+		return
+	}
+
+	options := app.webauthn.CreationOptions(challenge, loggedInUser.ID, loggedInUser.Email, loggedInUser.Name, exclude)
+	writeJSON(w, http.StatusOK, map[string]interface{}{"publicKey": options})
+}
+
+// registerPasskey verifies the new credential and stores it
+func (app *application) registerPasskey(w http.ResponseWriter, r *http.Request) {
+	loggedInUser, _ := app.GetUserFromSession(r)
+
+	if r.URL.Path != "/user/settings/passkeys/register" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body struct {
+		Name       string                        `json:"name"`
+		Credential webauthn.RegistrationResponse `json:"credential"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxWebAuthnBodySize)).Decode(&body); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Unable to read passkey")
+		return
+	}
+
+	name := strings.TrimSpace(body.Name)
+	if name == "" {
+		name = "Passkey"
+	}
+	if utf8.RuneCountInString(name) > maxPasskeyNameLength {
+		writeJSONError(w, http.StatusBadRequest, "Name must not exceed 50 characters")
+		return
+	}
+
+	challenge, err := app.finishWebAuthnCeremony(w, r, ceremonyRegistration)
+	if err != nil || challenge.UserID != loggedInUser.ID {
+		writeJSONError(w, http.StatusBadRequest, "Passkey registration expired, please try again")
+		return
+	}
+
+	credential, err := app.webauthn.VerifyRegistration(challenge.Challenge, body.Credential)
+	if err != nil {
+		logger.ErrorLogger.Printf("Error verifying passkey registration: %v\n", err)
+		writeJSONError(w, http.StatusBadRequest, "Passkey could not be verified")
+		return
+	}
+
+	passkey := models.Passkey{
+		ID:         webauthn.EncodeID(credential.ID),
+		UserID:     loggedInUser.ID,
+		Name:       name,
+		PublicKey:  credential.PublicKey,
+		SignCount:  credential.SignCount,
+		Transports: strings.Join(credential.Transports, ","),
+	}
+
+	if _, err := models.CreatePasskey(app.db, passkey); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Unable to save passkey")
+		return
+	}
+
+	logger.InfoLogger.Printf("User %s registered passkey %q\n", loggedInUser.ID, name)
+	writeJSON(w, http.StatusOK, map[string]string{"redirect": "/user/settings/passkeys"})
+}
+
+func (app *application) renamePasskey(w http.ResponseWriter, r *http.Request) {
+	loggedInUser, _ := app.GetUserFromSession(r)
+
+	if r.URL.Path != "/user/settings/passkeys/rename" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := strings.TrimSpace(r.PostFormValue("name"))
+	if name == "" || utf8.RuneCountInString(name) > maxPasskeyNameLength {
+		app.renderError(w, r, http.StatusBadRequest, "Passkey name must be between 1 and 50 characters.")
+		return
+	}
+
+	if err := models.RenamePasskey(app.db, r.PostFormValue("id"), loggedInUser.ID, name); err != nil {
+		http.Error(w, "Unable to rename passkey", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/user/settings/passkeys", http.StatusSeeOther)
+}
+
+func (app *application) deletePasskey(w http.ResponseWriter, r *http.Request) {
+	loggedInUser, _ := app.GetUserFromSession(r)
+
+	if r.URL.Path != "/user/settings/passkeys/delete" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := models.DeletePasskey(app.db, r.PostFormValue("id"), loggedInUser.ID); err != nil {
+		http.Error(w, "Unable to delete passkey", http.StatusInternalServerError)
+		return
+	}
+
+	logger.InfoLogger.Printf("User %s deleted a passkey\n", loggedInUser.ID)
+	http.Redirect(w, r, "/user/settings/passkeys", http.StatusSeeOther)
+}
+
+// passkeyLoginOptions starts a passkey sign-in
+func (app *application) passkeyLoginOptions(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/user/login/passkey/options" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	challenge, err := app.startWebAuthnCeremony(w, "", ceremonyLogin)
+	if err != nil {
+		logger.ErrorLogger.Printf("Error starting passkey login: %v\n", err)
+		writeJSONError(w, http.StatusInternalServerError, "Unable to start passkey sign-in")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"publicKey": app.webauthn.RequestOptions(challenge)})
+}
+
+// loginPasskey verifies the assertion and logs the owner of the passkey in
+func (app *application) loginPasskey(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/user/login/passkey" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var assertion webauthn.AssertionResponse
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxWebAuthnBodySize)).Decode(&assertion); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Unable to read passkey")
+		return
+	}
+
+	challenge, err := app.finishWebAuthnCeremony(w, r, ceremonyLogin)
+	if err != nil {
+		if !errors.Is(err, models.ErrWebAuthnChallengeNotFound) {
+			logger.ErrorLogger.Printf("Error getting passkey challenge: %v\n", err)
+		}
+		writeJSONError(w, http.StatusBadRequest, "Passkey sign-in expired, please try again")
+		return
+	}
+
+	passkey, err := models.GetPasskeyByID(app.db, assertion.ID)
+	if err != nil {
+		writeJSONError(w, http.StatusUnauthorized, "This passkey is not registered")
+		return
+	}
+
+	if assertion.Response.UserHandle != "" {
+		userHandle, err := webauthn.DecodeID(assertion.Response.UserHandle)
+		if err != nil || string(userHandle) != passkey.UserID {
+			writeJSONError(w, http.StatusUnauthorized, "Passkey could not be verified")
+			return
+		}
+	}
+
+	credential := webauthn.Credential{PublicKey: passkey.PublicKey, SignCount: passkey.SignCount}
+	signCount, err := app.webauthn.VerifyAssertion(challenge.Challenge, assertion, credential)
+	if err != nil {
+		logger.ErrorLogger.Printf("Error verifying passkey for user %s: %v\n", passkey.UserID, err)
+		writeJSONError(w, http.StatusUnauthorized, "Passkey could not be verified")
+		return
+	}
+
+	if msg := app.suspensionMessage(passkey.UserID); msg != "" {
+		writeJSONError(w, http.StatusForbidden, msg)
+		return
+	}
+
+	if err := models.RecordPasskeyUse(app.db, passkey.ID, signCount); err != nil {
+		logger.ErrorLogger.Printf("Error updating passkey: %v\n", err)
+	}
+
+	app.SetSession(w, r, passkey.UserID)
+	logger.InfoLogger.Printf("User %s signed in with passkey %q\n", passkey.UserID, passkey.Name)
+	writeJSON(w, http.StatusOK, map[string]string{"redirect": "/"})
+}
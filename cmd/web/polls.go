@@ -0,0 +1,140 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"forum/logger"
+	"forum/pkg/models"
+)
+
+// Limits of a poll on the create form.
+const (
+	minPollOptions      = 2
+	maxPollOptions      = 10
+	maxPollOptionLength = 80
+)
+
+// parsePoll reads the poll fields of the create form, one option per line.
+// It returns nil when no options were entered, and a message for the form if
+// the poll is invalid. A closing time must come after publishAt, which is the
+// zero time unless the post is scheduled.
+func parsePoll(form url.Values, publishAt, now time.Time) (*models.Poll, string) {
+	var options []models.PollOption
+	for _, line := range strings.Split(form.Get("poll_options"), "\n") {
+		if text := strings.TrimSpace(line); text != "" {
+			options = append(options, models.PollOption{Text: text})
+		}
+	}
+	if len(options) == 0 {
+		return nil, ""
+	}
+
+	if len(options) < minPollOptions || len(options) > maxPollOptions {
+		return nil, "A poll needs between 2 and 10 options"
+	}
+	for _, o := range options {
+		if utf8.RuneCountInString(o.Text) > maxPollOptionLength {
+			return nil, "Each option must not exceed 80 characters"
+		}
+	}
+
+	poll := &models.Poll{
+		Multiple: form.Get("poll_multiple") != "",
+		Options:  options,
+	}
+
+	if value := form.Get("poll_closes_at"); value != "" {
+		closesAt, err := time.ParseInLocation(publishAtLayout, value, time.Local)
+		if err != nil {
+			return nil, "Enter a valid closing date and time"
+		}
+		if !closesAt.After(now) || !closesAt.After(publishAt) {
+			return nil, "The poll must close after the post is published"
+		}
+		poll.ClosesAt = sql.NullTime{Time: closesAt, Valid: true}
+	}
+
+	return poll, ""
+}
+
+// pollFormData fills in the poll fields of the create form from a draft.
+func pollFormData(formData url.Values, poll models.Poll) {
+	var options []string
+	for _, o := range poll.Options {
+		options = append(options, o.Text)
+	}
+	formData.Set("poll_options", strings.Join(options, "\n"))
+	if poll.Multiple {
+		formData.Set("poll_multiple", "on")
+	}
+	if poll.ClosesAt.Valid {
+		formData.Set("poll_closes_at", poll.ClosesAt.Time.Local().Format(publishAtLayout))
+	}
+}
+
+// loadPoll sets post.Poll if the post has one, with the user's vote marked.
+func (app *application) loadPoll(post *models.Post, userID string) error {
+	poll, ok, err := models.GetPoll(app.db, post.ID, userID)
+	if err != nil || !ok {
+		return err
+	}
+	post.Poll = &poll
+	return nil
+}
+
+// votePoll records a vote on a post's poll. Voting again while the poll is
+// open replaces the earlier vote.
+func (app *application) votePoll(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/post/poll/vote" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		logger.ErrorLogger.Printf("Error parsing a form: %s\n", err)
+		http.Error(w, "Unable to parse form", http.StatusBadRequest)
+		return
+	}
+
+	user, _ := app.GetUserFromSession(r)
+	postID := r.PostForm.Get("post_id")
+
+	if !app.acceptsReplies(postID) {
+		http.NotFound(w, r)
+		return
+	}
+
+	if app.threadArchived(postID) {
+		http.Error(w, "This thread is archived", http.StatusForbidden)
+		return
+	}
+
+	err := models.Vote(app.db, postID, user.ID, r.PostForm["option"])
+	if errors.Is(err, models.ErrPollClosed) {
+		http.Error(w, "This poll is closed", http.StatusConflict)
+		return
+	}
+	if errors.Is(err, models.ErrInvalidVote) {
+		http.Error(w, "Choose an option of the poll", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		logger.ErrorLogger.Printf("Error saving vote: %v\n", err)
+		http.Error(w, "Unable to save vote", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/post?id="+postID, http.StatusSeeOther)
+}
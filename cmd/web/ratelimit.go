@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"forum/logger"
+	"forum/pkg/ratelimit"
+	"forum/pkg/uploads"
+)
+
+// rateLimitPolicies are the limits for each kind of request. Each can be
+// changed with RATE_LIMIT_<NAME>, e.g. RATE_LIMIT_AUTH=10/1m or
+// RATE_LIMIT_STATIC=off.
+type rateLimitPolicies struct {
+	auth     ratelimit.Policy
+	post     ratelimit.Policy
+	comment  ratelimit.Policy
+	reaction ratelimit.Policy
+	static   ratelimit.Policy
+	general  ratelimit.Policy
+}
+
+var defaultRateLimits = map[string]string{
+	"auth":     "10/1m",
+	"post":     "5/10m",
+	"comment":  "10/1m",
+	"reaction": "60/1m",
+	"static":   "1000/1m,200",
+	"general":  "120/1m,30",
+}
+
+func loadRateLimitPolicy(name string) (ratelimit.Policy, error) {
+	value := os.Getenv("RATE_LIMIT_" + strings.ToUpper(name))
+	if value == "" {
+		value = defaultRateLimits[name]
+	}
+	return ratelimit.ParsePolicy(name, value)
+}
+
+func loadRateLimitPolicies() (rateLimitPolicies, error) {
+	var (
+		policies rateLimitPolicies
+		err      error
+	)
+
+	for name, policy := range map[string]*ratelimit.Policy{
+		"auth":     &policies.auth,
+		"post":     &policies.post,
+		"comment":  &policies.comment,
+		"reaction": &policies.reaction,
+		"static":   &policies.static,
+		"general":  &policies.general,
+	} {
+		if *policy, err = loadRateLimitPolicy(name); err != nil {
+			return policies, err
+		}
+	}
+
+	return policies, nil
+}
+
+// newRateLimitStore picks the bucket storage from RATE_LIMIT_STORE. "memory"
+// (the default) limits each server on its own, "database" shares the limits
+// between all servers using the same database.
+func (app *application) newRateLimitStore() (ratelimit.Store, error) {
+	switch store := os.Getenv("RATE_LIMIT_STORE"); store {
+	case "", "memory":
+		return ratelimit.NewMemoryStore(), nil
+	case "database":
+		return ratelimit.NewSQLStore(app.db), nil
+	default:
+		return nil, fmt.Errorf("unknown RATE_LIMIT_STORE %q", store)
+	}
+}
+
+// rateLimitPolicy returns the policy for the request and whether it is limited
+// per user rather than per client address.
+func (app *application) rateLimitPolicy(r *http.Request) (ratelimit.Policy, bool) {
+	path := r.URL.Path
+
+	switch {
+	case strings.HasPrefix(path, "/static/") || strings.HasPrefix(path, uploads.URLPrefix):
+		return app.rateLimits.static, false
+	case strings.HasPrefix(path, "/login/") || path == "/GoogleCallback":
+		return app.rateLimits.auth, false
+	case r.Method != http.MethodPost:
+		return app.rateLimits.general, true
+	case strings.HasPrefix(path, "/user/login") || path == "/user/signup":
+		return app.rateLimits.auth, false
+	case path == "/post/create" || path == "/post/edit":
+		return app.rateLimits.post, true
+	case path == "/post/comment":
+		return app.rateLimits.comment, true
+	case path == "/post/reaction" || path == "/post/comment/reaction" || path == "/post/emoji" || path == "/post/poll/vote":
+		return app.rateLimits.reaction, true
+	default:
+		return app.rateLimits.general, true
+	}
+}
+
+func (app *application) rateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		policy, perUser := app.rateLimitPolicy(r)
+		if policy.Disabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := "ip:" + app.clientIP(r)
+		if perUser {
+			if user, loggedIn := app.GetUserFromSession(r); loggedIn {
+				key = "user:" + user.ID
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), time.Second)
+		defer cancel()
+
+		allowed, retryAfter, err := app.limiter.Allow(ctx, policy, key)
+		if err != nil {
+			// Fail open, an unavailable store should not take the forum down.
+			logger.ErrorLogger.Printf("Error checking rate limit: %v\n", err)
+		}
+
+		if !allowed {
+			seconds := int((retryAfter + time.Second - 1) / time.Second)
+			if seconds < 1 {
+				seconds = 1
+			}
+			w.Header().Set("Retry-After", strconv.Itoa(seconds))
+
+			if policy.Name == "static" {
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
+			}
+			app.renderError(w, r, http.StatusTooManyRequests, "You are doing that too often. Please wait a moment and try again.")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
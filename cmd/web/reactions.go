@@ -0,0 +1,23 @@
+package main
+
+import "forum/pkg/models"
+
+// loadUserReactions marks the like or dislike the user viewing the post page
+// left on the post and on each of its comments, so they show as active.
+func (app *application) loadUserReactions(data *templateData, viewerID string) error {
+	if viewerID == "" {
+		return nil
+	}
+
+	postReaction, commentReactions, err := models.GetUserReactionsOnPost(app.db, data.Post.ID, viewerID)
+	if err != nil {
+		return err
+	}
+
+	data.PostReaction = postReaction
+	for i := range data.Comments {
+		data.Comments[i].Reaction = commentReactions[data.Comments[i].ID]
+	}
+
+	return nil
+}
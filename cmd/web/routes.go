@@ -2,6 +2,9 @@ package main
 
 import (
 	"net/http"
+
+	"forum/pkg/models"
+	"forum/pkg/uploads"
 )
 
 func (app *application) routes() http.Handler {
@@ -13,6 +16,9 @@ func (app *application) routes() http.Handler {
 	mux.HandleFunc("/user/signup", app.signup)
 	mux.HandleFunc("/user/login", app.login)
 	mux.HandleFunc("/user/logout", app.logout)
+	mux.HandleFunc("/user/login/2fa", app.loginTwoFactor)
+	mux.HandleFunc("/user/login/passkey/options", app.passkeyLoginOptions)
+	mux.HandleFunc("/user/login/passkey", app.loginPasskey)
 
 	// google auth
 	mux.HandleFunc("/login/google", app.handleGoogleLogin)
@@ -28,13 +34,38 @@ func (app *application) routes() http.Handler {
 	// filter
 	mux.HandleFunc("/filter", app.filter)
 
+	// tags
+	mux.HandleFunc("/tag", app.tagPage)
+	mux.HandleFunc("/tags/suggest", app.suggestTags)
+
 	// post handlers
 	mux.HandleFunc("/post/", app.showPost)
 	mux.HandleFunc("/post/create", app.requireLogin(app.createPost))
+	mux.HandleFunc("/post/preview", app.requireLogin(app.markdownPreview))
+	mux.HandleFunc("/post/edit", app.requireLogin(app.editDraft))
+	mux.HandleFunc("/post/draft/delete", app.requireLogin(app.deleteDraft))
 
 	// post like/dislike handler
 	mux.HandleFunc("/post/reaction", app.requireLogin(app.createPostReaction))
 
+	// poll vote handler
+	mux.HandleFunc("/post/poll/vote", app.requireLogin(app.votePoll))
+
+	// accepted answer handler
+	mux.HandleFunc("/post/answer", app.requireLogin(app.acceptAnswer))
+
+	// emoji reaction handler
+	mux.HandleFunc("/post/emoji", app.requireLogin(app.toggleEmojiReaction))
+
+	// thread watches, follows and category subscriptions
+	mux.HandleFunc("/post/watch", app.requireLogin(app.watchThread))
+	mux.HandleFunc("/user/follow", app.requireLogin(app.followUser))
+	mux.HandleFunc("/category/subscribe", app.requireLogin(app.subscribeCategory))
+
+	// bookmarks
+	mux.HandleFunc("/bookmark", app.requireLogin(app.saveBookmark))
+	mux.HandleFunc("/bookmark/delete", app.requireLogin(app.deleteBookmark))
+
 	// comment handler
 	mux.HandleFunc("/post/comment", app.requireLogin(app.createComment))
 
@@ -44,13 +75,59 @@ func (app *application) routes() http.Handler {
 	// user profile
 	mux.HandleFunc("/user/profile", app.requireLogin(app.userProfile))
 	mux.HandleFunc("/user/profile/posts", app.requireLogin(app.userProfilePostsPage))
+	mux.HandleFunc("/user/profile/drafts", app.requireLogin(app.userProfileDraftsPage))
 	mux.HandleFunc("/user/profile/comments", app.requireLogin(app.userProfileCommentsPage))
 	mux.HandleFunc("/user/profile/post/reactions", app.requireLogin(app.userProfilePostReaction))
 	mux.HandleFunc("/user/profile/comment/reactions", app.requireLogin(app.userProfileCommentReaction))
 	mux.HandleFunc("/user/profile/activity", app.requireLogin(app.userActivity))
+	mux.HandleFunc("/user/profile/following", app.requireLogin(app.userProfileFollowingPage))
+	mux.HandleFunc("/user/profile/saved", app.requireLogin(app.userProfileSavedPage))
+	mux.HandleFunc("/user/profile/saved/collections", app.requireLogin(app.createCollection))
+	mux.HandleFunc("/user/profile/saved/collections/delete", app.requireLogin(app.deleteCollection))
+
+	// notifications
+	mux.HandleFunc("/user/notifications", app.requireLogin(app.notifications))
+
+	// user settings
+	mux.HandleFunc("/user/settings/security", app.requireLogin(app.securitySettings))
+	mux.HandleFunc("/user/settings/2fa/enroll", app.requireLogin(app.enrollTwoFactor))
+	mux.HandleFunc("/user/settings/2fa/recovery", app.requireLogin(app.regenerateRecoveryCodes))
+	mux.HandleFunc("/user/settings/2fa/disable", app.requireLogin(app.disableTwoFactor))
+	mux.HandleFunc("/user/settings/passkeys", app.requireLogin(app.passkeySettings))
+	mux.HandleFunc("/user/settings/passkeys/options", app.requireLogin(app.passkeyRegistrationOptions))
+	mux.HandleFunc("/user/settings/passkeys/register", app.requireLogin(app.registerPasskey))
+	mux.HandleFunc("/user/settings/passkeys/rename", app.requireLogin(app.renamePasskey))
+	mux.HandleFunc("/user/settings/passkeys/delete", app.requireLogin(app.deletePasskey))
+	mux.HandleFunc("/user/settings/storage", app.requireLogin(app.storageSettings))
+	mux.HandleFunc("/user/settings/account", app.requireLogin(app.accountSettings))
+	mux.HandleFunc("/user/settings/account/export", app.requireLogin(app.exportAccount))
+	mux.HandleFunc("/user/settings/account/delete", app.requireLogin(app.deleteAccount))
+	mux.HandleFunc("/user/settings/account/cancel", app.requireLogin(app.cancelAccountDeletion))
+
+	// moderation
+	mux.HandleFunc("/moderation/suspensions", app.requireRole(app.suspensions, models.RoleModerator, models.RoleAdmin))
+	mux.HandleFunc("/moderation/suspensions/lift", app.requireRole(app.liftSuspension, models.RoleModerator, models.RoleAdmin))
+	mux.HandleFunc("/moderation/queue", app.requireRole(app.reviewQueue, models.RoleModerator, models.RoleAdmin))
+	mux.HandleFunc("/moderation/queue/resolve", app.requireRole(app.resolveReview, models.RoleModerator, models.RoleAdmin))
+	mux.HandleFunc("/moderation/tags", app.requireRole(app.moderationTags, models.RoleModerator, models.RoleAdmin))
+	mux.HandleFunc("/moderation/tags/synonym/delete", app.requireRole(app.removeTagSynonym, models.RoleModerator, models.RoleAdmin))
+	mux.HandleFunc("/moderation/thread", app.requireRole(app.moderateThread, models.RoleModerator, models.RoleAdmin))
+
+	// admin
+	mux.HandleFunc("/admin/security", app.requireRole(app.adminSecurity, models.RoleAdmin))
+	mux.HandleFunc("/admin/archiving", app.requireRole(app.adminArchiving, models.RoleAdmin))
+	mux.HandleFunc("/admin/qa", app.requireRole(app.adminQA, models.RoleAdmin))
+	mux.HandleFunc("/admin/lockouts", app.requireRole(app.adminLockouts, models.RoleAdmin))
+	mux.HandleFunc("/admin/lockouts/unlock", app.requireRole(app.unlockAccount, models.RoleAdmin))
+	mux.HandleFunc("/admin/ipbans", app.requireRole(app.adminIPBans, models.RoleAdmin))
+	mux.HandleFunc("/admin/ipbans/delete", app.requireRole(app.deleteIPBan, models.RoleAdmin))
+
+	// content security policy violation reports
+	mux.HandleFunc("/csp-report", app.cspReport)
 
 	fileServer := http.FileServer(http.Dir("./ui/static"))
 	mux.Handle("/static/", http.StripPrefix("/static", fileServer))
-	
-	return rateLimiter(secureHeaders(mux))
+	mux.HandleFunc(uploads.URLPrefix, app.serveUpload)
+
+	return app.blockBannedIPs(app.rateLimit(app.secureHeaders(app.csrfProtect(mux))))
 }
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"forum/logger"
+)
+
+const cspNonceContextKey = contextKey("cspNonce")
+
+// defaultCSP only allows our own scripts and those carrying the request's
+// nonce. {nonce} is replaced on every request.
+const defaultCSP = "default-src 'self'; " +
+	"script-src 'self' 'nonce-{nonce}'; " +
+	"style-src 'self' https://fonts.googleapis.com; " +
+	"font-src 'self' https://fonts.gstatic.com; " +
+	"img-src 'self' data:; " +
+	"connect-src 'self'; " +
+	"object-src 'none'; " +
+	"base-uri 'self'; " +
+	"form-action 'self'; " +
+	"frame-ancestors 'none'; " +
+	"report-uri /csp-report; " +
+	"report-to csp-endpoint"
+
+// securityPolicy holds the security headers sent with every response. Each
+// header can be changed in the environment, an empty value leaves it out.
+type securityPolicy struct {
+	csp                       string
+	cspReportOnly             bool
+	strictTransportSecurity   string
+	referrerPolicy            string
+	permissionsPolicy         string
+	crossOriginOpenerPolicy   string
+	crossOriginEmbedderPolicy string
+	crossOriginResourcePolicy string
+}
+
+func envOrDefault(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return fallback
+}
+
+// loadSecurityPolicy reads the headers from the environment. imageOrigin,
+// where the upload store sends browsers for images, is added to img-src in
+// the default policy.
+func loadSecurityPolicy(imageOrigin string) securityPolicy {
+	reportOnly, _ := strconv.ParseBool(os.Getenv("CSP_REPORT_ONLY"))
+
+	csp := defaultCSP
+	if imageOrigin != "" {
+		csp = strings.Replace(csp, "img-src 'self' data:", "img-src 'self' data: "+imageOrigin, 1)
+	}
+
+	return securityPolicy{
+		csp:                       envOrDefault("CONTENT_SECURITY_POLICY", csp),
+		cspReportOnly:             reportOnly,
+		strictTransportSecurity:   envOrDefault("STRICT_TRANSPORT_SECURITY", "max-age=31536000; includeSubDomains"),
+		referrerPolicy:            envOrDefault("REFERRER_POLICY", "strict-origin-when-cross-origin"),
+		permissionsPolicy:         envOrDefault("PERMISSIONS_POLICY", "camera=(), microphone=(), geolocation=(), payment=(), usb=()"),
+		crossOriginOpenerPolicy:   envOrDefault("CROSS_ORIGIN_OPENER_POLICY", "same-origin"),
+		crossOriginEmbedderPolicy: envOrDefault("CROSS_ORIGIN_EMBEDDER_POLICY", "credentialless"),
+		crossOriginResourcePolicy: envOrDefault("CROSS_ORIGIN_RESOURCE_POLICY", "same-origin"),
+	}
+}
+
+func newCSPNonce() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		logger.ErrorLogger.Fatalf("Error generating CSP nonce: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// secureHeaders sets the security policy headers and makes a fresh CSP nonce
+// available to the templates through the request context.
+func (app *application) secureHeaders(next http.Handler) http.Handler {
+	policy := app.securityPolicy
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		nonce := newCSPNonce()
+
+		if policy.csp != "" {
+			csp := strings.ReplaceAll(policy.csp, "{nonce}", nonce)
+			if policy.cspReportOnly {
+				h.Set("Content-Security-Policy-Report-Only", csp)
+			} else {
+				h.Set("Content-Security-Policy", csp)
+			}
+			h.Set("Reporting-Endpoints", `csp-endpoint="/csp-report"`)
+		}
+
+		setIfNotEmpty(h, "Strict-Transport-Security", policy.strictTransportSecurity)
+		setIfNotEmpty(h, "Referrer-Policy", policy.referrerPolicy)
+		setIfNotEmpty(h, "Permissions-Policy", policy.permissionsPolicy)
+		setIfNotEmpty(h, "Cross-Origin-Opener-Policy", policy.crossOriginOpenerPolicy)
+		setIfNotEmpty(h, "Cross-Origin-Embedder-Policy", policy.crossOriginEmbedderPolicy)
+		setIfNotEmpty(h, "Cross-Origin-Resource-Policy", policy.crossOriginResourcePolicy)
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("X-Frame-Options", "deny")
+		// The XSS auditor is gone from browsers and could be abused where it
+		// still exists, the CSP covers it.
+		h.Set("X-XSS-Protection", "0")
+
+		ctx := context.WithValue(r.Context(), cspNonceContextKey, nonce)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func setIfNotEmpty(h http.Header, key, value string) {
+	if value != "" {
+		h.Set(key, value)
+	}
+}
+
+// cspNonce returns the nonce inline scripts need to carry on this request.
+func cspNonce(r *http.Request) string {
+	nonce, _ := r.Context().Value(cspNonceContextKey).(string)
+	return nonce
+}
+
+const maxCSPReportSize = 64 << 10
+
+// cspViolation holds the fields we log from both the report-uri format and
+// the Reporting API format.
+type cspViolation struct {
+	DocumentURI        string `json:"document-uri"`
+	DocumentURL        string `json:"documentURL"`
+	ViolatedDirective  string `json:"violated-directive"`
+	EffectiveDirective string `json:"effectiveDirective"`
+	BlockedURI         string `json:"blocked-uri"`
+	BlockedURL         string `json:"blockedURL"`
+	SourceFile         string `json:"source-file"`
+	SourceFileAPI      string `json:"sourceFile"`
+	LineNumber         int    `json:"line-number"`
+	LineNumberAPI      int    `json:"lineNumber"`
+	Disposition        string `json:"disposition"`
+}
+
+func (v cspViolation) String() string {
+	document, directive, blocked, source, line := v.DocumentURI, v.ViolatedDirective, v.BlockedURI, v.SourceFile, v.LineNumber
+	if document == "" {
+		document = v.DocumentURL
+	}
+	if directive == "" {
+		directive = v.EffectiveDirective
+	}
+	if blocked == "" {
+		blocked = v.BlockedURL
+	}
+	if source == "" {
+		source = v.SourceFileAPI
+	}
+	if line == 0 {
+		line = v.LineNumberAPI
+	}
+
+	return "document=" + strconv.Quote(document) + " directive=" + strconv.Quote(directive) +
+		" blocked=" + strconv.Quote(blocked) + " source=" + strconv.Quote(source) + ":" + strconv.Itoa(line) +
+		" disposition=" + strconv.Quote(v.Disposition)
+}
+
+// cspReport logs violations browsers send for the Content-Security-Policy.
+func (app *application) cspReport(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/csp-report" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxCSPReportSize))
+	if err != nil {
+		http.Error(w, "Report too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	var violations []cspViolation
+
+	// report-uri sends {"csp-report": {...}}, the Reporting API sends a list
+	// of reports with the violation in "body".
+	var legacy struct {
+		Report *cspViolation `json:"csp-report"`
+	}
+	var reports []struct {
+		Type string       `json:"type"`
+		Body cspViolation `json:"body"`
+	}
+
+	switch {
+	case json.Unmarshal(body, &legacy) == nil && legacy.Report != nil:
+		violations = append(violations, *legacy.Report)
+	case json.Unmarshal(body, &reports) == nil:
+		for _, report := range reports {
+			if report.Type == "csp-violation" {
+				violations = append(violations, report.Body)
+			}
+		}
+	default:
+		http.Error(w, "Invalid report", http.StatusBadRequest)
+		return
+	}
+
+	for _, violation := range violations {
+		logger.ErrorLogger.Printf("CSP violation from %s: %s\n", app.clientIP(r), violation)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
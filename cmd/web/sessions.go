@@ -29,7 +29,7 @@ func (app *application) SetSession(w http.ResponseWriter, r *http.Request, id st
 			HttpOnly: true,
 			Expires:  time.Now().Add(2 * time.Hour),
 			Secure:   true,
-			// SameSite: http.SameSiteStrictMode,
+			SameSite: http.SameSiteLaxMode,
 		}
 		http.SetCookie(w, cookie)
 
@@ -58,6 +58,7 @@ func (app *application) SetSession(w http.ResponseWriter, r *http.Request, id st
 				HttpOnly: true,
 				Expires:  time.Now().Add(2 * time.Hour),
 				Secure:   true,
+				SameSite: http.SameSiteLaxMode,
 			}
 			http.SetCookie(w, cookie)
 
@@ -84,6 +85,7 @@ func (app *application) SetSession(w http.ResponseWriter, r *http.Request, id st
 				HttpOnly: true,
 				Expires:  time.Now().Add(2 * time.Hour),
 				Secure:   true,
+				SameSite: http.SameSiteLaxMode,
 			}
 			http.SetCookie(w, cookie)
 
@@ -117,8 +119,10 @@ func (app *application) GetUserFromSession(r *http.Request) (models.User, bool)
 		return models.User{}, false
 	}
 
+	// Suspended users are signed out even if a session slipped through
 	var user models.User
-	err = app.db.QueryRow(`SELECT id, name, email, hashed_password, created_at, updated_at FROM users WHERE id = ?`, userID).Scan(&user.ID, &user.Name, &user.Email, &user.HashedPassword, &user.CreatedAt, &user.UpdatedAt)
+	err = app.db.QueryRow(`SELECT id, name, email, hashed_password, created_at, updated_at, role, totp_enabled, deletion_requested_at FROM users WHERE id = ?
+		AND NOT EXISTS (SELECT 1 FROM suspensions s WHERE s.user_id = users.id AND `+models.ActiveSuspension+`)`, userID, time.Now()).Scan(&user.ID, &user.Name, &user.Email, &user.HashedPassword, &user.CreatedAt, &user.UpdatedAt, &user.Role, &user.TOTPEnabled, &user.DeletionRequestedAt)
 	if err != nil {
 		return models.User{}, false
 	}
@@ -146,10 +150,11 @@ func (app *application) DeleteSession(w http.ResponseWriter, r *http.Request) (*
 	}
 
 	cookie = &http.Cookie{
-		Name:   "session",
-		Value:  "",
-		MaxAge: -1,
-		Path:   "/",
+		Name:     "session",
+		Value:    "",
+		MaxAge:   -1,
+		Path:     "/",
+		SameSite: http.SameSiteLaxMode,
 	}
 	http.SetCookie(w, cookie)
 	return cookie, nil
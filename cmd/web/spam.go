@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"forum/logger"
+	"forum/pkg/models"
+	"forum/pkg/spam"
+)
+
+// recentContentLimit caps how many recent posts and comments are compared
+// against new content.
+const recentContentLimit = 500
+
+// loadSpamRules reads SPAM_THRESHOLD, the comma separated SPAM_BLOCKED_WORDS
+// and SPAM_BLOCKED_DOMAINS, and the block list file SPAM_BLOCKLIST_FILE.
+func loadSpamRules() (spam.Rules, error) {
+	rules := spam.DefaultRules()
+
+	if value := os.Getenv("SPAM_THRESHOLD"); value != "" {
+		threshold, err := strconv.Atoi(value)
+		if err != nil || threshold <= 0 {
+			return rules, fmt.Errorf("invalid SPAM_THRESHOLD %q", value)
+		}
+		rules.Threshold = threshold
+	}
+
+	rules.AddBlocked(os.Getenv("SPAM_BLOCKED_WORDS"), os.Getenv("SPAM_BLOCKED_DOMAINS"))
+
+	if path := os.Getenv("SPAM_BLOCKLIST_FILE"); path != "" {
+		if err := rules.LoadBlocklist(path); err != nil {
+			return rules, err
+		}
+	}
+
+	return rules, nil
+}
+
+// scoreContent runs the spam checks on new content by user. Staff are never
+// held for review.
+func (app *application) scoreContent(user models.User, text string) (spam.Result, error) {
+	if user.IsStaff() {
+		return spam.Result{}, nil
+	}
+
+	now := time.Now()
+	since := now.Add(-app.spamRules.DuplicateWindow)
+	if app.spamRules.VelocityWindow > app.spamRules.DuplicateWindow {
+		since = now.Add(-app.spamRules.VelocityWindow)
+	}
+
+	recent, err := models.GetRecentContent(app.db, since, recentContentLimit)
+	if err != nil {
+		return spam.Result{}, err
+	}
+
+	submission := spam.Submission{
+		UserID:         user.ID,
+		Text:           text,
+		AuthorJoinedAt: user.CreatedAt,
+	}
+	for _, c := range recent {
+		submission.Recent = append(submission.Recent, spam.Recent{UserID: c.UserID, Text: c.Text, CreatedAt: c.CreatedAt})
+	}
+
+	return app.spamRules.Score(submission, now), nil
+}
+
+func (app *application) notifyAuthor(userID, message, link string) {
+	notification := models.Notification{
+		UserID:  userID,
+		Kind:    models.NotificationReview,
+		Message: message,
+		Link:    link,
+	}
+	if _, err := models.CreateNotification(app.db, notification); err != nil {
+		logger.ErrorLogger.Printf("Error notifying user %s about review: %v\n", userID, err)
+	}
+}
+
+// reviewQueue lists posts and comments held by the spam checks
+func (app *application) reviewQueue(w http.ResponseWriter, r *http.Request) {
+	loggedInUser, isLoggedIn := app.GetUserFromSession(r)
+
+	if r.URL.Path != "/moderation/queue" {
+		http.NotFound(w, r)
+		return
+	}
+
+	items, err := models.GetReviewQueue(app.db)
+	if err != nil {
+		logger.ErrorLogger.Printf("Error getting review queue: %v\n", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	data := &templateData{
+		IsLoggedIn:   isLoggedIn,
+		LoggedInUser: loggedInUser,
+		ReviewItems:  items,
+	}
+
+	if err := app.renderTemplate(w, r, "moderation.queue.page.html", data); err != nil {
+		logger.ErrorLogger.Printf("Error rendering template: %v\n", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// resolveReview approves or rejects an item in the review queue
+func (app *application) resolveReview(w http.ResponseWriter, r *http.Request) {
+	loggedInUser, _ := app.GetUserFromSession(r)
+
+	if r.URL.Path != "/moderation/queue/resolve" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	item, err := models.GetReviewItemByID(app.db, r.PostFormValue("id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	what := "Your comment on \"" + item.Title + "\""
+	if item.Kind == models.ReviewPost {
+		what = "Your post \"" + item.Title + "\""
+	}
+
+	switch r.PostFormValue("action") {
+	case "approve":
+		if err := models.ApproveReviewItem(app.db, item); err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		app.notifyAuthor(item.UserID, what+" was approved by a moderator.", "/post?id="+item.PostID)
+		if item.Kind == models.ReviewComment {
+			app.notifyWatchers(models.Comment{ID: item.ItemID, UserID: item.UserID, PostID: item.PostID}, item.UserName)
+		}
+
+	case "reject":
+		if err := models.RejectReviewItem(app.db, item); err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		app.notifyAuthor(item.UserID, what+" was removed by a moderator.", "")
+
+	default:
+		http.Error(w, "Unknown action", http.StatusBadRequest)
+		return
+	}
+
+	logger.InfoLogger.Printf("%s %s %sd %s %s by %s\n", loggedInUser.Role, loggedInUser.Name, r.PostFormValue("action"), item.Kind, item.ItemID, item.UserName)
+	http.Redirect(w, r, "/moderation/queue", http.StatusSeeOther)
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"forum/logger"
+	"forum/pkg/models"
+)
+
+// Limits of the tags on a post.
+const (
+	maxTags         = 5
+	maxTagLength    = 30
+	tagSuggestLimit = 10
+)
+
+// splitTags reads a comma separated list of tags, normalized and without
+// duplicates.
+func splitTags(value string) []string {
+	var tags []string
+	for _, name := range strings.Split(value, ",") {
+		if tag := models.NormalizeTag(name); tag != "" && !contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// parseTags reads the tags field of the create form. It returns a message for
+// the form if there are too many tags or one is too long.
+func parseTags(value string) ([]string, string) {
+	tags := splitTags(value)
+	if len(tags) > maxTags {
+		return nil, fmt.Sprintf("A post can have at most %d tags", maxTags)
+	}
+	for _, tag := range tags {
+		if utf8.RuneCountInString(tag) > maxTagLength {
+			return nil, fmt.Sprintf("Each tag must not exceed %d characters", maxTagLength)
+		}
+	}
+	return tags, ""
+}
+
+// attachTags looks up the tags of each post in a list.
+func (app *application) attachTags(posts []models.Post) {
+	for i := range posts {
+		tags, err := models.GetTagsByPostID(app.db, posts[i].ID)
+		if err != nil {
+			logger.ErrorLogger.Printf("Error getting tags: %v\n", err)
+			continue
+		}
+		posts[i].Tags = tags
+	}
+}
+
+// tagPage lists the published posts with a tag. A synonym redirects to the
+// tag it stands for.
+func (app *application) tagPage(w http.ResponseWriter, r *http.Request) {
+	loggedInUser, isLoggedIn := app.GetUserFromSession(r)
+
+	if r.URL.Path != "/tag" {
+		http.NotFound(w, r)
+		return
+	}
+
+	name := models.NormalizeTag(r.URL.Query().Get("name"))
+	tag, err := models.GetTag(app.db, name)
+	if errors.Is(err, models.ErrTagNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if tag.Name != r.URL.Query().Get("name") {
+		http.Redirect(w, r, "/tag?name="+url.QueryEscape(tag.Name), http.StatusMovedPermanently)
+		return
+	}
+
+	posts, err := models.GetPostsByTagID(app.db, tag.ID)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	app.attachImageVariants(posts)
+	app.attachTags(posts)
+
+	data := &templateData{
+		Tag:          tag,
+		Posts:        posts,
+		IsLoggedIn:   isLoggedIn,
+		LoggedInUser: loggedInUser,
+	}
+
+	if err := app.renderTemplate(w, r, "tag.page.html", data); err != nil {
+		logger.ErrorLogger.Printf("Error rendering template: %v\n", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// suggestTags autocompletes the tags field of the create form, given what
+// has been typed of a tag as q.
+func (app *application) suggestTags(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/tags/suggest" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	type suggestion struct {
+		Name  string `json:"name"`
+		Posts int    `json:"posts"`
+	}
+	suggestions := []suggestion{}
+
+	prefix := models.NormalizeTag(r.URL.Query().Get("q"))
+	if prefix != "" {
+		tags, err := models.SuggestTags(app.db, prefix, tagSuggestLimit)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Unable to suggest tags")
+			return
+		}
+		for _, tag := range tags {
+			suggestions = append(suggestions, suggestion{Name: tag.Name, Posts: tag.Posts})
+		}
+	}
+
+	writeJSON(w, http.StatusOK, suggestions)
+}
+
+func (app *application) renderModerationTags(w http.ResponseWriter, r *http.Request, formErrors map[string]string) {
+	loggedInUser, isLoggedIn := app.GetUserFromSession(r)
+
+	tags, err := models.GetTags(app.db)
+	if err != nil {
+		logger.ErrorLogger.Printf("Error getting tags: %v\n", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	data := &templateData{
+		IsLoggedIn:   isLoggedIn,
+		LoggedInUser: loggedInUser,
+		Tags:         tags,
+		FormErrors:   formErrors,
+		FormData:     r.PostForm,
+	}
+
+	status := http.StatusOK
+	if len(formErrors) > 0 {
+		status = http.StatusUnprocessableEntity
+	}
+
+	if err := app.renderTemplateWithStatus(w, r, status, "moderation.tags.page.html", data); err != nil {
+		logger.ErrorLogger.Printf("Error rendering template: %v\n", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// moderationTags lists the tags and lets moderators add synonyms and merge
+// tags.
+func (app *application) moderationTags(w http.ResponseWriter, r *http.Request) {
+	loggedInUser, _ := app.GetUserFromSession(r)
+
+	if r.URL.Path != "/moderation/tags" {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		app.renderModerationTags(w, r, nil)
+
+	case http.MethodPost:
+		if err := r.ParseForm(); err != nil {
+			logger.ErrorLogger.Printf("Error parsing a form: %s\n", err)
+			http.Error(w, "Unable to parse form", http.StatusBadRequest)
+			return
+		}
+
+		switch r.PostForm.Get("action") {
+		case "synonym":
+			name := models.NormalizeTag(r.PostForm.Get("synonym"))
+			tagName := models.NormalizeTag(r.PostForm.Get("tag"))
+			if name == "" || tagName == "" || name == tagName {
+				app.renderModerationTags(w, r, map[string]string{"synonym": "Enter a synonym and the tag it stands for"})
+				return
+			}
+
+			err := models.AddTagSynonym(app.db, name, tagName)
+			if errors.Is(err, models.ErrTagNotFound) {
+				app.renderModerationTags(w, r, map[string]string{"synonym": "No tag with that name"})
+				return
+			}
+			if errors.Is(err, models.ErrTagExists) {
+				app.renderModerationTags(w, r, map[string]string{"synonym": "That name is a tag of its own, merge it instead"})
+				return
+			}
+			if err != nil {
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+
+			logger.InfoLogger.Printf("%s %s made %q a synonym of tag %q\n", loggedInUser.Role, loggedInUser.Name, name, tagName)
+
+		case "merge":
+			from, err := models.GetTag(app.db, models.NormalizeTag(r.PostForm.Get("from")))
+			if errors.Is(err, models.ErrTagNotFound) {
+				app.renderModerationTags(w, r, map[string]string{"merge": "No tag with the name to merge"})
+				return
+			}
+			if err != nil {
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+
+			into, err := models.GetTag(app.db, models.NormalizeTag(r.PostForm.Get("into")))
+			if errors.Is(err, models.ErrTagNotFound) {
+				app.renderModerationTags(w, r, map[string]string{"merge": "No tag with the name to merge into"})
+				return
+			}
+			if err != nil {
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+
+			if from.ID == into.ID {
+				app.renderModerationTags(w, r, map[string]string{"merge": "Choose two different tags"})
+				return
+			}
+
+			if err := models.MergeTags(app.db, from, into); err != nil {
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+
+			logger.InfoLogger.Printf("%s %s merged tag %q into %q\n", loggedInUser.Role, loggedInUser.Name, from.Name, into.Name)
+
+		default:
+			http.Error(w, "Unknown action", http.StatusBadRequest)
+			return
+		}
+
+		http.Redirect(w, r, "/moderation/tags", http.StatusSeeOther)
+
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// removeTagSynonym removes a synonym, leaving the posts tagged as they are.
+func (app *application) removeTagSynonym(w http.ResponseWriter, r *http.Request) {
+	loggedInUser, _ := app.GetUserFromSession(r)
+
+	if r.URL.Path != "/moderation/tags/synonym/delete" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := r.PostFormValue("name")
+	if err := models.RemoveTagSynonym(app.db, name); err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	logger.InfoLogger.Printf("%s %s removed tag synonym %q\n", loggedInUser.Role, loggedInUser.Name, name)
+	http.Redirect(w, r, "/moderation/tags", http.StatusSeeOther)
+}
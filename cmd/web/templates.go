@@ -1,13 +1,16 @@
 package main
 
 import (
+	"fmt"
 	"html/template"
 	"net/url"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"forum/logger"
 	"forum/pkg/models"
+	"forum/pkg/uploads"
 )
 
 type templateData struct {
@@ -27,6 +30,62 @@ type templateData struct {
 	PostDislikes              int
 	UserLikedDislikedPosts    []models.Post
 	UserLikedDislikedComments []models.Comment
+	CSRFToken                 string
+	CSPNonce                  string
+	StatusCode                int
+	StatusText                string
+	ErrorMessage              string
+	TOTPSecret                string
+	QRCode                    template.URL
+	RecoveryCodes             []string
+	RecoveryCodesLeft         int
+	TwoFactorRequired         bool
+	RequiredRoles             map[string]bool
+	Users                     []models.User
+	Passkeys                  []models.Passkey
+	Notifications             []models.Notification
+	UnreadNotifications       int
+	LockedAccounts            []models.LockedAccount
+	Suspensions               []models.Suspension
+	IPBans                    []models.IPBan
+	ReviewItems               []models.ReviewItem
+	Attachments               []models.Attachment
+	StorageUsed               int64
+	StorageQuota              int64
+	DeletionDate              time.Time
+	DeletionDelay             time.Duration
+	DeletedContent            string
+	TimeZone                  string
+	Tag                       models.Tag
+	Tags                      []models.Tag
+	Bookmarks                 []models.Bookmark
+	Collections               []models.Collection
+	Collection                models.Collection
+	// Bookmarked holds the user's bookmarks on a post page, by comment ID
+	// and under "" for the post itself.
+	Bookmarked map[string]models.Bookmark
+	// ThreadWatch is how the user is notified about the post's comments,
+	// see models.GetThreadWatchState.
+	ThreadWatch     string
+	FollowingAuthor bool
+	ThreadWatches   []models.ThreadWatch
+	Categories      []string
+	Subscriptions   []string
+	// Feed is "following" on the home page's Following feed.
+	Feed string
+	// ArchiveDays maps categories to the days of inactivity after which
+	// their threads are archived.
+	ArchiveDays map[string]int
+	// Question is set on the post page when the post is in a Q&A category.
+	Question     bool
+	QACategories []string
+	// Reactions are the emoji users can react with, and EmojiCounts the
+	// reactions on the post page, keyed by comment ID, "" for the post.
+	Reactions   []string
+	EmojiCounts map[string][]emojiCount
+	// PostReaction is the like or dislike the user left on the post page's
+	// post.
+	PostReaction string
 }
 
 func humanDate(t time.Time) string {
@@ -36,8 +95,39 @@ func humanDate(t time.Time) string {
 	return t.Local().Format("15:04 on 02 Jan 2006")
 }
 
+// days writes a duration in whole days, e.g. "14 days".
+func days(d time.Duration) string {
+	n := int((d + 12*time.Hour) / (24 * time.Hour))
+	if n == 1 {
+		return "1 day"
+	}
+	return fmt.Sprintf("%d days", n)
+}
+
+// srcset lists image variants for an img srcset attribute.
+func srcset(variants []models.ImageVariant) string {
+	entries := make([]string, len(variants))
+	for i, v := range variants {
+		entries[i] = fmt.Sprintf("/%s %dw", v.URL, v.Width)
+	}
+	return strings.Join(entries, ", ")
+}
+
+// percent returns part as a whole percentage of total, at most 100.
+func percent(part, total int64) int64 {
+	if total <= 0 || part >= total {
+		return 100
+	}
+	return part * 100 / total
+}
+
 var functions = template.FuncMap{
 	"humanDate": humanDate,
+	"srcset":    srcset,
+	"size":      uploads.FormatSize,
+	"days":      days,
+	"percent":   percent,
+	"contains":  contains,
 }
 
 func newTemplateCache(dir string) (map[string]*template.Template, error) {
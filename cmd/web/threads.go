@@ -0,0 +1,192 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"forum/logger"
+	"forum/pkg/models"
+)
+
+// archiveInterval is how often inactive threads are archived.
+const archiveInterval = time.Hour
+
+// maxArchiveDays is the longest inactivity an admin can set before threads
+// are archived, about ten years.
+const maxArchiveDays = 3650
+
+// threadClosed reports whether a post takes no new comments, because a
+// moderator locked it or it was archived.
+func (app *application) threadClosed(postID string) bool {
+	post, err := models.GetPostByID(app.db, postID)
+	return err == nil && (post.Locked() || post.Archived())
+}
+
+// threadArchived reports whether a post was archived, which also stops
+// reactions and poll votes.
+func (app *application) threadArchived(postID string) bool {
+	post, err := models.GetPostByID(app.db, postID)
+	return err == nil && post.Archived()
+}
+
+// moderateThread pins, locks or archives a post, or undoes it.
+func (app *application) moderateThread(w http.ResponseWriter, r *http.Request) {
+	loggedInUser, _ := app.GetUserFromSession(r)
+
+	if r.URL.Path != "/moderation/thread" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		logger.ErrorLogger.Printf("Error parsing a form: %s\n", err)
+		http.Error(w, "Unable to parse form", http.StatusBadRequest)
+		return
+	}
+
+	postID := r.PostForm.Get("post_id")
+	action := r.PostForm.Get("action")
+
+	var err error
+	switch action {
+	case "pin-global":
+		err = models.PinPost(app.db, postID, models.PinGlobal)
+	case "pin-category":
+		err = models.PinPost(app.db, postID, models.PinCategory)
+	case "unpin":
+		err = models.PinPost(app.db, postID, "")
+	case "lock":
+		err = models.LockPost(app.db, postID, true)
+	case "unlock":
+		err = models.LockPost(app.db, postID, false)
+	case "archive":
+		err = models.ArchivePost(app.db, postID, true)
+	case "unarchive":
+		err = models.ArchivePost(app.db, postID, false)
+	default:
+		http.Error(w, "Unknown action", http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, models.ErrThreadNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	logger.InfoLogger.Printf("%s %s: %s post %s\n", loggedInUser.Role, loggedInUser.Name, action, postID)
+	http.Redirect(w, r, "/post?id="+postID, http.StatusSeeOther)
+}
+
+// adminArchiving sets after how many days without activity the threads of
+// each category are archived.
+func (app *application) adminArchiving(w http.ResponseWriter, r *http.Request) {
+	loggedInUser, isLoggedIn := app.GetUserFromSession(r)
+
+	if r.URL.Path != "/admin/archiving" {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		days, err := models.GetArchiveDays(app.db, postCategories)
+		if err != nil {
+			logger.ErrorLogger.Printf("Error getting archive settings: %v\n", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		data := &templateData{
+			IsLoggedIn:   isLoggedIn,
+			LoggedInUser: loggedInUser,
+			Categories:   postCategories,
+			ArchiveDays:  days,
+		}
+
+		if err := app.renderTemplate(w, r, "admin.archiving.page.html", data); err != nil {
+			logger.ErrorLogger.Printf("Error rendering template: %v\n", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+
+	case http.MethodPost:
+		if err := r.ParseForm(); err != nil {
+			logger.ErrorLogger.Printf("Error parsing a form: %s\n", err)
+			http.Error(w, "Unable to parse form", http.StatusBadRequest)
+			return
+		}
+
+		days := make(map[string]int)
+		for _, category := range postCategories {
+			value := r.PostForm.Get(category)
+			if value == "" {
+				value = "0"
+			}
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 || n > maxArchiveDays {
+				http.Error(w, fmt.Sprintf("Days for %s must be between 0 and %d", category, maxArchiveDays), http.StatusBadRequest)
+				return
+			}
+			days[category] = n
+		}
+
+		for category, n := range days {
+			if err := models.SetArchiveDays(app.db, category, n); err != nil {
+				logger.ErrorLogger.Printf("Error saving archive settings: %v\n", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		logger.InfoLogger.Printf("Admin %s set archive days to %v\n", loggedInUser.Name, days)
+		http.Redirect(w, r, "/admin/archiving", http.StatusSeeOther)
+
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// archiveThreads archives inactive threads every archiveInterval until the
+// process exits.
+func (app *application) archiveThreads() {
+	ticker := time.NewTicker(archiveInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		app.archiveInactiveThreads(time.Now())
+	}
+}
+
+func (app *application) archiveInactiveThreads(now time.Time) {
+	days, err := models.GetArchiveDays(app.db, postCategories)
+	if err != nil {
+		logger.ErrorLogger.Printf("Error getting archive settings: %v\n", err)
+		return
+	}
+
+	for category, n := range days {
+		if n == 0 {
+			continue
+		}
+		archived, err := models.ArchiveInactivePosts(app.db, category, now.AddDate(0, 0, -n), now)
+		if err != nil {
+			logger.ErrorLogger.Printf("Error archiving threads in %s: %v\n", category, err)
+			continue
+		}
+		if archived > 0 {
+			logger.InfoLogger.Printf("Archived %d inactive threads in %s\n", archived, category)
+		}
+	}
+}
@@ -0,0 +1,473 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/base64"
+	"errors"
+	"html/template"
+	"image/png"
+	"net/http"
+	"strings"
+	"time"
+
+	"forum/logger"
+	"forum/pkg/models"
+	"forum/pkg/totp"
+
+	"github.com/google/uuid"
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+const (
+	totpIssuer          = "Forum"
+	mfaCookieName       = "mfa_challenge"
+	mfaChallengeTimeout = 5 * time.Minute
+	recoveryCodeCount   = 10
+)
+
+// generateRecoveryCodes returns new one-time codes formatted as xxxxx-xxxxx.
+func generateRecoveryCodes() ([]string, error) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		raw := make([]byte, 7)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(encoding.EncodeToString(raw))[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+	}
+
+	return codes, nil
+}
+
+func qrCodeDataURL(content string) (template.URL, error) {
+	qr, err := qrcode.New(content, qrcode.Medium)
+	if err != nil {
+		return "", err
+	}
+
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, qr.Image(256)); err != nil {
+		return "", err
+	}
+
+	return template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())), nil
+}
+
+// mustEnrollTwoFactor reports whether the user's role requires two-factor
+// authentication that the user hasn't set up yet.
+func (app *application) mustEnrollTwoFactor(user models.User) bool {
+	if user.TOTPEnabled {
+		return false
+	}
+
+	roles, err := models.GetRolesRequiring2FA(app.db)
+	if err != nil {
+		return false
+	}
+
+	return roles[user.Role]
+}
+
+// checkTOTP validates a code for the user and makes sure it can't be used again.
+func (app *application) checkTOTP(user models.User, code string) bool {
+	step, ok := totp.Validate(user.TOTPSecret, code, time.Now())
+	if !ok {
+		return false
+	}
+
+	fresh, err := models.UseTOTPStep(app.db, user.ID, step)
+	if err != nil {
+		return false
+	}
+
+	return fresh
+}
+
+// startTwoFactorChallenge is called after a correct password for a user with
+// two-factor enabled. The session is only created once the second step passes.
+func (app *application) startTwoFactorChallenge(w http.ResponseWriter, r *http.Request, user models.User) {
+	challenge := models.MFAChallenge{
+		ID:        uuid.New().String(),
+		UserID:    user.ID,
+		ExpiresAt: time.Now().Add(mfaChallengeTimeout),
+	}
+
+	if _, err := models.CreateMFAChallenge(app.db, challenge); err != nil {
+		logger.ErrorLogger.Printf("Error creating login challenge: %v\n", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     mfaCookieName,
+		Value:    challenge.ID,
+		Path:     "/user/login/2fa",
+		HttpOnly: true,
+		Expires:  challenge.ExpiresAt,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, r, "/user/login/2fa", http.StatusSeeOther)
+}
+
+func clearTwoFactorCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     mfaCookieName,
+		Value:    "",
+		MaxAge:   -1,
+		Path:     "/user/login/2fa",
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// second login step for users with two-factor enabled
+func (app *application) loginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/user/login/2fa" {
+		http.NotFound(w, r)
+		return
+	}
+
+	cookie, err := r.Cookie(mfaCookieName)
+	if err != nil {
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	challenge, err := models.GetMFAChallenge(app.db, cookie.Value)
+	if err != nil {
+		if !errors.Is(err, models.ErrMFAChallengeNotFound) {
+			logger.ErrorLogger.Printf("Error getting login challenge: %v\n", err)
+		}
+		clearTwoFactorCookie(w)
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if err := app.renderTemplate(w, r, "login.2fa.page.html", nil); err != nil {
+			logger.ErrorLogger.Printf("Error rendering template: %v\n", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+
+	case http.MethodPost:
+		if err := r.ParseForm(); err != nil {
+			logger.ErrorLogger.Printf("Error parsing a form: %s\n", err)
+			http.Error(w, "Unable to parse form", http.StatusBadRequest)
+			return
+		}
+
+		user, err := models.GetUserByID(app.db, challenge.UserID)
+		if err != nil {
+			logger.ErrorLogger.Printf("Error getting user: %v\n", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if msg := app.suspensionMessage(user.ID); msg != "" {
+			clearTwoFactorCookie(w)
+			app.renderError(w, r, http.StatusForbidden, msg)
+			return
+		}
+
+		if wait, locked := app.loginWait(r, user.Email); wait > 0 {
+			clearTwoFactorCookie(w)
+			app.renderError(w, r, http.StatusTooManyRequests, throttledLoginMessage(w, wait, locked))
+			return
+		}
+
+		code := r.PostForm.Get("code")
+		recoveryCode := r.PostForm.Get("recovery_code")
+
+		verified := false
+		if strings.TrimSpace(recoveryCode) != "" {
+			verified, err = models.UseRecoveryCode(app.db, user.ID, recoveryCode)
+			if err != nil {
+				logger.ErrorLogger.Printf("Error using recovery code: %v\n", err)
+			}
+			if verified {
+				logger.InfoLogger.Printf("User %s signed in with a recovery code\n", user.ID)
+			}
+		} else {
+			verified = app.checkTOTP(user, code)
+		}
+
+		if !verified {
+			app.recordLoginFailure(r, user.Email)
+
+			left, err := models.RecordMFAFailure(app.db, challenge.ID)
+			if err != nil {
+				logger.ErrorLogger.Printf("Error recording failed code: %v\n", err)
+			}
+			if left == 0 {
+				clearTwoFactorCookie(w)
+				app.renderError(w, r, http.StatusUnauthorized, "Too many incorrect codes. Please log in again.")
+				return
+			}
+
+			data := &templateData{
+				FormErrors: map[string]string{"code": "The code is incorrect or has already been used"},
+			}
+			if err := app.renderTemplate(w, r, "login.2fa.page.html", data); err != nil {
+				logger.ErrorLogger.Printf("Error rendering template: %v\n", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		if err := models.DeleteMFAChallenge(app.db, challenge.ID); err != nil {
+			logger.ErrorLogger.Printf("Error deleting login challenge: %v\n", err)
+		}
+		clearTwoFactorCookie(w)
+
+		app.clearLoginFailures(user.Email)
+		app.SetSession(w, r, user.ID)
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+
+	default:
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// security settings page
+func (app *application) securitySettings(w http.ResponseWriter, r *http.Request) {
+	loggedInUser, _ := app.GetUserFromSession(r)
+
+	if r.URL.Path != "/user/settings/security" {
+		http.NotFound(w, r)
+		return
+	}
+
+	app.renderSecuritySettings(w, r, loggedInUser, nil)
+}
+
+func (app *application) enrollTwoFactor(w http.ResponseWriter, r *http.Request) {
+	loggedInUser, isLoggedIn := app.GetUserFromSession(r)
+
+	if r.URL.Path != "/user/settings/2fa/enroll" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if loggedInUser.TOTPEnabled {
+		http.Redirect(w, r, "/user/settings/security", http.StatusSeeOther)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		secret, err := totp.GenerateSecret()
+		if err != nil {
+			logger.ErrorLogger.Printf("Error generating totp secret: %v\n", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if err := models.SetTOTPSecret(app.db, loggedInUser.ID, secret); err != nil {
+			logger.ErrorLogger.Printf("Error saving totp secret: %v\n", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		app.renderEnrollTwoFactor(w, r, loggedInUser, isLoggedIn, secret, nil)
+
+	case http.MethodPost:
+		if err := r.ParseForm(); err != nil {
+			logger.ErrorLogger.Printf("Error parsing a form: %s\n", err)
+			http.Error(w, "Unable to parse form", http.StatusBadRequest)
+			return
+		}
+
+		user, err := models.GetUserByID(app.db, loggedInUser.ID)
+		if err != nil || user.TOTPSecret == "" {
+			http.Redirect(w, r, "/user/settings/2fa/enroll", http.StatusSeeOther)
+			return
+		}
+
+		step, ok := totp.Validate(user.TOTPSecret, r.PostForm.Get("code"), time.Now())
+		if !ok {
+			formErrors := map[string]string{"code": "The code is incorrect, check the time on your phone and try again"}
+			app.renderEnrollTwoFactor(w, r, loggedInUser, isLoggedIn, user.TOTPSecret, formErrors)
+			return
+		}
+
+		codes, err := generateRecoveryCodes()
+		if err != nil {
+			logger.ErrorLogger.Printf("Error generating recovery codes: %v\n", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if err := models.ReplaceRecoveryCodes(app.db, user.ID, codes); err != nil {
+			logger.ErrorLogger.Printf("Error saving recovery codes: %v\n", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if err := models.EnableTOTP(app.db, user.ID, step); err != nil {
+			logger.ErrorLogger.Printf("Error enabling 2FA: %v\n", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		logger.InfoLogger.Printf("User %s enabled two-factor authentication\n", user.ID)
+
+		loggedInUser.TOTPEnabled = true
+		app.renderRecoveryCodes(w, r, loggedInUser, isLoggedIn, codes)
+
+	default:
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (app *application) renderEnrollTwoFactor(w http.ResponseWriter, r *http.Request, user models.User, isLoggedIn bool, secret string, formErrors map[string]string) {
+	qrCode, err := qrCodeDataURL(totp.URI(totpIssuer, user.Email, secret))
+	if err != nil {
+		logger.ErrorLogger.Printf("Error generating QR code: %v\n", err)
+	}
+
+	data := &templateData{
+		IsLoggedIn:   isLoggedIn,
+		LoggedInUser: user,
+		TOTPSecret:   secret,
+		QRCode:       qrCode,
+		FormErrors:   formErrors,
+	}
+
+	if err := app.renderTemplate(w, r, "usersettings.2fa.enroll.page.html", data); err != nil {
+		logger.ErrorLogger.Printf("Error rendering template: %v\n", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+func (app *application) renderRecoveryCodes(w http.ResponseWriter, r *http.Request, user models.User, isLoggedIn bool, codes []string) {
+	data := &templateData{
+		IsLoggedIn:    isLoggedIn,
+		LoggedInUser:  user,
+		RecoveryCodes: codes,
+	}
+
+	if err := app.renderTemplate(w, r, "usersettings.2fa.recovery.page.html", data); err != nil {
+		logger.ErrorLogger.Printf("Error rendering template: %v\n", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// regenerateRecoveryCodes replaces all recovery codes after checking a current code
+func (app *application) regenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	loggedInUser, isLoggedIn := app.GetUserFromSession(r)
+
+	if r.URL.Path != "/user/settings/2fa/recovery" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, err := models.GetUserByID(app.db, loggedInUser.ID)
+	if err != nil || !user.TOTPEnabled {
+		http.Redirect(w, r, "/user/settings/security", http.StatusSeeOther)
+		return
+	}
+
+	if !app.checkTOTP(user, r.PostFormValue("code")) {
+		app.renderSecuritySettings(w, r, loggedInUser, map[string]string{"code": "The code is incorrect or has already been used"})
+		return
+	}
+
+	codes, err := generateRecoveryCodes()
+	if err != nil {
+		logger.ErrorLogger.Printf("Error generating recovery codes: %v\n", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := models.ReplaceRecoveryCodes(app.db, user.ID, codes); err != nil {
+		logger.ErrorLogger.Printf("Error saving recovery codes: %v\n", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	app.renderRecoveryCodes(w, r, loggedInUser, isLoggedIn, codes)
+}
+
+func (app *application) disableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	loggedInUser, _ := app.GetUserFromSession(r)
+
+	if r.URL.Path != "/user/settings/2fa/disable" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	roles, err := models.GetRolesRequiring2FA(app.db)
+	if err != nil {
+		logger.ErrorLogger.Printf("Error getting 2FA settings: %v\n", err)
+	}
+	if roles[loggedInUser.Role] {
+		app.renderSecuritySettings(w, r, loggedInUser, map[string]string{"disable": "Two-factor authentication is required for your role and can't be turned off"})
+		return
+	}
+
+	user, err := models.GetUserByID(app.db, loggedInUser.ID)
+	if err != nil || !user.TOTPEnabled {
+		http.Redirect(w, r, "/user/settings/security", http.StatusSeeOther)
+		return
+	}
+
+	if !app.checkTOTP(user, r.PostFormValue("code")) {
+		app.renderSecuritySettings(w, r, loggedInUser, map[string]string{"disable": "The code is incorrect or has already been used"})
+		return
+	}
+
+	if err := models.DisableTOTP(app.db, user.ID); err != nil {
+		logger.ErrorLogger.Printf("Error disabling 2FA: %v\n", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	logger.InfoLogger.Printf("User %s disabled two-factor authentication\n", user.ID)
+	http.Redirect(w, r, "/user/settings/security", http.StatusSeeOther)
+}
+
+func (app *application) renderSecuritySettings(w http.ResponseWriter, r *http.Request, user models.User, formErrors map[string]string) {
+	codesLeft, err := models.UnusedRecoveryCodeCount(app.db, user.ID)
+	if err != nil {
+		logger.ErrorLogger.Printf("Error counting recovery codes: %v\n", err)
+	}
+
+	roles, err := models.GetRolesRequiring2FA(app.db)
+	if err != nil {
+		logger.ErrorLogger.Printf("Error getting 2FA settings: %v\n", err)
+	}
+
+	data := &templateData{
+		IsLoggedIn:        true,
+		LoggedInUser:      user,
+		RecoveryCodesLeft: codesLeft,
+		TwoFactorRequired: roles[user.Role],
+		FormErrors:        formErrors,
+	}
+
+	if err := app.renderTemplate(w, r, "usersettings.security.page.html", data); err != nil {
+		logger.ErrorLogger.Printf("Error rendering template: %v\n", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
@@ -1,63 +1,176 @@
 package main
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
+	"context"
+	"errors"
+	"fmt"
 	"io"
-	"mime/multipart"
+	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"forum/logger"
+	"forum/pkg/blobstore"
+	"forum/pkg/models"
+	"forum/pkg/uploads"
 )
 
-func (app *application) UploadImage(image multipart.File, extension string) (string, error) {
-	destinationFolder := "ui/static/img/uploads/post"
+const maxImageSize = 20 << 20
 
-	// Create the destination folder if it does not exist
-	if err := os.MkdirAll(destinationFolder, os.ModePerm); err != nil {
-		logger.ErrorLogger.Printf("Error creating destination folder: %v\n", err)
-		return "", err
+// uploadGC schedules the collection of images no post uses any more.
+type uploadGC struct {
+	// interval between collections; zero disables them
+	interval time.Duration
+	grace    time.Duration
+}
+
+// loadUploadGC reads UPLOAD_GC_INTERVAL (default 6h, 0 to disable) and
+// UPLOAD_GC_GRACE (default 24h).
+func loadUploadGC() (uploadGC, error) {
+	gc := uploadGC{interval: 6 * time.Hour, grace: uploads.DefaultGrace}
+
+	if value := os.Getenv("UPLOAD_GC_INTERVAL"); value != "" {
+		interval, err := time.ParseDuration(value)
+		if value == "0" {
+			interval, err = 0, nil
+		}
+		if err != nil || interval < 0 {
+			return gc, fmt.Errorf("invalid UPLOAD_GC_INTERVAL %q", value)
+		}
+		gc.interval = interval
 	}
 
-	// Calculate the hash of the file name
-	hash := sha256.New()
-	if _, err := io.Copy(hash, image); err != nil {
-		logger.ErrorLogger.Printf("Error calculating file hash: %v\n", err)
-		return "", err
+	if value := os.Getenv("UPLOAD_GC_GRACE"); value != "" {
+		grace, err := time.ParseDuration(value)
+		if err != nil || grace < 0 {
+			return gc, fmt.Errorf("invalid UPLOAD_GC_GRACE %q", value)
+		}
+		gc.grace = grace
 	}
-	imageHash := hex.EncodeToString(hash.Sum(nil))
 
-	// Create a new file in the destination folder and write the uploaded file to it
-	fileName := imageHash + extension
-	fullPath := filepath.Join(destinationFolder, fileName)
+	return gc, nil
+}
 
-	// Check if file already exists
-	// if _, err := os.Stat(fullPath); !os.IsNotExist(err) {
-	// 	return "", errors.New("image already exists")
-	// }
+// collectUploads runs the upload garbage collector every interval until the
+// process exits.
+func (app *application) collectUploads() {
+	if app.uploadGC.interval == 0 {
+		return
+	}
 
-	newFile, err := os.Create(fullPath)
-	if err != nil {
-		logger.ErrorLogger.Printf("Error creating new file: %v\n", err)
-		return "", err
+	ticker := time.NewTicker(app.uploadGC.interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		report, err := uploads.Collect(app.db, app.blobs, app.uploadGC.grace, false)
+		if err != nil {
+			logger.ErrorLogger.Printf("Error collecting uploads: %v\n", err)
+			continue
+		}
+		if len(report.Untracked) > 0 || len(report.Collected) > 0 {
+			logger.InfoLogger.Printf("Collected %d upload(s) (%d bytes), started tracking %d\n", len(report.Collected), report.Bytes, len(report.Untracked))
+		}
 	}
-	defer newFile.Close()
+}
+
+// loadUploadStore opens the blob store named by UPLOAD_STORE, fs (the
+// default, storing files in UPLOAD_DIR) or s3.
+func loadUploadStore() (blobstore.BlobStore, error) {
+	return blobstore.Open(os.Getenv("UPLOAD_STORE"))
+}
+
+// attachImageVariants looks up the resized copies of each post's image.
+func (app *application) attachImageVariants(posts []models.Post) {
+	for i := range posts {
+		if posts[i].ImageFullPath == "" {
+			continue
+		}
 
-	// Set the file pointer back to the beginning of the file
-	if _, err := image.Seek(0, 0); err != nil {
-		logger.ErrorLogger.Printf("Error setting file pointer to the beginning: %v\n", err)
-		return "", err
+		variants, err := models.GetImageVariants(app.db, posts[i].ImageFullPath)
+		if err != nil {
+			logger.ErrorLogger.Printf("Error getting image variants: %v\n", err)
+			continue
+		}
+		posts[i].ImageVariants = variants
 	}
+}
 
-	if _, err := io.Copy(newFile, image); err != nil {
-		logger.ErrorLogger.Printf("Error copying file: %v\n", err)
-		return "", err
+// serveUpload serves post attachments. Only names storeAttachments generates
+// are served, with a fixed content type and a policy that keeps the browser
+// from running anything in them; documents are always downloaded. Images in
+// stores that can hand out their own URLs, such as S3 with public or signed
+// URLs, are redirected to instead. Documents are never redirected, since the
+// bucket would serve them without those headers.
+func (app *application) serveUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
 	}
 
-	logger.InfoLogger.Printf("Successfully uploaded file to %v\n", fullPath)
+	dir, name, found := strings.Cut(strings.TrimPrefix(r.URL.Path, uploads.URLPrefix), "/")
+	if !found || !uploads.ValidName(name) || dir != uploads.Dir(name) {
+		http.NotFound(w, r)
+		return
+	}
 
-	// Return the file path
-	return strings.Replace(fullPath, "ui/", "", 1), nil
+	if !uploads.IsDocument(name) {
+		url, err := app.blobs.URL(uploads.Key(name))
+		if err != nil {
+			logger.ErrorLogger.Printf("Error getting URL of upload %v: %v\n", name, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if url != "" {
+			// Signed URLs expire, so the redirect itself must not be cached
+			w.Header().Set("Cache-Control", "no-cache")
+			http.Redirect(w, r, url, http.StatusFound)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Minute)
+	defer cancel()
+
+	body, info, err := app.blobs.Get(ctx, uploads.Key(name))
+	if errors.Is(err, blobstore.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		logger.ErrorLogger.Printf("Error reading upload %v: %v\n", name, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer body.Close()
+
+	w.Header().Set("Content-Type", uploads.ContentType(name))
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	if uploads.IsDocument(name) {
+		w.Header().Set("Content-Disposition", "attachment")
+	}
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+
+	if content, ok := body.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, name, info.ModTime, content)
+		return
+	}
+
+	// Names are content hashes, so they make a stable ETag
+	etag := `"` + strings.TrimSuffix(name, filepath.Ext(name)) + `"`
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if info.Size >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	}
+	if r.Method == http.MethodHead {
+		return
+	}
+	io.Copy(w, body)
 }
@@ -1,7 +1,7 @@
 package main
 
 import (
-	"io"
+	"fmt"
 	"mime/multipart"
 	"regexp"
 	"strings"
@@ -11,7 +11,7 @@ import (
 	"forum/pkg/models"
 )
 
-func validateCreatePostForm(title, content, extension string, categories []string, handler *multipart.FileHeader) map[string]string {
+func validateCreatePostForm(title, content string, categories []string, files []*multipart.FileHeader) map[string]string {
 	errors := make(map[string]string)
 
 	title = strings.TrimSpace(title)
@@ -32,60 +32,29 @@ func validateCreatePostForm(title, content, extension string, categories []strin
 		errors["categories"] = "Please select between 1 and 3 categories"
 	}
 
-	// Check if the file is actually an image by reading the first few bytes and comparing them to
-	// the magic numbers of image file formats
-	if handler != nil {
-		image, err := handler.Open()
-		if err != nil {
-			errors["image"] = "Unable to read image file"
-		} else {
-			defer image.Close()
-
-			// Read the first few bytes of the file
-			buffer := make([]byte, 512)
-			if _, err := io.ReadFull(image, buffer); err != nil {
-				errors["image"] = "Unable to read image file"
-			} else {
-				// Check if the magic number matches one of the supported image formats
-				// contentType := http.DetectContentType(buffer)
-				contentType := handler.Header.Get("Content-Type")
-				if !strings.HasPrefix(contentType, "image/") {
-					errors["image"] = "Only image files are supported"
-				} else if !strings.EqualFold(extension, ".jpeg") && !strings.EqualFold(extension, ".jpg") &&
-					!strings.EqualFold(extension, ".png") && !strings.EqualFold(extension, ".svg") &&
-					!strings.EqualFold(extension, ".gif") {
-					errors["image"] = "Only JPEG, PNG, SVG, and GIF file formats are supported"
-				}
-			}
-		}
+	// The files' types are checked from their content when they are read
+	if len(files) > maxAttachments {
+		errors["attachments"] = fmt.Sprintf("A post can have at most %d attachments", maxAttachments)
 	}
-
-	if handler.Size > 20<<20 {
-		errors["image"] = "file size should not exceed 20MB"
+	for _, fh := range files {
+		if fh.Size > maxImageSize {
+			errors["attachments"] = "Each file must not exceed 20MB"
+		}
 	}
 
 	return errors
 }
 
-func validateCreatePostFormWithoutImage(title, content string, categories []string) map[string]string {
-	errors := make(map[string]string)
+// validateDraftForm checks a post saved as a draft. Only a title is needed, so
+// the draft can be found again; the rest is checked when it is published.
+func validateDraftForm(title, content string, categories []string, files []*multipart.FileHeader) map[string]string {
+	errors := validateCreatePostForm(title, content, categories, files)
 
-	title = strings.TrimSpace(title)
-	if title == "" {
-		errors["title"] = "Title is required"
-	} else if utf8.RuneCountInString(title) > 50 {
-		errors["title"] = "Title must not exceed 50 characters"
+	if strings.TrimSpace(content) == "" {
+		delete(errors, "content")
 	}
-
-	content = strings.TrimSpace(content)
-	if content == "" {
-		errors["content"] = "Content is required"
-	} else if utf8.RuneCountInString(content) > 500 {
-		errors["content"] = "Must not exceed 1000 characters"
-	}
-
-	if len(categories) < 1 || len(categories) > 3 {
-		errors["categories"] = "Please select between 1 and 3 categories"
+	if len(categories) == 0 {
+		delete(errors, "categories")
 	}
 
 	return errors
@@ -135,6 +104,8 @@ func (app *application) validateSignUpForm(name, email, password string) map[str
 		errors["password"] = "Password is required"
 	} else if !checkPassword(password) {
 		errors["password"] = "Password must contain at least 6 characters, including at least one uppercase letter, one lowercase letter, one number, and one special character."
+	} else if app.breachedPasswords.Contains(password) {
+		errors["password"] = "This password has appeared in a data breach and is easy to guess. Please choose a different one."
 	}
 
 	dbName, _ := models.GetUserByName(app.db, name)
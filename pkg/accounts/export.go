@@ -0,0 +1,400 @@
+// Package accounts exports a user's data and deletes accounts once their
+// cooling-off period is over.
+package accounts
+
+import (
+	"archive/zip"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"path"
+	"time"
+
+	"forum/logger"
+	"forum/pkg/blobstore"
+	"forum/pkg/models"
+	"forum/pkg/uploads"
+)
+
+// The export holds one JSON file per kind of data and the user's uploads
+// under uploads/. Session IDs and secrets such as the password hash are left
+// out: they are credentials rather than the user's data.
+
+type profile struct {
+	ID               string    `json:"id"`
+	Name             string    `json:"name"`
+	Email            string    `json:"email"`
+	Role             string    `json:"role"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+	TwoFactorEnabled bool      `json:"two_factor_enabled"`
+	Passkeys         []passkey `json:"passkeys"`
+}
+
+type passkey struct {
+	Name       string     `json:"name"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+type post struct {
+	ID          string       `json:"id"`
+	Title       string       `json:"title"`
+	Content     string       `json:"content"`
+	Category    string       `json:"category"`
+	Tags        []string     `json:"tags"`
+	Status      string       `json:"status"`
+	CreatedAt   time.Time    `json:"created_at"`
+	Attachments []attachment `json:"attachments"`
+}
+
+type attachment struct {
+	Kind     string `json:"kind"`
+	FileName string `json:"file_name"`
+	MimeType string `json:"mime"`
+	Size     int64  `json:"size"`
+	// File is the path of the upload in the export, empty if it could not be
+	// read.
+	File string `json:"file"`
+}
+
+type comment struct {
+	ID        string    `json:"id"`
+	PostID    string    `json:"post_id"`
+	PostTitle string    `json:"post_title"`
+	Content   string    `json:"content"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type reactions struct {
+	Posts    []postReaction    `json:"posts"`
+	Comments []commentReaction `json:"comments"`
+}
+
+type postReaction struct {
+	PostID    string    `json:"post_id"`
+	Reaction  string    `json:"reaction"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type commentReaction struct {
+	PostID    string    `json:"post_id"`
+	CommentID string    `json:"comment_id"`
+	Reaction  string    `json:"reaction"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type pollVote struct {
+	PostID    string    `json:"post_id"`
+	Option    string    `json:"option"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type bookmark struct {
+	PostID     string    `json:"post_id"`
+	PostTitle  string    `json:"post_title"`
+	CommentID  string    `json:"comment_id,omitempty"`
+	Collection string    `json:"collection,omitempty"`
+	Note       string    `json:"note"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type following struct {
+	Users      []string      `json:"users"`
+	Categories []string      `json:"categories"`
+	Threads    []threadWatch `json:"threads"`
+}
+
+type threadWatch struct {
+	PostID    string    `json:"post_id"`
+	PostTitle string    `json:"post_title"`
+	State     string    `json:"state"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type session struct {
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Export writes a zip of the user's profile, posts, comments, reactions, poll
+// votes, bookmarks, follows and sessions as JSON, along with the files
+// attached to their posts.
+func Export(w io.Writer, db *sql.DB, store blobstore.BlobStore, userID string) error {
+	user, err := models.GetUserByID(db, userID)
+	if err != nil {
+		return err
+	}
+
+	passkeys, err := models.GetPasskeysByUserID(db, userID)
+	if err != nil {
+		return err
+	}
+
+	posts, err := models.GetAllPostsByUserID(db, userID)
+	if err != nil {
+		return err
+	}
+
+	attachments, err := models.GetAttachmentsByUserID(db, userID)
+	if err != nil {
+		return err
+	}
+
+	comments, err := models.GetAllCommentsByUserID(db, userID)
+	if err != nil {
+		return err
+	}
+
+	postReactions, err := models.GetPostReactionsByUserID(db, userID)
+	if err != nil {
+		return err
+	}
+
+	commentReactions, err := models.GetCommentReactionsByUserID(db, userID)
+	if err != nil {
+		return err
+	}
+
+	emojiReactions, err := models.GetEmojiReactionsByUserID(db, userID)
+	if err != nil {
+		return err
+	}
+
+	votes, err := models.GetPollVotesByUserID(db, userID)
+	if err != nil {
+		return err
+	}
+
+	bookmarks, err := models.GetBookmarksByUserID(db, userID, "")
+	if err != nil {
+		return err
+	}
+
+	followed, err := models.GetFollowedUsers(db, userID)
+	if err != nil {
+		return err
+	}
+
+	subscriptions, err := models.GetCategorySubscriptions(db, userID)
+	if err != nil {
+		return err
+	}
+
+	watches, err := models.GetThreadWatchesByUserID(db, userID)
+	if err != nil {
+		return err
+	}
+
+	sessions, err := models.GetSessionsByUserID(db, userID)
+	if err != nil {
+		return err
+	}
+
+	z := zip.NewWriter(w)
+
+	p := profile{
+		ID:               user.ID,
+		Name:             user.Name,
+		Email:            user.Email,
+		Role:             user.Role,
+		CreatedAt:        user.CreatedAt,
+		UpdatedAt:        user.UpdatedAt,
+		TwoFactorEnabled: user.TOTPEnabled,
+		Passkeys:         []passkey{},
+	}
+	for _, k := range passkeys {
+		exported := passkey{Name: k.Name, CreatedAt: k.CreatedAt}
+		if k.LastUsedAt.Valid {
+			exported.LastUsedAt = &k.LastUsedAt.Time
+		}
+		p.Passkeys = append(p.Passkeys, exported)
+	}
+	if err := writeJSON(z, "profile.json", p); err != nil {
+		return err
+	}
+
+	// Files go in the zip once, however many posts they are attached to
+	files := make(map[string]string)
+	byPost := make(map[string][]attachment)
+	for _, a := range attachments {
+		file, ok := files[a.URL]
+		if !ok {
+			file = writeUpload(z, store, a.URL)
+			files[a.URL] = file
+		}
+		byPost[a.PostID] = append(byPost[a.PostID], attachment{
+			Kind:     a.Kind,
+			FileName: a.FileName,
+			MimeType: a.MimeType,
+			Size:     a.Size,
+			File:     file,
+		})
+	}
+
+	exportedPosts := []post{}
+	for _, p := range posts {
+		exported := post{
+			ID:          p.ID,
+			Title:       p.Title,
+			Content:     p.Content,
+			Category:    p.Category,
+			Status:      p.Status,
+			CreatedAt:   p.CreatedAt,
+			Attachments: byPost[p.ID],
+		}
+		if exported.Attachments == nil {
+			exported.Attachments = []attachment{}
+		}
+		if exported.Tags, err = models.GetTagsByPostID(db, p.ID); err != nil {
+			return err
+		}
+		if exported.Tags == nil {
+			exported.Tags = []string{}
+		}
+		exportedPosts = append(exportedPosts, exported)
+	}
+	if err := writeJSON(z, "posts.json", exportedPosts); err != nil {
+		return err
+	}
+
+	exportedComments := []comment{}
+	for _, c := range comments {
+		exportedComments = append(exportedComments, comment{
+			ID:        c.ID,
+			PostID:    c.PostID,
+			PostTitle: c.Post.Title,
+			Content:   c.Content,
+			Status:    c.Status,
+			CreatedAt: c.CreatedAt,
+		})
+	}
+	if err := writeJSON(z, "comments.json", exportedComments); err != nil {
+		return err
+	}
+
+	exportedReactions := reactions{Posts: []postReaction{}, Comments: []commentReaction{}}
+	for _, r := range postReactions {
+		exportedReactions.Posts = append(exportedReactions.Posts, postReaction{
+			PostID:    r.PostID,
+			Reaction:  r.ReactionType,
+			CreatedAt: r.CreatedAt,
+		})
+	}
+	for _, r := range commentReactions {
+		exportedReactions.Comments = append(exportedReactions.Comments, commentReaction{
+			PostID:    r.PostID,
+			CommentID: r.CommentID,
+			Reaction:  r.ReactionType,
+			CreatedAt: r.CreatedAt,
+		})
+	}
+	for _, r := range emojiReactions {
+		if r.CommentID == "" {
+			exportedReactions.Posts = append(exportedReactions.Posts, postReaction{
+				PostID:    r.PostID,
+				Reaction:  r.Emoji,
+				CreatedAt: r.CreatedAt,
+			})
+			continue
+		}
+		exportedReactions.Comments = append(exportedReactions.Comments, commentReaction{
+			PostID:    r.PostID,
+			CommentID: r.CommentID,
+			Reaction:  r.Emoji,
+			CreatedAt: r.CreatedAt,
+		})
+	}
+	if err := writeJSON(z, "reactions.json", exportedReactions); err != nil {
+		return err
+	}
+
+	exportedVotes := []pollVote{}
+	for _, v := range votes {
+		exportedVotes = append(exportedVotes, pollVote{PostID: v.PostID, Option: v.Option, CreatedAt: v.CreatedAt})
+	}
+	if err := writeJSON(z, "poll_votes.json", exportedVotes); err != nil {
+		return err
+	}
+
+	exportedBookmarks := []bookmark{}
+	for _, b := range bookmarks {
+		exportedBookmarks = append(exportedBookmarks, bookmark{
+			PostID:     b.PostID,
+			PostTitle:  b.PostTitle,
+			CommentID:  b.CommentID,
+			Collection: b.CollectionName,
+			Note:       b.Note,
+			CreatedAt:  b.CreatedAt,
+		})
+	}
+	if err := writeJSON(z, "bookmarks.json", exportedBookmarks); err != nil {
+		return err
+	}
+
+	exportedFollowing := following{Users: []string{}, Categories: []string{}, Threads: []threadWatch{}}
+	for _, u := range followed {
+		exportedFollowing.Users = append(exportedFollowing.Users, u.Name)
+	}
+	exportedFollowing.Categories = append(exportedFollowing.Categories, subscriptions...)
+	for _, w := range watches {
+		exportedFollowing.Threads = append(exportedFollowing.Threads, threadWatch{
+			PostID:    w.PostID,
+			PostTitle: w.PostTitle,
+			State:     w.State,
+			CreatedAt: w.CreatedAt,
+		})
+	}
+	if err := writeJSON(z, "following.json", exportedFollowing); err != nil {
+		return err
+	}
+
+	exportedSessions := []session{}
+	for _, s := range sessions {
+		exportedSessions = append(exportedSessions, session{CreatedAt: s.CreatedAt, ExpiresAt: s.ExpiresAt})
+	}
+	if err := writeJSON(z, "sessions.json", exportedSessions); err != nil {
+		return err
+	}
+
+	return z.Close()
+}
+
+func writeJSON(z *zip.Writer, name string, v interface{}) error {
+	f, err := z.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(f)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// writeUpload adds the upload behind url to the zip and returns its path
+// there. A file that can't be read is logged and left out, so one missing
+// file doesn't stop the user getting the rest of their data.
+func writeUpload(z *zip.Writer, store blobstore.BlobStore, url string) string {
+	data, ok, err := uploads.Read(store, url)
+	if !ok {
+		return ""
+	}
+	if err != nil {
+		logger.ErrorLogger.Printf("Error reading upload %s for export: %v\n", url, err)
+		return ""
+	}
+
+	name := "uploads/" + path.Base(url)
+	f, err := z.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store, Modified: time.Now()})
+	if err != nil {
+		logger.ErrorLogger.Printf("Error adding upload %s to export: %v\n", url, err)
+		return ""
+	}
+	if _, err := f.Write(data); err != nil {
+		logger.ErrorLogger.Printf("Error adding upload %s to export: %v\n", url, err)
+		return ""
+	}
+
+	return name
+}
@@ -0,0 +1,41 @@
+package accounts
+
+import (
+	"database/sql"
+	"time"
+
+	"forum/pkg/models"
+)
+
+// DefaultDelay is the cooling-off period between a user asking for their
+// account to be deleted and the deletion. Logging in and cancelling during it
+// keeps the account.
+const DefaultDelay = 14 * 24 * time.Hour
+
+// Purge deletes the accounts whose cooling-off period is over, removing or
+// anonymizing their content according to the admin policy. It returns the
+// accounts it deleted, or would delete with dryRun.
+func Purge(db *sql.DB, delay time.Duration, dryRun bool) ([]models.User, error) {
+	due, err := models.GetAccountsDueForDeletion(db, delay)
+	if err != nil || dryRun {
+		return due, err
+	}
+
+	policy, err := models.GetDeletedContentPolicy(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var purged []models.User
+	for _, user := range due {
+		deleted, err := models.PurgeAccount(db, user.ID, policy)
+		if err != nil {
+			return purged, err
+		}
+		if deleted {
+			purged = append(purged, user)
+		}
+	}
+
+	return purged, nil
+}
@@ -0,0 +1,120 @@
+// Package blobstore stores uploaded files on local disk or in an
+// S3-compatible object store.
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNotFound   = errors.New("blob not found")
+	ErrInvalidKey = errors.New("invalid blob key")
+)
+
+// Info describes a stored blob.
+type Info struct {
+	Size        int64
+	ModTime     time.Time
+	ContentType string
+}
+
+// BlobStore is where uploads are kept. Keys are slash separated paths such
+// as "post/<name>.jpg".
+type BlobStore interface {
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	// Get returns the blob's content, which the caller must close. Stores
+	// on disk return an io.ReadSeeker as well.
+	Get(ctx context.Context, key string) (io.ReadCloser, Info, error)
+	// Stat returns ErrNotFound if there is no blob with the key.
+	Stat(ctx context.Context, key string) (Info, error)
+	Delete(ctx context.Context, key string) error
+	// List calls fn with every key under prefix.
+	List(ctx context.Context, prefix string, fn func(key string) error) error
+	// URL returns a URL the browser can fetch the blob from directly, or
+	// an empty string if it has to be served by the application.
+	URL(key string) (string, error)
+	// Origin returns the scheme and host of the URLs URL hands out, or an
+	// empty string if it hands out none.
+	Origin() string
+}
+
+// Store kinds accepted by Open.
+const (
+	KindFS = "fs"
+	KindS3 = "s3"
+)
+
+// validKey rejects keys that could escape the store's root.
+func validKey(key string) bool {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return false
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// Open returns the store of the given kind, configured from the environment:
+// UPLOAD_DIR for the file system, and S3_ENDPOINT, S3_REGION, S3_BUCKET,
+// S3_ACCESS_KEY, S3_SECRET_KEY, S3_PATH_STYLE, S3_PUBLIC_URL, S3_SIGNED_URLS
+// and S3_URL_EXPIRY for S3.
+func Open(kind string) (BlobStore, error) {
+	switch kind {
+	case KindFS, "":
+		dir := os.Getenv("UPLOAD_DIR")
+		if dir == "" {
+			dir = "uploads"
+		}
+		return NewFS(dir), nil
+
+	case KindS3:
+		config := S3Config{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			Region:    os.Getenv("S3_REGION"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+			PublicURL: os.Getenv("S3_PUBLIC_URL"),
+			PathStyle: true,
+			URLExpiry: 15 * time.Minute,
+		}
+
+		if value := os.Getenv("S3_PATH_STYLE"); value != "" {
+			pathStyle, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid S3_PATH_STYLE %q", value)
+			}
+			config.PathStyle = pathStyle
+		}
+
+		if value := os.Getenv("S3_SIGNED_URLS"); value != "" {
+			signed, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid S3_SIGNED_URLS %q", value)
+			}
+			config.SignedURLs = signed
+		}
+
+		if value := os.Getenv("S3_URL_EXPIRY"); value != "" {
+			expiry, err := time.ParseDuration(value)
+			if err != nil || expiry < time.Second || expiry > 7*24*time.Hour {
+				return nil, fmt.Errorf("invalid S3_URL_EXPIRY %q, must be between 1s and 168h", value)
+			}
+			config.URLExpiry = expiry
+		}
+
+		return NewS3(config)
+	}
+
+	return nil, fmt.Errorf("unknown blob store %q, expected %s or %s", kind, KindFS, KindS3)
+}
@@ -0,0 +1,442 @@
+package blobstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testBucket    = "forum"
+	testAccessKey = "test-access-key"
+	testSecretKey = "test-secret-key"
+)
+
+// fakeS3 is an in-memory stand-in for an S3 service with path-style
+// buckets. It checks the SigV4 signature on every request, in the
+// Authorization header or in a presigned URL, and refuses the request with
+// SignatureDoesNotMatch as S3 does if it is wrong.
+type fakeS3 struct {
+	t        *testing.T
+	server   *httptest.Server
+	verifier *S3
+
+	mu      sync.Mutex
+	objects map[string]fakeObject
+}
+
+type fakeObject struct {
+	data        []byte
+	contentType string
+	modified    time.Time
+}
+
+func newFakeS3(t *testing.T) *fakeS3 {
+	t.Helper()
+
+	f := &fakeS3{t: t, objects: map[string]fakeObject{}}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serveHTTP))
+	t.Cleanup(f.server.Close)
+
+	verifier, err := NewS3(f.config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.verifier = verifier
+	return f
+}
+
+func (f *fakeS3) config() S3Config {
+	return S3Config{
+		Endpoint:  f.server.URL,
+		Bucket:    testBucket,
+		AccessKey: testAccessKey,
+		SecretKey: testSecretKey,
+		PathStyle: true,
+	}
+}
+
+// store returns a client for the service, with changes made to its config.
+func (f *fakeS3) store(change func(*S3Config)) *S3 {
+	f.t.Helper()
+
+	config := f.config()
+	if change != nil {
+		change(&config)
+	}
+	store, err := NewS3(config)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	return store
+}
+
+func (f *fakeS3) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		fakeS3Error(w, http.StatusBadRequest, "IncompleteBody")
+		return
+	}
+
+	if r.URL.Query().Get("X-Amz-Signature") != "" {
+		err = f.checkPresigned(r)
+	} else {
+		err = f.checkAuthorization(r, body)
+	}
+	if err != nil {
+		f.t.Logf("%s %s: %v", r.Method, r.URL, err)
+		fakeS3Error(w, http.StatusForbidden, "SignatureDoesNotMatch")
+		return
+	}
+
+	key := strings.TrimPrefix(r.URL.Path, "/"+testBucket+"/")
+	if key == r.URL.Path {
+		fakeS3Error(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodGet && key == "" && r.URL.Query().Get("list-type") == "2":
+		f.list(w, r.URL.Query().Get("prefix"))
+
+	case r.Method == http.MethodPut:
+		f.objects[key] = fakeObject{data: body, contentType: r.Header.Get("Content-Type"), modified: time.Now()}
+
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		object, ok := f.objects[key]
+		if !ok {
+			fakeS3Error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("Content-Type", object.contentType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(object.data)))
+		w.Header().Set("Last-Modified", object.modified.UTC().Format(http.TimeFormat))
+		if r.Method == http.MethodGet {
+			w.Write(object.data)
+		}
+
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		fakeS3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, prefix string) {
+	var result listBucketResult
+	for key := range f.objects {
+		if strings.HasPrefix(key, prefix) {
+			result.Contents = append(result.Contents, struct {
+				Key string `xml:"Key"`
+			}{key})
+		}
+	}
+	sort.Slice(result.Contents, func(i, j int) bool { return result.Contents[i].Key < result.Contents[j].Key })
+
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"ListBucketResult"`
+		listBucketResult
+	}{listBucketResult: result})
+}
+
+func fakeS3Error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string   `xml:"Code"`
+		Message string   `xml:"Message"`
+	}{Code: code, Message: code})
+}
+
+// checkAuthorization recomputes the signature of a request signed in its
+// Authorization header.
+func (f *fakeS3) checkAuthorization(r *http.Request, body []byte) error {
+	credential, signedHeaders, signature, ok := parseAuthorization(r.Header.Get("Authorization"))
+	if !ok {
+		return errors.New("malformed Authorization header")
+	}
+
+	now, err := time.Parse(sigDateFormat, r.Header.Get("X-Amz-Date"))
+	if err != nil {
+		return errors.New("missing X-Amz-Date")
+	}
+	if credential != testAccessKey+"/"+f.verifier.scope(now) {
+		return errors.New("wrong credential " + credential)
+	}
+
+	payloadHash := r.Header.Get("X-Amz-Content-Sha256")
+	if payloadHash != sha256Hex(body) {
+		return errors.New("X-Amz-Content-Sha256 does not match the body")
+	}
+
+	headers := map[string]string{}
+	for _, name := range strings.Split(signedHeaders, ";") {
+		if name == "host" {
+			headers[name] = r.Host
+		} else {
+			headers[name] = r.Header.Get(name)
+		}
+	}
+	for _, name := range []string{"host", "x-amz-date", "x-amz-content-sha256"} {
+		if _, ok := headers[name]; !ok {
+			return errors.New(name + " is not signed")
+		}
+	}
+	if _, ok := headers["content-type"]; !ok && r.Header.Get("Content-Type") != "" {
+		return errors.New("content-type is not signed")
+	}
+
+	request, _ := canonicalRequest(r.Method, r.URL, headers, payloadHash)
+	return f.checkSignature(request, now, signature)
+}
+
+// checkPresigned recomputes the signature of a presigned URL.
+func (f *fakeS3) checkPresigned(r *http.Request) error {
+	query := r.URL.Query()
+	signature := query.Get("X-Amz-Signature")
+	query.Del("X-Amz-Signature")
+
+	now, err := time.Parse(sigDateFormat, query.Get("X-Amz-Date"))
+	if err != nil {
+		return errors.New("missing X-Amz-Date")
+	}
+	if query.Get("X-Amz-Credential") != testAccessKey+"/"+f.verifier.scope(now) {
+		return errors.New("wrong credential " + query.Get("X-Amz-Credential"))
+	}
+	if query.Get("X-Amz-SignedHeaders") != "host" {
+		return errors.New("unexpected signed headers " + query.Get("X-Amz-SignedHeaders"))
+	}
+	expires, err := strconv.Atoi(query.Get("X-Amz-Expires"))
+	if err != nil || time.Since(now) > time.Duration(expires)*time.Second {
+		return errors.New("URL has expired")
+	}
+
+	u := *r.URL
+	u.RawQuery = query.Encode()
+	request, _ := canonicalRequest(r.Method, &u, map[string]string{"host": r.Host}, unsignedPayload)
+	return f.checkSignature(request, now, signature)
+}
+
+func (f *fakeS3) checkSignature(request string, now time.Time, signature string) error {
+	stringToSign := strings.Join([]string{
+		sigAlgorithm,
+		now.Format(sigDateFormat),
+		f.verifier.scope(now),
+		sha256Hex([]byte(request)),
+	}, "\n")
+	if !hmac.Equal([]byte(signature), []byte(f.verifier.signature(stringToSign, now))) {
+		return errors.New("signature does not match")
+	}
+	return nil
+}
+
+func parseAuthorization(header string) (credential, signedHeaders, signature string, ok bool) {
+	if !strings.HasPrefix(header, sigAlgorithm+" ") {
+		return "", "", "", false
+	}
+	for _, field := range strings.Split(strings.TrimPrefix(header, sigAlgorithm+" "), ", ") {
+		name, value, _ := strings.Cut(field, "=")
+		switch name {
+		case "Credential":
+			credential = value
+		case "SignedHeaders":
+			signedHeaders = value
+		case "Signature":
+			signature = value
+		}
+	}
+	return credential, signedHeaders, signature, credential != "" && signedHeaders != "" && signature != ""
+}
+
+func TestStores(t *testing.T) {
+	stores := map[string]func(t *testing.T) BlobStore{
+		"fs": func(t *testing.T) BlobStore { return NewFS(t.TempDir()) },
+		"s3": func(t *testing.T) BlobStore { return newFakeS3(t).store(nil) },
+	}
+
+	for name, open := range stores {
+		t.Run(name, func(t *testing.T) {
+			testStore(t, open(t))
+		})
+	}
+}
+
+func testStore(t *testing.T, store BlobStore) {
+	ctx := context.Background()
+	// A space and a plus sign need escaping in the signed path
+	key := "post/a b+c.jpg"
+	data := []byte("not really a JPEG")
+
+	if _, err := store.Stat(ctx, key); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Stat before Put: err = %v, want ErrNotFound", err)
+	}
+	if _, _, err := store.Get(ctx, key); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get before Put: err = %v, want ErrNotFound", err)
+	}
+
+	if err := store.Put(ctx, key, data, "image/jpeg"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if err := store.Put(ctx, "file/d.txt", []byte("text"), "text/plain; charset=utf-8"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	info, err := store.Stat(ctx, key)
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if info.Size != int64(len(data)) || info.ContentType != "image/jpeg" || info.ModTime.IsZero() {
+		t.Errorf("Stat = %+v, want %d bytes of image/jpeg with a modification time", info, len(data))
+	}
+
+	body, info, err := store.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	got, err := io.ReadAll(body)
+	body.Close()
+	if err != nil {
+		t.Fatalf("reading blob: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("Get = %q, want %q", got, data)
+	}
+	if info.Size != int64(len(data)) {
+		t.Errorf("Get size = %d, want %d", info.Size, len(data))
+	}
+
+	var keys []string
+	err = store.List(ctx, "post/", func(key string) error {
+		keys = append(keys, key)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(keys) != 1 || keys[0] != key {
+		t.Errorf("List = %q, want [%q]", keys, key)
+	}
+
+	if err := store.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := store.Stat(ctx, key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Stat after Delete: err = %v, want ErrNotFound", err)
+	}
+	if err := store.Delete(ctx, key); err != nil {
+		t.Errorf("Delete of a missing blob: %v", err)
+	}
+
+	for _, bad := range []string{"", "/post/x.jpg", "post/../x.jpg", `post\x.jpg`} {
+		if err := store.Put(ctx, bad, data, ""); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Put(%q): err = %v, want ErrInvalidKey", bad, err)
+		}
+	}
+}
+
+func TestS3WrongSecret(t *testing.T) {
+	store := newFakeS3(t).store(func(config *S3Config) { config.SecretKey = "wrong" })
+
+	err := store.Put(context.Background(), "post/x.jpg", []byte("x"), "image/jpeg")
+	var s3Err *S3Error
+	if !errors.As(err, &s3Err) || s3Err.StatusCode != http.StatusForbidden || s3Err.Code != "SignatureDoesNotMatch" {
+		t.Fatalf("Put with the wrong secret: err = %v, want SignatureDoesNotMatch", err)
+	}
+}
+
+func TestS3SignedURL(t *testing.T) {
+	f := newFakeS3(t)
+	store := f.store(func(config *S3Config) { config.SignedURLs = true })
+	key := "post/a b+c.jpg"
+	data := []byte("not really a JPEG")
+
+	if err := store.Put(context.Background(), key, data, "image/jpeg"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	signed, err := store.URL(key)
+	if err != nil {
+		t.Fatalf("URL: %v", err)
+	}
+	if !strings.HasPrefix(signed, f.server.URL+"/") {
+		t.Fatalf("URL = %q, want one on %s", signed, f.server.URL)
+	}
+	if origin := store.Origin(); origin != f.server.URL {
+		t.Errorf("Origin = %q, want %q", origin, f.server.URL)
+	}
+
+	resp, err := http.Get(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !bytes.Equal(got, data) {
+		t.Errorf("GET signed URL = %d %q, want 200 %q", resp.StatusCode, got, data)
+	}
+
+	// The signature covers the key
+	tampered := strings.Replace(signed, "a%20b%2Bc.jpg", "other.jpg", 1)
+	resp, err = http.Get(tampered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("GET tampered URL = %d, want 403", resp.StatusCode)
+	}
+}
+
+func TestS3PublicURL(t *testing.T) {
+	store := newFakeS3(t).store(func(config *S3Config) {
+		config.PublicURL = "https://cdn.example/forum/"
+		config.SignedURLs = true
+	})
+
+	u, err := store.URL("post/a b+c.jpg")
+	if err != nil {
+		t.Fatalf("URL: %v", err)
+	}
+	if want := "https://cdn.example/forum/post/a%20b%2Bc.jpg"; u != want {
+		t.Errorf("URL = %q, want %q", u, want)
+	}
+	if origin := store.Origin(); origin != "https://cdn.example" {
+		t.Errorf("Origin = %q, want https://cdn.example", origin)
+	}
+
+	if _, err := NewS3(S3Config{
+		Endpoint: "http://localhost:9000", Bucket: testBucket,
+		AccessKey: testAccessKey, SecretKey: testSecretKey,
+		PublicURL: "cdn.example",
+	}); err == nil {
+		t.Error("NewS3 accepted a public URL without a scheme")
+	}
+}
+
+func TestFSHasNoURLs(t *testing.T) {
+	store := NewFS(t.TempDir())
+	if u, err := store.URL("post/x.jpg"); u != "" || err != nil {
+		t.Errorf("URL = %q, %v, want empty", u, err)
+	}
+	if origin := store.Origin(); origin != "" {
+		t.Errorf("Origin = %q, want empty", origin)
+	}
+}
@@ -0,0 +1,313 @@
+package blobstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3Config configures an S3-compatible store such as AWS S3 or MinIO.
+type S3Config struct {
+	// Endpoint is the base URL of the service, e.g. https://s3.eu-north-1.amazonaws.com
+	// or http://localhost:9000.
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// PathStyle puts the bucket in the path rather than the host name, which
+	// MinIO and most self-hosted services need.
+	PathStyle bool
+
+	// PublicURL, if set, is a base URL that serves the bucket publicly, such
+	// as a CDN. Browsers are sent there instead of through the application.
+	PublicURL string
+	// SignedURLs sends browsers to presigned URLs valid for URLExpiry. It is
+	// ignored when PublicURL is set.
+	SignedURLs bool
+	URLExpiry  time.Duration
+}
+
+// S3 keeps blobs in a bucket, talking to the service over its REST API.
+type S3 struct {
+	config   S3Config
+	endpoint *url.URL
+	client   *http.Client
+}
+
+func NewS3(config S3Config) (*S3, error) {
+	if config.Endpoint == "" || config.Bucket == "" {
+		return nil, errors.New("S3 store needs an endpoint and a bucket")
+	}
+	if config.AccessKey == "" || config.SecretKey == "" {
+		return nil, errors.New("S3 store needs an access key and a secret key")
+	}
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+	if config.URLExpiry == 0 {
+		config.URLExpiry = 15 * time.Minute
+	}
+
+	endpoint, err := url.Parse(config.Endpoint)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", config.Endpoint)
+	}
+	endpoint.Path = strings.TrimSuffix(endpoint.Path, "/")
+
+	if config.PublicURL != "" {
+		public, err := url.Parse(config.PublicURL)
+		if err != nil || (public.Scheme != "http" && public.Scheme != "https") || public.Host == "" {
+			return nil, fmt.Errorf("invalid S3 public URL %q", config.PublicURL)
+		}
+	}
+
+	return &S3{
+		config:   config,
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: time.Minute},
+	}, nil
+}
+
+// objectURL returns the URL of a key, or of the bucket itself if key is empty.
+func (s *S3) objectURL(key string) *url.URL {
+	u := *s.endpoint
+	if s.config.PathStyle {
+		u.Path += "/" + s.config.Bucket
+	} else {
+		u.Host = s.config.Bucket + "." + u.Host
+	}
+	u.Path += "/" + key
+	u.RawPath = u.Path
+	if key != "" {
+		u.RawPath = strings.TrimSuffix(u.RawPath, key) + escapePath(key)
+	}
+	return &u
+}
+
+// S3Error is an error response from the service.
+type S3Error struct {
+	StatusCode int
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func (e *S3Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("S3 request failed with status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("S3 request failed with status %d: %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+func (s *S3) do(ctx context.Context, method string, u *url.URL, body []byte, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	s.sign(req, body, time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		s3Err := &S3Error{StatusCode: resp.StatusCode}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		xml.Unmarshal(data, s3Err)
+		return nil, s3Err
+	}
+
+	return resp, nil
+}
+
+func (s *S3) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+
+	header := http.Header{}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+
+	resp, err := s.do(ctx, http.MethodPut, s.objectURL(key), data, header)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// object sends a GET or HEAD request for a key.
+func (s *S3) object(ctx context.Context, method, key string) (*http.Response, Info, error) {
+	if !validKey(key) {
+		return nil, Info{}, ErrInvalidKey
+	}
+
+	resp, err := s.do(ctx, method, s.objectURL(key), nil, nil)
+	var s3Err *S3Error
+	if errors.As(err, &s3Err) && s3Err.StatusCode == http.StatusNotFound {
+		return nil, Info{}, ErrNotFound
+	}
+	if err != nil {
+		return nil, Info{}, err
+	}
+
+	info := Info{
+		Size:        resp.ContentLength,
+		ContentType: resp.Header.Get("Content-Type"),
+	}
+	if modified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.ModTime = modified
+	}
+
+	return resp, info, nil
+}
+
+func (s *S3) Get(ctx context.Context, key string) (io.ReadCloser, Info, error) {
+	resp, info, err := s.object(ctx, http.MethodGet, key)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	return resp.Body, info, nil
+}
+
+func (s *S3) Stat(ctx context.Context, key string) (Info, error) {
+	resp, info, err := s.object(ctx, http.MethodHead, key)
+	if err != nil {
+		return Info{}, err
+	}
+	resp.Body.Close()
+	return info, nil
+}
+
+// Delete succeeds for keys that do not exist, as S3 itself does.
+func (s *S3) Delete(ctx context.Context, key string) error {
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+
+	resp, err := s.do(ctx, http.MethodDelete, s.objectURL(key), nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// List pages through ListObjectsV2.
+func (s *S3) List(ctx context.Context, prefix string, fn func(key string) error) error {
+	token := ""
+	for {
+		u := s.objectURL("")
+		query := url.Values{}
+		query.Set("list-type", "2")
+		query.Set("prefix", prefix)
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		u.RawQuery = query.Encode()
+
+		resp, err := s.do(ctx, http.MethodGet, u, nil, nil)
+		if err != nil {
+			return err
+		}
+
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("failed to decode bucket listing: %v", err)
+		}
+
+		for _, object := range result.Contents {
+			if err := fn(object.Key); err != nil {
+				return err
+			}
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// URL returns the public or presigned URL of the key, if the store is
+// configured for either.
+func (s *S3) URL(key string) (string, error) {
+	if !validKey(key) {
+		return "", ErrInvalidKey
+	}
+
+	if s.config.PublicURL != "" {
+		return strings.TrimSuffix(s.config.PublicURL, "/") + "/" + escapePath(key), nil
+	}
+	if s.config.SignedURLs {
+		return s.presign(http.MethodGet, s.objectURL(key), s.config.URLExpiry, time.Now()), nil
+	}
+	return "", nil
+}
+
+// Origin is the origin of S3_PUBLIC_URL, or of the bucket for presigned URLs.
+func (s *S3) Origin() string {
+	var u *url.URL
+	switch {
+	case s.config.PublicURL != "":
+		// NewS3 has checked that it parses
+		u, _ = url.Parse(s.config.PublicURL)
+	case s.config.SignedURLs:
+		u = s.objectURL("")
+	default:
+		return ""
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// escapePath percent-encodes a key the way SigV4 expects, leaving slashes
+// alone.
+func escapePath(key string) string {
+	parts := strings.Split(key, "/")
+	for i, part := range parts {
+		parts[i] = uriEncode(part)
+	}
+	return strings.Join(parts, "/")
+}
+
+// uriEncode encodes everything except the unreserved characters of RFC 3986.
+func uriEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteString("%" + strings.ToUpper(strconv.FormatUint(uint64(c)|0x100, 16)[1:]))
+	}
+	return b.String()
+}
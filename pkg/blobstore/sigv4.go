@@ -0,0 +1,150 @@
+package blobstore
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Request signing, AWS Signature Version 4:
+// https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-authenticating-requests.html
+
+const (
+	sigAlgorithm    = "AWS4-HMAC-SHA256"
+	sigDateFormat   = "20060102T150405Z"
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+)
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *S3) scope(now time.Time) string {
+	return now.Format("20060102") + "/" + s.config.Region + "/s3/aws4_request"
+}
+
+func (s *S3) signature(stringToSign string, now time.Time) string {
+	key := hmacSHA256([]byte("AWS4"+s.config.SecretKey), now.Format("20060102"))
+	key = hmacSHA256(key, s.config.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+// canonicalQuery sorts and encodes the query string as SigV4 requires.
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var pairs []string
+	for _, key := range keys {
+		values := append([]string(nil), query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			pairs = append(pairs, uriEncode(key)+"="+uriEncode(value))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// canonicalRequest returns the canonical request and the list of signed
+// headers. headers must already be lower case.
+func canonicalRequest(method string, u *url.URL, headers map[string]string, payloadHash string) (string, string) {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(headers[name]) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+
+	request := strings.Join([]string{
+		method,
+		path,
+		canonicalQuery(u.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	return request, signedHeaders
+}
+
+// sign adds the Authorization header to a request.
+func (s *S3) sign(req *http.Request, body []byte, now time.Time) {
+	now = now.UTC()
+	payloadHash := sha256Hex(body)
+	req.Header.Set("X-Amz-Date", now.Format(sigDateFormat))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") || lower == "content-type" {
+			headers[lower] = req.Header.Get(name)
+		}
+	}
+
+	request, signedHeaders := canonicalRequest(req.Method, req.URL, headers, payloadHash)
+	stringToSign := strings.Join([]string{
+		sigAlgorithm,
+		now.Format(sigDateFormat),
+		s.scope(now),
+		sha256Hex([]byte(request)),
+	}, "\n")
+
+	req.Header.Set("Authorization", sigAlgorithm+
+		" Credential="+s.config.AccessKey+"/"+s.scope(now)+
+		", SignedHeaders="+signedHeaders+
+		", Signature="+s.signature(stringToSign, now))
+}
+
+// presign returns a URL that grants the request to whoever holds it until
+// expiry has passed.
+func (s *S3) presign(method string, u *url.URL, expiry time.Duration, now time.Time) string {
+	now = now.UTC()
+	signed := *u
+
+	query := signed.Query()
+	query.Set("X-Amz-Algorithm", sigAlgorithm)
+	query.Set("X-Amz-Credential", s.config.AccessKey+"/"+s.scope(now))
+	query.Set("X-Amz-Date", now.Format(sigDateFormat))
+	query.Set("X-Amz-Expires", strconv.Itoa(int(expiry/time.Second)))
+	query.Set("X-Amz-SignedHeaders", "host")
+	signed.RawQuery = canonicalQuery(query)
+
+	request, _ := canonicalRequest(method, &signed, map[string]string{"host": signed.Host}, unsignedPayload)
+	stringToSign := strings.Join([]string{
+		sigAlgorithm,
+		now.Format(sigDateFormat),
+		s.scope(now),
+		sha256Hex([]byte(request)),
+	}, "\n")
+
+	signed.RawQuery += "&X-Amz-Signature=" + s.signature(stringToSign, now)
+	return signed.String()
+}
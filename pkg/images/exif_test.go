@@ -0,0 +1,147 @@
+package images
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"testing"
+)
+
+type byteOrder interface {
+	binary.ByteOrder
+	binary.AppendByteOrder
+}
+
+// testTIFF builds the TIFF structure of an EXIF segment with an orientation
+// tag in its first IFD, after another tag so the entries are walked.
+func testTIFF(order byteOrder, orientation uint16) []byte {
+	tiff := []byte("II*\x00")
+	if order == binary.BigEndian {
+		tiff = []byte("MM\x00*")
+	}
+	tiff = order.AppendUint32(tiff, 8)
+
+	tiff = order.AppendUint16(tiff, 2)
+	// ImageWidth, LONG
+	tiff = order.AppendUint16(tiff, 0x0100)
+	tiff = order.AppendUint16(tiff, 4)
+	tiff = order.AppendUint32(tiff, 1)
+	tiff = order.AppendUint32(tiff, 64)
+	// Orientation, SHORT, stored in the first half of the value field
+	tiff = order.AppendUint16(tiff, exifOrientationTag)
+	tiff = order.AppendUint16(tiff, 3)
+	tiff = order.AppendUint32(tiff, 1)
+	tiff = order.AppendUint16(tiff, orientation)
+	tiff = order.AppendUint16(tiff, 0)
+	// No next IFD
+	return order.AppendUint32(tiff, 0)
+}
+
+// testJPEG encodes a small JPEG and puts an APP1 segment with the given
+// payload right after SOI.
+func testJPEG(t *testing.T, app1 []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 4, 2)), nil); err != nil {
+		t.Fatal(err)
+	}
+	encoded := buf.Bytes()
+
+	segment := []byte{0xFF, 0xE1}
+	segment = binary.BigEndian.AppendUint16(segment, uint16(len(app1)+2))
+	segment = append(segment, app1...)
+
+	data := append([]byte(nil), encoded[:2]...)
+	data = append(data, segment...)
+	return append(data, encoded[2:]...)
+}
+
+func exifSegment(tiff []byte) []byte {
+	return append([]byte("Exif\x00\x00"), tiff...)
+}
+
+func TestJPEGOrientation(t *testing.T) {
+	for _, order := range []byteOrder{binary.LittleEndian, binary.BigEndian} {
+		for _, want := range []int{1, 3, 6, 8} {
+			data := testJPEG(t, exifSegment(testTIFF(order, uint16(want))))
+			if got := jpegOrientation(data); got != want {
+				t.Errorf("%v orientation %d: jpegOrientation = %d", order, want, got)
+			}
+		}
+	}
+}
+
+func TestJPEGOrientationDefaults(t *testing.T) {
+	tiff := testTIFF(binary.BigEndian, 6)
+
+	badOffset := append([]byte(nil), tiff...)
+	binary.BigEndian.PutUint32(badOffset[4:], 0xFFFFFFF0)
+
+	tooManyEntries := append([]byte(nil), tiff...)
+	binary.BigEndian.PutUint16(tooManyEntries[8:], 0xFFFF)
+	tooManyEntries = tooManyEntries[:8+2+12] // only the first entry is there
+
+	tests := map[string][]byte{
+		"not a JPEG":             []byte("GIF89a"),
+		"no EXIF":                testJPEG(t, []byte("XMP\x00")),
+		"out of range value":     testJPEG(t, exifSegment(testTIFF(binary.LittleEndian, 9))),
+		"zero value":             testJPEG(t, exifSegment(testTIFF(binary.LittleEndian, 0))),
+		"unknown byte order":     testJPEG(t, exifSegment(append([]byte("XX"), tiff[2:]...))),
+		"IFD offset past end":    testJPEG(t, exifSegment(badOffset)),
+		"entries past end":       testJPEG(t, exifSegment(tooManyEntries)),
+		"truncated IFD":          testJPEG(t, exifSegment(tiff[:len(tiff)-10])),
+		"truncated TIFF header":  testJPEG(t, exifSegment(tiff[:6])),
+		"APP1 longer than file":  testJPEG(t, exifSegment(tiff))[:20],
+		"segment length too low": {0xFF, 0xD8, 0xFF, 0xE1, 0x00, 0x01, 0x00, 0x00},
+	}
+
+	for name, data := range tests {
+		if got := jpegOrientation(data); got != 1 {
+			t.Errorf("%s: jpegOrientation = %d, want 1", name, got)
+		}
+	}
+}
+
+// Every prefix must be read without going past the end, and gives the
+// default until the orientation entry is complete.
+func TestJPEGOrientationTruncated(t *testing.T) {
+	tiff := testTIFF(binary.LittleEndian, 6)
+	// Header, entry count and both entries
+	entryEnd := 8 + 2 + 2*12
+
+	for n := 0; n <= len(tiff); n++ {
+		want := 1
+		if n >= entryEnd {
+			want = 6
+		}
+		if got := exifOrientation(tiff[:n]); got != want {
+			t.Errorf("exifOrientation of the first %d bytes = %d, want %d", n, got, want)
+		}
+	}
+
+	data := testJPEG(t, exifSegment(tiff))
+	app1End := 2 + 4 + 6 + len(tiff)
+	for n := 0; n < app1End; n++ {
+		if got := jpegOrientation(data[:n]); got != 1 {
+			t.Errorf("jpegOrientation of the first %d bytes = %d, want 1", n, got)
+		}
+	}
+}
+
+func TestProcessRotatesJPEG(t *testing.T) {
+	data := testJPEG(t, exifSegment(testTIFF(binary.BigEndian, 6)))
+
+	out, _, err := Process(data, DefaultLimits)
+	if err != nil {
+		t.Fatalf("Process: %v", err)
+	}
+	config, err := jpeg.DecodeConfig(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Width != 2 || config.Height != 4 {
+		t.Errorf("rotated image is %dx%d, want 2x4", config.Width, config.Height)
+	}
+}
@@ -0,0 +1,260 @@
+// Package images checks uploaded images and re-encodes them so that only
+// clean pixel data, without metadata such as EXIF or GPS tags, is stored.
+package images
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+)
+
+// Formats that are accepted, named as image.Decode names them.
+const (
+	JPEG = "jpeg"
+	PNG  = "png"
+	GIF  = "gif"
+)
+
+var (
+	ErrUnsupported = errors.New("unsupported image format")
+	ErrTooLarge    = errors.New("image dimensions are too large")
+	ErrInvalid     = errors.New("invalid image")
+)
+
+// Limits bound the size of the decoded image, which can be far larger than
+// the upload itself.
+type Limits struct {
+	MaxWidth  int
+	MaxHeight int
+	// MaxPixels caps width × height summed over every frame.
+	MaxPixels int
+}
+
+var DefaultLimits = Limits{
+	MaxWidth:  10000,
+	MaxHeight: 10000,
+	MaxPixels: 40_000_000,
+}
+
+const jpegQuality = 90
+
+// Sniff returns the format of the image from its first bytes, or an empty
+// string if it is not one of the accepted formats. SVG is never accepted.
+func Sniff(data []byte) string {
+	switch http.DetectContentType(data) {
+	case "image/jpeg":
+		return JPEG
+	case "image/png":
+		return PNG
+	case "image/gif":
+		return GIF
+	}
+	return ""
+}
+
+// Extension returns the file extension used for the format.
+func Extension(format string) string {
+	if format == JPEG {
+		return ".jpg"
+	}
+	return "." + format
+}
+
+// ContentType returns the MIME type for an extension returned by Extension.
+func ContentType(extension string) string {
+	switch extension {
+	case ".jpg":
+		return "image/jpeg"
+	case ".png":
+		return "image/png"
+	case ".gif":
+		return "image/gif"
+	case ".webp":
+		return "image/webp"
+	}
+	return ""
+}
+
+// Process checks the image against the limits before decoding it and
+// re-encodes it in the same format. JPEG photos are rotated according to their
+// EXIF orientation, since the tag itself is dropped.
+func Process(data []byte, limits Limits) ([]byte, string, error) {
+	format := Sniff(data)
+	if format == "" {
+		return nil, "", ErrUnsupported
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+
+	frames := 1
+	if format == GIF {
+		if frames, err = countGIFFrames(data); err != nil {
+			return nil, "", fmt.Errorf("%w: %v", ErrInvalid, err)
+		}
+	}
+
+	if config.Width <= 0 || config.Height <= 0 {
+		return nil, "", ErrInvalid
+	}
+	if config.Width > limits.MaxWidth || config.Height > limits.MaxHeight ||
+		config.Width*config.Height > limits.MaxPixels/frames {
+		return nil, "", ErrTooLarge
+	}
+
+	var out bytes.Buffer
+	switch format {
+	case GIF:
+		g, err := gif.DecodeAll(bytes.NewReader(data))
+		if err != nil {
+			return nil, "", fmt.Errorf("%w: %v", ErrInvalid, err)
+		}
+		clean := &gif.GIF{
+			Image:           g.Image,
+			Delay:           g.Delay,
+			LoopCount:       g.LoopCount,
+			Disposal:        g.Disposal,
+			Config:          g.Config,
+			BackgroundIndex: g.BackgroundIndex,
+		}
+		err = gif.EncodeAll(&out, clean)
+		if err != nil {
+			return nil, "", err
+		}
+
+	case PNG:
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, "", fmt.Errorf("%w: %v", ErrInvalid, err)
+		}
+		if err := png.Encode(&out, img); err != nil {
+			return nil, "", err
+		}
+
+	case JPEG:
+		img, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, "", fmt.Errorf("%w: %v", ErrInvalid, err)
+		}
+		if orientation := jpegOrientation(data); orientation > 1 {
+			img = orient(img, orientation)
+		}
+		if err := jpeg.Encode(&out, img, &jpeg.Options{Quality: jpegQuality}); err != nil {
+			return nil, "", err
+		}
+	}
+
+	return out.Bytes(), format, nil
+}
+
+// countGIFFrames walks the GIF block structure without decompressing the
+// frames, so an animation with a huge number of frames is caught before it is
+// decoded.
+func countGIFFrames(data []byte) (int, error) {
+	errTruncated := errors.New("truncated GIF")
+
+	if len(data) < 13 {
+		return 0, errTruncated
+	}
+	pos := 13
+	if data[10]&0x80 != 0 {
+		pos += 3 << (data[10]&0x07 + 1)
+	}
+
+	skipSubBlocks := func() error {
+		for {
+			if pos >= len(data) {
+				return errTruncated
+			}
+			size := int(data[pos])
+			pos++
+			if size == 0 {
+				return nil
+			}
+			pos += size
+		}
+	}
+
+	frames := 0
+	for pos < len(data) {
+		switch data[pos] {
+		case 0x21: // extension
+			pos += 2
+			if err := skipSubBlocks(); err != nil {
+				return 0, err
+			}
+		case 0x2C: // image descriptor
+			if pos+10 > len(data) {
+				return 0, errTruncated
+			}
+			flags := data[pos+9]
+			pos += 10
+			if flags&0x80 != 0 {
+				pos += 3 << (flags&0x07 + 1)
+			}
+			pos++ // LZW minimum code size
+			if err := skipSubBlocks(); err != nil {
+				return 0, err
+			}
+			frames++
+		case 0x3B: // trailer
+			if frames == 0 {
+				return 0, errors.New("GIF has no frames")
+			}
+			return frames, nil
+		default:
+			return 0, fmt.Errorf("unknown GIF block 0x%02x", data[pos])
+		}
+	}
+
+	return 0, errTruncated
+}
+
+// orient applies an EXIF orientation (2–8) to the image.
+func orient(img image.Image, orientation int) image.Image {
+	b := img.Bounds()
+	src := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // mirrored
+				dx, dy = w-1-x, y
+			case 3: // rotated 180°
+				dx, dy = w-1-x, h-1-y
+			case 4: // mirrored vertically
+				dx, dy = x, h-1-y
+			case 5: // transposed
+				dx, dy = y, x
+			case 6: // rotated 90° clockwise
+				dx, dy = h-1-y, x
+			case 7: // transversed
+				dx, dy = h-1-y, w-1-x
+			case 8: // rotated 90° counter-clockwise
+				dx, dy = y, w-1-x
+			default:
+				return img
+			}
+			copy(dst.Pix[dst.PixOffset(dx, dy):dst.PixOffset(dx, dy)+4], src.Pix[src.PixOffset(x, y):src.PixOffset(x, y)+4])
+		}
+	}
+
+	return dst
+}
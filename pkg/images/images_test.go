@@ -0,0 +1,90 @@
+package images
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"testing"
+)
+
+// testGIF encodes an animation of the given number of 2x2 frames.
+func testGIF(t *testing.T, frames int) []byte {
+	t.Helper()
+
+	palette := color.Palette{color.Black, color.White}
+	g := &gif.GIF{}
+	for i := 0; i < frames; i++ {
+		frame := image.NewPaletted(image.Rect(0, 0, 2, 2), palette)
+		frame.SetColorIndex(i%2, 0, 1)
+		g.Image = append(g.Image, frame)
+		g.Delay = append(g.Delay, 10)
+	}
+
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestCountGIFFrames(t *testing.T) {
+	for _, want := range []int{1, 2, 7} {
+		got, err := countGIFFrames(testGIF(t, want))
+		if err != nil || got != want {
+			t.Errorf("countGIFFrames(%d frames) = %d, %v", want, got, err)
+		}
+	}
+}
+
+func TestCountGIFFramesRejects(t *testing.T) {
+	data := testGIF(t, 3)
+
+	// The first block after the header and global colour table
+	first := 13
+	if data[10]&0x80 != 0 {
+		first += 3 << (data[10]&0x07 + 1)
+	}
+	badBlock := append([]byte(nil), data...)
+	badBlock[first] = 0x99
+
+	noFrames := append(append([]byte(nil), data[:first]...), 0x3B)
+
+	tests := map[string][]byte{
+		"empty":           nil,
+		"header only":     data[:6],
+		"no trailer":      data[:len(data)-1],
+		"truncated frame": data[:len(data)/2],
+		"bad block":       badBlock,
+		"no frames":       noFrames,
+	}
+
+	for name, data := range tests {
+		if frames, err := countGIFFrames(data); err == nil {
+			t.Errorf("%s: countGIFFrames = %d, want an error", name, frames)
+		}
+	}
+}
+
+// Every prefix of a valid GIF must be refused without reading past the end.
+func TestCountGIFFramesTruncated(t *testing.T) {
+	data := testGIF(t, 3)
+	for n := 0; n < len(data); n++ {
+		if _, err := countGIFFrames(data[:n]); err == nil {
+			t.Errorf("countGIFFrames accepted the first %d of %d bytes", n, len(data))
+		}
+	}
+}
+
+func TestProcessCountsGIFFrames(t *testing.T) {
+	data := testGIF(t, 5)
+
+	// 5 frames of 2x2 are 20 pixels in total
+	if _, _, err := Process(data, Limits{MaxWidth: 10, MaxHeight: 10, MaxPixels: 20}); err != nil {
+		t.Errorf("Process within the pixel limit: %v", err)
+	}
+	if _, _, err := Process(data, Limits{MaxWidth: 10, MaxHeight: 10, MaxPixels: 19}); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Process over the pixel limit: err = %v, want ErrTooLarge", err)
+	}
+}
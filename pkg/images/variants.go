@@ -0,0 +1,115 @@
+package images
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+
+	"github.com/chai2010/webp"
+	"golang.org/x/image/draw"
+)
+
+// Size is a resized copy of an upload made for a particular place in the
+// layout.
+type Size struct {
+	Name  string
+	Width int
+}
+
+// Sizes are the variants made for every upload, smallest first. Images are
+// never scaled up, so a small upload gets fewer variants.
+var Sizes = []Size{
+	{Name: "thumb", Width: 320},
+	{Name: "feed", Width: 800},
+	{Name: "full", Width: 1600},
+}
+
+// VariantExtension is the extension of every variant.
+const VariantExtension = ".webp"
+
+const webpQuality = 80
+
+// Variant is an encoded, resized copy of an image.
+type Variant struct {
+	Name   string
+	Width  int
+	Height int
+	Data   []byte
+}
+
+// MakeVariants resizes a processed image to each of the Sizes and encodes
+// the results as WebP. Animated GIFs get no variants, since a still frame
+// would lose the animation.
+func MakeVariants(data []byte, limits Limits) ([]Variant, error) {
+	format := Sniff(data)
+	if format == "" {
+		return nil, ErrUnsupported
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	if config.Width > limits.MaxWidth || config.Height > limits.MaxHeight || config.Width*config.Height > limits.MaxPixels {
+		return nil, ErrTooLarge
+	}
+
+	var img image.Image
+	switch format {
+	case GIF:
+		frames, err := countGIFFrames(data)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+		}
+		if frames > 1 {
+			return nil, nil
+		}
+		img, err = gif.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+		}
+	case PNG:
+		img, err = png.Decode(bytes.NewReader(data))
+	case JPEG:
+		img, err = jpeg.Decode(bytes.NewReader(data))
+		// uploads from before Process existed may still carry the tag
+		if orientation := jpegOrientation(data); err == nil && orientation > 1 {
+			img = orient(img, orientation)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+
+	bounds := img.Bounds()
+	var variants []Variant
+	for _, size := range Sizes {
+		width := size.Width
+		if width >= bounds.Dx() {
+			width = bounds.Dx()
+		}
+		if len(variants) > 0 && variants[len(variants)-1].Width == width {
+			break
+		}
+
+		height := bounds.Dy() * width / bounds.Dx()
+		if height < 1 {
+			height = 1
+		}
+
+		dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+		draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Src, nil)
+
+		var out bytes.Buffer
+		if err := webp.Encode(&out, dst, &webp.Options{Quality: webpQuality}); err != nil {
+			return nil, err
+		}
+
+		variants = append(variants, Variant{Name: size.Name, Width: width, Height: height, Data: out.Bytes()})
+	}
+
+	return variants, nil
+}
@@ -0,0 +1,79 @@
+// Package markdown renders post and comment content written in CommonMark,
+// with GitHub's tables, task lists, strikethrough and autolinks, into HTML
+// that is safe to put in a page.
+package markdown
+
+import (
+	"bytes"
+	"html/template"
+	"regexp"
+
+	"forum/logger"
+
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	highlighting "github.com/yuin/goldmark-highlighting/v2"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/renderer/html"
+)
+
+// Version identifies the output of Render. Rendered content is cached along
+// with the version it was made with, so bump it whenever a change here alters
+// the HTML and the cache will be rebuilt as pages are viewed.
+const Version = 1
+
+// HighlightStyle is the chroma style ui/static/css/highlight.css was generated
+// from. Code is highlighted with classes rather than inline styles, which the
+// Content-Security-Policy doesn't allow.
+const HighlightStyle = "github"
+
+// Raw HTML in the source is left out by goldmark, and whatever it produces
+// still goes through the sanitizer.
+var converter = goldmark.New(
+	goldmark.WithExtensions(
+		extension.NewTable(extension.WithTableCellAlignMethod(extension.TableCellAlignAttribute)),
+		extension.Strikethrough,
+		extension.Linkify,
+		extension.TaskList,
+		highlighting.NewHighlighting(
+			highlighting.WithStyle(HighlightStyle),
+			highlighting.WithFormatOptions(chromahtml.WithClasses(true)),
+		),
+	),
+	// Line breaks were shown as typed before content was Markdown, so keep
+	// them rather than joining the lines of a paragraph
+	goldmark.WithRendererOptions(html.WithHardWraps()),
+)
+
+var policy = newPolicy()
+
+func newPolicy() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	p.RequireNoReferrerOnLinks(true)
+
+	// chroma's token classes
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^[a-z0-9]+( [a-z0-9]+)*$`)).OnElements("pre", "code", "span")
+
+	// task list items
+	p.AllowAttrs("type").Matching(regexp.MustCompile(`^checkbox$`)).OnElements("input")
+	p.AllowAttrs("checked", "disabled").OnElements("input")
+
+	// table cell alignment
+	p.AllowAttrs("align").Matching(regexp.MustCompile(`^(left|center|right)$`)).OnElements("th", "td")
+
+	return p
+}
+
+// Render converts Markdown source to sanitized HTML.
+func Render(source string) template.HTML {
+	var buf bytes.Buffer
+	if err := converter.Convert([]byte(source), &buf); err != nil {
+		// goldmark only fails when writing does, which a buffer doesn't, but
+		// fall back to showing the text as it was written
+		logger.ErrorLogger.Printf("Error rendering markdown: %v\n", err)
+		return template.HTML("<p>" + template.HTMLEscapeString(source) + "</p>")
+	}
+
+	return template.HTML(policy.SanitizeBytes(buf.Bytes()))
+}
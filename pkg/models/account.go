@@ -0,0 +1,260 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"forum/logger"
+
+	"github.com/google/uuid"
+)
+
+// What happens to the posts, comments and reactions of a deleted account.
+const (
+	// DeletedContentAnonymize keeps the content under a "deleted" name that
+	// can't be traced back to the account.
+	DeletedContentAnonymize = "anonymize"
+	// DeletedContentDelete removes the content, along with replies and
+	// reactions to the account's posts.
+	DeletedContentDelete = "delete"
+)
+
+// GetDeletedContentPolicy returns DeletedContentAnonymize or
+// DeletedContentDelete. Anonymizing is the default.
+func GetDeletedContentPolicy(db *sql.DB) (string, error) {
+	policy, err := GetSetting(db, SettingDeletedContent, DeletedContentAnonymize)
+	if err != nil {
+		return DeletedContentAnonymize, err
+	}
+	if policy != DeletedContentDelete {
+		policy = DeletedContentAnonymize
+	}
+	return policy, nil
+}
+
+func SetDeletedContentPolicy(db *sql.DB, policy string) error {
+	if policy != DeletedContentAnonymize && policy != DeletedContentDelete {
+		return fmt.Errorf("unknown deleted content policy %q", policy)
+	}
+	return SetSetting(db, SettingDeletedContent, policy)
+}
+
+// RequestAccountDeletion schedules the account for deletion and signs the user
+// out everywhere. The account can be restored by logging in and cancelling
+// until PurgeAccount runs.
+func RequestAccountDeletion(db *sql.DB, userID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to begin account deletion transaction: %v", err)
+		return fmt.Errorf("failed to begin account deletion transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	query := "UPDATE users SET deletion_requested_at = ?, updated_at = ? WHERE id = ? AND deletion_requested_at IS NULL"
+	if _, err := tx.ExecContext(ctx, query, time.Now(), time.Now(), userID); err != nil {
+		logger.ErrorLogger.Printf("Failed to request account deletion: %v", err)
+		return fmt.Errorf("failed to request account deletion: %v", err)
+	}
+
+	for _, query := range []string{
+		"DELETE FROM sessions WHERE user_id = ?",
+		"DELETE FROM mfa_challenges WHERE user_id = ?",
+	} {
+		if _, err := tx.ExecContext(ctx, query, userID); err != nil {
+			logger.ErrorLogger.Printf("Failed to revoke sessions: %v", err)
+			return fmt.Errorf("failed to revoke sessions: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		logger.ErrorLogger.Printf("Failed to commit account deletion request: %v", err)
+		return fmt.Errorf("failed to commit account deletion request: %v", err)
+	}
+
+	return nil
+}
+
+func CancelAccountDeletion(db *sql.DB, userID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "UPDATE users SET deletion_requested_at = NULL, updated_at = ? WHERE id = ?"
+	if _, err := db.ExecContext(ctx, query, time.Now(), userID); err != nil {
+		logger.ErrorLogger.Printf("Failed to cancel account deletion: %v", err)
+		return fmt.Errorf("failed to cancel account deletion: %v", err)
+	}
+
+	return nil
+}
+
+// GetAccountsDueForDeletion returns the accounts whose deletion was requested
+// at least delay ago.
+func GetAccountsDueForDeletion(db *sql.DB, delay time.Duration) ([]User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT id, name, email, deletion_requested_at FROM users
+		WHERE deletion_requested_at IS NOT NULL AND deletion_requested_at <= ?
+		ORDER BY deletion_requested_at`
+	rows, err := db.QueryContext(ctx, query, time.Now().Add(-delay))
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to get accounts due for deletion: %v", err)
+		return nil, fmt.Errorf("failed to get accounts due for deletion: %v", err)
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(&user.ID, &user.Name, &user.Email, &user.DeletionRequestedAt); err != nil {
+			logger.ErrorLogger.Printf("Failed to scan user: %v", err)
+			return nil, fmt.Errorf("failed to scan user: %v", err)
+		}
+		users = append(users, user)
+	}
+
+	if err := rows.Err(); err != nil {
+		logger.ErrorLogger.Printf("Failed to get accounts due for deletion: %v", err)
+		return nil, fmt.Errorf("failed to get accounts due for deletion: %v", err)
+	}
+
+	return users, nil
+}
+
+// accountData is removed whatever the policy. Each statement takes the user's
+// ID.
+var accountData = []string{
+	"DELETE FROM sessions WHERE user_id = ?",
+	"DELETE FROM recovery_codes WHERE user_id = ?",
+	"DELETE FROM mfa_challenges WHERE user_id = ?",
+	"DELETE FROM passkeys WHERE user_id = ?",
+	"DELETE FROM webauthn_challenges WHERE user_id = ?",
+	"DELETE FROM notifications WHERE user_id = ?",
+	"DELETE FROM suspensions WHERE user_id = ?",
+	"DELETE FROM login_throttles WHERE kind = 'account' AND key = (SELECT email FROM users WHERE id = ?)",
+	"DELETE FROM bookmarks WHERE user_id = ?",
+	"DELETE FROM bookmark_collections WHERE user_id = ?",
+	"DELETE FROM follows WHERE follower_id = ?",
+	"DELETE FROM follows WHERE followee_id = ?",
+	"DELETE FROM category_subscriptions WHERE user_id = ?",
+	"DELETE FROM thread_watches WHERE user_id = ?",
+	// drafts were never public, and scheduled ones must not be published
+	// under the anonymous name
+	"DELETE FROM posts WHERE user_id = ? AND status = 'draft'",
+}
+
+// accountContent removes the account's posts, with everything on them, and its
+// comments, reactions and poll votes elsewhere.
+var accountContent = []string{
+	"DELETE FROM review_queue WHERE kind = 'comment' AND item_id IN (SELECT c.id FROM comments c JOIN posts p ON p.id = c.post_id WHERE p.user_id = ?)",
+	"DELETE FROM comment_reactions WHERE post_id IN (SELECT id FROM posts WHERE user_id = ?)",
+	"DELETE FROM comments WHERE post_id IN (SELECT id FROM posts WHERE user_id = ?)",
+	"DELETE FROM post_reactions WHERE post_id IN (SELECT id FROM posts WHERE user_id = ?)",
+	"DELETE FROM comment_reactions WHERE comment_id IN (SELECT id FROM comments WHERE user_id = ?)",
+	"DELETE FROM review_queue WHERE user_id = ?",
+	"DELETE FROM comments WHERE user_id = ?",
+	"DELETE FROM post_reactions WHERE user_id = ?",
+	"DELETE FROM comment_reactions WHERE user_id = ?",
+	"DELETE FROM emoji_reactions WHERE user_id = ?",
+	"DELETE FROM poll_vote_choices WHERE vote_id IN (SELECT id FROM poll_votes WHERE user_id = ?)",
+	"DELETE FROM poll_votes WHERE user_id = ?",
+	"DELETE FROM posts WHERE user_id = ?",
+}
+
+// PurgeAccount permanently deletes an account whose deletion was requested,
+// and reports whether it did: the user may have cancelled in the meantime.
+// Its content is removed or anonymized according to policy. Anonymized content
+// is moved to a new user ID, so nothing links it to the old account, such as
+// an ID that came from Google. Attachments of removed posts are left to the
+// upload garbage collector.
+func PurgeAccount(db *sql.DB, userID, policy string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to begin account purge transaction: %v", err)
+		return false, fmt.Errorf("failed to begin account purge transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var pending bool
+	err = tx.QueryRowContext(ctx, "SELECT deletion_requested_at IS NOT NULL FROM users WHERE id = ?", userID).Scan(&pending)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to get account: %v", err)
+		return false, fmt.Errorf("failed to get account: %v", err)
+	}
+	if !pending {
+		return false, nil
+	}
+
+	statements := accountData
+	if policy == DeletedContentDelete {
+		statements = append(append([]string{}, accountData...), accountContent...)
+	}
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement, userID); err != nil {
+			logger.ErrorLogger.Printf("Failed to purge account %s: %v", userID, err)
+			return false, fmt.Errorf("failed to purge account %s: %v", userID, err)
+		}
+	}
+
+	if policy == DeletedContentDelete {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM users WHERE id = ?", userID); err != nil {
+			logger.ErrorLogger.Printf("Failed to delete user: %v", err)
+			return false, fmt.Errorf("failed to delete user: %v", err)
+		}
+	} else if err := anonymizeUser(ctx, tx, userID); err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		logger.ErrorLogger.Printf("Failed to commit account purge: %v", err)
+		return false, fmt.Errorf("failed to commit account purge: %v", err)
+	}
+
+	return true, nil
+}
+
+// anonymizeUser replaces the user's ID and personal details. The row stays so
+// the remaining content still has an author, who can no longer log in.
+func anonymizeUser(ctx context.Context, tx *sql.Tx, userID string) error {
+	newID := uuid.New().String()
+
+	for _, statement := range []string{
+		"UPDATE posts SET user_id = ? WHERE user_id = ?",
+		"UPDATE comments SET user_id = ? WHERE user_id = ?",
+		"UPDATE post_reactions SET user_id = ? WHERE user_id = ?",
+		"UPDATE comment_reactions SET user_id = ? WHERE user_id = ?",
+		"UPDATE emoji_reactions SET user_id = ? WHERE user_id = ?",
+		"UPDATE poll_votes SET user_id = ? WHERE user_id = ?",
+		"UPDATE review_queue SET user_id = ? WHERE user_id = ?",
+		"UPDATE suspensions SET created_by = ? WHERE created_by = ?",
+		"UPDATE ip_bans SET created_by = ? WHERE created_by = ?",
+	} {
+		if _, err := tx.ExecContext(ctx, statement, newID, userID); err != nil {
+			logger.ErrorLogger.Printf("Failed to anonymize content: %v", err)
+			return fmt.Errorf("failed to anonymize content: %v", err)
+		}
+	}
+
+	query := `UPDATE users SET id = ?, name = ?, email = ?, hashed_password = '', role = ?,
+		totp_secret = '', totp_enabled = 0, totp_last_step = 0, upload_quota = 0,
+		deletion_requested_at = NULL, deleted_at = ?, updated_at = ?
+		WHERE id = ?`
+	_, err := tx.ExecContext(ctx, query, newID, "deleted-"+newID[:8], newID+"@deleted.invalid", RoleUser, time.Now(), time.Now(), userID)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to anonymize user: %v", err)
+		return fmt.Errorf("failed to anonymize user: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,34 @@
+package models
+
+import "testing"
+
+// Anonymizing an account also moves the IP bans it created to the new ID, so
+// nothing still refers to the old one.
+func TestPurgeAccountAnonymizesIPBans(t *testing.T) {
+	db := openTestDB(t)
+	insertTestUser(t, db, "mod")
+
+	if _, err := CreateIPBan(db, IPBan{CIDR: "192.0.2.0/24", Reason: "spam", CreatedBy: "mod"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := RequestAccountDeletion(db, "mod"); err != nil {
+		t.Fatal(err)
+	}
+
+	purged, err := PurgeAccount(db, "mod", DeletedContentAnonymize)
+	if err != nil || !purged {
+		t.Fatalf("PurgeAccount = %v, %v, want true", purged, err)
+	}
+
+	var newID string
+	if err := db.QueryRow("SELECT id FROM users WHERE deleted_at IS NOT NULL").Scan(&newID); err != nil {
+		t.Fatal(err)
+	}
+	bans, err := GetActiveIPBans(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bans) != 1 || bans[0].CreatedBy != newID {
+		t.Errorf("bans = %+v, want one created by %s", bans, newID)
+	}
+}
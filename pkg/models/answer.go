@@ -0,0 +1,106 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"forum/logger"
+)
+
+// Values of the answers filter of GetPostsWithFilters.
+const (
+	AnswersUnanswered = "unanswered"
+	AnswersSolved     = "solved"
+)
+
+var ErrNotAnswer = errors.New("no such comment on the question")
+
+// GetQACategories returns the categories whose posts are questions that can
+// have an accepted answer.
+func GetQACategories(db *sql.DB) ([]string, error) {
+	value, err := GetSetting(db, SettingQACategories, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var categories []string
+	for _, category := range strings.Split(value, ",") {
+		if category = strings.TrimSpace(category); category != "" {
+			categories = append(categories, category)
+		}
+	}
+
+	return categories, nil
+}
+
+func SetQACategories(db *sql.DB, categories []string) error {
+	return SetSetting(db, SettingQACategories, strings.Join(categories, ","))
+}
+
+// IsQuestion reports whether one of the post's categories runs in Q&A mode.
+func IsQuestion(db *sql.DB, post Post) (bool, error) {
+	qa, err := GetQACategories(db)
+	if err != nil {
+		return false, err
+	}
+
+	// A post's categories are stored joined by "; "
+	for _, category := range strings.Split(post.Category, "; ") {
+		for _, c := range qa {
+			if c == category {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// questionCondition is a WHERE condition matching the posts in the given Q&A
+// categories, with its arguments.
+func questionCondition(qa []string) (string, []interface{}) {
+	if len(qa) == 0 {
+		return "0", nil
+	}
+
+	conditions := make([]string, len(qa))
+	args := make([]interface{}, len(qa))
+	for i, category := range qa {
+		conditions[i] = "'; ' || category || '; ' LIKE '%; ' || ? || '; %'"
+		args[i] = category
+	}
+
+	return "(" + strings.Join(conditions, " OR ") + ")", args
+}
+
+// AcceptAnswer marks a published comment on the post as its accepted answer,
+// replacing the one accepted before. An empty commentID leaves the question
+// without an accepted answer.
+func AcceptAnswer(db *sql.DB, postID, commentID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if commentID != "" {
+		var exists bool
+		query := "SELECT EXISTS (SELECT 1 FROM comments WHERE id = ? AND post_id = ? AND status = 'published')"
+		if err := db.QueryRowContext(ctx, query, commentID, postID).Scan(&exists); err != nil {
+			logger.ErrorLogger.Printf("Failed to check answer: %v", err)
+			return fmt.Errorf("failed to check answer: %v", err)
+		}
+		if !exists {
+			return ErrNotAnswer
+		}
+	}
+
+	_, err := db.ExecContext(ctx, "UPDATE posts SET accepted_comment_id = ? WHERE id = ? AND status = 'published'", commentID, postID)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to accept answer: %v", err)
+		return fmt.Errorf("failed to accept answer: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,198 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"forum/logger"
+)
+
+// Attachment kinds.
+const (
+	AttachmentImage    = "image"
+	AttachmentDocument = "document"
+)
+
+// Quota values with a special meaning in users.upload_quota.
+const (
+	QuotaDefault   = 0
+	QuotaUnlimited = -1
+)
+
+// Attachment is a file attached to a post. Posts with the same file share the
+// upload behind URL.
+type Attachment struct {
+	ID       string         `json:"id"`
+	PostID   string         `json:"post_id"`
+	Kind     string         `json:"kind"`
+	URL      string         `json:"url"`
+	FileName string         `json:"file_name"`
+	MimeType string         `json:"mime"`
+	Size     int64          `json:"size"`
+	Position int            `json:"position"`
+	Variants []ImageVariant `json:"variants"`
+
+	// PostTitle is filled in by GetAttachmentsByUserID.
+	PostTitle string `json:"-"`
+}
+
+func (a Attachment) IsImage() bool {
+	return a.Kind == AttachmentImage
+}
+
+// insertAttachments adds a post's attachments as part of creating the post.
+func insertAttachments(ctx context.Context, tx *sql.Tx, post Post) error {
+	query := "INSERT INTO post_attachments (id, post_id, kind, url, file_name, mime, size, position) VALUES (?, ?, ?, ?, ?, ?, ?, ?)"
+	for i, a := range post.Attachments {
+		if _, err := tx.ExecContext(ctx, query, a.ID, post.ID, a.Kind, a.URL, a.FileName, a.MimeType, a.Size, i); err != nil {
+			logger.ErrorLogger.Printf("Failed to save attachment: %v", err)
+			return fmt.Errorf("failed to save attachment: %v", err)
+		}
+	}
+	return nil
+}
+
+// GetAttachmentsByPostID returns a post's attachments in the order they were
+// uploaded.
+func GetAttachmentsByPostID(db *sql.DB, postID string) ([]Attachment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "SELECT id, post_id, kind, url, file_name, mime, size, position FROM post_attachments WHERE post_id = ? ORDER BY position"
+	rows, err := db.QueryContext(ctx, query, postID)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to get attachments: %v", err)
+		return nil, fmt.Errorf("failed to get attachments: %v", err)
+	}
+	defer rows.Close()
+
+	var attachments []Attachment
+	for rows.Next() {
+		var a Attachment
+		if err := rows.Scan(&a.ID, &a.PostID, &a.Kind, &a.URL, &a.FileName, &a.MimeType, &a.Size, &a.Position); err != nil {
+			logger.ErrorLogger.Printf("Failed to scan attachment: %v", err)
+			return nil, fmt.Errorf("failed to scan attachment: %v", err)
+		}
+		attachments = append(attachments, a)
+	}
+
+	if err := rows.Err(); err != nil {
+		logger.ErrorLogger.Printf("Failed to get attachments: %v", err)
+		return nil, fmt.Errorf("failed to get attachments: %v", err)
+	}
+
+	return attachments, nil
+}
+
+// GetAttachmentsByUserID returns the attachments on a user's posts, newest
+// post first.
+func GetAttachmentsByUserID(db *sql.DB, userID string) ([]Attachment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT a.id, a.post_id, a.kind, a.url, a.file_name, a.mime, a.size, a.position, p.title
+		FROM post_attachments a JOIN posts p ON p.id = a.post_id
+		WHERE p.user_id = ? ORDER BY p.created_at DESC, a.position`
+	rows, err := db.QueryContext(ctx, query, userID)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to get user attachments: %v", err)
+		return nil, fmt.Errorf("failed to get user attachments: %v", err)
+	}
+	defer rows.Close()
+
+	var attachments []Attachment
+	for rows.Next() {
+		var a Attachment
+		if err := rows.Scan(&a.ID, &a.PostID, &a.Kind, &a.URL, &a.FileName, &a.MimeType, &a.Size, &a.Position, &a.PostTitle); err != nil {
+			logger.ErrorLogger.Printf("Failed to scan attachment: %v", err)
+			return nil, fmt.Errorf("failed to scan attachment: %v", err)
+		}
+		attachments = append(attachments, a)
+	}
+
+	if err := rows.Err(); err != nil {
+		logger.ErrorLogger.Printf("Failed to get user attachments: %v", err)
+		return nil, fmt.Errorf("failed to get user attachments: %v", err)
+	}
+
+	return attachments, nil
+}
+
+// GetStorageUsage returns the bytes a user's attachments take up, and the
+// uploads they are stored in. A file attached to several of the user's posts
+// is only counted once.
+func GetStorageUsage(db *sql.DB, userID string) (int64, map[string]bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT a.url, MAX(a.size) FROM post_attachments a JOIN posts p ON p.id = a.post_id
+		WHERE p.user_id = ? GROUP BY a.url`
+	rows, err := db.QueryContext(ctx, query, userID)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to get storage usage: %v", err)
+		return 0, nil, fmt.Errorf("failed to get storage usage: %v", err)
+	}
+	defer rows.Close()
+
+	var total int64
+	urls := make(map[string]bool)
+	for rows.Next() {
+		var url string
+		var size int64
+		if err := rows.Scan(&url, &size); err != nil {
+			logger.ErrorLogger.Printf("Failed to scan storage usage: %v", err)
+			return 0, nil, fmt.Errorf("failed to scan storage usage: %v", err)
+		}
+		total += size
+		urls[url] = true
+	}
+
+	if err := rows.Err(); err != nil {
+		logger.ErrorLogger.Printf("Failed to get storage usage: %v", err)
+		return 0, nil, fmt.Errorf("failed to get storage usage: %v", err)
+	}
+
+	return total, urls, nil
+}
+
+// GetUploadQuota returns the user's own quota in bytes, QuotaDefault if the
+// site default applies or QuotaUnlimited.
+func GetUploadQuota(db *sql.DB, userID string) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var quota int64
+	err := db.QueryRowContext(ctx, "SELECT upload_quota FROM users WHERE id = ?", userID).Scan(&quota)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to get upload quota: %v", err)
+		return 0, fmt.Errorf("failed to get upload quota: %v", err)
+	}
+
+	return quota, nil
+}
+
+// SetUploadQuota gives a user their own quota in bytes, or QuotaDefault or
+// QuotaUnlimited.
+func SetUploadQuota(db *sql.DB, email string, quota int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if quota < QuotaUnlimited {
+		return fmt.Errorf("invalid quota %d", quota)
+	}
+
+	query := "UPDATE users SET upload_quota = ?, updated_at = ? WHERE email = ?"
+	result, err := db.ExecContext(ctx, query, quota, time.Now(), email)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to set upload quota: %v", err)
+		return fmt.Errorf("failed to set upload quota: %v", err)
+	}
+
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("no user found with email %s", email)
+	}
+
+	return nil
+}
@@ -0,0 +1,247 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"forum/logger"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrBookmarkTarget      = errors.New("nothing to bookmark")
+	ErrCollectionNotFound  = errors.New("no such collection")
+	ErrCollectionNameTaken = errors.New("a collection with that name already exists")
+)
+
+// Bookmark is a post, or a comment on it, that a user saved to come back to.
+// Only the user who saved it sees it. PostTitle, CommentContent and
+// CollectionName are filled in when bookmarks are listed.
+type Bookmark struct {
+	ID             string    `json:"id"`
+	UserID         string    `json:"user_id"`
+	PostID         string    `json:"post_id"`
+	CommentID      string    `json:"comment_id"`
+	CollectionID   string    `json:"collection_id"`
+	Note           string    `json:"note"`
+	CreatedAt      time.Time `json:"created_at"`
+	PostTitle      string    `json:"post_title"`
+	CommentContent string    `json:"comment_content"`
+	CollectionName string    `json:"collection_name"`
+}
+
+// Collection is a named group of a user's bookmarks.
+type Collection struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"user_id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	Bookmarks int       `json:"bookmarks"`
+}
+
+// SaveBookmark bookmarks a published post or comment, or updates the
+// collection and note of the bookmark the user already has on it.
+func SaveBookmark(db *sql.DB, bookmark Bookmark) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var exists bool
+	query := "SELECT EXISTS (SELECT 1 FROM posts WHERE id = ? AND status = 'published')"
+	args := []interface{}{bookmark.PostID}
+	if bookmark.CommentID != "" {
+		query = "SELECT EXISTS (SELECT 1 FROM comments WHERE id = ? AND post_id = ? AND status = 'published')"
+		args = []interface{}{bookmark.CommentID, bookmark.PostID}
+	}
+	if err := db.QueryRowContext(ctx, query, args...).Scan(&exists); err != nil {
+		logger.ErrorLogger.Printf("Failed to check bookmark: %v", err)
+		return fmt.Errorf("failed to check bookmark: %v", err)
+	}
+	if !exists {
+		return ErrBookmarkTarget
+	}
+
+	if bookmark.CollectionID != "" {
+		query := "SELECT EXISTS (SELECT 1 FROM bookmark_collections WHERE id = ? AND user_id = ?)"
+		if err := db.QueryRowContext(ctx, query, bookmark.CollectionID, bookmark.UserID).Scan(&exists); err != nil {
+			logger.ErrorLogger.Printf("Failed to check collection: %v", err)
+			return fmt.Errorf("failed to check collection: %v", err)
+		}
+		if !exists {
+			return ErrCollectionNotFound
+		}
+	}
+
+	query = `INSERT INTO bookmarks (id, user_id, post_id, comment_id, collection_id, note, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (user_id, post_id, comment_id) DO UPDATE SET collection_id = excluded.collection_id, note = excluded.note`
+	_, err := db.ExecContext(ctx, query, uuid.New().String(), bookmark.UserID, bookmark.PostID, bookmark.CommentID, bookmark.CollectionID, bookmark.Note, time.Now())
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to save bookmark: %v", err)
+		return fmt.Errorf("failed to save bookmark: %v", err)
+	}
+
+	return nil
+}
+
+// DeleteBookmark removes one of the user's bookmarks.
+func DeleteBookmark(db *sql.DB, id, userID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if _, err := db.ExecContext(ctx, "DELETE FROM bookmarks WHERE id = ? AND user_id = ?", id, userID); err != nil {
+		logger.ErrorLogger.Printf("Failed to delete bookmark: %v", err)
+		return fmt.Errorf("failed to delete bookmark: %v", err)
+	}
+
+	return nil
+}
+
+const bookmarkColumns = `b.id, b.user_id, b.post_id, b.comment_id, b.collection_id, b.note, b.created_at,
+		p.title, COALESCE(c.content, ''), COALESCE(bc.name, '')
+	FROM bookmarks b
+	JOIN posts p ON p.id = b.post_id
+	LEFT JOIN comments c ON c.id = b.comment_id
+	LEFT JOIN bookmark_collections bc ON bc.id = b.collection_id`
+
+// GetBookmarksByUserID returns the user's bookmarks, newest first. With a
+// collectionID only those in the collection are returned.
+func GetBookmarksByUserID(db *sql.DB, userID, collectionID string) ([]Bookmark, error) {
+	query := "SELECT " + bookmarkColumns + " WHERE b.user_id = ?"
+	args := []interface{}{userID}
+	if collectionID != "" {
+		query += " AND b.collection_id = ?"
+		args = append(args, collectionID)
+	}
+	return getBookmarks(db, query+" ORDER BY b.created_at DESC", args...)
+}
+
+// GetBookmarksOnPost returns the user's bookmarks on a post and its comments.
+func GetBookmarksOnPost(db *sql.DB, userID, postID string) ([]Bookmark, error) {
+	return getBookmarks(db, "SELECT "+bookmarkColumns+" WHERE b.user_id = ? AND b.post_id = ?", userID, postID)
+}
+
+func getBookmarks(db *sql.DB, query string, args ...interface{}) ([]Bookmark, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to get bookmarks: %v", err)
+		return nil, fmt.Errorf("failed to get bookmarks: %v", err)
+	}
+	defer rows.Close()
+
+	var bookmarks []Bookmark
+	for rows.Next() {
+		var b Bookmark
+		err := rows.Scan(&b.ID, &b.UserID, &b.PostID, &b.CommentID, &b.CollectionID, &b.Note, &b.CreatedAt, &b.PostTitle, &b.CommentContent, &b.CollectionName)
+		if err != nil {
+			logger.ErrorLogger.Printf("Failed to scan bookmark: %v", err)
+			return nil, fmt.Errorf("failed to scan bookmark: %v", err)
+		}
+		bookmarks = append(bookmarks, b)
+	}
+
+	if err := rows.Err(); err != nil {
+		logger.ErrorLogger.Printf("Failed to get bookmarks: %v", err)
+		return nil, fmt.Errorf("failed to get bookmarks: %v", err)
+	}
+
+	return bookmarks, nil
+}
+
+// CreateCollection adds a collection for the user's bookmarks.
+func CreateCollection(db *sql.DB, userID, name string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var taken bool
+	query := "SELECT EXISTS (SELECT 1 FROM bookmark_collections WHERE user_id = ? AND name = ?)"
+	if err := db.QueryRowContext(ctx, query, userID, name).Scan(&taken); err != nil {
+		logger.ErrorLogger.Printf("Failed to check collection: %v", err)
+		return "", fmt.Errorf("failed to check collection: %v", err)
+	}
+	if taken {
+		return "", ErrCollectionNameTaken
+	}
+
+	id := uuid.New().String()
+	query = "INSERT INTO bookmark_collections (id, user_id, name, created_at) VALUES (?, ?, ?, ?)"
+	if _, err := db.ExecContext(ctx, query, id, userID, name, time.Now()); err != nil {
+		logger.ErrorLogger.Printf("Failed to create collection: %v", err)
+		return "", fmt.Errorf("failed to create collection: %v", err)
+	}
+
+	return id, nil
+}
+
+// DeleteCollection deletes one of the user's collections. Its bookmarks are
+// kept, outside any collection.
+func DeleteCollection(db *sql.DB, id, userID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to begin collection transaction: %v", err)
+		return fmt.Errorf("failed to begin collection transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, "DELETE FROM bookmark_collections WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to delete collection: %v", err)
+		return fmt.Errorf("failed to delete collection: %v", err)
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return ErrCollectionNotFound
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE bookmarks SET collection_id = '' WHERE collection_id = ? AND user_id = ?", id, userID); err != nil {
+		logger.ErrorLogger.Printf("Failed to move bookmarks: %v", err)
+		return fmt.Errorf("failed to move bookmarks: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		logger.ErrorLogger.Printf("Failed to commit collection deletion: %v", err)
+		return fmt.Errorf("failed to commit collection deletion: %v", err)
+	}
+
+	return nil
+}
+
+// GetCollectionsByUserID returns the user's collections by name, with the
+// number of bookmarks in each.
+func GetCollectionsByUserID(db *sql.DB, userID string) ([]Collection, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT bc.id, bc.user_id, bc.name, bc.created_at, (SELECT COUNT(*) FROM bookmarks b WHERE b.collection_id = bc.id)
+		FROM bookmark_collections bc WHERE bc.user_id = ? ORDER BY bc.name`
+	rows, err := db.QueryContext(ctx, query, userID)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to get collections: %v", err)
+		return nil, fmt.Errorf("failed to get collections: %v", err)
+	}
+	defer rows.Close()
+
+	var collections []Collection
+	for rows.Next() {
+		var c Collection
+		if err := rows.Scan(&c.ID, &c.UserID, &c.Name, &c.CreatedAt, &c.Bookmarks); err != nil {
+			logger.ErrorLogger.Printf("Failed to scan collection: %v", err)
+			return nil, fmt.Errorf("failed to scan collection: %v", err)
+		}
+		collections = append(collections, c)
+	}
+
+	if err := rows.Err(); err != nil {
+		logger.ErrorLogger.Printf("Failed to get collections: %v", err)
+		return nil, fmt.Errorf("failed to get collections: %v", err)
+	}
+
+	return collections, nil
+}
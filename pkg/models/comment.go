@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"html/template"
 	"time"
 
 	"forum/logger"
@@ -23,6 +24,15 @@ type Comment struct {
 	LoggedInUser  User
 	Likes         int
 	Dislikes      int
+	Status        string `json:"status"`
+	// Accepted is set on the accepted answer to a question.
+	Accepted bool `json:"accepted"`
+	// Reaction is the like or dislike the user viewing the comment left on it.
+	Reaction string `json:"-"`
+	// ContentHTML is the rendered Markdown of Content, cached by
+	// SaveCommentContentHTML like Post.ContentHTML.
+	ContentHTML        template.HTML `json:"-"`
+	ContentHTMLVersion int           `json:"-"`
 }
 
 func CreateComment(db *sql.DB, comment Comment) (string, error) {
@@ -52,11 +62,11 @@ func GetAllCommentsByPostID(db *sql.DB, postID string) ([]Comment, error) {
 	var comments []Comment
 
 	query := `
-		SELECT comments.id, comments.user_id, comments.post_id, comments.content , comments.created_at, users.id, users.name, users.email,  users.created_at, posts.id, posts.user_id, posts.title, posts.content, posts.created_at
+		SELECT comments.id, comments.user_id, comments.post_id, comments.content, comments.created_at, comments.content_html, comments.content_html_version, comments.likes_count, comments.dislikes_count, users.id, users.name, users.email,  users.created_at, posts.id, posts.user_id, posts.title, posts.content, posts.created_at
 		FROM comments
 		JOIN users ON comments.user_id = users.id
 		JOIN posts ON comments.post_id = posts.id 
-		WHERE comments.post_id = ? 
+		WHERE comments.post_id = ? AND comments.status = 'published'
 		ORDER BY comments.created_at DESC
 	`
 	rows, err := db.QueryContext(ctx, query, postID)
@@ -71,7 +81,7 @@ func GetAllCommentsByPostID(db *sql.DB, postID string) ([]Comment, error) {
 		var user User
 		var post Post
 
-		err := rows.Scan(&comment.ID, &comment.UserID, &comment.PostID, &comment.Content, &comment.CreatedAt, &user.ID, &user.Name, &user.Email, &user.CreatedAt, &post.ID, &post.UserID, &post.Title, &post.Content, &post.CreatedAt)
+		err := rows.Scan(&comment.ID, &comment.UserID, &comment.PostID, &comment.Content, &comment.CreatedAt, &comment.ContentHTML, &comment.ContentHTMLVersion, &comment.Likes, &comment.Dislikes, &user.ID, &user.Name, &user.Email, &user.CreatedAt, &post.ID, &post.UserID, &post.Title, &post.Content, &post.CreatedAt)
 		if err != nil {
 			logger.ErrorLogger.Printf("Failed to scan comment: %v", err)
 			return nil, fmt.Errorf("failed to scan comment: %v", err)
@@ -89,20 +99,19 @@ func GetAllCommentsByPostID(db *sql.DB, postID string) ([]Comment, error) {
 	return comments, nil
 }
 
-func CommentCountByPostID(db *sql.DB, postID string) (int, error) {
+// SaveCommentContentHTML caches the rendered Markdown of a comment, as
+// SavePostContentHTML does for posts.
+func SaveCommentContentHTML(db *sql.DB, id, content string, html template.HTML, version int) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	var count int
-
-	query := `SELECT COUNT(*) FROM comments WHERE post_id = ?`
-	err := db.QueryRowContext(ctx, query, postID).Scan(&count)
-	if err != nil {
-		logger.ErrorLogger.Printf("Failed to get comment count: %v", err)
-		return 0, fmt.Errorf("failed to get comment count: %v", err)
+	query := "UPDATE comments SET content_html = ?, content_html_version = ? WHERE id = ? AND content = ?"
+	if _, err := db.ExecContext(ctx, query, string(html), version, id, content); err != nil {
+		logger.ErrorLogger.Printf("Failed to save comment HTML: %v", err)
+		return fmt.Errorf("failed to save comment HTML: %v", err)
 	}
 
-	return count, nil
+	return nil
 }
 
 func GetAllCommentsByUserID(db *sql.DB, userID string) ([]Comment, error) {
@@ -114,7 +123,7 @@ func GetAllCommentsByUserID(db *sql.DB, userID string) ([]Comment, error) {
 	var comments []Comment
 
 	query := `
-        SELECT comments.id, comments.user_id, comments.post_id, comments.content, comments.created_at, users.id, users.name, users.email, users.created_at, posts.id, posts.user_id, posts.title, posts.content, posts.created_at
+        SELECT comments.id, comments.user_id, comments.post_id, comments.content, comments.created_at, comments.status, users.id, users.name, users.email, users.created_at, posts.id, posts.user_id, posts.title, posts.content, posts.created_at
         FROM comments
         JOIN users ON comments.user_id = users.id
         JOIN posts ON comments.post_id = posts.id
@@ -133,7 +142,7 @@ func GetAllCommentsByUserID(db *sql.DB, userID string) ([]Comment, error) {
 		var user User
 		var post Post
 
-		err := rows.Scan(&comment.ID, &comment.UserID, &comment.PostID, &comment.Content, &comment.CreatedAt, &user.ID, &user.Name, &user.Email, &user.CreatedAt, &post.ID, &post.UserID, &post.Title, &post.Content, &post.CreatedAt)
+		err := rows.Scan(&comment.ID, &comment.UserID, &comment.PostID, &comment.Content, &comment.CreatedAt, &comment.Status, &user.ID, &user.Name, &user.Email, &user.CreatedAt, &post.ID, &post.UserID, &post.Title, &post.Content, &post.CreatedAt)
 		if err != nil {
 			logger.ErrorLogger.Println("Failed to scan comment:", err)
 			return nil, fmt.Errorf("failed to scan comment: %v", err)
@@ -151,7 +160,7 @@ func GetAllComments(db *sql.DB) ([]Comment, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	query := "SELECT * FROM comments"
+	query := "SELECT id, user_id, post_id, content, created_at FROM comments WHERE status = 'published'"
 	rows, err := db.QueryContext(ctx, query)
 	if err != nil {
 		logger.ErrorLogger.Printf("Failed to get comments: %v", err)
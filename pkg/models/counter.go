@@ -0,0 +1,75 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"forum/logger"
+)
+
+// counterRepairs recount the stored like, dislike and comment counters, only
+// touching the rows whose counters are off. Triggers keep them in step, so
+// these are for counters that drifted, such as after editing the database by
+// hand.
+var counterRepairs = []struct {
+	table string
+	query string
+}{
+	{"posts", `UPDATE posts SET likes_count = n.likes, dislikes_count = n.dislikes, comments_count = n.comments
+		FROM (SELECT p.id,
+			(SELECT COUNT(*) FROM post_reactions r WHERE r.post_id = p.id AND r.reaction_type = 'like') AS likes,
+			(SELECT COUNT(*) FROM post_reactions r WHERE r.post_id = p.id AND r.reaction_type = 'dislike') AS dislikes,
+			(SELECT COUNT(*) FROM comments c WHERE c.post_id = p.id AND c.status = 'published') AS comments
+			FROM posts p) AS n
+		WHERE posts.id = n.id
+			AND (posts.likes_count, posts.dislikes_count, posts.comments_count) != (n.likes, n.dislikes, n.comments)`},
+	{"comments", `UPDATE comments SET likes_count = n.likes, dislikes_count = n.dislikes
+		FROM (SELECT c.id,
+			(SELECT COUNT(*) FROM comment_reactions r WHERE r.comment_id = c.id AND r.reaction_type = 'like') AS likes,
+			(SELECT COUNT(*) FROM comment_reactions r WHERE r.comment_id = c.id AND r.reaction_type = 'dislike') AS dislikes
+			FROM comments c) AS n
+		WHERE comments.id = n.id
+			AND (comments.likes_count, comments.dislikes_count) != (n.likes, n.dislikes)`},
+}
+
+// RepairCounters recounts the like, dislike and comment counters of posts and
+// comments, and returns how many rows of each table were off. With dryRun the
+// counters are left as they were.
+func RepairCounters(db *sql.DB, dryRun bool) (map[string]int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to begin counter repair transaction: %v", err)
+		return nil, fmt.Errorf("failed to begin counter repair transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	repaired := make(map[string]int64)
+	for _, repair := range counterRepairs {
+		result, err := tx.ExecContext(ctx, repair.query)
+		if err != nil {
+			logger.ErrorLogger.Printf("Failed to repair %s counters: %v", repair.table, err)
+			return nil, fmt.Errorf("failed to repair %s counters: %v", repair.table, err)
+		}
+		repaired[repair.table], err = result.RowsAffected()
+		if err != nil {
+			logger.ErrorLogger.Printf("Failed to repair %s counters: %v", repair.table, err)
+			return nil, fmt.Errorf("failed to repair %s counters: %v", repair.table, err)
+		}
+	}
+
+	if dryRun {
+		return repaired, nil
+	}
+
+	if err := tx.Commit(); err != nil {
+		logger.ErrorLogger.Printf("Failed to commit counter repair: %v", err)
+		return nil, fmt.Errorf("failed to commit counter repair: %v", err)
+	}
+
+	return repaired, nil
+}
@@ -0,0 +1,162 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"forum/logger"
+)
+
+// ErrNotDraft is returned when a draft was published or deleted before it
+// could be changed.
+var ErrNotDraft = errors.New("post is not a draft")
+
+// UpdateDraft saves changes to a draft: its text, categories, tags, publish
+// time, image and poll, the attachments in removed are dropped and post.Attachments
+// not yet saved are added after the remaining ones.
+func UpdateDraft(db *sql.DB, post Post, removed []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to begin draft transaction: %v", err)
+		return fmt.Errorf("failed to begin draft transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	query := "UPDATE posts SET title = ?, content = ?, image_url = ?, category = ?, publish_at = ? WHERE id = ? AND user_id = ? AND status = 'draft'"
+	result, err := tx.ExecContext(ctx, query, post.Title, post.Content, post.ImageFullPath, post.Category, post.PublishAt, post.ID, post.UserID)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to update draft: %v", err)
+		return fmt.Errorf("failed to update draft: %v", err)
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return ErrNotDraft
+	}
+
+	for _, id := range removed {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM post_attachments WHERE id = ? AND post_id = ?", id, post.ID); err != nil {
+			logger.ErrorLogger.Printf("Failed to remove attachment: %v", err)
+			return fmt.Errorf("failed to remove attachment: %v", err)
+		}
+	}
+
+	// Attachments already saved keep their row, new ones are numbered after
+	// them
+	var position int
+	if err := tx.QueryRowContext(ctx, "SELECT COALESCE(MAX(position), -1) + 1 FROM post_attachments WHERE post_id = ?", post.ID).Scan(&position); err != nil {
+		logger.ErrorLogger.Printf("Failed to get attachments: %v", err)
+		return fmt.Errorf("failed to get attachments: %v", err)
+	}
+	insert := "INSERT INTO post_attachments (id, post_id, kind, url, file_name, mime, size, position) VALUES (?, ?, ?, ?, ?, ?, ?, ?)"
+	for _, a := range post.Attachments {
+		if a.PostID == post.ID {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, insert, a.ID, post.ID, a.Kind, a.URL, a.FileName, a.MimeType, a.Size, position); err != nil {
+			logger.ErrorLogger.Printf("Failed to save attachment: %v", err)
+			return fmt.Errorf("failed to save attachment: %v", err)
+		}
+		position++
+	}
+
+	if err := replacePoll(ctx, tx, post); err != nil {
+		return err
+	}
+
+	if err := replaceTags(ctx, tx, post); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		logger.ErrorLogger.Printf("Failed to commit draft: %v", err)
+		return fmt.Errorf("failed to commit draft: %v", err)
+	}
+
+	return nil
+}
+
+// PublishDraft publishes a draft as of publishedAt, which becomes the post's
+// creation time so it shows up as new.
+func PublishDraft(db *sql.DB, postID string, publishedAt time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "UPDATE posts SET status = ?, publish_at = NULL, created_at = ? WHERE id = ? AND status = 'draft'"
+	result, err := db.ExecContext(ctx, query, StatusPublished, publishedAt, postID)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to publish draft: %v", err)
+		return fmt.Errorf("failed to publish draft: %v", err)
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return ErrNotDraft
+	}
+
+	return nil
+}
+
+// DeleteDraft deletes one of the user's drafts. Its attachments are released
+// by the posts_attachments_delete trigger.
+func DeleteDraft(db *sql.DB, postID, userID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := db.ExecContext(ctx, "DELETE FROM posts WHERE id = ? AND user_id = ? AND status = 'draft'", postID, userID)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to delete draft: %v", err)
+		return fmt.Errorf("failed to delete draft: %v", err)
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return ErrNotDraft
+	}
+
+	return nil
+}
+
+// GetDraftsByUserID returns the user's drafts, scheduled ones first in the
+// order they will be published, then the rest newest first.
+func GetDraftsByUserID(db *sql.DB, userID string) ([]Post, error) {
+	return getDrafts(db, `SELECT id, user_id, title, content, image_url, category, created_at, status, publish_at
+		FROM posts WHERE user_id = ? AND status = 'draft'
+		ORDER BY publish_at IS NULL, publish_at, created_at DESC`, userID)
+}
+
+// GetDueDrafts returns the scheduled drafts whose publish time has come.
+func GetDueDrafts(db *sql.DB, now time.Time) ([]Post, error) {
+	return getDrafts(db, `SELECT id, user_id, title, content, image_url, category, created_at, status, publish_at
+		FROM posts WHERE status = 'draft' AND publish_at IS NOT NULL AND publish_at <= ?
+		ORDER BY publish_at`, now)
+}
+
+func getDrafts(db *sql.DB, query string, args ...interface{}) ([]Post, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to get drafts: %v", err)
+		return nil, fmt.Errorf("failed to get drafts: %v", err)
+	}
+	defer rows.Close()
+
+	var posts []Post
+	for rows.Next() {
+		var post Post
+		if err := rows.Scan(&post.ID, &post.UserID, &post.Title, &post.Content, &post.ImageFullPath, &post.Category, &post.CreatedAt, &post.Status, &post.PublishAt); err != nil {
+			logger.ErrorLogger.Printf("Failed to scan draft: %v", err)
+			return nil, fmt.Errorf("failed to scan draft: %v", err)
+		}
+		posts = append(posts, post)
+	}
+
+	if err := rows.Err(); err != nil {
+		logger.ErrorLogger.Printf("Failed to get drafts: %v", err)
+		return nil, fmt.Errorf("failed to get drafts: %v", err)
+	}
+
+	return posts, nil
+}
@@ -0,0 +1,115 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"forum/logger"
+)
+
+// EmojiReaction is an emoji a user left on a post, or on a comment on it when
+// CommentID is set. UserName is filled in when reactions are listed.
+type EmojiReaction struct {
+	PostID    string    `json:"post_id"`
+	CommentID string    `json:"comment_id"`
+	UserID    string    `json:"user_id"`
+	UserName  string    `json:"user_name"`
+	Emoji     string    `json:"emoji"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ToggleEmojiReaction leaves the emoji on a published post or comment, or
+// takes it back if the user had already left it. It reports whether the
+// reaction is there now.
+func ToggleEmojiReaction(db *sql.DB, reaction EmojiReaction) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to begin reaction transaction: %v", err)
+		return false, fmt.Errorf("failed to begin reaction transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := checkReactionTarget(ctx, tx, reaction.PostID, reaction.CommentID); err != nil {
+		return false, err
+	}
+
+	query := "DELETE FROM emoji_reactions WHERE post_id = ? AND comment_id = ? AND user_id = ? AND emoji = ?"
+	result, err := tx.ExecContext(ctx, query, reaction.PostID, reaction.CommentID, reaction.UserID, reaction.Emoji)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to remove reaction: %v", err)
+		return false, fmt.Errorf("failed to remove reaction: %v", err)
+	}
+	removed, err := result.RowsAffected()
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to remove reaction: %v", err)
+		return false, fmt.Errorf("failed to remove reaction: %v", err)
+	}
+
+	if removed == 0 {
+		query = "INSERT INTO emoji_reactions (post_id, comment_id, user_id, emoji, created_at) VALUES (?, ?, ?, ?, ?)"
+		_, err := tx.ExecContext(ctx, query, reaction.PostID, reaction.CommentID, reaction.UserID, reaction.Emoji, time.Now())
+		if err != nil {
+			logger.ErrorLogger.Printf("Failed to add reaction: %v", err)
+			return false, fmt.Errorf("failed to add reaction: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		logger.ErrorLogger.Printf("Failed to commit reaction: %v", err)
+		return false, fmt.Errorf("failed to commit reaction: %v", err)
+	}
+
+	return removed == 0, nil
+}
+
+// GetEmojiReactionsOnPost returns the emoji reactions on a post and its
+// comments, oldest first.
+func GetEmojiReactionsOnPost(db *sql.DB, postID string) ([]EmojiReaction, error) {
+	query := `SELECT r.post_id, r.comment_id, r.user_id, u.name, r.emoji, r.created_at
+		FROM emoji_reactions r JOIN users u ON u.id = r.user_id
+		WHERE r.post_id = ? ORDER BY r.created_at`
+	return getEmojiReactions(db, query, postID)
+}
+
+// GetEmojiReactionsByUserID returns the emoji reactions the user left, newest
+// first.
+func GetEmojiReactionsByUserID(db *sql.DB, userID string) ([]EmojiReaction, error) {
+	query := `SELECT r.post_id, r.comment_id, r.user_id, u.name, r.emoji, r.created_at
+		FROM emoji_reactions r JOIN users u ON u.id = r.user_id
+		WHERE r.user_id = ? ORDER BY r.created_at DESC`
+	return getEmojiReactions(db, query, userID)
+}
+
+func getEmojiReactions(db *sql.DB, query string, args ...interface{}) ([]EmojiReaction, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to get emoji reactions: %v", err)
+		return nil, fmt.Errorf("failed to get emoji reactions: %v", err)
+	}
+	defer rows.Close()
+
+	var reactions []EmojiReaction
+	for rows.Next() {
+		var r EmojiReaction
+		if err := rows.Scan(&r.PostID, &r.CommentID, &r.UserID, &r.UserName, &r.Emoji, &r.CreatedAt); err != nil {
+			logger.ErrorLogger.Printf("Failed to scan emoji reaction: %v", err)
+			return nil, fmt.Errorf("failed to scan emoji reaction: %v", err)
+		}
+		reactions = append(reactions, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		logger.ErrorLogger.Printf("Failed to get emoji reactions: %v", err)
+		return nil, fmt.Errorf("failed to get emoji reactions: %v", err)
+	}
+
+	return reactions, nil
+}
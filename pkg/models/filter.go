@@ -10,9 +10,13 @@ import (
 	_ "github.com/mattn/go-sqlite3"
 )
 
-func GetPostsWithFilters(db *sql.DB, categories []string, fromDate string, likes []int) ([]Post, error) {
+// GetPostsWithFilters returns the published posts matching the filters. answers
+// is AnswersUnanswered for the questions without an accepted answer,
+// AnswersSolved for those with one, or empty for every post.
+func GetPostsWithFilters(db *sql.DB, categories []string, tags []string, fromDate string, likes []int, answers string) ([]Post, error) {
 	var posts []Post
 	var query string
+	var args []interface{}
 
 	// Date filer
 	if fromDate != "" {
@@ -20,6 +24,7 @@ func GetPostsWithFilters(db *sql.DB, categories []string, fromDate string, likes
 	}
 
 	// Category filter
+	categoryFiltered := false
 	if len(categories) > 0 {
 		categoryConditions := []string{}
 		for _, category := range categories {
@@ -29,9 +34,16 @@ func GetPostsWithFilters(db *sql.DB, categories []string, fromDate string, likes
 		}
 		if len(categoryConditions) > 0 {
 			query = query + fmt.Sprintf(" AND (%s)", strings.Join(categoryConditions, " OR "))
+			categoryFiltered = true
 		}
 	}
 
+	// Tag filter, by name or synonym
+	if len(tags) > 0 {
+		query = query + " AND id IN (SELECT post_id FROM post_tags WHERE tag_id IN (" + tagIDs(tags) + "))"
+		args = append(args, tagIDArgs(tags)...)
+	}
+
 	// Likes filter
 	if len(likes) > 0 {
 		likesConditions := []string{}
@@ -40,9 +52,9 @@ func GetPostsWithFilters(db *sql.DB, categories []string, fromDate string, likes
 				continue
 			}
 			if like == 0 {
-				likesConditions = append(likesConditions, "(SELECT COUNT(*) FROM post_reactions WHERE reaction_type='like' AND post_id=posts.id) = 0")
+				likesConditions = append(likesConditions, "likes_count = 0")
 			} else {
-				likesConditions = append(likesConditions, fmt.Sprintf("(SELECT COUNT(*) FROM post_reactions WHERE reaction_type='like' AND post_id=posts.id) >= %d", like))
+				likesConditions = append(likesConditions, fmt.Sprintf("likes_count >= %d", like))
 			}
 		}
 		if len(likesConditions) > 0 {
@@ -50,12 +62,27 @@ func GetPostsWithFilters(db *sql.DB, categories []string, fromDate string, likes
 		}
 	}
 
-	query = fmt.Sprintf("SELECT * FROM posts WHERE 1=1 %s", query)
+	// Answers filter
+	switch answers {
+	case AnswersSolved:
+		query = query + " AND accepted_comment_id != ''"
+	case AnswersUnanswered:
+		qa, err := GetQACategories(db)
+		if err != nil {
+			return nil, err
+		}
+		condition, qaArgs := questionCondition(qa)
+		query = query + " AND accepted_comment_id = '' AND " + condition
+		args = append(args, qaArgs...)
+	}
+
+	// Pinned posts first, pinned to their categories too when filtering by them
+	query = fmt.Sprintf("SELECT id, user_id, title, content, image_url, category, created_at, pinned, locked_at, archived_at, accepted_comment_id, likes_count, dislikes_count, comments_count FROM posts WHERE status = 'published' %s ORDER BY %s, created_at DESC", query, pinOrder(categoryFiltered))
 
 	// Log the query being executed
 	logger.InfoLogger.Printf("Executing query: %s", query)
 
-	rows, err := db.Query(query)
+	rows, err := db.Query(query, args...)
 	if err != nil {
 		// Log the error and return it
 		logger.ErrorLogger.Printf("Error executing query: %s", err)
@@ -66,7 +93,7 @@ func GetPostsWithFilters(db *sql.DB, categories []string, fromDate string, likes
 
 	for rows.Next() {
 		var post Post
-		err := rows.Scan(&post.ID, &post.UserID, &post.Title, &post.Content, &post.ImageFullPath, &post.Category, &post.CreatedAt)
+		err := rows.Scan(&post.ID, &post.UserID, &post.Title, &post.Content, &post.ImageFullPath, &post.Category, &post.CreatedAt, &post.Pinned, &post.LockedAt, &post.ArchivedAt, &post.AcceptedCommentID, &post.Likes, &post.Dislikes, &post.CommentsCount)
 		if err != nil {
 			// Log the error and return it
 			logger.ErrorLogger.Printf("Error scanning rows: %s", err)
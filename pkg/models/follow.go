@@ -0,0 +1,322 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"forum/logger"
+)
+
+// States of a thread watch.
+const (
+	ThreadWatching = "watching"
+	ThreadMuted    = "muted"
+)
+
+// ThreadWatch is a user's choice about being notified of comments on a post.
+type ThreadWatch struct {
+	PostID    string    `json:"post_id"`
+	PostTitle string    `json:"post_title"`
+	State     string    `json:"state"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Follow makes follower follow followee. Following twice is harmless.
+func Follow(db *sql.DB, followerID, followeeID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "INSERT OR IGNORE INTO follows (follower_id, followee_id, created_at) VALUES (?, ?, ?)"
+	if _, err := db.ExecContext(ctx, query, followerID, followeeID, time.Now()); err != nil {
+		logger.ErrorLogger.Printf("Failed to follow user: %v", err)
+		return fmt.Errorf("failed to follow user: %v", err)
+	}
+
+	return nil
+}
+
+func Unfollow(db *sql.DB, followerID, followeeID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "DELETE FROM follows WHERE follower_id = ? AND followee_id = ?"
+	if _, err := db.ExecContext(ctx, query, followerID, followeeID); err != nil {
+		logger.ErrorLogger.Printf("Failed to unfollow user: %v", err)
+		return fmt.Errorf("failed to unfollow user: %v", err)
+	}
+
+	return nil
+}
+
+func IsFollowing(db *sql.DB, followerID, followeeID string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var following bool
+	query := "SELECT EXISTS (SELECT 1 FROM follows WHERE follower_id = ? AND followee_id = ?)"
+	if err := db.QueryRowContext(ctx, query, followerID, followeeID).Scan(&following); err != nil {
+		logger.ErrorLogger.Printf("Failed to check follow: %v", err)
+		return false, fmt.Errorf("failed to check follow: %v", err)
+	}
+
+	return following, nil
+}
+
+// GetFollowedUsers returns the users the user follows, by name. Only their
+// ID and name are filled in.
+func GetFollowedUsers(db *sql.DB, userID string) ([]User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "SELECT u.id, u.name FROM follows f JOIN users u ON u.id = f.followee_id WHERE f.follower_id = ? ORDER BY u.name"
+	rows, err := db.QueryContext(ctx, query, userID)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to get followed users: %v", err)
+		return nil, fmt.Errorf("failed to get followed users: %v", err)
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(&user.ID, &user.Name); err != nil {
+			logger.ErrorLogger.Printf("Failed to scan followed user: %v", err)
+			return nil, fmt.Errorf("failed to scan followed user: %v", err)
+		}
+		users = append(users, user)
+	}
+
+	if err := rows.Err(); err != nil {
+		logger.ErrorLogger.Printf("Failed to get followed users: %v", err)
+		return nil, fmt.Errorf("failed to get followed users: %v", err)
+	}
+
+	return users, nil
+}
+
+func SubscribeCategory(db *sql.DB, userID, category string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "INSERT OR IGNORE INTO category_subscriptions (user_id, category, created_at) VALUES (?, ?, ?)"
+	if _, err := db.ExecContext(ctx, query, userID, category, time.Now()); err != nil {
+		logger.ErrorLogger.Printf("Failed to subscribe to category: %v", err)
+		return fmt.Errorf("failed to subscribe to category: %v", err)
+	}
+
+	return nil
+}
+
+func UnsubscribeCategory(db *sql.DB, userID, category string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "DELETE FROM category_subscriptions WHERE user_id = ? AND category = ?"
+	if _, err := db.ExecContext(ctx, query, userID, category); err != nil {
+		logger.ErrorLogger.Printf("Failed to unsubscribe from category: %v", err)
+		return fmt.Errorf("failed to unsubscribe from category: %v", err)
+	}
+
+	return nil
+}
+
+func GetCategorySubscriptions(db *sql.DB, userID string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, "SELECT category FROM category_subscriptions WHERE user_id = ? ORDER BY category", userID)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to get category subscriptions: %v", err)
+		return nil, fmt.Errorf("failed to get category subscriptions: %v", err)
+	}
+	defer rows.Close()
+
+	var categories []string
+	for rows.Next() {
+		var category string
+		if err := rows.Scan(&category); err != nil {
+			logger.ErrorLogger.Printf("Failed to scan category subscription: %v", err)
+			return nil, fmt.Errorf("failed to scan category subscription: %v", err)
+		}
+		categories = append(categories, category)
+	}
+
+	if err := rows.Err(); err != nil {
+		logger.ErrorLogger.Printf("Failed to get category subscriptions: %v", err)
+		return nil, fmt.Errorf("failed to get category subscriptions: %v", err)
+	}
+
+	return categories, nil
+}
+
+// GetFollowingFeed returns the newest published posts by users the user
+// follows or in categories they subscribe to, leaving out their own.
+func GetFollowingFeed(db *sql.DB, userID string, limit int) ([]Post, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// A post's categories are stored joined by "; "
+	query := `SELECT p.id, p.user_id, p.title, p.content, p.image_url, p.category, p.created_at, p.likes_count, p.dislikes_count, p.comments_count
+		FROM posts p
+		WHERE p.status = 'published' AND p.user_id != ? AND (
+			p.user_id IN (SELECT followee_id FROM follows WHERE follower_id = ?)
+			OR EXISTS (SELECT 1 FROM category_subscriptions s
+				WHERE s.user_id = ? AND '; ' || p.category || '; ' LIKE '%; ' || s.category || '; %'))
+		ORDER BY p.created_at DESC
+		LIMIT ?`
+	rows, err := db.QueryContext(ctx, query, userID, userID, userID, limit)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to get following feed: %v", err)
+		return nil, fmt.Errorf("failed to get following feed: %v", err)
+	}
+	defer rows.Close()
+
+	var posts []Post
+	for rows.Next() {
+		var post Post
+		if err := rows.Scan(&post.ID, &post.UserID, &post.Title, &post.Content, &post.ImageFullPath, &post.Category, &post.CreatedAt, &post.Likes, &post.Dislikes, &post.CommentsCount); err != nil {
+			logger.ErrorLogger.Printf("Failed to scan feed post: %v", err)
+			return nil, fmt.Errorf("failed to scan feed post: %v", err)
+		}
+		posts = append(posts, post)
+	}
+
+	if err := rows.Err(); err != nil {
+		logger.ErrorLogger.Printf("Failed to get following feed: %v", err)
+		return nil, fmt.Errorf("failed to get following feed: %v", err)
+	}
+
+	return posts, nil
+}
+
+// SetThreadWatch sets the user's watch on a post to ThreadWatching or
+// ThreadMuted, or removes it when state is empty.
+func SetThreadWatch(db *sql.DB, userID, postID, state string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var err error
+	if state == "" {
+		_, err = db.ExecContext(ctx, "DELETE FROM thread_watches WHERE user_id = ? AND post_id = ?", userID, postID)
+	} else {
+		query := `INSERT INTO thread_watches (user_id, post_id, state, created_at) VALUES (?, ?, ?, ?)
+			ON CONFLICT (user_id, post_id) DO UPDATE SET state = excluded.state`
+		_, err = db.ExecContext(ctx, query, userID, postID, state, time.Now())
+	}
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to set thread watch: %v", err)
+		return fmt.Errorf("failed to set thread watch: %v", err)
+	}
+
+	return nil
+}
+
+// WatchThreadIfUnset watches a post the user comments on, unless they
+// already chose to watch or mute it.
+func WatchThreadIfUnset(db *sql.DB, userID, postID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "INSERT OR IGNORE INTO thread_watches (user_id, post_id, state, created_at) VALUES (?, ?, ?, ?)"
+	if _, err := db.ExecContext(ctx, query, userID, postID, ThreadWatching, time.Now()); err != nil {
+		logger.ErrorLogger.Printf("Failed to watch thread: %v", err)
+		return fmt.Errorf("failed to watch thread: %v", err)
+	}
+
+	return nil
+}
+
+// GetThreadWatchState returns how the user is notified about comments on the
+// post: ThreadWatching, ThreadMuted, or "" when they aren't. Authors watch
+// their own posts unless they mute them.
+func GetThreadWatchState(db *sql.DB, userID string, post Post) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var state string
+	err := db.QueryRowContext(ctx, "SELECT state FROM thread_watches WHERE user_id = ? AND post_id = ?", userID, post.ID).Scan(&state)
+	if err == sql.ErrNoRows {
+		if post.UserID == userID {
+			return ThreadWatching, nil
+		}
+		return "", nil
+	}
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to get thread watch: %v", err)
+		return "", fmt.Errorf("failed to get thread watch: %v", err)
+	}
+
+	return state, nil
+}
+
+// GetThreadWatchers returns the IDs of the users to notify about a new comment
+// on the post: those watching it and its author, unless they muted it,
+// leaving out the commenter.
+func GetThreadWatchers(db *sql.DB, postID, commenterID string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT user_id FROM thread_watches WHERE post_id = ? AND state = 'watching' AND user_id != ?
+		UNION
+		SELECT user_id FROM posts WHERE id = ? AND user_id != ?
+			AND user_id NOT IN (SELECT user_id FROM thread_watches WHERE post_id = ? AND state = 'muted')`
+	rows, err := db.QueryContext(ctx, query, postID, commenterID, postID, commenterID, postID)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to get thread watchers: %v", err)
+		return nil, fmt.Errorf("failed to get thread watchers: %v", err)
+	}
+	defer rows.Close()
+
+	var userIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			logger.ErrorLogger.Printf("Failed to scan thread watcher: %v", err)
+			return nil, fmt.Errorf("failed to scan thread watcher: %v", err)
+		}
+		userIDs = append(userIDs, id)
+	}
+
+	if err := rows.Err(); err != nil {
+		logger.ErrorLogger.Printf("Failed to get thread watchers: %v", err)
+		return nil, fmt.Errorf("failed to get thread watchers: %v", err)
+	}
+
+	return userIDs, nil
+}
+
+// GetThreadWatchesByUserID returns the posts the user watches or muted,
+// newest choice first.
+func GetThreadWatchesByUserID(db *sql.DB, userID string) ([]ThreadWatch, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT w.post_id, p.title, w.state, w.created_at FROM thread_watches w
+		JOIN posts p ON p.id = w.post_id
+		WHERE w.user_id = ? ORDER BY w.created_at DESC`
+	rows, err := db.QueryContext(ctx, query, userID)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to get thread watches: %v", err)
+		return nil, fmt.Errorf("failed to get thread watches: %v", err)
+	}
+	defer rows.Close()
+
+	var watches []ThreadWatch
+	for rows.Next() {
+		var w ThreadWatch
+		if err := rows.Scan(&w.PostID, &w.PostTitle, &w.State, &w.CreatedAt); err != nil {
+			logger.ErrorLogger.Printf("Failed to scan thread watch: %v", err)
+			return nil, fmt.Errorf("failed to scan thread watch: %v", err)
+		}
+		watches = append(watches, w)
+	}
+
+	if err := rows.Err(); err != nil {
+		logger.ErrorLogger.Printf("Failed to get thread watches: %v", err)
+		return nil, fmt.Errorf("failed to get thread watches: %v", err)
+	}
+
+	return watches, nil
+}
@@ -0,0 +1,117 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"forum/logger"
+)
+
+// ImageVariant is a resized copy of an uploaded image. Variants are keyed by
+// the path of the original, as stored in posts.image_url.
+type ImageVariant struct {
+	ImageURL string `json:"image_url"`
+	Name     string `json:"name"`
+	URL      string `json:"url"`
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+}
+
+// SaveImageVariants replaces the variants recorded for an image.
+func SaveImageVariants(db *sql.DB, imageURL string, variants []ImageVariant) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to begin image variants transaction: %v", err)
+		return fmt.Errorf("failed to begin image variants transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM image_variants WHERE image_url = ?", imageURL); err != nil {
+		logger.ErrorLogger.Printf("Failed to delete image variants: %v", err)
+		return fmt.Errorf("failed to delete image variants: %v", err)
+	}
+
+	query := "INSERT INTO image_variants (image_url, name, url, width, height) VALUES (?, ?, ?, ?, ?)"
+	for _, v := range variants {
+		if _, err := tx.ExecContext(ctx, query, imageURL, v.Name, v.URL, v.Width, v.Height); err != nil {
+			logger.ErrorLogger.Printf("Failed to save image variant: %v", err)
+			return fmt.Errorf("failed to save image variant: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		logger.ErrorLogger.Printf("Failed to commit image variants: %v", err)
+		return fmt.Errorf("failed to commit image variants: %v", err)
+	}
+
+	return nil
+}
+
+// GetImageVariants returns the variants of an image, smallest first.
+func GetImageVariants(db *sql.DB, imageURL string) ([]ImageVariant, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "SELECT image_url, name, url, width, height FROM image_variants WHERE image_url = ? ORDER BY width"
+	rows, err := db.QueryContext(ctx, query, imageURL)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to get image variants: %v", err)
+		return nil, fmt.Errorf("failed to get image variants: %v", err)
+	}
+	defer rows.Close()
+
+	var variants []ImageVariant
+	for rows.Next() {
+		var v ImageVariant
+		if err := rows.Scan(&v.ImageURL, &v.Name, &v.URL, &v.Width, &v.Height); err != nil {
+			logger.ErrorLogger.Printf("Failed to scan image variant: %v", err)
+			return nil, fmt.Errorf("failed to scan image variant: %v", err)
+		}
+		variants = append(variants, v)
+	}
+
+	if err := rows.Err(); err != nil {
+		logger.ErrorLogger.Printf("Failed to get image variants: %v", err)
+		return nil, fmt.Errorf("failed to get image variants: %v", err)
+	}
+
+	return variants, nil
+}
+
+// GetImagesWithoutVariants returns the images attached to posts that have no
+// variants recorded.
+func GetImagesWithoutVariants(db *sql.DB) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT DISTINCT url FROM post_attachments a WHERE kind = 'image'
+		AND NOT EXISTS (SELECT 1 FROM image_variants v WHERE v.image_url = a.url)`
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to get images without variants: %v", err)
+		return nil, fmt.Errorf("failed to get images without variants: %v", err)
+	}
+	defer rows.Close()
+
+	var urls []string
+	for rows.Next() {
+		var url string
+		if err := rows.Scan(&url); err != nil {
+			logger.ErrorLogger.Printf("Failed to scan image URL: %v", err)
+			return nil, fmt.Errorf("failed to scan image URL: %v", err)
+		}
+		urls = append(urls, url)
+	}
+
+	if err := rows.Err(); err != nil {
+		logger.ErrorLogger.Printf("Failed to get images without variants: %v", err)
+		return nil, fmt.Errorf("failed to get images without variants: %v", err)
+	}
+
+	return urls, nil
+}
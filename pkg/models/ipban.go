@@ -0,0 +1,74 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"forum/logger"
+
+	"github.com/google/uuid"
+)
+
+// IPBan blocks every request from an address or CIDR range.
+type IPBan struct {
+	ID        string       `json:"id"`
+	CIDR      string       `json:"cidr"`
+	Reason    string       `json:"reason"`
+	CreatedBy string       `json:"created_by"`
+	CreatedAt time.Time    `json:"created_at"`
+	ExpiresAt sql.NullTime `json:"expires_at"`
+}
+
+func CreateIPBan(db *sql.DB, ban IPBan) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	ban.ID = uuid.New().String()
+	query := "INSERT INTO ip_bans (id, cidr, reason, created_by, created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?)"
+	if _, err := db.ExecContext(ctx, query, ban.ID, ban.CIDR, ban.Reason, ban.CreatedBy, time.Now(), ban.ExpiresAt); err != nil {
+		logger.ErrorLogger.Printf("Failed to create IP ban: %v", err)
+		return "", fmt.Errorf("failed to create IP ban: %v", err)
+	}
+
+	return ban.ID, nil
+}
+
+// GetActiveIPBans returns the bans that have not expired, newest first.
+func GetActiveIPBans(db *sql.DB) ([]IPBan, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "SELECT id, cidr, reason, created_by, created_at, expires_at FROM ip_bans WHERE expires_at IS NULL OR expires_at > ? ORDER BY created_at DESC"
+	rows, err := db.QueryContext(ctx, query, time.Now())
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to get IP bans: %v", err)
+		return nil, fmt.Errorf("failed to get IP bans: %v", err)
+	}
+	defer rows.Close()
+
+	var bans []IPBan
+	for rows.Next() {
+		var ban IPBan
+		if err := rows.Scan(&ban.ID, &ban.CIDR, &ban.Reason, &ban.CreatedBy, &ban.CreatedAt, &ban.ExpiresAt); err != nil {
+			logger.ErrorLogger.Printf("Failed to scan IP ban: %v", err)
+			return nil, fmt.Errorf("failed to scan IP ban: %v", err)
+		}
+		bans = append(bans, ban)
+	}
+
+	return bans, rows.Err()
+}
+
+func DeleteIPBan(db *sql.DB, id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if _, err := db.ExecContext(ctx, "DELETE FROM ip_bans WHERE id = ?", id); err != nil {
+		logger.ErrorLogger.Printf("Failed to delete IP ban: %v", err)
+		return fmt.Errorf("failed to delete IP ban: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,129 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"forum/logger"
+)
+
+// Kinds of keys failed logins are counted against.
+const (
+	ThrottleAccount = "account"
+	ThrottleIP      = "ip"
+)
+
+// LoginThrottle counts failed logins for an account (keyed by email) or an IP
+// address since the last success.
+type LoginThrottle struct {
+	Kind          string       `json:"kind"`
+	Key           string       `json:"key"`
+	Failures      int          `json:"failures"`
+	LastFailureAt time.Time    `json:"last_failure_at"`
+	LockedUntil   sql.NullTime `json:"locked_until"`
+}
+
+// LockedAccount is a locked account throttle joined with the user it belongs to.
+type LockedAccount struct {
+	LoginThrottle
+	UserID   string `json:"user_id"`
+	UserName string `json:"user_name"`
+}
+
+// GetLoginThrottle returns the throttle for kind and key. A key without failed
+// logins returns a zero LoginThrottle and no error.
+func GetLoginThrottle(db *sql.DB, kind, key string) (LoginThrottle, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	throttle := LoginThrottle{Kind: kind, Key: key}
+	query := "SELECT failures, last_failure_at, locked_until FROM login_throttles WHERE kind = ? AND key = ?"
+	err := db.QueryRowContext(ctx, query, kind, key).Scan(&throttle.Failures, &throttle.LastFailureAt, &throttle.LockedUntil)
+	if err != nil && err != sql.ErrNoRows {
+		logger.ErrorLogger.Printf("Failed to get login throttle: %v", err)
+		return throttle, fmt.Errorf("failed to get login throttle: %v", err)
+	}
+
+	return throttle, nil
+}
+
+// RecordLoginFailure counts a failed login. Failures older than resetAfter are
+// forgotten and counting starts over.
+func RecordLoginFailure(db *sql.DB, kind, key string, resetAfter time.Duration) (LoginThrottle, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	throttle := LoginThrottle{Kind: kind, Key: key}
+	query := `INSERT INTO login_throttles (kind, key, failures, last_failure_at) VALUES (?, ?, 1, ?)
+		ON CONFLICT(kind, key) DO UPDATE SET
+			failures = CASE WHEN login_throttles.last_failure_at < ? THEN 1 ELSE login_throttles.failures + 1 END,
+			locked_until = CASE WHEN login_throttles.last_failure_at < ? THEN NULL ELSE login_throttles.locked_until END,
+			last_failure_at = excluded.last_failure_at
+		RETURNING failures, last_failure_at, locked_until`
+	err := db.QueryRowContext(ctx, query, kind, key, now, now.Add(-resetAfter), now.Add(-resetAfter)).Scan(&throttle.Failures, &throttle.LastFailureAt, &throttle.LockedUntil)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to record login failure: %v", err)
+		return throttle, fmt.Errorf("failed to record login failure: %v", err)
+	}
+
+	return throttle, nil
+}
+
+func LockLogin(db *sql.DB, kind, key string, until time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if _, err := db.ExecContext(ctx, "UPDATE login_throttles SET locked_until = ? WHERE kind = ? AND key = ?", until, kind, key); err != nil {
+		logger.ErrorLogger.Printf("Failed to lock login: %v", err)
+		return fmt.Errorf("failed to lock login: %v", err)
+	}
+
+	return nil
+}
+
+// ClearLoginFailures forgets the failed logins for kind and key, which also unlocks it.
+func ClearLoginFailures(db *sql.DB, kind, key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if _, err := db.ExecContext(ctx, "DELETE FROM login_throttles WHERE kind = ? AND key = ?", kind, key); err != nil {
+		logger.ErrorLogger.Printf("Failed to clear login failures: %v", err)
+		return fmt.Errorf("failed to clear login failures: %v", err)
+	}
+
+	return nil
+}
+
+// GetLockedAccounts returns the accounts that are currently locked out, most recent first.
+func GetLockedAccounts(db *sql.DB) ([]LockedAccount, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT t.kind, t.key, t.failures, t.last_failure_at, t.locked_until, u.id, u.name
+		FROM login_throttles t
+		JOIN users u ON LOWER(u.email) = t.key
+		WHERE t.kind = ? AND t.locked_until > ?
+		ORDER BY t.last_failure_at DESC`
+	rows, err := db.QueryContext(ctx, query, ThrottleAccount, time.Now())
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to get locked accounts: %v", err)
+		return nil, fmt.Errorf("failed to get locked accounts: %v", err)
+	}
+	defer rows.Close()
+
+	var accounts []LockedAccount
+	for rows.Next() {
+		var account LockedAccount
+		err := rows.Scan(&account.Kind, &account.Key, &account.Failures, &account.LastFailureAt, &account.LockedUntil, &account.UserID, &account.UserName)
+		if err != nil {
+			logger.ErrorLogger.Printf("Failed to scan locked account: %v", err)
+			return nil, fmt.Errorf("failed to scan locked account: %v", err)
+		}
+		accounts = append(accounts, account)
+	}
+
+	return accounts, rows.Err()
+}
@@ -0,0 +1,183 @@
+package models
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"forum/logger"
+
+	"github.com/google/uuid"
+)
+
+// MaxMFAAttempts is how many wrong codes a login challenge accepts before it
+// is thrown away and the user has to enter their password again.
+const MaxMFAAttempts = 5
+
+var ErrMFAChallengeNotFound = errors.New("login challenge not found or expired")
+
+type MFAChallenge struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"user_id"`
+	Attempts  int       `json:"attempts"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// hashRecoveryCode normalizes and hashes a recovery code. The codes are long
+// random strings so a plain SHA-256 is enough to keep them safe at rest.
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// ReplaceRecoveryCodes removes the user's old recovery codes and stores the new ones hashed.
+func ReplaceRecoveryCodes(db *sql.DB, userID string, codes []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to begin recovery codes transaction: %v", err)
+		return fmt.Errorf("failed to begin recovery codes transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		logger.ErrorLogger.Printf("Failed to delete recovery codes: %v", err)
+		return fmt.Errorf("failed to delete recovery codes: %v", err)
+	}
+
+	query := "INSERT INTO recovery_codes (id, user_id, code_hash, created_at) VALUES (?, ?, ?, ?)"
+	for _, code := range codes {
+		if _, err := tx.ExecContext(ctx, query, uuid.New().String(), userID, hashRecoveryCode(code), time.Now()); err != nil {
+			logger.ErrorLogger.Printf("Failed to create recovery code: %v", err)
+			return fmt.Errorf("failed to create recovery code: %v", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func DeleteRecoveryCodes(db *sql.DB, userID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if _, err := db.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		logger.ErrorLogger.Printf("Failed to delete recovery codes: %v", err)
+		return fmt.Errorf("failed to delete recovery codes: %v", err)
+	}
+
+	return nil
+}
+
+// UseRecoveryCode marks a matching unused code as used. It returns false if
+// the code doesn't exist or was already used.
+func UseRecoveryCode(db *sql.DB, userID, code string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "UPDATE recovery_codes SET used_at = ? WHERE user_id = ? AND code_hash = ? AND used_at IS NULL"
+	result, err := db.ExecContext(ctx, query, time.Now(), userID, hashRecoveryCode(code))
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to use recovery code: %v", err)
+		return false, fmt.Errorf("failed to use recovery code: %v", err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to use recovery code: %v", err)
+	}
+
+	return n > 0, nil
+}
+
+func UnusedRecoveryCodeCount(db *sql.DB, userID string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var count int
+	query := "SELECT COUNT(*) FROM recovery_codes WHERE user_id = ? AND used_at IS NULL"
+	if err := db.QueryRowContext(ctx, query, userID).Scan(&count); err != nil {
+		logger.ErrorLogger.Printf("Failed to count recovery codes: %v", err)
+		return 0, fmt.Errorf("failed to count recovery codes: %v", err)
+	}
+
+	return count, nil
+}
+
+func CreateMFAChallenge(db *sql.DB, challenge MFAChallenge) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// A user only ever has one pending challenge
+	if _, err := db.ExecContext(ctx, "DELETE FROM mfa_challenges WHERE user_id = ? OR expires_at <= ?", challenge.UserID, time.Now()); err != nil {
+		logger.ErrorLogger.Printf("Failed to delete old login challenges: %v", err)
+		return challenge.ID, fmt.Errorf("failed to delete old login challenges: %v", err)
+	}
+
+	query := "INSERT INTO mfa_challenges (id, user_id, attempts, created_at, expires_at) VALUES (?, ?, 0, ?, ?)"
+	_, err := db.ExecContext(ctx, query, challenge.ID, challenge.UserID, time.Now(), challenge.ExpiresAt)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to create login challenge: %v", err)
+		return challenge.ID, fmt.Errorf("failed to create login challenge: %v", err)
+	}
+
+	return challenge.ID, nil
+}
+
+func GetMFAChallenge(db *sql.DB, id string) (MFAChallenge, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var challenge MFAChallenge
+	query := "SELECT id, user_id, attempts, created_at, expires_at FROM mfa_challenges WHERE id = ? AND expires_at > ?"
+	err := db.QueryRowContext(ctx, query, id, time.Now()).Scan(&challenge.ID, &challenge.UserID, &challenge.Attempts, &challenge.CreatedAt, &challenge.ExpiresAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return MFAChallenge{}, ErrMFAChallengeNotFound
+		}
+		logger.ErrorLogger.Printf("Failed to get login challenge: %v", err)
+		return MFAChallenge{}, fmt.Errorf("failed to get login challenge: %v", err)
+	}
+
+	return challenge, nil
+}
+
+// RecordMFAFailure counts a wrong code and drops the challenge once it has
+// used up its attempts. It returns the attempts left.
+func RecordMFAFailure(db *sql.DB, id string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var attempts int
+	query := "UPDATE mfa_challenges SET attempts = attempts + 1 WHERE id = ? RETURNING attempts"
+	if err := db.QueryRowContext(ctx, query, id).Scan(&attempts); err != nil {
+		logger.ErrorLogger.Printf("Failed to record login challenge failure: %v", err)
+		return 0, fmt.Errorf("failed to record login challenge failure: %v", err)
+	}
+
+	if attempts >= MaxMFAAttempts {
+		return 0, DeleteMFAChallenge(db, id)
+	}
+
+	return MaxMFAAttempts - attempts, nil
+}
+
+func DeleteMFAChallenge(db *sql.DB, id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if _, err := db.ExecContext(ctx, "DELETE FROM mfa_challenges WHERE id = ?", id); err != nil {
+		logger.ErrorLogger.Printf("Failed to delete login challenge: %v", err)
+		return fmt.Errorf("failed to delete login challenge: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,98 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"forum/logger"
+
+	"github.com/google/uuid"
+)
+
+// Kinds of notifications.
+const (
+	NotificationAccountLocked = "account_locked"
+	NotificationReview        = "review"
+	NotificationScheduledPost = "scheduled_post"
+	NotificationComment       = "comment"
+	NotificationAnswer        = "answer"
+)
+
+type Notification struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"user_id"`
+	Kind      string    `json:"kind"`
+	Message   string    `json:"message"`
+	Link      string    `json:"link"`
+	Read      bool      `json:"read"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func CreateNotification(db *sql.DB, notification Notification) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	notification.ID = uuid.New().String()
+	query := "INSERT INTO notifications (id, user_id, kind, message, link, read, created_at) VALUES (?, ?, ?, ?, ?, FALSE, ?)"
+	_, err := db.ExecContext(ctx, query, notification.ID, notification.UserID, notification.Kind, notification.Message, notification.Link, time.Now())
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to create notification: %v", err)
+		return "", fmt.Errorf("failed to create notification: %v", err)
+	}
+
+	return notification.ID, nil
+}
+
+// GetNotificationsByUserID returns the user's latest notifications, newest first.
+func GetNotificationsByUserID(db *sql.DB, userID string, limit int) ([]Notification, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "SELECT id, user_id, kind, message, link, read, created_at FROM notifications WHERE user_id = ? ORDER BY created_at DESC LIMIT ?"
+	rows, err := db.QueryContext(ctx, query, userID, limit)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to get notifications: %v", err)
+		return nil, fmt.Errorf("failed to get notifications: %v", err)
+	}
+	defer rows.Close()
+
+	var notifications []Notification
+	for rows.Next() {
+		var n Notification
+		if err := rows.Scan(&n.ID, &n.UserID, &n.Kind, &n.Message, &n.Link, &n.Read, &n.CreatedAt); err != nil {
+			logger.ErrorLogger.Printf("Failed to scan notification: %v", err)
+			return nil, fmt.Errorf("failed to scan notification: %v", err)
+		}
+		notifications = append(notifications, n)
+	}
+
+	return notifications, rows.Err()
+}
+
+func CountUnreadNotifications(db *sql.DB, userID string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var count int
+	err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM notifications WHERE user_id = ? AND read = FALSE", userID).Scan(&count)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to count notifications: %v", err)
+		return 0, fmt.Errorf("failed to count notifications: %v", err)
+	}
+
+	return count, nil
+}
+
+func MarkNotificationsRead(db *sql.DB, userID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if _, err := db.ExecContext(ctx, "UPDATE notifications SET read = TRUE WHERE user_id = ? AND read = FALSE", userID); err != nil {
+		logger.ErrorLogger.Printf("Failed to mark notifications read: %v", err)
+		return fmt.Errorf("failed to mark notifications read: %v", err)
+	}
+
+	return nil
+}
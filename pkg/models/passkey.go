@@ -0,0 +1,175 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"forum/logger"
+)
+
+var ErrWebAuthnChallengeNotFound = errors.New("passkey challenge not found or expired")
+
+type Passkey struct {
+	ID         string       `json:"id"`
+	UserID     string       `json:"user_id"`
+	Name       string       `json:"name"`
+	PublicKey  []byte       `json:"-"`
+	SignCount  uint32       `json:"-"`
+	Transports string       `json:"transports"`
+	CreatedAt  time.Time    `json:"created_at"`
+	LastUsedAt sql.NullTime `json:"last_used_at"`
+}
+
+// WebAuthnChallenge keeps the random challenge of a registration or sign-in
+// ceremony between the options request and the browser's response.
+type WebAuthnChallenge struct {
+	ID        string
+	UserID    string
+	Ceremony  string
+	Challenge []byte
+	ExpiresAt time.Time
+}
+
+func CreatePasskey(db *sql.DB, passkey Passkey) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "INSERT INTO passkeys (id, user_id, name, public_key, sign_count, transports, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)"
+	_, err := db.ExecContext(ctx, query, passkey.ID, passkey.UserID, passkey.Name, passkey.PublicKey, passkey.SignCount, passkey.Transports, time.Now())
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to create passkey: %v", err)
+		return passkey.ID, fmt.Errorf("failed to create passkey: %v", err)
+	}
+
+	return passkey.ID, nil
+}
+
+func GetPasskeyByID(db *sql.DB, id string) (Passkey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var passkey Passkey
+	query := "SELECT id, user_id, name, public_key, sign_count, transports, created_at, last_used_at FROM passkeys WHERE id = ?"
+	err := db.QueryRowContext(ctx, query, id).Scan(&passkey.ID, &passkey.UserID, &passkey.Name, &passkey.PublicKey, &passkey.SignCount, &passkey.Transports, &passkey.CreatedAt, &passkey.LastUsedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return Passkey{}, fmt.Errorf("no passkey found with ID %s", id)
+		}
+		logger.ErrorLogger.Printf("Failed to get passkey: %v", err)
+		return Passkey{}, fmt.Errorf("failed to get passkey: %v", err)
+	}
+
+	return passkey, nil
+}
+
+func GetPasskeysByUserID(db *sql.DB, userID string) ([]Passkey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "SELECT id, user_id, name, public_key, sign_count, transports, created_at, last_used_at FROM passkeys WHERE user_id = ? ORDER BY created_at"
+	rows, err := db.QueryContext(ctx, query, userID)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to get passkeys: %v", err)
+		return nil, fmt.Errorf("failed to get passkeys: %v", err)
+	}
+	defer rows.Close()
+
+	var passkeys []Passkey
+	for rows.Next() {
+		var passkey Passkey
+		err := rows.Scan(&passkey.ID, &passkey.UserID, &passkey.Name, &passkey.PublicKey, &passkey.SignCount, &passkey.Transports, &passkey.CreatedAt, &passkey.LastUsedAt)
+		if err != nil {
+			logger.ErrorLogger.Printf("Failed to scan passkey: %v", err)
+			return nil, fmt.Errorf("failed to scan passkey: %v", err)
+		}
+		passkeys = append(passkeys, passkey)
+	}
+
+	if err := rows.Err(); err != nil {
+		logger.ErrorLogger.Printf("Failed to iterate over passkeys: %v", err)
+		return nil, fmt.Errorf("failed to iterate over passkeys: %v", err)
+	}
+
+	return passkeys, nil
+}
+
+// RecordPasskeyUse stores the new signature counter after a successful sign-in.
+func RecordPasskeyUse(db *sql.DB, id string, signCount uint32) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "UPDATE passkeys SET sign_count = ?, last_used_at = ? WHERE id = ?"
+	if _, err := db.ExecContext(ctx, query, signCount, time.Now(), id); err != nil {
+		logger.ErrorLogger.Printf("Failed to update passkey: %v", err)
+		return fmt.Errorf("failed to update passkey: %v", err)
+	}
+
+	return nil
+}
+
+func RenamePasskey(db *sql.DB, id, userID, name string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "UPDATE passkeys SET name = ? WHERE id = ? AND user_id = ?"
+	if _, err := db.ExecContext(ctx, query, name, id, userID); err != nil {
+		logger.ErrorLogger.Printf("Failed to rename passkey: %v", err)
+		return fmt.Errorf("failed to rename passkey: %v", err)
+	}
+
+	return nil
+}
+
+func DeletePasskey(db *sql.DB, id, userID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if _, err := db.ExecContext(ctx, "DELETE FROM passkeys WHERE id = ? AND user_id = ?", id, userID); err != nil {
+		logger.ErrorLogger.Printf("Failed to delete passkey: %v", err)
+		return fmt.Errorf("failed to delete passkey: %v", err)
+	}
+
+	return nil
+}
+
+func CreateWebAuthnChallenge(db *sql.DB, challenge WebAuthnChallenge) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Expired challenges are never read again
+	if _, err := db.ExecContext(ctx, "DELETE FROM webauthn_challenges WHERE expires_at <= ?", time.Now()); err != nil {
+		logger.ErrorLogger.Printf("Failed to delete expired passkey challenges: %v", err)
+	}
+
+	query := "INSERT INTO webauthn_challenges (id, user_id, ceremony, challenge, expires_at) VALUES (?, ?, ?, ?, ?)"
+	_, err := db.ExecContext(ctx, query, challenge.ID, challenge.UserID, challenge.Ceremony, challenge.Challenge, challenge.ExpiresAt)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to create passkey challenge: %v", err)
+		return challenge.ID, fmt.Errorf("failed to create passkey challenge: %v", err)
+	}
+
+	return challenge.ID, nil
+}
+
+// TakeWebAuthnChallenge returns the challenge and deletes it, so every
+// challenge can only be answered once.
+func TakeWebAuthnChallenge(db *sql.DB, id, ceremony string) (WebAuthnChallenge, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var challenge WebAuthnChallenge
+	query := "DELETE FROM webauthn_challenges WHERE id = ? AND ceremony = ? AND expires_at > ? RETURNING id, user_id, ceremony, challenge, expires_at"
+	err := db.QueryRowContext(ctx, query, id, ceremony, time.Now()).Scan(&challenge.ID, &challenge.UserID, &challenge.Ceremony, &challenge.Challenge, &challenge.ExpiresAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return WebAuthnChallenge{}, ErrWebAuthnChallengeNotFound
+		}
+		logger.ErrorLogger.Printf("Failed to get passkey challenge: %v", err)
+		return WebAuthnChallenge{}, fmt.Errorf("failed to get passkey challenge: %v", err)
+	}
+
+	return challenge, nil
+}
@@ -0,0 +1,260 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"forum/logger"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrPollClosed  = errors.New("the poll is closed")
+	ErrInvalidVote = errors.New("the vote does not match the poll's options")
+)
+
+// Poll is a post's poll. Votes, Voters and the options' Votes and Chosen are
+// filled in by GetPoll.
+type Poll struct {
+	PostID   string       `json:"post_id"`
+	Multiple bool         `json:"multiple"`
+	ClosesAt sql.NullTime `json:"-"`
+	Options  []PollOption `json:"options"`
+
+	// Voters is the number of users who voted, Voted whether the user the
+	// poll was loaded for is one of them.
+	Voters int  `json:"voters"`
+	Voted  bool `json:"-"`
+}
+
+type PollOption struct {
+	ID       string `json:"id"`
+	Text     string `json:"text"`
+	Position int    `json:"position"`
+	Votes    int    `json:"votes"`
+	Chosen   bool   `json:"-"`
+}
+
+// Closed reports whether voting has ended.
+func (p Poll) Closed() bool {
+	return p.ClosesAt.Valid && !time.Now().Before(p.ClosesAt.Time)
+}
+
+// ShowResults reports whether the counts can be shown: only to those who
+// have voted until the poll closes, so they don't sway the vote.
+func (p Poll) ShowResults() bool {
+	return p.Voted || p.Closed()
+}
+
+// PollVote is an option a user voted for, as exported with their data.
+type PollVote struct {
+	PostID    string
+	Option    string
+	CreatedAt time.Time
+}
+
+// insertPoll adds a post's poll, if it has one, as part of saving the post.
+func insertPoll(ctx context.Context, tx *sql.Tx, post Post) error {
+	if post.Poll == nil {
+		return nil
+	}
+
+	query := "INSERT INTO polls (post_id, multiple, closes_at) VALUES (?, ?, ?)"
+	if _, err := tx.ExecContext(ctx, query, post.ID, post.Poll.Multiple, post.Poll.ClosesAt); err != nil {
+		logger.ErrorLogger.Printf("Failed to save poll: %v", err)
+		return fmt.Errorf("failed to save poll: %v", err)
+	}
+
+	query = "INSERT INTO poll_options (id, post_id, text, position) VALUES (?, ?, ?, ?)"
+	for i, o := range post.Poll.Options {
+		if _, err := tx.ExecContext(ctx, query, uuid.New().String(), post.ID, o.Text, i); err != nil {
+			logger.ErrorLogger.Printf("Failed to save poll option: %v", err)
+			return fmt.Errorf("failed to save poll option: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// replacePoll replaces the poll of a draft, which has no votes yet.
+func replacePoll(ctx context.Context, tx *sql.Tx, post Post) error {
+	for _, statement := range []string{
+		"DELETE FROM poll_options WHERE post_id = ?",
+		"DELETE FROM polls WHERE post_id = ?",
+	} {
+		if _, err := tx.ExecContext(ctx, statement, post.ID); err != nil {
+			logger.ErrorLogger.Printf("Failed to remove poll: %v", err)
+			return fmt.Errorf("failed to remove poll: %v", err)
+		}
+	}
+
+	return insertPoll(ctx, tx, post)
+}
+
+// GetPoll returns the post's poll with its results, and whether it has one.
+// userID, which may be empty, is the user whose vote is marked.
+func GetPoll(db *sql.DB, postID, userID string) (Poll, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var poll Poll
+	query := `SELECT post_id, multiple, closes_at, (SELECT COUNT(*) FROM poll_votes v WHERE v.post_id = polls.post_id)
+		FROM polls WHERE post_id = ?`
+	err := db.QueryRowContext(ctx, query, postID).Scan(&poll.PostID, &poll.Multiple, &poll.ClosesAt, &poll.Voters)
+	if err == sql.ErrNoRows {
+		return Poll{}, false, nil
+	}
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to get poll: %v", err)
+		return Poll{}, false, fmt.Errorf("failed to get poll: %v", err)
+	}
+
+	query = `SELECT o.id, o.text, o.position,
+			(SELECT COUNT(*) FROM poll_vote_choices c WHERE c.option_id = o.id),
+			EXISTS (SELECT 1 FROM poll_vote_choices c JOIN poll_votes v ON v.id = c.vote_id WHERE c.option_id = o.id AND v.user_id = ?)
+		FROM poll_options o WHERE o.post_id = ? ORDER BY o.position`
+	rows, err := db.QueryContext(ctx, query, userID, postID)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to get poll options: %v", err)
+		return Poll{}, false, fmt.Errorf("failed to get poll options: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var o PollOption
+		if err := rows.Scan(&o.ID, &o.Text, &o.Position, &o.Votes, &o.Chosen); err != nil {
+			logger.ErrorLogger.Printf("Failed to scan poll option: %v", err)
+			return Poll{}, false, fmt.Errorf("failed to scan poll option: %v", err)
+		}
+		poll.Voted = poll.Voted || o.Chosen
+		poll.Options = append(poll.Options, o)
+	}
+
+	if err := rows.Err(); err != nil {
+		logger.ErrorLogger.Printf("Failed to get poll options: %v", err)
+		return Poll{}, false, fmt.Errorf("failed to get poll options: %v", err)
+	}
+
+	return poll, true, nil
+}
+
+// Vote records the user's vote, replacing any vote they made before. A single
+// choice poll takes exactly one option, a multiple choice poll one or more.
+func Vote(db *sql.DB, postID, userID string, optionIDs []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to begin vote transaction: %v", err)
+		return fmt.Errorf("failed to begin vote transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var multiple bool
+	var closesAt sql.NullTime
+	err = tx.QueryRowContext(ctx, "SELECT multiple, closes_at FROM polls WHERE post_id = ?", postID).Scan(&multiple, &closesAt)
+	if err == sql.ErrNoRows {
+		return ErrInvalidVote
+	}
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to get poll: %v", err)
+		return fmt.Errorf("failed to get poll: %v", err)
+	}
+	if closesAt.Valid && !time.Now().Before(closesAt.Time) {
+		return ErrPollClosed
+	}
+
+	chosen := make(map[string]bool)
+	for _, id := range optionIDs {
+		chosen[id] = true
+	}
+	if len(chosen) == 0 || (!multiple && len(chosen) > 1) {
+		return ErrInvalidVote
+	}
+
+	ids := make([]interface{}, 0, len(chosen)+1)
+	ids = append(ids, postID)
+	for id := range chosen {
+		ids = append(ids, id)
+	}
+	var valid int
+	query := "SELECT COUNT(*) FROM poll_options WHERE post_id = ? AND id IN (?" + strings.Repeat(", ?", len(chosen)-1) + ")"
+	if err := tx.QueryRowContext(ctx, query, ids...).Scan(&valid); err != nil {
+		logger.ErrorLogger.Printf("Failed to check poll options: %v", err)
+		return fmt.Errorf("failed to check poll options: %v", err)
+	}
+	if valid != len(chosen) {
+		return ErrInvalidVote
+	}
+
+	// The vote row is replaced by the unique constraint, its choices have to
+	// be removed here
+	query = "DELETE FROM poll_vote_choices WHERE vote_id IN (SELECT id FROM poll_votes WHERE post_id = ? AND user_id = ?)"
+	if _, err := tx.ExecContext(ctx, query, postID, userID); err != nil {
+		logger.ErrorLogger.Printf("Failed to remove previous vote: %v", err)
+		return fmt.Errorf("failed to remove previous vote: %v", err)
+	}
+
+	voteID := uuid.New().String()
+	query = "INSERT INTO poll_votes (id, post_id, user_id, created_at) VALUES (?, ?, ?, ?)"
+	if _, err := tx.ExecContext(ctx, query, voteID, postID, userID, time.Now()); err != nil {
+		logger.ErrorLogger.Printf("Failed to save vote: %v", err)
+		return fmt.Errorf("failed to save vote: %v", err)
+	}
+
+	query = "INSERT INTO poll_vote_choices (vote_id, option_id, post_id) VALUES (?, ?, ?)"
+	for id := range chosen {
+		if _, err := tx.ExecContext(ctx, query, voteID, id, postID); err != nil {
+			logger.ErrorLogger.Printf("Failed to save vote: %v", err)
+			return fmt.Errorf("failed to save vote: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		logger.ErrorLogger.Printf("Failed to commit vote: %v", err)
+		return fmt.Errorf("failed to commit vote: %v", err)
+	}
+
+	return nil
+}
+
+// GetPollVotesByUserID returns the options the user voted for, one entry per
+// option.
+func GetPollVotesByUserID(db *sql.DB, userID string) ([]PollVote, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT v.post_id, o.text, v.created_at FROM poll_votes v
+		JOIN poll_vote_choices c ON c.vote_id = v.id
+		JOIN poll_options o ON o.id = c.option_id
+		WHERE v.user_id = ? ORDER BY v.created_at, o.position`
+	rows, err := db.QueryContext(ctx, query, userID)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to get poll votes: %v", err)
+		return nil, fmt.Errorf("failed to get poll votes: %v", err)
+	}
+	defer rows.Close()
+
+	var votes []PollVote
+	for rows.Next() {
+		var v PollVote
+		if err := rows.Scan(&v.PostID, &v.Option, &v.CreatedAt); err != nil {
+			logger.ErrorLogger.Printf("Failed to scan poll vote: %v", err)
+			return nil, fmt.Errorf("failed to scan poll vote: %v", err)
+		}
+		votes = append(votes, v)
+	}
+
+	if err := rows.Err(); err != nil {
+		logger.ErrorLogger.Printf("Failed to get poll votes: %v", err)
+		return nil, fmt.Errorf("failed to get poll votes: %v", err)
+	}
+
+	return votes, nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"html/template"
 	"time"
 
 	"forum/logger"
@@ -22,25 +23,72 @@ type Post struct {
 	CommentsCount int
 	Likes         int
 	Dislikes      int
+	Status        string         `json:"status"`
+	ImageVariants []ImageVariant `json:"image_variants"`
+	Attachments   []Attachment   `json:"attachments"`
+	// PublishAt is when a scheduled draft will be published.
+	PublishAt sql.NullTime `json:"-"`
+	// Poll is set when the post has one.
+	Poll *Poll `json:"poll"`
+	// Tags are the names of the post's tags.
+	Tags []string `json:"tags"`
+	// Pinned is PinGlobal or PinCategory while a moderator keeps the post on
+	// top of the listings, since PinnedAt.
+	Pinned   string       `json:"pinned"`
+	PinnedAt sql.NullTime `json:"-"`
+	// LockedAt and ArchivedAt are set once the thread takes no new comments.
+	LockedAt   sql.NullTime `json:"-"`
+	ArchivedAt sql.NullTime `json:"-"`
+	// AcceptedCommentID is the comment accepted as the answer to a question.
+	AcceptedCommentID string `json:"accepted_comment_id"`
+	// ContentHTML is the rendered Markdown of Content, cached by
+	// SavePostContentHTML. It is stale unless ContentHTMLVersion is the
+	// renderer's current version.
+	ContentHTML        template.HTML `json:"-"`
+	ContentHTMLVersion int           `json:"-"`
 }
 
 func CreatePost(db *sql.DB, post Post) (string, error) {
 	context, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	query := "INSERT INTO posts (id, user_id, title, content, image_url, category, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)"
-	statement, err := db.PrepareContext(context, query)
+	tx, err := db.BeginTx(context, nil)
 	if err != nil {
-		logger.ErrorLogger.Printf("failed to prepare create post statement: %v", err)
-		return post.ID, fmt.Errorf("failed to prepare create post statement: %v", err)
+		logger.ErrorLogger.Printf("failed to begin create post transaction: %v", err)
+		return post.ID, fmt.Errorf("failed to begin create post transaction: %v", err)
 	}
+	defer tx.Rollback()
 
-	_, err = statement.ExecContext(context, &post.ID, &post.UserID, &post.Title, &post.Content, &post.ImageFullPath, &post.Category, &post.CreatedAt)
+	// Posts are published unless they are saved as a draft
+	status := post.Status
+	if status == "" {
+		status = StatusPublished
+	}
+
+	query := "INSERT INTO posts (id, user_id, title, content, image_url, category, created_at, status, publish_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	_, err = tx.ExecContext(context, query, &post.ID, &post.UserID, &post.Title, &post.Content, &post.ImageFullPath, &post.Category, &post.CreatedAt, status, post.PublishAt)
 	if err != nil {
 		logger.ErrorLogger.Printf("failed to create post: %v", err)
 		return post.ID, fmt.Errorf("failed to create post: %v", err)
 	}
 
+	if err := insertAttachments(context, tx, post); err != nil {
+		return post.ID, err
+	}
+
+	if err := insertPoll(context, tx, post); err != nil {
+		return post.ID, err
+	}
+
+	if err := insertTags(context, tx, post); err != nil {
+		return post.ID, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		logger.ErrorLogger.Printf("failed to commit post: %v", err)
+		return post.ID, fmt.Errorf("failed to commit post: %v", err)
+	}
+
 	return post.ID, nil
 }
 
@@ -48,7 +96,7 @@ func GetAllPosts(db *sql.DB) ([]Post, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	query := "SELECT id, user_id, title, content, image_url, category, created_at FROM posts ORDER BY created_at DESC"
+	query := "SELECT id, user_id, title, content, image_url, category, created_at, pinned, locked_at, archived_at, accepted_comment_id, likes_count, dislikes_count, comments_count FROM posts WHERE status = 'published' ORDER BY " + pinOrder(false) + ", created_at DESC"
 	rows, err := db.QueryContext(ctx, query)
 	if err != nil {
 		logger.ErrorLogger.Printf("failed to execute get all posts query: %v", err)
@@ -59,7 +107,7 @@ func GetAllPosts(db *sql.DB) ([]Post, error) {
 	var posts []Post
 	for rows.Next() {
 		var post Post
-		err := rows.Scan(&post.ID, &post.UserID, &post.Title, &post.Content, &post.ImageFullPath, &post.Category, &post.CreatedAt)
+		err := rows.Scan(&post.ID, &post.UserID, &post.Title, &post.Content, &post.ImageFullPath, &post.Category, &post.CreatedAt, &post.Pinned, &post.LockedAt, &post.ArchivedAt, &post.AcceptedCommentID, &post.Likes, &post.Dislikes, &post.CommentsCount)
 		if err != nil {
 			logger.ErrorLogger.Printf("failed to scan posts row: %v", err)
 			return nil, fmt.Errorf("failed to scan posts row: %v", err)
@@ -82,12 +130,14 @@ func GetPostByID(db *sql.DB, id string) (Post, error) {
 	var post Post
 
 	query := `
-        SELECT id, user_id, title, content, image_url, category, created_at
+        SELECT id, user_id, title, content, image_url, category, created_at, status, content_html, content_html_version, publish_at,
+            pinned, pinned_at, locked_at, archived_at, accepted_comment_id, likes_count, dislikes_count, comments_count
         FROM posts
         WHERE id = ?
         LIMIT 1
         `
-	err := db.QueryRowContext(ctx, query, id).Scan(&post.ID, &post.UserID, &post.Title, &post.Content, &post.ImageFullPath, &post.Category, &post.CreatedAt)
+	err := db.QueryRowContext(ctx, query, id).Scan(&post.ID, &post.UserID, &post.Title, &post.Content, &post.ImageFullPath, &post.Category, &post.CreatedAt, &post.Status, &post.ContentHTML, &post.ContentHTMLVersion, &post.PublishAt,
+		&post.Pinned, &post.PinnedAt, &post.LockedAt, &post.ArchivedAt, &post.AcceptedCommentID, &post.Likes, &post.Dislikes, &post.CommentsCount)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			logger.ErrorLogger.Printf("no post found with ID %s", id)
@@ -100,11 +150,27 @@ func GetPostByID(db *sql.DB, id string) (Post, error) {
 	return post, nil
 }
 
+// SavePostContentHTML caches the rendered Markdown of a post. It is only
+// stored if the content is still what was rendered, so an edit made meanwhile
+// isn't covered by the old HTML.
+func SavePostContentHTML(db *sql.DB, id, content string, html template.HTML, version int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "UPDATE posts SET content_html = ?, content_html_version = ? WHERE id = ? AND content = ?"
+	if _, err := db.ExecContext(ctx, query, string(html), version, id, content); err != nil {
+		logger.ErrorLogger.Printf("Failed to save post HTML: %v", err)
+		return fmt.Errorf("failed to save post HTML: %v", err)
+	}
+
+	return nil
+}
+
 func GetAllPostsByUserID(db *sql.DB, userID string) ([]Post, error) {
 	context, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	query := "SELECT id, user_id, title, content, image_url, category, created_at FROM posts WHERE user_id=? ORDER BY created_at DESC"
+	query := "SELECT id, user_id, title, content, image_url, category, created_at, status FROM posts WHERE user_id=? ORDER BY created_at DESC"
 
 	logger.InfoLogger.Printf("GetAllPostsByUserID query: %v", query)
 
@@ -118,7 +184,7 @@ func GetAllPostsByUserID(db *sql.DB, userID string) ([]Post, error) {
 	var posts []Post
 	for rows.Next() {
 		var post Post
-		err := rows.Scan(&post.ID, &post.UserID, &post.Title, &post.Content, &post.ImageFullPath, &post.Category, &post.CreatedAt)
+		err := rows.Scan(&post.ID, &post.UserID, &post.Title, &post.Content, &post.ImageFullPath, &post.Category, &post.CreatedAt, &post.Status)
 		if err != nil {
 			logger.ErrorLogger.Printf("Failed to scan posts row: %v", err)
 			return nil, fmt.Errorf("failed to scan posts row: %v", err)
@@ -3,12 +3,15 @@ package models
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"time"
 
 	"forum/logger"
 )
 
+var ErrReactionTarget = errors.New("nothing to react to")
+
 type PostReaction struct {
 	ID           string    `json:"id"`
 	UserID       string    `json:"user_id"`
@@ -26,106 +29,236 @@ type CommentReaction struct {
 	CreatedAt    time.Time `json:"created_at"`
 }
 
-func CreatePostReaction(db *sql.DB, reaction PostReaction) (string, error) {
+// Reaction types of post and comment reactions.
+const (
+	ReactionLike    = "like"
+	ReactionDislike = "dislike"
+)
+
+// TogglePostReaction leaves the like or dislike on a published post, in place
+// of the user's other one, or takes it back if the user had already left it.
+// It returns the user's reaction now, empty if there is none. The post's
+// counters follow in the same transaction.
+func TogglePostReaction(db *sql.DB, reaction PostReaction) (string, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	query := "INSERT INTO post_reactions (id, user_id, post_id, reaction_type, created_at) VALUES (?, ?, ?, ?, ?)"
-	statement, err := db.PrepareContext(ctx, query)
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
-		logger.ErrorLogger.Printf("Failed to prepare create post reaction statement: %v\n", err)
-		return reaction.ID, fmt.Errorf("failed to prepare create post reaction statement: %v", err)
+		logger.ErrorLogger.Printf("Failed to begin post reaction transaction: %v\n", err)
+		return "", fmt.Errorf("failed to begin post reaction transaction: %v", err)
 	}
+	defer tx.Rollback()
 
-	_, err = statement.ExecContext(ctx, &reaction.ID, &reaction.UserID, &reaction.PostID, &reaction.ReactionType, &reaction.CreatedAt)
+	if err := checkReactionTarget(ctx, tx, reaction.PostID, ""); err != nil {
+		return "", err
+	}
+
+	var current string
+	query := "SELECT reaction_type FROM post_reactions WHERE user_id = ? AND post_id = ?"
+	err = tx.QueryRowContext(ctx, query, reaction.UserID, reaction.PostID).Scan(&current)
+	if err != nil && err != sql.ErrNoRows {
+		logger.ErrorLogger.Printf("Failed to get post reaction: %v\n", err)
+		return "", fmt.Errorf("failed to get post reaction: %v", err)
+	}
+
+	result := reaction.ReactionType
+	switch {
+	case err == sql.ErrNoRows:
+		query = "INSERT INTO post_reactions (id, user_id, post_id, reaction_type, created_at) VALUES (?, ?, ?, ?, ?)"
+		_, err = tx.ExecContext(ctx, query, reaction.ID, reaction.UserID, reaction.PostID, reaction.ReactionType, reaction.CreatedAt)
+	case current == reaction.ReactionType:
+		result = ""
+		query = "DELETE FROM post_reactions WHERE user_id = ? AND post_id = ?"
+		_, err = tx.ExecContext(ctx, query, reaction.UserID, reaction.PostID)
+	default:
+		query = "UPDATE post_reactions SET reaction_type = ?, created_at = ? WHERE user_id = ? AND post_id = ?"
+		_, err = tx.ExecContext(ctx, query, reaction.ReactionType, reaction.CreatedAt, reaction.UserID, reaction.PostID)
+	}
 	if err != nil {
-		logger.ErrorLogger.Printf("Failed to create post reaction: %v\n", err)
-		return reaction.ID, fmt.Errorf("failed to create post reaction: %v", err)
+		logger.ErrorLogger.Printf("Failed to toggle post reaction: %v\n", err)
+		return "", fmt.Errorf("failed to toggle post reaction: %v", err)
 	}
 
-	return reaction.ID, nil
+	if err := tx.Commit(); err != nil {
+		logger.ErrorLogger.Printf("Failed to commit post reaction: %v\n", err)
+		return "", fmt.Errorf("failed to commit post reaction: %v", err)
+	}
+
+	return result, nil
 }
 
-func PostLikeCountByPostID(db *sql.DB, postID string) (int, error) {
+// ToggleCommentReaction is TogglePostReaction for a published comment on the
+// post.
+func ToggleCommentReaction(db *sql.DB, reaction CommentReaction) (string, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	var count int
-
-	query := `SELECT COUNT(*) FROM post_reactions WHERE post_id = ? AND reaction_type = 'like'`
-	err := db.QueryRowContext(ctx, query, postID).Scan(&count)
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
-		logger.ErrorLogger.Printf("Failed to get like count: %v\n", err)
-		return 0, fmt.Errorf("failed to get like count: %v", err)
+		logger.ErrorLogger.Printf("Failed to begin comment reaction transaction: %v", err)
+		return "", fmt.Errorf("failed to begin comment reaction transaction: %v", err)
 	}
+	defer tx.Rollback()
 
-	return count, nil
-}
-
-func PostDislikeCountByPostID(db *sql.DB, postID string) (int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	if err := checkReactionTarget(ctx, tx, reaction.PostID, reaction.CommentID); err != nil {
+		return "", err
+	}
 
-	var count int
+	var current string
+	query := "SELECT reaction_type FROM comment_reactions WHERE user_id = ? AND post_id = ? AND comment_id = ?"
+	err = tx.QueryRowContext(ctx, query, reaction.UserID, reaction.PostID, reaction.CommentID).Scan(&current)
+	if err != nil && err != sql.ErrNoRows {
+		logger.ErrorLogger.Printf("Failed to get comment reaction: %v", err)
+		return "", fmt.Errorf("failed to get comment reaction: %v", err)
+	}
 
-	query := `SELECT COUNT(*) FROM post_reactions WHERE post_id = ? AND reaction_type = 'dislike'`
-	err := db.QueryRowContext(ctx, query, postID).Scan(&count)
+	result := reaction.ReactionType
+	switch {
+	case err == sql.ErrNoRows:
+		query = "INSERT INTO comment_reactions (id, user_id, post_id, comment_id, reaction_type, created_at) VALUES (?, ?, ?, ?, ?, ?)"
+		_, err = tx.ExecContext(ctx, query, reaction.ID, reaction.UserID, reaction.PostID, reaction.CommentID, reaction.ReactionType, reaction.CreatedAt)
+	case current == reaction.ReactionType:
+		result = ""
+		query = "DELETE FROM comment_reactions WHERE user_id = ? AND post_id = ? AND comment_id = ?"
+		_, err = tx.ExecContext(ctx, query, reaction.UserID, reaction.PostID, reaction.CommentID)
+	default:
+		query = "UPDATE comment_reactions SET reaction_type = ?, created_at = ? WHERE user_id = ? AND post_id = ? AND comment_id = ?"
+		_, err = tx.ExecContext(ctx, query, reaction.ReactionType, reaction.CreatedAt, reaction.UserID, reaction.PostID, reaction.CommentID)
+	}
 	if err != nil {
-		logger.ErrorLogger.Printf("Failed to get like count: %v", err)
-		return 0, fmt.Errorf("failed to get like count: %v", err)
+		logger.ErrorLogger.Printf("Failed to toggle comment reaction: %v", err)
+		return "", fmt.Errorf("failed to toggle comment reaction: %v", err)
 	}
 
-	return count, nil
+	if err := tx.Commit(); err != nil {
+		logger.ErrorLogger.Printf("Failed to commit comment reaction: %v", err)
+		return "", fmt.Errorf("failed to commit comment reaction: %v", err)
+	}
+
+	return result, nil
 }
 
-func CreateCommentReaction(db *sql.DB, reaction CommentReaction) (string, error) {
-	context, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// checkReactionTarget returns ErrReactionTarget unless the post, or the
+// comment on it when commentID is set, is published.
+func checkReactionTarget(ctx context.Context, tx *sql.Tx, postID, commentID string) error {
+	var exists bool
+	query := "SELECT EXISTS (SELECT 1 FROM posts WHERE id = ? AND status = 'published')"
+	args := []interface{}{postID}
+	if commentID != "" {
+		query = "SELECT EXISTS (SELECT 1 FROM comments WHERE id = ? AND post_id = ? AND status = 'published')"
+		args = []interface{}{commentID, postID}
+	}
+	if err := tx.QueryRowContext(ctx, query, args...).Scan(&exists); err != nil {
+		logger.ErrorLogger.Printf("Failed to check reaction: %v", err)
+		return fmt.Errorf("failed to check reaction: %v", err)
+	}
+	if !exists {
+		return ErrReactionTarget
+	}
+	return nil
+}
+
+// GetUserReactionsOnPost returns the user's like or dislike on the post, empty
+// if there is none, and those on its comments keyed by comment ID.
+func GetUserReactionsOnPost(db *sql.DB, postID, userID string) (string, map[string]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	query := "INSERT INTO comment_reactions (id, user_id, post_id, comment_id, reaction_type, created_at) VALUES (?, ?, ?, ?, ?, ?)"
-	statement, err := db.PrepareContext(context, query)
-	if err != nil {
-		logger.ErrorLogger.Printf("Failed to prepare create comment reaction statement: %v", err)
-		return reaction.ID, fmt.Errorf("failed to prepare create comment reaction statement: %v", err)
+	var postReaction string
+	query := "SELECT reaction_type FROM post_reactions WHERE post_id = ? AND user_id = ?"
+	err := db.QueryRowContext(ctx, query, postID, userID).Scan(&postReaction)
+	if err != nil && err != sql.ErrNoRows {
+		logger.ErrorLogger.Printf("Failed to get post reaction: %v\n", err)
+		return "", nil, fmt.Errorf("failed to get post reaction: %v", err)
 	}
 
-	_, err = statement.ExecContext(context, &reaction.ID, &reaction.UserID, &reaction.PostID, &reaction.CommentID, &reaction.ReactionType, &reaction.CreatedAt)
+	query = "SELECT comment_id, reaction_type FROM comment_reactions WHERE post_id = ? AND user_id = ?"
+	rows, err := db.QueryContext(ctx, query, postID, userID)
 	if err != nil {
-		logger.ErrorLogger.Printf("Failed to create comment reaction: %v", err)
-		return reaction.ID, fmt.Errorf("failed to create comment reaction: %v", err)
+		logger.ErrorLogger.Printf("Failed to get comment reactions: %v\n", err)
+		return "", nil, fmt.Errorf("failed to get comment reactions: %v", err)
+	}
+	defer rows.Close()
+
+	commentReactions := make(map[string]string)
+	for rows.Next() {
+		var commentID, reactionType string
+		if err := rows.Scan(&commentID, &reactionType); err != nil {
+			logger.ErrorLogger.Printf("Failed to scan comment reaction: %v\n", err)
+			return "", nil, fmt.Errorf("failed to scan comment reaction: %v", err)
+		}
+		commentReactions[commentID] = reactionType
+	}
+
+	if err := rows.Err(); err != nil {
+		logger.ErrorLogger.Printf("Failed to get comment reactions: %v\n", err)
+		return "", nil, fmt.Errorf("failed to get comment reactions: %v", err)
 	}
 
-	return reaction.ID, nil
+	return postReaction, commentReactions, nil
 }
 
-func CommentLikeCountByCommentID(db *sql.DB, commentID string) (int, error) {
+// GetPostReactionsByUserID returns every post reaction the user has made,
+// newest first.
+func GetPostReactionsByUserID(db *sql.DB, userID string) ([]PostReaction, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	var count int
-
-	query := `SELECT COUNT(*) FROM comment_reactions WHERE comment_id = ? AND reaction_type = 'like'`
-	err := db.QueryRowContext(ctx, query, commentID).Scan(&count)
+	query := "SELECT id, user_id, post_id, reaction_type, created_at FROM post_reactions WHERE user_id = ? ORDER BY created_at DESC"
+	rows, err := db.QueryContext(ctx, query, userID)
 	if err != nil {
-		logger.ErrorLogger.Printf("failed to get like count: %v", err)
-		return 0, fmt.Errorf("failed to get like count: %v", err)
+		logger.ErrorLogger.Printf("Failed to get post reactions: %v\n", err)
+		return nil, fmt.Errorf("failed to get post reactions: %v", err)
+	}
+	defer rows.Close()
+
+	var reactions []PostReaction
+	for rows.Next() {
+		var r PostReaction
+		if err := rows.Scan(&r.ID, &r.UserID, &r.PostID, &r.ReactionType, &r.CreatedAt); err != nil {
+			logger.ErrorLogger.Printf("Failed to scan post reaction: %v\n", err)
+			return nil, fmt.Errorf("failed to scan post reaction: %v", err)
+		}
+		reactions = append(reactions, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		logger.ErrorLogger.Printf("Failed to get post reactions: %v\n", err)
+		return nil, fmt.Errorf("failed to get post reactions: %v", err)
 	}
 
-	return count, nil
+	return reactions, nil
 }
 
-func CommentDislikeCountByCommentID(db *sql.DB, commentID string) (int, error) {
+// GetCommentReactionsByUserID returns every comment reaction the user has
+// made, newest first.
+func GetCommentReactionsByUserID(db *sql.DB, userID string) ([]CommentReaction, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	var count int
-
-	query := `SELECT COUNT(*) FROM comment_reactions WHERE comment_id = ? AND reaction_type = 'dislike'`
-	err := db.QueryRowContext(ctx, query, commentID).Scan(&count)
+	query := "SELECT id, user_id, post_id, comment_id, reaction_type, created_at FROM comment_reactions WHERE user_id = ? ORDER BY created_at DESC"
+	rows, err := db.QueryContext(ctx, query, userID)
 	if err != nil {
-		logger.ErrorLogger.Printf("failed to get dislike count: %v", err)
-		return 0, fmt.Errorf("failed to get dislike count: %v", err)
+		logger.ErrorLogger.Printf("Failed to get comment reactions: %v\n", err)
+		return nil, fmt.Errorf("failed to get comment reactions: %v", err)
+	}
+	defer rows.Close()
+
+	var reactions []CommentReaction
+	for rows.Next() {
+		var r CommentReaction
+		if err := rows.Scan(&r.ID, &r.UserID, &r.PostID, &r.CommentID, &r.ReactionType, &r.CreatedAt); err != nil {
+			logger.ErrorLogger.Printf("Failed to scan comment reaction: %v\n", err)
+			return nil, fmt.Errorf("failed to scan comment reaction: %v", err)
+		}
+		reactions = append(reactions, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		logger.ErrorLogger.Printf("Failed to get comment reactions: %v\n", err)
+		return nil, fmt.Errorf("failed to get comment reactions: %v", err)
 	}
 
-	return count, nil
+	return reactions, nil
 }
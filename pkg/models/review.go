@@ -0,0 +1,286 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"forum/logger"
+
+	"github.com/google/uuid"
+)
+
+// Posts and comments are published straight away unless the spam checks hold
+// them for review. Posts can also be kept as drafts, which only their author
+// sees, until they are published by hand or at their publish_at time.
+const (
+	StatusPublished = "published"
+	StatusPending   = "pending"
+	StatusDraft     = "draft"
+)
+
+const (
+	ReviewPost    = "post"
+	ReviewComment = "comment"
+)
+
+// ReviewItem is a post or comment waiting in the moderation queue.
+type ReviewItem struct {
+	ID        string    `json:"id"`
+	Kind      string    `json:"kind"`
+	ItemID    string    `json:"item_id"`
+	PostID    string    `json:"post_id"`
+	UserID    string    `json:"user_id"`
+	UserName  string    `json:"user_name"`
+	Title     string    `json:"title"`
+	Content   string    `json:"content"`
+	Score     int       `json:"score"`
+	Reasons   []string  `json:"reasons"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// RecentContent is a post or comment used to spot duplicates and bursts of
+// posting.
+type RecentContent struct {
+	UserID    string
+	Text      string
+	CreatedAt time.Time
+}
+
+// GetRecentContent returns the posts and comments, published or held, created
+// since the given time, newest first. Drafts are left out: a draft being
+// published would otherwise count as a duplicate of itself.
+func GetRecentContent(db *sql.DB, since time.Time, limit int) ([]RecentContent, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		SELECT user_id, title || char(10) || content, created_at FROM posts WHERE created_at >= ? AND status != 'draft'
+		UNION ALL
+		SELECT user_id, content, created_at FROM comments WHERE created_at >= ?
+		ORDER BY 3 DESC
+		LIMIT ?`
+	rows, err := db.QueryContext(ctx, query, since, since, limit)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to get recent content: %v", err)
+		return nil, fmt.Errorf("failed to get recent content: %v", err)
+	}
+	defer rows.Close()
+
+	var recent []RecentContent
+	for rows.Next() {
+		var c RecentContent
+		if err := rows.Scan(&c.UserID, &c.Text, &c.CreatedAt); err != nil {
+			logger.ErrorLogger.Printf("Failed to scan recent content: %v", err)
+			return nil, fmt.Errorf("failed to scan recent content: %v", err)
+		}
+		recent = append(recent, c)
+	}
+
+	if err := rows.Err(); err != nil {
+		logger.ErrorLogger.Printf("Failed to get recent content: %v", err)
+		return nil, fmt.Errorf("failed to get recent content: %v", err)
+	}
+
+	return recent, nil
+}
+
+// HoldPost stores a post as pending and adds it to the moderation queue.
+func HoldPost(db *sql.DB, post Post, score int, reasons []string) error {
+	return hold(db, ReviewPost, post.ID, post.UserID, score, reasons, func(ctx context.Context, tx *sql.Tx) error {
+		query := "INSERT INTO posts (id, user_id, title, content, image_url, category, created_at, status) VALUES (?, ?, ?, ?, ?, ?, ?, ?)"
+		if _, err := tx.ExecContext(ctx, query, post.ID, post.UserID, post.Title, post.Content, post.ImageFullPath, post.Category, post.CreatedAt, StatusPending); err != nil {
+			return err
+		}
+		if err := insertAttachments(ctx, tx, post); err != nil {
+			return err
+		}
+		if err := insertPoll(ctx, tx, post); err != nil {
+			return err
+		}
+		return insertTags(ctx, tx, post)
+	})
+}
+
+// HoldComment stores a comment as pending and adds it to the moderation queue.
+func HoldComment(db *sql.DB, comment Comment, score int, reasons []string) error {
+	return hold(db, ReviewComment, comment.ID, comment.UserID, score, reasons, func(ctx context.Context, tx *sql.Tx) error {
+		query := "INSERT INTO comments (id, user_id, post_id, content, created_at, status) VALUES (?, ?, ?, ?, ?, ?)"
+		_, err := tx.ExecContext(ctx, query, comment.ID, comment.UserID, comment.PostID, comment.Content, comment.CreatedAt, StatusPending)
+		return err
+	})
+}
+
+// HoldDraft sends a draft that is being published to the moderation queue
+// instead, as of publishedAt.
+func HoldDraft(db *sql.DB, post Post, publishedAt time.Time, score int, reasons []string) error {
+	return hold(db, ReviewPost, post.ID, post.UserID, score, reasons, func(ctx context.Context, tx *sql.Tx) error {
+		query := "UPDATE posts SET status = ?, publish_at = NULL, created_at = ? WHERE id = ? AND status = 'draft'"
+		result, err := tx.ExecContext(ctx, query, StatusPending, publishedAt, post.ID)
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err != nil || n == 0 {
+			return ErrNotDraft
+		}
+		return nil
+	})
+}
+
+// hold runs insert, which stores the item as pending, and queues the item in
+// the same transaction.
+func hold(db *sql.DB, kind, itemID, userID string, score int, reasons []string, insert func(ctx context.Context, tx *sql.Tx) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to begin review transaction: %v", err)
+		return fmt.Errorf("failed to begin review transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := insert(ctx, tx); err != nil {
+		logger.ErrorLogger.Printf("Failed to create pending %s: %v", kind, err)
+		return fmt.Errorf("failed to create pending %s: %v", kind, err)
+	}
+
+	query := "INSERT INTO review_queue (id, kind, item_id, user_id, score, reasons, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)"
+	if _, err := tx.ExecContext(ctx, query, uuid.New().String(), kind, itemID, userID, score, strings.Join(reasons, "\n"), time.Now()); err != nil {
+		logger.ErrorLogger.Printf("Failed to queue %s for review: %v", kind, err)
+		return fmt.Errorf("failed to queue %s for review: %v", kind, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		logger.ErrorLogger.Printf("Failed to commit review: %v", err)
+		return fmt.Errorf("failed to commit review: %v", err)
+	}
+
+	return nil
+}
+
+const reviewColumns = `q.id, q.kind, q.item_id, q.user_id, COALESCE(u.name, ''), q.score, q.reasons, q.created_at,
+		COALESCE(p.title, cp.title, ''), COALESCE(p.content, c.content, ''), COALESCE(p.id, c.post_id, '')
+	FROM review_queue q
+	LEFT JOIN users u ON u.id = q.user_id
+	LEFT JOIN posts p ON q.kind = 'post' AND p.id = q.item_id
+	LEFT JOIN comments c ON q.kind = 'comment' AND c.id = q.item_id
+	LEFT JOIN posts cp ON cp.id = c.post_id`
+
+func scanReviewItem(row interface{ Scan(...interface{}) error }) (ReviewItem, error) {
+	var item ReviewItem
+	var reasons string
+	err := row.Scan(&item.ID, &item.Kind, &item.ItemID, &item.UserID, &item.UserName, &item.Score, &reasons, &item.CreatedAt, &item.Title, &item.Content, &item.PostID)
+	if reasons != "" {
+		item.Reasons = strings.Split(reasons, "\n")
+	}
+	return item, err
+}
+
+// GetReviewQueue returns the content waiting for review, oldest first.
+func GetReviewQueue(db *sql.DB) ([]ReviewItem, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, "SELECT "+reviewColumns+" ORDER BY q.created_at")
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to get review queue: %v", err)
+		return nil, fmt.Errorf("failed to get review queue: %v", err)
+	}
+	defer rows.Close()
+
+	var items []ReviewItem
+	for rows.Next() {
+		item, err := scanReviewItem(rows)
+		if err != nil {
+			logger.ErrorLogger.Printf("Failed to scan review item: %v", err)
+			return nil, fmt.Errorf("failed to scan review item: %v", err)
+		}
+		items = append(items, item)
+	}
+
+	if err := rows.Err(); err != nil {
+		logger.ErrorLogger.Printf("Failed to get review queue: %v", err)
+		return nil, fmt.Errorf("failed to get review queue: %v", err)
+	}
+
+	return items, nil
+}
+
+func GetReviewItemByID(db *sql.DB, id string) (ReviewItem, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	item, err := scanReviewItem(db.QueryRowContext(ctx, "SELECT "+reviewColumns+" WHERE q.id = ?", id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ReviewItem{}, fmt.Errorf("no review item found with ID %s", id)
+		}
+		logger.ErrorLogger.Printf("Failed to get review item: %v", err)
+		return ReviewItem{}, fmt.Errorf("failed to get review item: %v", err)
+	}
+
+	return item, nil
+}
+
+// ApproveReviewItem publishes the post or comment and removes it from the
+// queue.
+func ApproveReviewItem(db *sql.DB, item ReviewItem) error {
+	if item.Kind == ReviewComment {
+		return resolveReviewItem(db, item, "UPDATE comments SET status = 'published' WHERE id = ?")
+	}
+	return resolveReviewItem(db, item, "UPDATE posts SET status = 'published' WHERE id = ?")
+}
+
+// RejectReviewItem deletes the post or comment and removes it from the queue.
+// A rejected post takes its comments and reactions with it.
+func RejectReviewItem(db *sql.DB, item ReviewItem) error {
+	if item.Kind == ReviewComment {
+		return resolveReviewItem(db, item,
+			"DELETE FROM comment_reactions WHERE comment_id = ?",
+			"DELETE FROM comments WHERE id = ?",
+		)
+	}
+	return resolveReviewItem(db, item,
+		"DELETE FROM review_queue WHERE kind = 'comment' AND item_id IN (SELECT id FROM comments WHERE post_id = ?)",
+		"DELETE FROM comment_reactions WHERE comment_id IN (SELECT id FROM comments WHERE post_id = ?)",
+		"DELETE FROM comments WHERE post_id = ?",
+		"DELETE FROM post_reactions WHERE post_id = ?",
+		"DELETE FROM posts WHERE id = ?",
+	)
+}
+
+// resolveReviewItem runs the statements, each taking the item's ID, and
+// removes the item from the queue.
+func resolveReviewItem(db *sql.DB, item ReviewItem, statements ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to begin review transaction: %v", err)
+		return fmt.Errorf("failed to begin review transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement, item.ItemID); err != nil {
+			logger.ErrorLogger.Printf("Failed to resolve review of %s %s: %v", item.Kind, item.ItemID, err)
+			return fmt.Errorf("failed to resolve review of %s %s: %v", item.Kind, item.ItemID, err)
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM review_queue WHERE id = ?", item.ID); err != nil {
+		logger.ErrorLogger.Printf("Failed to remove review item: %v", err)
+		return fmt.Errorf("failed to remove review item: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		logger.ErrorLogger.Printf("Failed to commit review: %v", err)
+		return fmt.Errorf("failed to commit review: %v", err)
+	}
+
+	return nil
+}
@@ -13,9 +13,12 @@ func GetAllBySearchKey(db *sql.DB, searchKey string) ([]Post, error) {
 	context, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	query := "SELECT id, user_id, title, content, category, created_at FROM posts WHERE title LIKE '%' || ? || '%' OR content LIKE '%' || ? || '%' OR category LIKE '%' || ? || '%' ORDER BY created_at DESC LIMIT 15"
+	// A search for a tag's name or synonym also finds the posts with the tag
+	tag := []string{NormalizeTag(searchKey)}
+	query := "SELECT id, user_id, title, content, category, created_at, likes_count, dislikes_count, comments_count FROM posts WHERE status = 'published' AND (title LIKE '%' || ? || '%' OR content LIKE '%' || ? || '%' OR category LIKE '%' || ? || '%' OR id IN (SELECT post_id FROM post_tags WHERE tag_id IN (" + tagIDs(tag) + "))) ORDER BY created_at DESC LIMIT 15"
 
-	rows, err := db.QueryContext(context, query, searchKey, searchKey, searchKey)
+	args := append([]interface{}{searchKey, searchKey, searchKey}, tagIDArgs(tag)...)
+	rows, err := db.QueryContext(context, query, args...)
 	if err != nil {
 		logger.ErrorLogger.Printf("failed to execute get all posts query: %v", err)
 		return nil, fmt.Errorf("failed to execute get all posts query: %v", err)
@@ -25,7 +28,7 @@ func GetAllBySearchKey(db *sql.DB, searchKey string) ([]Post, error) {
 	var posts []Post
 	for rows.Next() {
 		var post Post
-		err := rows.Scan(&post.ID, &post.UserID, &post.Title, &post.Content, &post.Category, &post.CreatedAt)
+		err := rows.Scan(&post.ID, &post.UserID, &post.Title, &post.Content, &post.Category, &post.CreatedAt, &post.Likes, &post.Dislikes, &post.CommentsCount)
 		if err != nil {
 			logger.ErrorLogger.Printf("failed to scan posts row: %v", err)
 			return nil, fmt.Errorf("failed to scan posts row: %v", err)
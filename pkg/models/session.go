@@ -88,3 +88,29 @@ func DeleteSession(db *sql.DB, id string) error {
 
 	return nil
 }
+
+// GetSessionsByUserID returns the user's sessions, newest first.
+func GetSessionsByUserID(db *sql.DB, userID string) ([]Session, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "SELECT id, user_id, created_at, expires_at FROM sessions WHERE user_id = ? ORDER BY created_at DESC"
+	rows, err := db.QueryContext(ctx, query, userID)
+	if err != nil {
+		logger.ErrorLogger.Printf("failed to get sessions: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []Session
+	for rows.Next() {
+		var session Session
+		if err := rows.Scan(&session.ID, &session.UserID, &session.CreatedAt, &session.ExpiresAt); err != nil {
+			logger.ErrorLogger.Printf("failed to scan session: %v", err)
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+
+	return sessions, rows.Err()
+}
@@ -0,0 +1,81 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"forum/logger"
+)
+
+// Keys for site wide settings that admins can change at runtime.
+const (
+	SettingRequire2FARoles = "require_2fa_roles"
+	SettingDeletedContent  = "deleted_content"
+	// SettingArchiveDays is followed by the name of a category.
+	SettingArchiveDays  = "archive_days:"
+	SettingQACategories = "qa_categories"
+)
+
+// GetSetting returns the stored value for key, or fallback if it was never set.
+func GetSetting(db *sql.DB, key, fallback string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var value string
+	err := db.QueryRowContext(ctx, "SELECT value FROM settings WHERE key = ?", key).Scan(&value)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fallback, nil
+		}
+		logger.ErrorLogger.Printf("Failed to get setting %s: %v", key, err)
+		return fallback, fmt.Errorf("failed to get setting %s: %v", key, err)
+	}
+
+	return value, nil
+}
+
+func SetSetting(db *sql.DB, key, value string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `INSERT INTO settings (key, value, updated_at) VALUES (?, ?, ?)
+		ON CONFLICT(key) DO UPDATE SET value = excluded.value, updated_at = excluded.updated_at`
+	if _, err := db.ExecContext(ctx, query, key, value, time.Now()); err != nil {
+		logger.ErrorLogger.Printf("Failed to set setting %s: %v", key, err)
+		return fmt.Errorf("failed to set setting %s: %v", key, err)
+	}
+
+	return nil
+}
+
+// GetRolesRequiring2FA returns the roles that must have two-factor
+// authentication enabled before they can use the site.
+func GetRolesRequiring2FA(db *sql.DB) (map[string]bool, error) {
+	value, err := GetSetting(db, SettingRequire2FARoles, "")
+	if err != nil {
+		return nil, err
+	}
+
+	roles := make(map[string]bool)
+	for _, role := range strings.Split(value, ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			roles[role] = true
+		}
+	}
+
+	return roles, nil
+}
+
+func SetRolesRequiring2FA(db *sql.DB, roles []string) error {
+	var valid []string
+	for _, role := range roles {
+		if IsValidRole(role) {
+			valid = append(valid, role)
+		}
+	}
+
+	return SetSetting(db, SettingRequire2FARoles, strings.Join(valid, ","))
+}
@@ -2,12 +2,58 @@ package sqlite
 
 import (
 	"database/sql"
+	"fmt"
 	"log"
 	"os"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
+// columnMigrations adds columns that were introduced after a table was first
+// created. schema.sql only creates missing tables, so existing databases need
+// the new columns added here. New databases already get them from schema.sql.
+var columnMigrations = []struct {
+	table      string
+	column     string
+	definition string
+}{
+	{"users", "role", "TEXT NOT NULL DEFAULT 'user'"},
+	{"users", "totp_secret", "TEXT NOT NULL DEFAULT ''"},
+	{"users", "totp_enabled", "BOOLEAN NOT NULL DEFAULT 0"},
+	{"users", "totp_last_step", "INTEGER NOT NULL DEFAULT 0"},
+	{"posts", "status", "TEXT NOT NULL DEFAULT 'published'"},
+	{"comments", "status", "TEXT NOT NULL DEFAULT 'published'"},
+	{"users", "upload_quota", "INTEGER NOT NULL DEFAULT 0"},
+	{"users", "deletion_requested_at", "DATETIME"},
+	{"users", "deleted_at", "DATETIME"},
+	{"posts", "content_html", "TEXT NOT NULL DEFAULT ''"},
+	{"posts", "content_html_version", "INTEGER NOT NULL DEFAULT 0"},
+	{"comments", "content_html", "TEXT NOT NULL DEFAULT ''"},
+	{"comments", "content_html_version", "INTEGER NOT NULL DEFAULT 0"},
+	{"posts", "publish_at", "DATETIME"},
+	{"posts", "pinned", "TEXT NOT NULL DEFAULT ''"},
+	{"posts", "pinned_at", "DATETIME"},
+	{"posts", "locked_at", "DATETIME"},
+	{"posts", "archived_at", "DATETIME"},
+	{"posts", "reopened_at", "DATETIME"},
+	{"posts", "accepted_comment_id", "TEXT NOT NULL DEFAULT ''"},
+	{"posts", "likes_count", "INTEGER NOT NULL DEFAULT 0"},
+	{"posts", "dislikes_count", "INTEGER NOT NULL DEFAULT 0"},
+	{"posts", "comments_count", "INTEGER NOT NULL DEFAULT 0"},
+	{"comments", "likes_count", "INTEGER NOT NULL DEFAULT 0"},
+	{"comments", "dislikes_count", "INTEGER NOT NULL DEFAULT 0"},
+}
+
+// columnBackfills fill in a column added by columnMigrations from the rows
+// that were there before it, keyed by table and column.
+var columnBackfills = map[string]string{
+	"posts.likes_count":       "UPDATE posts SET likes_count = (SELECT COUNT(*) FROM post_reactions r WHERE r.post_id = posts.id AND r.reaction_type = 'like')",
+	"posts.dislikes_count":    "UPDATE posts SET dislikes_count = (SELECT COUNT(*) FROM post_reactions r WHERE r.post_id = posts.id AND r.reaction_type = 'dislike')",
+	"posts.comments_count":    "UPDATE posts SET comments_count = (SELECT COUNT(*) FROM comments c WHERE c.post_id = posts.id AND c.status = 'published')",
+	"comments.likes_count":    "UPDATE comments SET likes_count = (SELECT COUNT(*) FROM comment_reactions r WHERE r.comment_id = comments.id AND r.reaction_type = 'like')",
+	"comments.dislikes_count": "UPDATE comments SET dislikes_count = (SELECT COUNT(*) FROM comment_reactions r WHERE r.comment_id = comments.id AND r.reaction_type = 'dislike')",
+}
+
 func ConnectDB() (*sql.DB, error) {
 	dbPath := "./pkg/models/sqlite/forum.db"
 
@@ -35,6 +81,12 @@ func ConnectDB() (*sql.DB, error) {
 		return nil, err
 	}
 
+	// Bring tables created by an older schema up to date before the schema
+	// creates indexes that may refer to the new columns
+	if err := migrateColumns(db); err != nil {
+		return nil, err
+	}
+
 	// Execute the schema.sql content as SQL statements
 	_, err = db.Exec(string(schema))
 	if err != nil {
@@ -42,3 +94,56 @@ func ConnectDB() (*sql.DB, error) {
 	}
 	return db, nil
 }
+
+func migrateColumns(db *sql.DB) error {
+	for _, m := range columnMigrations {
+		exists, err := tableExists(db, m.table)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			// schema.sql will create it with every column
+			continue
+		}
+
+		exists, err = columnExists(db, m.table, m.column)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+
+		query := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", m.table, m.column, m.definition)
+		if _, err := db.Exec(query); err != nil {
+			return fmt.Errorf("failed to add column %s.%s: %v", m.table, m.column, err)
+		}
+		log.Printf("Added column %s.%s\n", m.table, m.column)
+
+		if backfill, ok := columnBackfills[m.table+"."+m.column]; ok {
+			if _, err := db.Exec(backfill); err != nil {
+				return fmt.Errorf("failed to fill in column %s.%s: %v", m.table, m.column, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func tableExists(db *sql.DB, table string) (bool, error) {
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, table).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("failed to look up table %s: %v", table, err)
+	}
+	return count > 0, nil
+}
+
+func columnExists(db *sql.DB, table, column string) (bool, error) {
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`, table, column).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("failed to look up column %s.%s: %v", table, column, err)
+	}
+	return count > 0, nil
+}
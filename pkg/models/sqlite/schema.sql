@@ -13,6 +13,19 @@ CREATE TABLE IF NOT EXISTS posts (
   image_url VARCHAR(255),
   category TEXT NOT NULL,
   created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  status TEXT NOT NULL DEFAULT 'published',
+  content_html TEXT NOT NULL DEFAULT '',
+  content_html_version INTEGER NOT NULL DEFAULT 0,
+  publish_at DATETIME,
+  pinned TEXT NOT NULL DEFAULT '',
+  pinned_at DATETIME,
+  locked_at DATETIME,
+  archived_at DATETIME,
+  reopened_at DATETIME,
+  accepted_comment_id TEXT NOT NULL DEFAULT '',
+  likes_count INTEGER NOT NULL DEFAULT 0,
+  dislikes_count INTEGER NOT NULL DEFAULT 0,
+  comments_count INTEGER NOT NULL DEFAULT 0,
   FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
 );
 
@@ -22,6 +35,11 @@ CREATE TABLE IF NOT EXISTS comments (
   post_id TEXT NOT NULL,
   content TEXT NOT NULL,
   created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  status TEXT NOT NULL DEFAULT 'published',
+  content_html TEXT NOT NULL DEFAULT '',
+  content_html_version INTEGER NOT NULL DEFAULT 0,
+  likes_count INTEGER NOT NULL DEFAULT 0,
+  dislikes_count INTEGER NOT NULL DEFAULT 0,
   FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
   FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE
 );
@@ -41,7 +59,14 @@ CREATE TABLE IF NOT EXISTS users (
   email TEXT NOT NULL UNIQUE,
   hashed_password TEXT NOT NULL,
   created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
-  updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+  updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  role TEXT NOT NULL DEFAULT 'user',
+  totp_secret TEXT NOT NULL DEFAULT '',
+  totp_enabled BOOLEAN NOT NULL DEFAULT 0,
+  totp_last_step INTEGER NOT NULL DEFAULT 0,
+  upload_quota INTEGER NOT NULL DEFAULT 0,
+  deletion_requested_at DATETIME,
+  deleted_at DATETIME
 );
 
 CREATE TABLE IF NOT EXISTS post_reactions (
@@ -67,5 +92,454 @@ CREATE TABLE IF NOT EXISTS comment_reactions (
   CONSTRAINT reaction_unique UNIQUE (user_id, post_id, comment_id) ON CONFLICT REPLACE
 );
 
+CREATE TABLE IF NOT EXISTS recovery_codes (
+  id TEXT PRIMARY KEY,
+  user_id TEXT NOT NULL,
+  code_hash TEXT NOT NULL,
+  used_at DATETIME,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS mfa_challenges (
+  id TEXT PRIMARY KEY,
+  user_id TEXT NOT NULL,
+  attempts INTEGER NOT NULL DEFAULT 0,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  expires_at DATETIME NOT NULL,
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS settings (
+  key TEXT PRIMARY KEY,
+  value TEXT NOT NULL DEFAULT '',
+  updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS passkeys (
+  id TEXT PRIMARY KEY,
+  user_id TEXT NOT NULL,
+  name TEXT NOT NULL,
+  public_key BLOB NOT NULL,
+  sign_count INTEGER NOT NULL DEFAULT 0,
+  transports TEXT NOT NULL DEFAULT '',
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  last_used_at DATETIME,
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS webauthn_challenges (
+  id TEXT PRIMARY KEY,
+  user_id TEXT NOT NULL DEFAULT '',
+  ceremony TEXT NOT NULL,
+  challenge BLOB NOT NULL,
+  expires_at DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS login_throttles (
+  kind TEXT NOT NULL,
+  key TEXT NOT NULL,
+  failures INTEGER NOT NULL DEFAULT 0,
+  last_failure_at DATETIME NOT NULL,
+  locked_until DATETIME,
+  PRIMARY KEY (kind, key)
+);
+
+CREATE TABLE IF NOT EXISTS notifications (
+  id TEXT PRIMARY KEY,
+  user_id TEXT NOT NULL,
+  kind TEXT NOT NULL,
+  message TEXT NOT NULL,
+  link TEXT NOT NULL DEFAULT '',
+  read BOOLEAN NOT NULL DEFAULT FALSE,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS rate_limits (
+  key TEXT PRIMARY KEY,
+  tokens REAL NOT NULL,
+  updated_at REAL NOT NULL,
+  allowed BOOLEAN NOT NULL DEFAULT TRUE
+);
+
+CREATE TABLE IF NOT EXISTS suspensions (
+  id TEXT PRIMARY KEY,
+  user_id TEXT NOT NULL,
+  reason TEXT NOT NULL,
+  created_by TEXT NOT NULL,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  expires_at DATETIME,
+  lifted_at DATETIME,
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS ip_bans (
+  id TEXT PRIMARY KEY,
+  cidr TEXT NOT NULL,
+  reason TEXT NOT NULL,
+  created_by TEXT NOT NULL,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  expires_at DATETIME
+);
+
+CREATE TABLE IF NOT EXISTS review_queue (
+  id TEXT PRIMARY KEY,
+  kind TEXT NOT NULL,
+  item_id TEXT NOT NULL,
+  user_id TEXT NOT NULL,
+  score INTEGER NOT NULL,
+  reasons TEXT NOT NULL DEFAULT '',
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS image_variants (
+  image_url TEXT NOT NULL,
+  name TEXT NOT NULL,
+  url TEXT NOT NULL,
+  width INTEGER NOT NULL,
+  height INTEGER NOT NULL,
+  PRIMARY KEY (image_url, name)
+);
+
+CREATE TABLE IF NOT EXISTS uploads (
+  hash TEXT PRIMARY KEY,
+  url TEXT NOT NULL UNIQUE,
+  size INTEGER NOT NULL,
+  mime TEXT NOT NULL,
+  ref_count INTEGER NOT NULL DEFAULT 0,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  unreferenced_at DATETIME
+);
+
+CREATE TABLE IF NOT EXISTS post_attachments (
+  id TEXT PRIMARY KEY,
+  post_id TEXT NOT NULL,
+  kind TEXT NOT NULL,
+  url TEXT NOT NULL,
+  file_name TEXT NOT NULL DEFAULT '',
+  mime TEXT NOT NULL DEFAULT '',
+  size INTEGER NOT NULL DEFAULT 0,
+  position INTEGER NOT NULL DEFAULT 0,
+  FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS post_attachments_post ON post_attachments (post_id, position);
+CREATE INDEX IF NOT EXISTS post_attachments_url ON post_attachments (url);
+
+-- Uploads used to be counted per post, from posts.image_url
+DROP TRIGGER IF EXISTS posts_upload_insert;
+DROP TRIGGER IF EXISTS posts_upload_update;
+DROP TRIGGER IF EXISTS posts_upload_delete;
+
+-- Posts from before attachments keep their image as their only attachment.
+-- This runs before the triggers below exist, so on the first start it does not
+-- count the images a second time.
+INSERT INTO post_attachments (id, post_id, kind, url, size)
+SELECT lower(hex(randomblob(16))), p.id, 'image', p.image_url,
+  COALESCE((SELECT size FROM uploads u WHERE u.url = p.image_url), 0)
+FROM posts p
+WHERE p.image_url IS NOT NULL AND p.image_url != ''
+  AND NOT EXISTS (SELECT 1 FROM post_attachments a WHERE a.post_id = p.id);
+
+-- uploads.ref_count counts the attachments using an upload, whichever code
+-- path adds or removes them. unreferenced_at starts the garbage collector's
+-- grace period. Foreign keys are not enforced, so attachments are removed with
+-- their post here as well.
+CREATE TRIGGER IF NOT EXISTS post_attachments_upload_insert AFTER INSERT ON post_attachments
+BEGIN
+  UPDATE uploads SET ref_count = ref_count + 1, unreferenced_at = NULL WHERE url = NEW.url;
+END;
+
+CREATE TRIGGER IF NOT EXISTS post_attachments_upload_delete AFTER DELETE ON post_attachments
+BEGIN
+  UPDATE uploads SET ref_count = MAX(ref_count - 1, 0),
+    unreferenced_at = CASE WHEN ref_count <= 1 THEN CURRENT_TIMESTAMP ELSE NULL END
+  WHERE url = OLD.url;
+END;
+
+CREATE TRIGGER IF NOT EXISTS posts_attachments_delete AFTER DELETE ON posts
+BEGIN
+  DELETE FROM post_attachments WHERE post_id = OLD.id;
+END;
+
+-- content_html caches the rendered Markdown of the current content, made with
+-- renderer version content_html_version. Changing the content drops it, so
+-- every revision is rendered afresh.
+CREATE TRIGGER IF NOT EXISTS posts_content_html_update AFTER UPDATE OF content ON posts
+WHEN NEW.content IS NOT OLD.content
+BEGIN
+  UPDATE posts SET content_html = '', content_html_version = 0 WHERE id = NEW.id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS comments_content_html_update AFTER UPDATE OF content ON comments
+WHEN NEW.content IS NOT OLD.content
+BEGIN
+  UPDATE comments SET content_html = '', content_html_version = 0 WHERE id = NEW.id;
+END;
+
+-- Scheduled drafts are looked up by the publisher every minute
+CREATE INDEX IF NOT EXISTS posts_publish_at ON posts (publish_at) WHERE status = 'draft';
+
+CREATE TABLE IF NOT EXISTS polls (
+  post_id TEXT PRIMARY KEY,
+  multiple BOOLEAN NOT NULL DEFAULT 0,
+  closes_at DATETIME,
+  FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS poll_options (
+  id TEXT PRIMARY KEY,
+  post_id TEXT NOT NULL,
+  text TEXT NOT NULL,
+  position INTEGER NOT NULL DEFAULT 0,
+  FOREIGN KEY (post_id) REFERENCES polls(post_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS poll_options_post ON poll_options (post_id, position);
+
+-- A user has one vote per poll, which picks one option or, in a multiple
+-- choice poll, several.
+CREATE TABLE IF NOT EXISTS poll_votes (
+  id TEXT PRIMARY KEY,
+  post_id TEXT NOT NULL,
+  user_id TEXT NOT NULL,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+  FOREIGN KEY (post_id) REFERENCES polls(post_id) ON DELETE CASCADE,
+  CONSTRAINT poll_vote_unique UNIQUE (user_id, post_id) ON CONFLICT REPLACE
+);
+
+CREATE TABLE IF NOT EXISTS poll_vote_choices (
+  vote_id TEXT NOT NULL,
+  option_id TEXT NOT NULL,
+  post_id TEXT NOT NULL,
+  PRIMARY KEY (vote_id, option_id),
+  FOREIGN KEY (vote_id) REFERENCES poll_votes(id) ON DELETE CASCADE,
+  FOREIGN KEY (option_id) REFERENCES poll_options(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS poll_vote_choices_option ON poll_vote_choices (option_id);
+
+-- Foreign keys are not enforced, so a poll goes with its post here
+CREATE TRIGGER IF NOT EXISTS posts_poll_delete AFTER DELETE ON posts
+BEGIN
+  DELETE FROM poll_vote_choices WHERE post_id = OLD.id;
+  DELETE FROM poll_votes WHERE post_id = OLD.id;
+  DELETE FROM poll_options WHERE post_id = OLD.id;
+  DELETE FROM polls WHERE post_id = OLD.id;
+END;
+
+-- Tags are free-form labels users add to posts, next to the fixed
+-- categories. Names are normalized before they are stored or looked up.
+CREATE TABLE IF NOT EXISTS tags (
+  id TEXT PRIMARY KEY,
+  name TEXT NOT NULL UNIQUE,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- A synonym is another name for a tag, set by moderators or left behind when
+-- a tag is merged into another. Posts tagged with it get the tag instead.
+CREATE TABLE IF NOT EXISTS tag_synonyms (
+  name TEXT PRIMARY KEY,
+  tag_id TEXT NOT NULL,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (tag_id) REFERENCES tags(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS tag_synonyms_tag ON tag_synonyms (tag_id);
+
+CREATE TABLE IF NOT EXISTS post_tags (
+  post_id TEXT NOT NULL,
+  tag_id TEXT NOT NULL,
+  PRIMARY KEY (post_id, tag_id),
+  FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE,
+  FOREIGN KEY (tag_id) REFERENCES tags(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS post_tags_tag ON post_tags (tag_id);
+
+CREATE TRIGGER IF NOT EXISTS posts_tags_delete AFTER DELETE ON posts
+BEGIN
+  DELETE FROM post_tags WHERE post_id = OLD.id;
+END;
+
+-- Bookmarks are private to the user who saved them. Collections are named
+-- by the user; a bookmark without a collection has collection_id ''.
+CREATE TABLE IF NOT EXISTS bookmark_collections (
+  id TEXT PRIMARY KEY,
+  user_id TEXT NOT NULL,
+  name TEXT NOT NULL,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+  CONSTRAINT bookmark_collection_unique UNIQUE (user_id, name)
+);
+
+-- A bookmark is on a post, or on one of its comments when comment_id is set.
+CREATE TABLE IF NOT EXISTS bookmarks (
+  id TEXT PRIMARY KEY,
+  user_id TEXT NOT NULL,
+  post_id TEXT NOT NULL,
+  comment_id TEXT NOT NULL DEFAULT '',
+  collection_id TEXT NOT NULL DEFAULT '',
+  note TEXT NOT NULL DEFAULT '',
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+  FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE,
+  CONSTRAINT bookmark_unique UNIQUE (user_id, post_id, comment_id)
+);
+
+CREATE INDEX IF NOT EXISTS bookmarks_collection ON bookmarks (user_id, collection_id, created_at);
+
+CREATE TRIGGER IF NOT EXISTS posts_bookmarks_delete AFTER DELETE ON posts
+BEGIN
+  DELETE FROM bookmarks WHERE post_id = OLD.id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS comments_bookmarks_delete AFTER DELETE ON comments
+BEGIN
+  DELETE FROM bookmarks WHERE comment_id = OLD.id;
+END;
+
+CREATE TABLE IF NOT EXISTS follows (
+  follower_id TEXT NOT NULL,
+  followee_id TEXT NOT NULL,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (follower_id, followee_id),
+  FOREIGN KEY (follower_id) REFERENCES users(id) ON DELETE CASCADE,
+  FOREIGN KEY (followee_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS follows_followee ON follows (followee_id);
+
+CREATE TABLE IF NOT EXISTS category_subscriptions (
+  user_id TEXT NOT NULL,
+  category TEXT NOT NULL,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (user_id, category),
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- A thread is watched, so new comments notify the user, or muted, so they
+-- don't even on the user's own posts. Without a row the user is notified
+-- only about comments on their own posts.
+CREATE TABLE IF NOT EXISTS thread_watches (
+  user_id TEXT NOT NULL,
+  post_id TEXT NOT NULL,
+  state TEXT NOT NULL DEFAULT 'watching',
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (user_id, post_id),
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+  FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS thread_watches_post ON thread_watches (post_id, state);
+
+CREATE TRIGGER IF NOT EXISTS posts_watches_delete AFTER DELETE ON posts
+BEGIN
+  DELETE FROM thread_watches WHERE post_id = OLD.id;
+END;
+
+-- accepted_comment_id on posts is the answer accepted to a question, '' until
+-- one is
+CREATE TRIGGER IF NOT EXISTS comments_accepted_delete AFTER DELETE ON comments
+BEGIN
+  UPDATE posts SET accepted_comment_id = '' WHERE accepted_comment_id = OLD.id;
+END;
+
+-- Emoji reactions come on top of the like or dislike that makes a post's
+-- score: a user can leave several different emoji. comment_id is '' for a
+-- reaction to the post itself.
+CREATE TABLE IF NOT EXISTS emoji_reactions (
+  post_id TEXT NOT NULL,
+  comment_id TEXT NOT NULL DEFAULT '',
+  user_id TEXT NOT NULL,
+  emoji TEXT NOT NULL,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (post_id, comment_id, user_id, emoji),
+  FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE,
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS emoji_reactions_user ON emoji_reactions (user_id);
+
+CREATE TRIGGER IF NOT EXISTS posts_emoji_reactions_delete AFTER DELETE ON posts
+BEGIN
+  DELETE FROM emoji_reactions WHERE post_id = OLD.id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS comments_emoji_reactions_delete AFTER DELETE ON comments
+BEGIN
+  DELETE FROM emoji_reactions WHERE comment_id = OLD.id;
+END;
+
+-- likes_count, dislikes_count and comments_count are kept here, in the same
+-- transaction as the reaction or comment that changes them, so listings don't
+-- count rows. Reactions are toggled by explicit deletes and updates: rows
+-- replaced through ON CONFLICT REPLACE would not fire the delete triggers.
+-- forumctl repair-counters recounts them.
+CREATE TRIGGER IF NOT EXISTS post_reactions_count_insert AFTER INSERT ON post_reactions
+BEGIN
+  UPDATE posts SET likes_count = likes_count + (NEW.reaction_type = 'like'),
+    dislikes_count = dislikes_count + (NEW.reaction_type = 'dislike')
+  WHERE id = NEW.post_id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS post_reactions_count_update AFTER UPDATE OF reaction_type ON post_reactions
+WHEN NEW.reaction_type IS NOT OLD.reaction_type
+BEGIN
+  UPDATE posts SET likes_count = MAX(likes_count + (NEW.reaction_type = 'like') - (OLD.reaction_type = 'like'), 0),
+    dislikes_count = MAX(dislikes_count + (NEW.reaction_type = 'dislike') - (OLD.reaction_type = 'dislike'), 0)
+  WHERE id = NEW.post_id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS post_reactions_count_delete AFTER DELETE ON post_reactions
+BEGIN
+  UPDATE posts SET likes_count = MAX(likes_count - (OLD.reaction_type = 'like'), 0),
+    dislikes_count = MAX(dislikes_count - (OLD.reaction_type = 'dislike'), 0)
+  WHERE id = OLD.post_id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS comment_reactions_count_insert AFTER INSERT ON comment_reactions
+BEGIN
+  UPDATE comments SET likes_count = likes_count + (NEW.reaction_type = 'like'),
+    dislikes_count = dislikes_count + (NEW.reaction_type = 'dislike')
+  WHERE id = NEW.comment_id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS comment_reactions_count_update AFTER UPDATE OF reaction_type ON comment_reactions
+WHEN NEW.reaction_type IS NOT OLD.reaction_type
+BEGIN
+  UPDATE comments SET likes_count = MAX(likes_count + (NEW.reaction_type = 'like') - (OLD.reaction_type = 'like'), 0),
+    dislikes_count = MAX(dislikes_count + (NEW.reaction_type = 'dislike') - (OLD.reaction_type = 'dislike'), 0)
+  WHERE id = NEW.comment_id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS comment_reactions_count_delete AFTER DELETE ON comment_reactions
+BEGIN
+  UPDATE comments SET likes_count = MAX(likes_count - (OLD.reaction_type = 'like'), 0),
+    dislikes_count = MAX(dislikes_count - (OLD.reaction_type = 'dislike'), 0)
+  WHERE id = OLD.comment_id;
+END;
+
+-- comments_count only counts published comments, so held ones are counted
+-- once they are approved
+CREATE TRIGGER IF NOT EXISTS comments_count_insert AFTER INSERT ON comments
+WHEN NEW.status = 'published'
+BEGIN
+  UPDATE posts SET comments_count = comments_count + 1 WHERE id = NEW.post_id;
+END;
 
+CREATE TRIGGER IF NOT EXISTS comments_count_update AFTER UPDATE OF status ON comments
+WHEN (NEW.status = 'published') != (OLD.status = 'published')
+BEGIN
+  UPDATE posts SET comments_count = MAX(comments_count + CASE WHEN NEW.status = 'published' THEN 1 ELSE -1 END, 0)
+  WHERE id = NEW.post_id;
+END;
 
+CREATE TRIGGER IF NOT EXISTS comments_count_delete AFTER DELETE ON comments
+WHEN OLD.status = 'published'
+BEGIN
+  UPDATE posts SET comments_count = MAX(comments_count - 1, 0) WHERE id = OLD.post_id;
+END;
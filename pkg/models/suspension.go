@@ -0,0 +1,155 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"forum/logger"
+
+	"github.com/google/uuid"
+)
+
+// Suspension keeps a user from logging in until it expires or is lifted. A
+// suspension without an expiry is a permanent ban.
+type Suspension struct {
+	ID            string       `json:"id"`
+	UserID        string       `json:"user_id"`
+	UserName      string       `json:"user_name"`
+	Reason        string       `json:"reason"`
+	CreatedBy     string       `json:"created_by"`
+	CreatedByName string       `json:"created_by_name"`
+	CreatedAt     time.Time    `json:"created_at"`
+	ExpiresAt     sql.NullTime `json:"expires_at"`
+	LiftedAt      sql.NullTime `json:"lifted_at"`
+}
+
+func (s Suspension) Permanent() bool {
+	return !s.ExpiresAt.Valid
+}
+
+// SuspendUser stores the suspension and signs the user out everywhere.
+func SuspendUser(db *sql.DB, suspension Suspension) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to begin suspension transaction: %v", err)
+		return "", fmt.Errorf("failed to begin suspension transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	suspension.ID = uuid.New().String()
+	query := "INSERT INTO suspensions (id, user_id, reason, created_by, created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?)"
+	if _, err := tx.ExecContext(ctx, query, suspension.ID, suspension.UserID, suspension.Reason, suspension.CreatedBy, time.Now(), suspension.ExpiresAt); err != nil {
+		logger.ErrorLogger.Printf("Failed to create suspension: %v", err)
+		return "", fmt.Errorf("failed to create suspension: %v", err)
+	}
+
+	for _, query := range []string{
+		"DELETE FROM sessions WHERE user_id = ?",
+		"DELETE FROM mfa_challenges WHERE user_id = ?",
+	} {
+		if _, err := tx.ExecContext(ctx, query, suspension.UserID); err != nil {
+			logger.ErrorLogger.Printf("Failed to revoke sessions: %v", err)
+			return "", fmt.Errorf("failed to revoke sessions: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		logger.ErrorLogger.Printf("Failed to commit suspension: %v", err)
+		return "", fmt.Errorf("failed to commit suspension: %v", err)
+	}
+
+	return suspension.ID, nil
+}
+
+const suspensionColumns = `s.id, s.user_id, u.name, s.reason, s.created_by, COALESCE(c.name, ''), s.created_at, s.expires_at, s.lifted_at
+	FROM suspensions s
+	JOIN users u ON u.id = s.user_id
+	LEFT JOIN users c ON c.id = s.created_by`
+
+// ActiveSuspension matches suspensions s that are neither lifted nor expired,
+// given the current time as its parameter.
+const ActiveSuspension = "s.lifted_at IS NULL AND (s.expires_at IS NULL OR s.expires_at > ?)"
+
+func scanSuspension(row interface{ Scan(...interface{}) error }) (Suspension, error) {
+	var s Suspension
+	err := row.Scan(&s.ID, &s.UserID, &s.UserName, &s.Reason, &s.CreatedBy, &s.CreatedByName, &s.CreatedAt, &s.ExpiresAt, &s.LiftedAt)
+	return s, err
+}
+
+// GetActiveSuspension returns the suspension that currently applies to the
+// user, the one lasting longest if there are several.
+func GetActiveSuspension(db *sql.DB, userID string) (Suspension, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "SELECT " + suspensionColumns + " WHERE s.user_id = ? AND " + ActiveSuspension +
+		" ORDER BY s.expires_at IS NULL DESC, s.expires_at DESC LIMIT 1"
+	suspension, err := scanSuspension(db.QueryRowContext(ctx, query, userID, time.Now()))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return Suspension{}, false, nil
+		}
+		logger.ErrorLogger.Printf("Failed to get suspension: %v", err)
+		return Suspension{}, false, fmt.Errorf("failed to get suspension: %v", err)
+	}
+
+	return suspension, true, nil
+}
+
+// GetActiveSuspensions returns all suspensions in force, newest first.
+func GetActiveSuspensions(db *sql.DB) ([]Suspension, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "SELECT " + suspensionColumns + " WHERE " + ActiveSuspension + " ORDER BY s.created_at DESC"
+	rows, err := db.QueryContext(ctx, query, time.Now())
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to get suspensions: %v", err)
+		return nil, fmt.Errorf("failed to get suspensions: %v", err)
+	}
+	defer rows.Close()
+
+	var suspensions []Suspension
+	for rows.Next() {
+		suspension, err := scanSuspension(rows)
+		if err != nil {
+			logger.ErrorLogger.Printf("Failed to scan suspension: %v", err)
+			return nil, fmt.Errorf("failed to scan suspension: %v", err)
+		}
+		suspensions = append(suspensions, suspension)
+	}
+
+	return suspensions, rows.Err()
+}
+
+func GetSuspensionByID(db *sql.DB, id string) (Suspension, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	suspension, err := scanSuspension(db.QueryRowContext(ctx, "SELECT "+suspensionColumns+" WHERE s.id = ?", id))
+	if err != nil {
+		if err != sql.ErrNoRows {
+			logger.ErrorLogger.Printf("Failed to get suspension: %v", err)
+		}
+		return Suspension{}, fmt.Errorf("failed to get suspension: %v", err)
+	}
+
+	return suspension, nil
+}
+
+func LiftSuspension(db *sql.DB, id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if _, err := db.ExecContext(ctx, "UPDATE suspensions SET lifted_at = ? WHERE id = ? AND lifted_at IS NULL", time.Now(), id); err != nil {
+		logger.ErrorLogger.Printf("Failed to lift suspension: %v", err)
+		return fmt.Errorf("failed to lift suspension: %v", err)
+	}
+
+	return nil
+}
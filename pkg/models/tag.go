@@ -0,0 +1,375 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"forum/logger"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrTagNotFound = errors.New("no tag with that name")
+	ErrTagExists   = errors.New("a tag with that name already exists")
+)
+
+// Tag is a free-form label on posts. Posts and Synonyms are filled in where
+// the tag is listed with them.
+type Tag struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	Posts     int       `json:"posts"`
+	Synonyms  []string  `json:"synonyms"`
+}
+
+// NormalizeTag turns what a user typed into a tag name: lower case, with
+// spaces and underscores as dashes and anything but letters, digits and
+// "-+.#" dropped, so "Machine Learning" and "machine_learning" are the same
+// tag and "C++" and "C#" keep their meaning. A leading "#" is dropped.
+func NormalizeTag(name string) string {
+	name = strings.TrimPrefix(strings.TrimSpace(name), "#")
+
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '+' || r == '.' || r == '#':
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			dash = false
+			b.WriteRune(r)
+		case r == '-' || r == '_' || unicode.IsSpace(r):
+			dash = true
+		}
+	}
+	return b.String()
+}
+
+// tagIDs selects the ids of the tags with the given names, directly or
+// through a synonym. It takes the names twice, see tagIDArgs.
+func tagIDs(names []string) string {
+	placeholders := "?" + strings.Repeat(", ?", len(names)-1)
+	return "SELECT id FROM tags WHERE name IN (" + placeholders + ") UNION SELECT tag_id FROM tag_synonyms WHERE name IN (" + placeholders + ")"
+}
+
+func tagIDArgs(names []string) []interface{} {
+	args := make([]interface{}, 0, 2*len(names))
+	for i := 0; i < 2; i++ {
+		for _, name := range names {
+			args = append(args, name)
+		}
+	}
+	return args
+}
+
+// resolveTag returns the id of the tag name stands for, following synonyms,
+// and creates the tag if there is none.
+func resolveTag(ctx context.Context, tx *sql.Tx, name string) (string, error) {
+	var id string
+	err := tx.QueryRowContext(ctx, "SELECT tag_id FROM tag_synonyms WHERE name = ?", name).Scan(&id)
+	if err == nil {
+		return id, nil
+	}
+	if err != sql.ErrNoRows {
+		return "", err
+	}
+
+	err = tx.QueryRowContext(ctx, "SELECT id FROM tags WHERE name = ?", name).Scan(&id)
+	if err == nil {
+		return id, nil
+	}
+	if err != sql.ErrNoRows {
+		return "", err
+	}
+
+	id = uuid.New().String()
+	_, err = tx.ExecContext(ctx, "INSERT INTO tags (id, name, created_at) VALUES (?, ?, ?)", id, name, time.Now())
+	return id, err
+}
+
+// insertTags tags a post with post.Tags as part of saving it.
+func insertTags(ctx context.Context, tx *sql.Tx, post Post) error {
+	for _, name := range post.Tags {
+		id, err := resolveTag(ctx, tx, name)
+		if err != nil {
+			logger.ErrorLogger.Printf("Failed to save tag: %v", err)
+			return fmt.Errorf("failed to save tag: %v", err)
+		}
+
+		// Two names can stand for the same tag
+		if _, err := tx.ExecContext(ctx, "INSERT OR IGNORE INTO post_tags (post_id, tag_id) VALUES (?, ?)", post.ID, id); err != nil {
+			logger.ErrorLogger.Printf("Failed to tag post: %v", err)
+			return fmt.Errorf("failed to tag post: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// replaceTags replaces the tags of a draft.
+func replaceTags(ctx context.Context, tx *sql.Tx, post Post) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM post_tags WHERE post_id = ?", post.ID); err != nil {
+		logger.ErrorLogger.Printf("Failed to remove tags: %v", err)
+		return fmt.Errorf("failed to remove tags: %v", err)
+	}
+
+	return insertTags(ctx, tx, post)
+}
+
+// GetTagsByPostID returns the names of the post's tags.
+func GetTagsByPostID(db *sql.DB, postID string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "SELECT t.name FROM post_tags pt JOIN tags t ON t.id = pt.tag_id WHERE pt.post_id = ? ORDER BY t.name"
+	rows, err := db.QueryContext(ctx, query, postID)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to get post tags: %v", err)
+		return nil, fmt.Errorf("failed to get post tags: %v", err)
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			logger.ErrorLogger.Printf("Failed to scan post tag: %v", err)
+			return nil, fmt.Errorf("failed to scan post tag: %v", err)
+		}
+		names = append(names, name)
+	}
+
+	if err := rows.Err(); err != nil {
+		logger.ErrorLogger.Printf("Failed to get post tags: %v", err)
+		return nil, fmt.Errorf("failed to get post tags: %v", err)
+	}
+
+	return names, nil
+}
+
+// GetTag returns the tag name stands for, following synonyms, with the
+// number of published posts it is on.
+func GetTag(db *sql.DB, name string) (Tag, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT t.id, t.name, t.created_at,
+			(SELECT COUNT(*) FROM post_tags pt JOIN posts p ON p.id = pt.post_id WHERE pt.tag_id = t.id AND p.status = 'published')
+		FROM tags t WHERE t.id IN (` + tagIDs([]string{name}) + `)`
+	var tag Tag
+	err := db.QueryRowContext(ctx, query, tagIDArgs([]string{name})...).Scan(&tag.ID, &tag.Name, &tag.CreatedAt, &tag.Posts)
+	if err == sql.ErrNoRows {
+		return Tag{}, ErrTagNotFound
+	}
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to get tag: %v", err)
+		return Tag{}, fmt.Errorf("failed to get tag: %v", err)
+	}
+
+	return tag, nil
+}
+
+// GetPostsByTagID returns the published posts with the tag, newest first.
+func GetPostsByTagID(db *sql.DB, tagID string) ([]Post, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT p.id, p.user_id, p.title, p.content, p.image_url, p.category, p.created_at, p.likes_count, p.dislikes_count, p.comments_count
+		FROM posts p JOIN post_tags pt ON pt.post_id = p.id
+		WHERE pt.tag_id = ? AND p.status = 'published'
+		ORDER BY p.created_at DESC`
+	rows, err := db.QueryContext(ctx, query, tagID)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to get tagged posts: %v", err)
+		return nil, fmt.Errorf("failed to get tagged posts: %v", err)
+	}
+	defer rows.Close()
+
+	var posts []Post
+	for rows.Next() {
+		var post Post
+		if err := rows.Scan(&post.ID, &post.UserID, &post.Title, &post.Content, &post.ImageFullPath, &post.Category, &post.CreatedAt, &post.Likes, &post.Dislikes, &post.CommentsCount); err != nil {
+			logger.ErrorLogger.Printf("Failed to scan tagged post: %v", err)
+			return nil, fmt.Errorf("failed to scan tagged post: %v", err)
+		}
+		posts = append(posts, post)
+	}
+
+	if err := rows.Err(); err != nil {
+		logger.ErrorLogger.Printf("Failed to get tagged posts: %v", err)
+		return nil, fmt.Errorf("failed to get tagged posts: %v", err)
+	}
+
+	return posts, nil
+}
+
+// SuggestTags returns up to limit tags whose name, or one of whose synonyms,
+// starts with prefix, the most used first.
+func SuggestTags(db *sql.DB, prefix string, limit int) ([]Tag, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// LIKE special characters in the prefix are matched literally
+	pattern := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(prefix) + "%"
+	query := `SELECT t.id, t.name, t.created_at, (SELECT COUNT(*) FROM post_tags pt WHERE pt.tag_id = t.id) AS uses
+		FROM tags t
+		WHERE t.name LIKE ? ESCAPE '\' OR t.id IN (SELECT tag_id FROM tag_synonyms WHERE name LIKE ? ESCAPE '\')
+		ORDER BY uses DESC, t.name
+		LIMIT ?`
+	return getTags(ctx, db, query, pattern, pattern, limit)
+}
+
+// GetTags returns every tag with its synonyms and the number of posts it is
+// on, including drafts, for moderators.
+func GetTags(db *sql.DB) ([]Tag, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT t.id, t.name, t.created_at, (SELECT COUNT(*) FROM post_tags pt WHERE pt.tag_id = t.id)
+		FROM tags t ORDER BY t.name`
+	tags, err := getTags(ctx, db, query)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT name, tag_id FROM tag_synonyms ORDER BY name")
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to get tag synonyms: %v", err)
+		return nil, fmt.Errorf("failed to get tag synonyms: %v", err)
+	}
+	defer rows.Close()
+
+	synonyms := make(map[string][]string)
+	for rows.Next() {
+		var name, tagID string
+		if err := rows.Scan(&name, &tagID); err != nil {
+			logger.ErrorLogger.Printf("Failed to scan tag synonym: %v", err)
+			return nil, fmt.Errorf("failed to scan tag synonym: %v", err)
+		}
+		synonyms[tagID] = append(synonyms[tagID], name)
+	}
+
+	if err := rows.Err(); err != nil {
+		logger.ErrorLogger.Printf("Failed to get tag synonyms: %v", err)
+		return nil, fmt.Errorf("failed to get tag synonyms: %v", err)
+	}
+
+	for i := range tags {
+		tags[i].Synonyms = synonyms[tags[i].ID]
+	}
+
+	return tags, nil
+}
+
+func getTags(ctx context.Context, db *sql.DB, query string, args ...interface{}) ([]Tag, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to get tags: %v", err)
+		return nil, fmt.Errorf("failed to get tags: %v", err)
+	}
+	defer rows.Close()
+
+	var tags []Tag
+	for rows.Next() {
+		var tag Tag
+		if err := rows.Scan(&tag.ID, &tag.Name, &tag.CreatedAt, &tag.Posts); err != nil {
+			logger.ErrorLogger.Printf("Failed to scan tag: %v", err)
+			return nil, fmt.Errorf("failed to scan tag: %v", err)
+		}
+		tags = append(tags, tag)
+	}
+
+	if err := rows.Err(); err != nil {
+		logger.ErrorLogger.Printf("Failed to get tags: %v", err)
+		return nil, fmt.Errorf("failed to get tags: %v", err)
+	}
+
+	return tags, nil
+}
+
+// AddTagSynonym makes name another name for the tag tagName stands for. A
+// name already in use as a tag has to be merged instead, so its posts move.
+func AddTagSynonym(db *sql.DB, name, tagName string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tag, err := GetTag(db, tagName)
+	if err != nil {
+		return err
+	}
+
+	var exists bool
+	if err := db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM tags WHERE name = ?)", name).Scan(&exists); err != nil {
+		logger.ErrorLogger.Printf("Failed to check tag: %v", err)
+		return fmt.Errorf("failed to check tag: %v", err)
+	}
+	if exists {
+		return ErrTagExists
+	}
+
+	query := "INSERT OR REPLACE INTO tag_synonyms (name, tag_id, created_at) VALUES (?, ?, ?)"
+	if _, err := db.ExecContext(ctx, query, name, tag.ID, time.Now()); err != nil {
+		logger.ErrorLogger.Printf("Failed to add tag synonym: %v", err)
+		return fmt.Errorf("failed to add tag synonym: %v", err)
+	}
+
+	return nil
+}
+
+// RemoveTagSynonym removes a synonym. Posts keep the tag it stood for.
+func RemoveTagSynonym(db *sql.DB, name string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if _, err := db.ExecContext(ctx, "DELETE FROM tag_synonyms WHERE name = ?", name); err != nil {
+		logger.ErrorLogger.Printf("Failed to remove tag synonym: %v", err)
+		return fmt.Errorf("failed to remove tag synonym: %v", err)
+	}
+
+	return nil
+}
+
+// MergeTags moves the posts of the tag from into the tag into and deletes
+// from, whose name and synonyms become synonyms of into.
+func MergeTags(db *sql.DB, from, into Tag) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to begin tag merge transaction: %v", err)
+		return fmt.Errorf("failed to begin tag merge transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	for _, statement := range []struct {
+		query string
+		args  []interface{}
+	}{
+		{"INSERT OR IGNORE INTO post_tags (post_id, tag_id) SELECT post_id, ? FROM post_tags WHERE tag_id = ?", []interface{}{into.ID, from.ID}},
+		{"DELETE FROM post_tags WHERE tag_id = ?", []interface{}{from.ID}},
+		{"UPDATE tag_synonyms SET tag_id = ? WHERE tag_id = ?", []interface{}{into.ID, from.ID}},
+		{"INSERT OR REPLACE INTO tag_synonyms (name, tag_id, created_at) VALUES (?, ?, ?)", []interface{}{from.Name, into.ID, time.Now()}},
+		{"DELETE FROM tags WHERE id = ?", []interface{}{from.ID}},
+	} {
+		if _, err := tx.ExecContext(ctx, statement.query, statement.args...); err != nil {
+			logger.ErrorLogger.Printf("Failed to merge tags: %v", err)
+			return fmt.Errorf("failed to merge tags: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		logger.ErrorLogger.Printf("Failed to commit tag merge: %v", err)
+		return fmt.Errorf("failed to commit tag merge: %v", err)
+	}
+
+	return nil
+}
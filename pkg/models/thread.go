@@ -0,0 +1,138 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"forum/logger"
+)
+
+// Where a pinned post stays on top: of every listing, or only of the listings
+// of its categories.
+const (
+	PinGlobal   = "global"
+	PinCategory = "category"
+)
+
+var ErrThreadNotFound = errors.New("no such published post")
+
+// Locked reports whether a moderator closed the thread to new comments.
+func (p Post) Locked() bool {
+	return p.LockedAt.Valid
+}
+
+// Archived reports whether the thread was archived, by a moderator or for
+// lack of activity. Archived threads are read only.
+func (p Post) Archived() bool {
+	return p.ArchivedAt.Valid
+}
+
+// pinOrder is the ORDER BY term that puts pinned posts first, the most
+// recently pinned on top. Posts pinned to their categories only count in
+// listings filtered by category.
+func pinOrder(byCategory bool) string {
+	pinned := "pinned = 'global'"
+	if byCategory {
+		pinned = "pinned != ''"
+	}
+	return fmt.Sprintf("(%s) DESC, CASE WHEN %s THEN pinned_at END DESC", pinned, pinned)
+}
+
+// PinPost pins a published post with PinGlobal or PinCategory, or unpins it
+// when scope is empty.
+func PinPost(db *sql.DB, id, scope string) error {
+	if scope == "" {
+		return updateThread(db, "UPDATE posts SET pinned = '', pinned_at = NULL WHERE id = ? AND status = 'published'", id)
+	}
+	if scope != PinGlobal && scope != PinCategory {
+		return fmt.Errorf("unknown pin scope %q", scope)
+	}
+	return updateThread(db, "UPDATE posts SET pinned = ?, pinned_at = ? WHERE id = ? AND status = 'published'", scope, time.Now(), id)
+}
+
+// LockPost closes a published post to new comments, or opens it again.
+func LockPost(db *sql.DB, id string, locked bool) error {
+	if !locked {
+		return updateThread(db, "UPDATE posts SET locked_at = NULL WHERE id = ? AND status = 'published'", id)
+	}
+	return updateThread(db, "UPDATE posts SET locked_at = COALESCE(locked_at, ?) WHERE id = ? AND status = 'published'", time.Now(), id)
+}
+
+// ArchivePost archives a published post, or brings it back. A thread brought
+// back counts as active from then on, so it isn't archived again straight
+// away.
+func ArchivePost(db *sql.DB, id string, archived bool) error {
+	if !archived {
+		return updateThread(db, "UPDATE posts SET archived_at = NULL, reopened_at = ? WHERE id = ? AND status = 'published'", time.Now(), id)
+	}
+	return updateThread(db, "UPDATE posts SET archived_at = COALESCE(archived_at, ?) WHERE id = ? AND status = 'published'", time.Now(), id)
+}
+
+func updateThread(db *sql.DB, query string, args ...interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := db.ExecContext(ctx, query, args...)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to update thread: %v", err)
+		return fmt.Errorf("failed to update thread: %v", err)
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return ErrThreadNotFound
+	}
+
+	return nil
+}
+
+// ArchiveInactivePosts archives the published posts in a category that have
+// had no new comment since before, and were neither created nor brought back
+// after it. Pinned posts are left alone. It returns how many were archived.
+func ArchiveInactivePosts(db *sql.DB, category string, before, now time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// A post's categories are stored joined by "; "
+	query := `UPDATE posts SET archived_at = ?
+		WHERE status = 'published' AND archived_at IS NULL AND pinned = ''
+			AND '; ' || category || '; ' LIKE '%; ' || ? || '; %'
+			AND created_at < ? AND (reopened_at IS NULL OR reopened_at < ?)
+			AND NOT EXISTS (SELECT 1 FROM comments c WHERE c.post_id = posts.id AND c.created_at >= ?)`
+	result, err := db.ExecContext(ctx, query, now, category, before, before, before)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to archive inactive posts: %v", err)
+		return 0, fmt.Errorf("failed to archive inactive posts: %v", err)
+	}
+
+	return result.RowsAffected()
+}
+
+// GetArchiveDays returns after how many days without activity the threads of
+// each category are archived. 0 means they never are.
+func GetArchiveDays(db *sql.DB, categories []string) (map[string]int, error) {
+	days := make(map[string]int)
+	for _, category := range categories {
+		value, err := GetSetting(db, SettingArchiveDays+category, "0")
+		if err != nil {
+			return nil, err
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			n = 0
+		}
+		days[category] = n
+	}
+
+	return days, nil
+}
+
+func SetArchiveDays(db *sql.DB, category string, days int) error {
+	if days < 0 {
+		return fmt.Errorf("invalid number of days %d", days)
+	}
+
+	return SetSetting(db, SettingArchiveDays+category, strconv.Itoa(days))
+}
@@ -0,0 +1,174 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"forum/logger"
+)
+
+// Upload is a stored image or document. Uploads are named after the SHA-256
+// of their content, so posts with the same file share one. RefCount is kept up
+// to date by triggers on post_attachments.
+type Upload struct {
+	Hash           string
+	URL            string
+	Size           int64
+	MimeType       string
+	RefCount       int
+	CreatedAt      time.Time
+	UnreferencedAt sql.NullTime
+}
+
+// TrackUpload records an upload, counting the attachments that already use
+// it. An upload that is stored again while unreferenced gets a fresh grace
+// period.
+func TrackUpload(db *sql.DB, upload Upload) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `INSERT INTO uploads (hash, url, size, mime, ref_count, unreferenced_at)
+		SELECT ?, ?, ?, ?, refs, CASE WHEN refs = 0 THEN CURRENT_TIMESTAMP END
+		FROM (SELECT COUNT(*) AS refs FROM post_attachments WHERE url = ?)
+		WHERE true
+		ON CONFLICT (hash) DO UPDATE SET unreferenced_at = CURRENT_TIMESTAMP WHERE ref_count = 0`
+	_, err := db.ExecContext(ctx, query, upload.Hash, upload.URL, upload.Size, upload.MimeType, upload.URL)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to track upload: %v", err)
+		return fmt.Errorf("failed to track upload: %v", err)
+	}
+
+	return nil
+}
+
+// IsUploadTracked reports whether there is an uploads row for the hash.
+func IsUploadTracked(db *sql.DB, hash string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var exists bool
+	err := db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM uploads WHERE hash = ?)", hash).Scan(&exists)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to look up upload: %v", err)
+		return false, fmt.Errorf("failed to look up upload: %v", err)
+	}
+
+	return exists, nil
+}
+
+// CountUploadReferences returns the number of attachments using the upload.
+func CountUploadReferences(db *sql.DB, url string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var count int
+	err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM post_attachments WHERE url = ?", url).Scan(&count)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to count upload references: %v", err)
+		return 0, fmt.Errorf("failed to count upload references: %v", err)
+	}
+
+	return count, nil
+}
+
+// GetUnreferencedUploads returns the uploads no post has used for at least
+// grace, oldest first.
+func GetUnreferencedUploads(db *sql.DB, grace time.Duration) ([]Upload, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT hash, url, size, mime, ref_count, created_at, unreferenced_at FROM uploads
+		WHERE ref_count = 0 AND unreferenced_at <= datetime('now', ?)
+		ORDER BY unreferenced_at`
+	rows, err := db.QueryContext(ctx, query, fmt.Sprintf("-%d seconds", int64(grace/time.Second)))
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to get unreferenced uploads: %v", err)
+		return nil, fmt.Errorf("failed to get unreferenced uploads: %v", err)
+	}
+	defer rows.Close()
+
+	var uploads []Upload
+	for rows.Next() {
+		var u Upload
+		if err := rows.Scan(&u.Hash, &u.URL, &u.Size, &u.MimeType, &u.RefCount, &u.CreatedAt, &u.UnreferencedAt); err != nil {
+			logger.ErrorLogger.Printf("Failed to scan upload: %v", err)
+			return nil, fmt.Errorf("failed to scan upload: %v", err)
+		}
+		uploads = append(uploads, u)
+	}
+
+	if err := rows.Err(); err != nil {
+		logger.ErrorLogger.Printf("Failed to get unreferenced uploads: %v", err)
+		return nil, fmt.Errorf("failed to get unreferenced uploads: %v", err)
+	}
+
+	return uploads, nil
+}
+
+// DeleteUnreferencedUpload removes an upload and its variants, provided no
+// post has started using it and it has not been uploaded again within grace
+// since it was looked up. deleteFiles is called with the upload's URL and
+// variants before the transaction commits. Until then the uploads row is
+// locked and anyone tracking the same upload waits, so their files can't be
+// stored before these are deleted. It returns whether the upload was
+// removed.
+func DeleteUnreferencedUpload(db *sql.DB, hash string, grace time.Duration, deleteFiles func(url string, variants []ImageVariant) error) (bool, error) {
+	// Deleting the files from a remote store can take a while
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to begin upload transaction: %v", err)
+		return false, fmt.Errorf("failed to begin upload transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var url string
+	query := `DELETE FROM uploads
+		WHERE hash = ? AND ref_count = 0 AND unreferenced_at <= datetime('now', ?)
+		RETURNING url`
+	err = tx.QueryRowContext(ctx, query, hash, fmt.Sprintf("-%d seconds", int64(grace/time.Second))).Scan(&url)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to delete upload: %v", err)
+		return false, fmt.Errorf("failed to delete upload: %v", err)
+	}
+
+	rows, err := tx.QueryContext(ctx, "DELETE FROM image_variants WHERE image_url = ? RETURNING image_url, name, url, width, height", url)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to delete image variants: %v", err)
+		return false, fmt.Errorf("failed to delete image variants: %v", err)
+	}
+
+	var variants []ImageVariant
+	for rows.Next() {
+		var v ImageVariant
+		if err := rows.Scan(&v.ImageURL, &v.Name, &v.URL, &v.Width, &v.Height); err != nil {
+			rows.Close()
+			logger.ErrorLogger.Printf("Failed to scan image variant: %v", err)
+			return false, fmt.Errorf("failed to scan image variant: %v", err)
+		}
+		variants = append(variants, v)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		logger.ErrorLogger.Printf("Failed to delete image variants: %v", err)
+		return false, fmt.Errorf("failed to delete image variants: %v", err)
+	}
+
+	if err := deleteFiles(url, variants); err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		logger.ErrorLogger.Printf("Failed to commit upload deletion: %v", err)
+		return false, fmt.Errorf("failed to commit upload deletion: %v", err)
+	}
+
+	return true, nil
+}
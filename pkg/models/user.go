@@ -5,13 +5,22 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"forum/logger"
+	"forum/pkg/password"
+)
 
-	"golang.org/x/crypto/bcrypt"
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
 )
 
+// Roles lists every role a user can have, lowest privilege first.
+var Roles = []string{RoleUser, RoleModerator, RoleAdmin}
+
 type User struct {
 	ID             string    `json:"id"`
 	Name           string    `json:"name"`
@@ -19,6 +28,30 @@ type User struct {
 	HashedPassword []byte    `json:"hashed_password"`
 	CreatedAt      time.Time `json:"created_at"`
 	UpdatedAt      time.Time `json:"updated_at"`
+	Role           string    `json:"role"`
+	TOTPSecret     string    `json:"-"`
+	TOTPEnabled    bool      `json:"totp_enabled"`
+	TOTPLastStep   int64     `json:"-"`
+	// DeletionRequestedAt is set while the account waits to be deleted.
+	DeletionRequestedAt sql.NullTime `json:"-"`
+}
+
+func IsValidRole(role string) bool {
+	for _, r := range Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// IsStaff reports whether the user is a moderator or an admin.
+func (u User) IsStaff() bool {
+	return u.Role == RoleModerator || u.Role == RoleAdmin
+}
+
+func (u User) IsAdmin() bool {
+	return u.Role == RoleAdmin
 }
 
 func CreateUser(db *sql.DB, user User) (string, error) {
@@ -79,7 +112,10 @@ func GetUserByName(db *sql.DB, name string) (User, error) {
 	return user, nil
 }
 
-func AuthenticateUser(db *sql.DB, email, password string) (string, error) {
+// AuthenticateUser checks the password and, when it is right but the stored
+// hash uses an old algorithm or old parameters, replaces the hash with one
+// made by hasher.
+func AuthenticateUser(db *sql.DB, hasher password.Hasher, email, plaintext string) (string, error) {
 	context, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
@@ -88,6 +124,9 @@ func AuthenticateUser(db *sql.DB, email, password string) (string, error) {
 	err := db.QueryRowContext(context, query, email).Scan(&user.ID, &user.HashedPassword)
 	if err != nil {
 		if err == sql.ErrNoRows {
+			// Spend the same time as for a real account so response times
+			// don't tell which emails are registered.
+			password.Verify(plaintext, dummyHash(hasher))
 			logger.ErrorLogger.Printf("email not found: %v\n", err)
 			return "", errors.New("email not found")
 		}
@@ -95,10 +134,184 @@ func AuthenticateUser(db *sql.DB, email, password string) (string, error) {
 		return "", fmt.Errorf("error retrieving user from database: %v", err)
 	}
 
-	err = bcrypt.CompareHashAndPassword(user.HashedPassword, []byte(password))
-	if err != nil {
+	ok, err := password.Verify(plaintext, user.HashedPassword)
+	if err != nil && !errors.Is(err, password.ErrUnknownFormat) {
+		logger.ErrorLogger.Printf("error verifying password: %v\n", err)
+	}
+	if !ok {
 		logger.ErrorLogger.Println("incorrect password")
 		return "", errors.New("incorrect password")
 	}
+
+	if hasher.NeedsRehash(user.HashedPassword) {
+		if hashed, err := hasher.Hash(plaintext); err != nil {
+			logger.ErrorLogger.Printf("error rehashing password: %v\n", err)
+		} else if err := UpdatePasswordHash(db, user.ID, hashed); err == nil {
+			logger.InfoLogger.Printf("Upgraded password hash of user %s to %s\n", user.ID, hasher.Algorithm)
+		}
+	}
+
 	return user.ID, nil
 }
+
+var (
+	dummyHashOnce sync.Once
+	dummyHashed   []byte
+)
+
+func dummyHash(hasher password.Hasher) []byte {
+	dummyHashOnce.Do(func() {
+		dummyHashed, _ = hasher.Hash("dummy password")
+	})
+	return dummyHashed
+}
+
+func UpdatePasswordHash(db *sql.DB, userID string, hashed []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "UPDATE users SET hashed_password = ?, updated_at = ? WHERE id = ?"
+	if _, err := db.ExecContext(ctx, query, hashed, time.Now(), userID); err != nil {
+		logger.ErrorLogger.Printf("Failed to update password hash: %v", err)
+		return fmt.Errorf("failed to update password hash: %v", err)
+	}
+
+	return nil
+}
+
+func GetUserByID(db *sql.DB, id string) (User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var user User
+	query := "SELECT id, name, email, hashed_password, created_at, updated_at, role, totp_secret, totp_enabled, totp_last_step, deletion_requested_at FROM users WHERE id = ? LIMIT 1"
+	err := db.QueryRowContext(ctx, query, id).Scan(&user.ID, &user.Name, &user.Email, &user.HashedPassword, &user.CreatedAt, &user.UpdatedAt, &user.Role, &user.TOTPSecret, &user.TOTPEnabled, &user.TOTPLastStep, &user.DeletionRequestedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			logger.ErrorLogger.Printf("no user found with ID %s", id)
+			return User{}, fmt.Errorf("no user found with ID %s", id)
+		}
+		logger.ErrorLogger.Printf("Failed to get user by ID: %v", err)
+		return User{}, fmt.Errorf("failed to get user by ID: %v", err)
+	}
+
+	return user, nil
+}
+
+func SetUserRole(db *sql.DB, email, role string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if !IsValidRole(role) {
+		return fmt.Errorf("unknown role %q", role)
+	}
+
+	query := "UPDATE users SET role = ?, updated_at = ? WHERE email = ?"
+	result, err := db.ExecContext(ctx, query, role, time.Now(), email)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to set user role: %v", err)
+		return fmt.Errorf("failed to set user role: %v", err)
+	}
+
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("no user found with email %s", email)
+	}
+
+	return nil
+}
+
+// SetTOTPSecret stores a new secret for a user that is enrolling. Two-factor
+// stays disabled until the user confirms the secret with a valid code.
+func SetTOTPSecret(db *sql.DB, userID, secret string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "UPDATE users SET totp_secret = ?, totp_enabled = 0, totp_last_step = 0, updated_at = ? WHERE id = ?"
+	_, err := db.ExecContext(ctx, query, secret, time.Now(), userID)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to set totp secret: %v", err)
+		return fmt.Errorf("failed to set totp secret: %v", err)
+	}
+
+	return nil
+}
+
+func EnableTOTP(db *sql.DB, userID string, step int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "UPDATE users SET totp_enabled = 1, totp_last_step = ?, updated_at = ? WHERE id = ? AND totp_secret != ''"
+	_, err := db.ExecContext(ctx, query, step, time.Now(), userID)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to enable totp: %v", err)
+		return fmt.Errorf("failed to enable totp: %v", err)
+	}
+
+	return nil
+}
+
+func DisableTOTP(db *sql.DB, userID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "UPDATE users SET totp_secret = '', totp_enabled = 0, totp_last_step = 0, updated_at = ? WHERE id = ?"
+	_, err := db.ExecContext(ctx, query, time.Now(), userID)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to disable totp: %v", err)
+		return fmt.Errorf("failed to disable totp: %v", err)
+	}
+
+	return DeleteRecoveryCodes(db, userID)
+}
+
+// UseTOTPStep records that the code for step has been used. It returns false
+// when that step, or a later one, was already used so a code can't be replayed.
+func UseTOTPStep(db *sql.DB, userID string, step int64) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "UPDATE users SET totp_last_step = ? WHERE id = ? AND totp_last_step < ?"
+	result, err := db.ExecContext(ctx, query, step, userID, step)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to update totp step: %v", err)
+		return false, fmt.Errorf("failed to update totp step: %v", err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to update totp step: %v", err)
+	}
+
+	return n > 0, nil
+}
+
+// GetStaffUsers returns all moderators and admins.
+func GetStaffUsers(db *sql.DB) ([]User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "SELECT id, name, email, created_at, role, totp_enabled FROM users WHERE role IN (?, ?) ORDER BY role, name"
+	rows, err := db.QueryContext(ctx, query, RoleModerator, RoleAdmin)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to get staff users: %v", err)
+		return nil, fmt.Errorf("failed to get staff users: %v", err)
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(&user.ID, &user.Name, &user.Email, &user.CreatedAt, &user.Role, &user.TOTPEnabled); err != nil {
+			logger.ErrorLogger.Printf("Failed to scan user: %v", err)
+			return nil, fmt.Errorf("failed to scan user: %v", err)
+		}
+		users = append(users, user)
+	}
+
+	if err := rows.Err(); err != nil {
+		logger.ErrorLogger.Printf("Failed to iterate over users: %v", err)
+		return nil, fmt.Errorf("failed to iterate over users: %v", err)
+	}
+
+	return users, nil
+}
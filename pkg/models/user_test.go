@@ -0,0 +1,75 @@
+package models
+
+import (
+	"database/sql"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"forum/logger"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	if logger.ErrorLogger == nil {
+		logger.InfoLogger = log.New(io.Discard, "", 0)
+		logger.ErrorLogger = log.New(os.Stderr, "ERROR: ", 0)
+	}
+
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "forum.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	schema, err := os.ReadFile("sqlite/schema.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(string(schema)); err != nil {
+		t.Fatalf("creating schema: %v", err)
+	}
+	return db
+}
+
+func insertTestUser(t *testing.T, db *sql.DB, id string) {
+	t.Helper()
+
+	_, err := db.Exec("INSERT INTO users (id, name, email, hashed_password) VALUES (?, ?, ?, '')", id, id, id+"@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// A TOTP code can only be used once: its step, and any earlier one, is
+// refused after it has been used.
+func TestUseTOTPStep(t *testing.T) {
+	db := openTestDB(t)
+	insertTestUser(t, db, "alice")
+
+	tests := []struct {
+		step  int64
+		fresh bool
+	}{
+		{100, true},
+		{100, false}, // the same code again
+		{99, false},  // an older code within the skew window
+		{101, true},  // the next period's code
+		{101, false},
+	}
+
+	for _, tt := range tests {
+		fresh, err := UseTOTPStep(db, "alice", tt.step)
+		if err != nil {
+			t.Fatalf("UseTOTPStep(%d): %v", tt.step, err)
+		}
+		if fresh != tt.fresh {
+			t.Errorf("UseTOTPStep(%d) = %v, want %v", tt.step, fresh, tt.fresh)
+		}
+	}
+}
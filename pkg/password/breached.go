@@ -0,0 +1,86 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"io"
+	"os"
+	"strings"
+)
+
+//go:embed breached.txt
+var bundledBreached string
+
+// BreachedList holds passwords known from data breaches. Plain entries are
+// matched case-insensitively. Entries in the Have I Been Pwned download format,
+// "<SHA-1>:<count>", are matched against the exact password.
+type BreachedList struct {
+	plain map[string]bool
+	sha1  map[string]bool
+}
+
+// LoadBreachedList reads the bundled list and, if path is not empty, a
+// larger local list on top of it.
+func LoadBreachedList(path string) (*BreachedList, error) {
+	list := &BreachedList{plain: make(map[string]bool), sha1: make(map[string]bool)}
+
+	if err := list.read(strings.NewReader(bundledBreached)); err != nil {
+		return nil, err
+	}
+
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		if err := list.read(f); err != nil {
+			return nil, err
+		}
+	}
+
+	return list, nil
+}
+
+func (l *BreachedList) read(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if hash, _, found := strings.Cut(line, ":"); found && len(hash) == 2*sha1.Size {
+			if _, err := hex.DecodeString(hash); err == nil {
+				l.sha1[strings.ToUpper(hash)] = true
+				continue
+			}
+		}
+
+		l.plain[strings.ToLower(line)] = true
+	}
+
+	return scanner.Err()
+}
+
+// Contains reports whether password is on the list.
+func (l *BreachedList) Contains(password string) bool {
+	if l.plain[strings.ToLower(password)] {
+		return true
+	}
+
+	if len(l.sha1) == 0 {
+		return false
+	}
+
+	sum := sha1.Sum([]byte(password))
+	return l.sha1[strings.ToUpper(hex.EncodeToString(sum[:]))]
+}
+
+// Len returns the number of entries on the list.
+func (l *BreachedList) Len() int {
+	return len(l.plain) + len(l.sha1)
+}
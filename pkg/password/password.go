@@ -0,0 +1,175 @@
+// Package password hashes and verifies passwords. Hashes are stored encoded
+// with their algorithm and parameters, so they can be upgraded when the
+// configuration changes.
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Supported algorithms.
+const (
+	Argon2id = "argon2id"
+	Bcrypt   = "bcrypt"
+)
+
+var ErrUnknownFormat = errors.New("password: unknown hash format")
+
+// Argon2Params are the argon2id cost parameters. Memory is in KiB.
+type Argon2Params struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2Params follow the OWASP recommendation for argon2id.
+var DefaultArgon2Params = Argon2Params{
+	Memory:      19 * 1024,
+	Iterations:  2,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// Hasher hashes new passwords with Algorithm.
+type Hasher struct {
+	Algorithm  string
+	Argon2     Argon2Params
+	BcryptCost int
+}
+
+// DefaultHasher uses argon2id with the default parameters.
+var DefaultHasher = Hasher{
+	Algorithm:  Argon2id,
+	Argon2:     DefaultArgon2Params,
+	BcryptCost: 12,
+}
+
+// Hash returns the encoded hash of password.
+func (h Hasher) Hash(password string) ([]byte, error) {
+	switch h.Algorithm {
+	case Argon2id:
+		salt := make([]byte, h.Argon2.SaltLength)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+		key := argon2.IDKey([]byte(password), salt, h.Argon2.Iterations, h.Argon2.Memory, h.Argon2.Parallelism, h.Argon2.KeyLength)
+		return []byte(encodeArgon2(h.Argon2, salt, key)), nil
+
+	case Bcrypt:
+		return bcrypt.GenerateFromPassword([]byte(password), h.BcryptCost)
+
+	default:
+		return nil, fmt.Errorf("password: unsupported algorithm %q", h.Algorithm)
+	}
+}
+
+// NeedsRehash reports whether encoded was made with a different algorithm or
+// different parameters than h would use now.
+func (h Hasher) NeedsRehash(encoded []byte) bool {
+	switch {
+	case isArgon2(encoded):
+		if h.Algorithm != Argon2id {
+			return true
+		}
+		params, salt, key, err := decodeArgon2(string(encoded))
+		if err != nil {
+			return true
+		}
+		return params.Memory != h.Argon2.Memory || params.Iterations != h.Argon2.Iterations ||
+			params.Parallelism != h.Argon2.Parallelism || uint32(len(salt)) != h.Argon2.SaltLength ||
+			uint32(len(key)) != h.Argon2.KeyLength
+
+	case isBcrypt(encoded):
+		if h.Algorithm != Bcrypt {
+			return true
+		}
+		cost, err := bcrypt.Cost(encoded)
+		return err != nil || cost != h.BcryptCost
+
+	default:
+		return true
+	}
+}
+
+// Verify reports whether password matches encoded, whichever supported
+// algorithm it was hashed with.
+func Verify(password string, encoded []byte) (bool, error) {
+	switch {
+	case isArgon2(encoded):
+		params, salt, key, err := decodeArgon2(string(encoded))
+		if err != nil {
+			return false, err
+		}
+		other := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+		return subtle.ConstantTimeCompare(key, other) == 1, nil
+
+	case isBcrypt(encoded):
+		err := bcrypt.CompareHashAndPassword(encoded, []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		return err == nil, err
+
+	default:
+		return false, ErrUnknownFormat
+	}
+}
+
+func isArgon2(encoded []byte) bool {
+	return strings.HasPrefix(string(encoded), "$argon2id$")
+}
+
+func isBcrypt(encoded []byte) bool {
+	s := string(encoded)
+	return strings.HasPrefix(s, "$2a$") || strings.HasPrefix(s, "$2b$") || strings.HasPrefix(s, "$2y$")
+}
+
+// encodeArgon2 uses the PHC string format, the same as the reference implementation:
+// $argon2id$v=19$m=19456,t=2,p=1$<salt>$<key>
+func encodeArgon2(params Argon2Params, salt, key []byte) string {
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, params.Memory, params.Iterations, params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+}
+
+func decodeArgon2(encoded string) (Argon2Params, []byte, []byte, error) {
+	var params Argon2Params
+
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return params, nil, nil, ErrUnknownFormat
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("password: unsupported argon2 version %q", parts[2])
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return params, nil, nil, fmt.Errorf("password: invalid argon2 parameters %q", parts[3])
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("password: invalid argon2 salt: %v", err)
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, fmt.Errorf("password: invalid argon2 key")
+	}
+
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+	return params, salt, key, nil
+}
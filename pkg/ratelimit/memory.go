@@ -0,0 +1,64 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const pruneInterval = time.Minute
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	full   time.Time
+}
+
+// MemoryStore keeps buckets in memory. The limits only apply to one server.
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastPrune time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]*bucket)}
+}
+
+func (s *MemoryStore) Take(ctx context.Context, key string, policy Policy, now time.Time) (bool, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.prune(now)
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(policy.Burst), last: now}
+		s.buckets[key] = b
+	}
+
+	b.tokens = refill(policy, b.tokens, b.last, now)
+	b.last = now
+
+	if b.tokens < 1 {
+		return false, wait(policy, b.tokens), nil
+	}
+
+	b.tokens--
+	b.full = now.Add(time.Duration((float64(policy.Burst) - b.tokens) / policy.Rate * float64(time.Second)))
+	return true, 0, nil
+}
+
+// prune forgets buckets that have filled up again, as they are the same as new ones.
+func (s *MemoryStore) prune(now time.Time) {
+	if now.Sub(s.lastPrune) < pruneInterval {
+		return
+	}
+	s.lastPrune = now
+
+	for key, b := range s.buckets {
+		if now.After(b.full) {
+			delete(s.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+// Package ratelimit implements token-bucket rate limiting with pluggable
+// storage for the buckets.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Policy allows Burst requests at once, refilled at Rate requests per second.
+// A policy with a zero Burst does not limit anything.
+type Policy struct {
+	Name  string
+	Rate  float64
+	Burst int
+}
+
+// PerPeriod returns a policy allowing n requests per period, all of which may
+// be used in a burst.
+func PerPeriod(name string, n int, period time.Duration) Policy {
+	return Policy{Name: name, Rate: float64(n) / period.Seconds(), Burst: n}
+}
+
+// ParsePolicy parses "<requests>/<period>" such as "10/1m", optionally
+// followed by ",<burst>". "off" disables the policy.
+func ParsePolicy(name, value string) (Policy, error) {
+	value = strings.TrimSpace(value)
+	if value == "off" {
+		return Policy{Name: name}, nil
+	}
+
+	spec, burstSpec, hasBurst := strings.Cut(value, ",")
+
+	countSpec, periodSpec, ok := strings.Cut(spec, "/")
+	if !ok {
+		return Policy{}, fmt.Errorf("ratelimit: policy %s: expected <requests>/<period>, got %q", name, value)
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(countSpec))
+	if err != nil || n <= 0 {
+		return Policy{}, fmt.Errorf("ratelimit: policy %s: invalid request count %q", name, countSpec)
+	}
+
+	period, err := time.ParseDuration(strings.TrimSpace(periodSpec))
+	if err != nil || period <= 0 {
+		return Policy{}, fmt.Errorf("ratelimit: policy %s: invalid period %q", name, periodSpec)
+	}
+
+	policy := PerPeriod(name, n, period)
+	if hasBurst {
+		burst, err := strconv.Atoi(strings.TrimSpace(burstSpec))
+		if err != nil || burst <= 0 {
+			return Policy{}, fmt.Errorf("ratelimit: policy %s: invalid burst %q", name, burstSpec)
+		}
+		policy.Burst = burst
+	}
+
+	return policy, nil
+}
+
+// Disabled reports whether the policy lets every request through.
+func (p Policy) Disabled() bool {
+	return p.Burst <= 0 || p.Rate <= 0
+}
+
+// Store keeps the token buckets. Implementations must make Take atomic so
+// that several servers sharing a store also share the limits.
+type Store interface {
+	// Take removes a token from the bucket for key, refilling it for the
+	// time passed since the last call. When the bucket is empty it returns
+	// false and how long until the next token.
+	Take(ctx context.Context, key string, policy Policy, now time.Time) (bool, time.Duration, error)
+}
+
+// refill returns the tokens in a bucket that had tokens at last and is
+// refilled up to the policy's burst.
+func refill(policy Policy, tokens float64, last, now time.Time) float64 {
+	elapsed := now.Sub(last).Seconds()
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	return math.Min(float64(policy.Burst), tokens+elapsed*policy.Rate)
+}
+
+// wait is how long until a bucket holding tokens has a whole token again.
+func wait(policy Policy, tokens float64) time.Duration {
+	return time.Duration((1 - tokens) / policy.Rate * float64(time.Second))
+}
+
+// fullAfter is how long an untouched bucket needs to fill up, after which it
+// can be forgotten.
+func fullAfter(policy Policy) time.Duration {
+	return time.Duration(float64(policy.Burst) / policy.Rate * float64(time.Second))
+}
+
+// Limiter checks requests against policies.
+type Limiter struct {
+	store Store
+}
+
+func New(store Store) *Limiter {
+	return &Limiter{store: store}
+}
+
+// Allow takes a token for key under policy. It returns whether the request
+// may proceed and, if not, how long the client should wait.
+func (l *Limiter) Allow(ctx context.Context, policy Policy, key string) (bool, time.Duration, error) {
+	if policy.Disabled() {
+		return true, 0, nil
+	}
+	return l.store.Take(ctx, policy.Name+":"+key, policy, time.Now())
+}
@@ -0,0 +1,69 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// SQLStore keeps buckets in the rate_limits table, so servers sharing the
+// database share the limits. Each Take is a single atomic statement.
+type SQLStore struct {
+	db *sql.DB
+
+	mu        sync.Mutex
+	lastPrune time.Time
+	maxFull   time.Duration
+}
+
+func NewSQLStore(db *sql.DB) *SQLStore {
+	return &SQLStore{db: db}
+}
+
+func (s *SQLStore) Take(ctx context.Context, key string, policy Policy, now time.Time) (bool, time.Duration, error) {
+	s.prune(ctx, policy, now)
+
+	// SET expressions all see the old row, so allowed is decided on the
+	// refilled token count before this request's token is taken.
+	query := `INSERT INTO rate_limits (key, tokens, updated_at, allowed) VALUES (?1, ?2 - 1, ?3, TRUE)
+		ON CONFLICT(key) DO UPDATE SET
+			tokens = MIN(?2, tokens + MAX(?3 - updated_at, 0) * ?4)
+				- (MIN(?2, tokens + MAX(?3 - updated_at, 0) * ?4) >= 1),
+			allowed = MIN(?2, tokens + MAX(?3 - updated_at, 0) * ?4) >= 1,
+			updated_at = ?3
+		RETURNING tokens, allowed`
+
+	var (
+		tokens  float64
+		allowed bool
+	)
+	nowSeconds := float64(now.UnixNano()) / float64(time.Second)
+	err := s.db.QueryRowContext(ctx, query, key, float64(policy.Burst), nowSeconds, policy.Rate).Scan(&tokens, &allowed)
+	if err != nil {
+		return true, 0, fmt.Errorf("ratelimit: failed to take token: %v", err)
+	}
+
+	if !allowed {
+		return false, wait(policy, tokens), nil
+	}
+	return true, 0, nil
+}
+
+// prune deletes buckets that have been idle long enough to be full again.
+func (s *SQLStore) prune(ctx context.Context, policy Policy, now time.Time) {
+	s.mu.Lock()
+	if full := fullAfter(policy); full > s.maxFull {
+		s.maxFull = full
+	}
+	if now.Sub(s.lastPrune) < pruneInterval {
+		s.mu.Unlock()
+		return
+	}
+	s.lastPrune = now
+	cutoff := float64(now.Add(-s.maxFull).UnixNano()) / float64(time.Second)
+	s.mu.Unlock()
+
+	s.db.ExecContext(ctx, "DELETE FROM rate_limits WHERE updated_at < ?", cutoff)
+}
@@ -0,0 +1,290 @@
+// Package spam scores new posts and comments so that likely spam can be held
+// for review instead of being published.
+package spam
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// DefaultThreshold is the score at which content is held for review.
+const DefaultThreshold = 10
+
+// Scores added by each signal.
+const (
+	blockedScore        = 10 // per blocked word or domain
+	newAccountLinkScore = 4  // per link from an account younger than NewAccountAge
+	youngLinkScore      = 2  // per link past the first from an account younger than YoungAccountAge
+	extraLinkScore      = 1  // per link past MaxLinks from any account
+	ownDuplicateScore   = 6  // the author posted the same text recently
+	otherDuplicateScore = 8  // another account posted the same text recently
+	velocityScore       = 3  // per item past VelocityLimit within VelocityWindow
+)
+
+// minDuplicateLength keeps short replies such as "thanks!" from counting as
+// duplicates.
+const minDuplicateLength = 20
+
+var linkPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s<>"']+`)
+
+// linkTrailers are left off the end of a link: the closing bracket of a
+// Markdown link or the punctuation ending a sentence.
+const linkTrailers = `).,;:!?]'"`
+
+// Rules configures the checks.
+type Rules struct {
+	Threshold       int
+	NewAccountAge   time.Duration
+	YoungAccountAge time.Duration
+	MaxLinks        int
+	DuplicateWindow time.Duration
+	VelocityWindow  time.Duration
+	VelocityLimit   int
+	BlockedWords    []string
+	BlockedDomains  []string
+}
+
+// DefaultRules returns the rules used when nothing is configured.
+func DefaultRules() Rules {
+	return Rules{
+		Threshold:       DefaultThreshold,
+		NewAccountAge:   24 * time.Hour,
+		YoungAccountAge: 7 * 24 * time.Hour,
+		MaxLinks:        5,
+		DuplicateWindow: 24 * time.Hour,
+		VelocityWindow:  10 * time.Minute,
+		VelocityLimit:   5,
+	}
+}
+
+// Recent is a post or comment made before the one being scored.
+type Recent struct {
+	UserID    string
+	Text      string
+	CreatedAt time.Time
+}
+
+// Submission is the content being scored along with what is known about its
+// author.
+type Submission struct {
+	UserID         string
+	Text           string
+	AuthorJoinedAt time.Time
+	// Recent holds posts and comments by every user within the duplicate
+	// and velocity windows.
+	Recent []Recent
+}
+
+// Result is the outcome of scoring a submission.
+type Result struct {
+	Score   int
+	Reasons []string
+}
+
+func (r *Result) add(score int, reason string) {
+	r.Score += score
+	r.Reasons = append(r.Reasons, reason)
+}
+
+// Held reports whether the content should wait for a moderator.
+func (rules Rules) Held(result Result) bool {
+	return result.Score >= rules.Threshold
+}
+
+// Score runs every check against the submission.
+func (rules Rules) Score(s Submission, now time.Time) Result {
+	var result Result
+
+	rules.checkLinks(&result, s, now)
+	rules.checkDuplicates(&result, s, now)
+	rules.checkVelocity(&result, s, now)
+	rules.checkBlocked(&result, s)
+
+	return result
+}
+
+func (rules Rules) checkLinks(result *Result, s Submission, now time.Time) {
+	links := len(findLinks(s.Text))
+	if links == 0 {
+		return
+	}
+
+	age := now.Sub(s.AuthorJoinedAt)
+	switch {
+	case age < rules.NewAccountAge:
+		result.add(links*newAccountLinkScore, fmt.Sprintf("%d link(s) from an account less than %s old", links, formatAge(rules.NewAccountAge)))
+	case age < rules.YoungAccountAge && links > 1:
+		result.add((links-1)*youngLinkScore, fmt.Sprintf("%d links from an account less than %s old", links, formatAge(rules.YoungAccountAge)))
+	case links > rules.MaxLinks:
+		result.add((links-rules.MaxLinks)*extraLinkScore, fmt.Sprintf("%d links", links))
+	}
+}
+
+func (rules Rules) checkDuplicates(result *Result, s Submission, now time.Time) {
+	text := normalize(s.Text)
+	if len(text) < minDuplicateLength {
+		return
+	}
+
+	var own, other bool
+	for _, recent := range s.Recent {
+		if now.Sub(recent.CreatedAt) > rules.DuplicateWindow || normalize(recent.Text) != text {
+			continue
+		}
+		if recent.UserID == s.UserID {
+			own = true
+		} else {
+			other = true
+		}
+	}
+
+	if own {
+		result.add(ownDuplicateScore, "same text posted recently by the author")
+	}
+	if other {
+		result.add(otherDuplicateScore, "same text posted recently by another account")
+	}
+}
+
+func (rules Rules) checkVelocity(result *Result, s Submission, now time.Time) {
+	if rules.VelocityLimit <= 0 {
+		return
+	}
+
+	count := 0
+	for _, recent := range s.Recent {
+		if recent.UserID == s.UserID && now.Sub(recent.CreatedAt) <= rules.VelocityWindow {
+			count++
+		}
+	}
+
+	if count >= rules.VelocityLimit {
+		result.add((count-rules.VelocityLimit+1)*velocityScore, fmt.Sprintf("%d posts and comments in the last %s", count+1, formatAge(rules.VelocityWindow)))
+	}
+}
+
+func (rules Rules) checkBlocked(result *Result, s Submission) {
+	text := strings.ToLower(s.Text)
+	words := " " + normalize(s.Text) + " "
+
+	for _, word := range rules.BlockedWords {
+		if strings.Contains(words, " "+word+" ") {
+			result.add(blockedScore, fmt.Sprintf("blocked word %q", word))
+		}
+	}
+
+	if len(rules.BlockedDomains) == 0 {
+		return
+	}
+	for _, link := range findLinks(text) {
+		host := linkHost(link)
+		for _, domain := range rules.BlockedDomains {
+			if host == domain || strings.HasSuffix(host, "."+domain) {
+				result.add(blockedScore, fmt.Sprintf("link to blocked domain %s", domain))
+			}
+		}
+	}
+}
+
+// findLinks returns the links in text without any trailing punctuation.
+func findLinks(text string) []string {
+	var links []string
+	for _, link := range linkPattern.FindAllString(text, -1) {
+		if link = strings.TrimRight(link, linkTrailers); link != "" {
+			links = append(links, link)
+		}
+	}
+	return links
+}
+
+func linkHost(link string) string {
+	if !strings.Contains(link, "://") {
+		link = "http://" + link
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+	// example.com. is the same host as example.com
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	return strings.TrimPrefix(host, "www.")
+}
+
+// normalize lowercases text and reduces it to words separated by single
+// spaces, so that small changes in punctuation or spacing still match.
+func normalize(text string) string {
+	fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r > 127)
+	})
+	return strings.Join(fields, " ")
+}
+
+func formatAge(d time.Duration) string {
+	if d%(24*time.Hour) == 0 {
+		days := int(d / (24 * time.Hour))
+		if days == 1 {
+			return "a day"
+		}
+		return fmt.Sprintf("%d days", days)
+	}
+	return d.String()
+}
+
+// AddBlocked adds comma separated words and domains to the block list.
+func (rules *Rules) AddBlocked(words, domains string) {
+	for _, word := range strings.Split(words, ",") {
+		rules.addWord(word)
+	}
+	for _, domain := range strings.Split(domains, ",") {
+		rules.addDomain(domain)
+	}
+}
+
+// LoadBlocklist adds the entries of a block list file: one word or phrase per
+// line, or "domain:example.com" for a domain. Lines starting with # are
+// comments.
+func (rules *Rules) LoadBlocklist(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return rules.readBlocklist(f)
+}
+
+func (rules *Rules) readBlocklist(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "domain:") {
+			rules.addDomain(strings.TrimPrefix(line, "domain:"))
+		} else {
+			rules.addWord(line)
+		}
+	}
+	return scanner.Err()
+}
+
+func (rules *Rules) addWord(word string) {
+	if word = normalize(word); word != "" {
+		rules.BlockedWords = append(rules.BlockedWords, word)
+	}
+}
+
+func (rules *Rules) addDomain(domain string) {
+	domain = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(domain)), "www.")
+	if domain != "" {
+		rules.BlockedDomains = append(rules.BlockedDomains, domain)
+	}
+}
@@ -0,0 +1,150 @@
+package spam
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFindLinks(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"no links here", nil},
+		{"see https://example.com/a?b=c", []string{"https://example.com/a?b=c"}},
+		{"see https://example.com.", []string{"https://example.com"}},
+		{"[x](https://example.com)", []string{"https://example.com"}},
+		{"(www.example.com), http://b.example!", []string{"www.example.com", "http://b.example"}},
+		{`<a href="https://example.com">`, []string{"https://example.com"}},
+		{"HTTPS://EXAMPLE.COM and http://x.example/y", []string{"HTTPS://EXAMPLE.COM", "http://x.example/y"}},
+	}
+
+	for _, tt := range tests {
+		if got := findLinks(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("findLinks(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestBlockedDomains(t *testing.T) {
+	var rules Rules
+	rules.AddBlocked("", "spam.com, WWW.Junk.example")
+
+	tests := []struct {
+		text    string
+		blocked bool
+	}{
+		{"visit https://spam.com", true},
+		{"visit https://SPAM.com/offer", true},
+		{"visit www.spam.com", true},
+		{"visit https://shop.spam.com/x", true},
+		{"[cheap](https://spam.com)", true},
+		{"[cheap](https://spam.com/offer)", true},
+		{"see https://spam.com.", true},
+		{"see https://spam.com!", true},
+		{"see https://spam.com./x", true},
+		{"(https://junk.example)", true},
+		{"visit https://notspam.com", false},
+		{"visit https://spam.com.evil.example", false},
+		{"spam.com without a scheme", false},
+	}
+
+	for _, tt := range tests {
+		var result Result
+		rules.checkBlocked(&result, Submission{Text: tt.text})
+		if got := result.Score > 0; got != tt.blocked {
+			t.Errorf("checkBlocked(%q) blocked = %v, want %v (%v)", tt.text, got, tt.blocked, result.Reasons)
+		}
+	}
+}
+
+func TestBlockedWords(t *testing.T) {
+	var rules Rules
+	rules.AddBlocked("casino, Free Money", "")
+
+	tests := []struct {
+		text  string
+		score int
+	}{
+		{"best CASINO in town", blockedScore},
+		{"get free, money now", blockedScore},
+		{"casinos are not the word", 0},
+		{"casino and free money", 2 * blockedScore},
+	}
+
+	for _, tt := range tests {
+		var result Result
+		rules.checkBlocked(&result, Submission{Text: tt.text})
+		if result.Score != tt.score {
+			t.Errorf("checkBlocked(%q) = %d, want %d", tt.text, result.Score, tt.score)
+		}
+	}
+}
+
+func TestLinkScores(t *testing.T) {
+	rules := DefaultRules()
+	now := time.Now()
+	links := func(n int) string {
+		text := ""
+		for i := 0; i < n; i++ {
+			text += "https://example.com/" + string(rune('a'+i)) + ". "
+		}
+		return text
+	}
+
+	tests := []struct {
+		name  string
+		age   time.Duration
+		links int
+		score int
+	}{
+		{"new account, no links", time.Hour, 0, 0},
+		{"new account", time.Hour, 2, 2 * newAccountLinkScore},
+		{"young account, one link", 2 * 24 * time.Hour, 1, 0},
+		{"young account", 2 * 24 * time.Hour, 3, 2 * youngLinkScore},
+		{"old account", 30 * 24 * time.Hour, 5, 0},
+		{"old account, many links", 30 * 24 * time.Hour, 7, 2 * extraLinkScore},
+	}
+
+	for _, tt := range tests {
+		var result Result
+		rules.checkLinks(&result, Submission{Text: links(tt.links), AuthorJoinedAt: now.Add(-tt.age)}, now)
+		if result.Score != tt.score {
+			t.Errorf("%s: score = %d, want %d (%v)", tt.name, result.Score, tt.score, result.Reasons)
+		}
+	}
+}
+
+func TestThreshold(t *testing.T) {
+	rules := DefaultRules()
+	rules.AddBlocked("", "spam.com")
+	now := time.Now()
+	old := now.Add(-30 * 24 * time.Hour)
+
+	tests := []struct {
+		name string
+		s    Submission
+		held bool
+	}{
+		{"ordinary post", Submission{UserID: "a", Text: "What is everyone reading?", AuthorJoinedAt: old}, false},
+		{"blocked domain", Submission{UserID: "a", Text: "[deal](https://spam.com)", AuthorJoinedAt: old}, true},
+		{"links from a new account", Submission{UserID: "a", Text: "https://a.example https://b.example https://c.example", AuthorJoinedAt: now}, true},
+		{"one link from a new account", Submission{UserID: "a", Text: "https://a.example", AuthorJoinedAt: now}, false},
+		{"text another account posted", Submission{
+			UserID: "a", Text: "Buy followers cheap at our site today", AuthorJoinedAt: old,
+			Recent: []Recent{{UserID: "b", Text: "buy followers, cheap at our site today!", CreatedAt: now.Add(-time.Hour)}},
+		}, false},
+	}
+
+	for _, tt := range tests {
+		result := rules.Score(tt.s, now)
+		if got := rules.Held(result); got != tt.held {
+			t.Errorf("%s: held = %v with score %d, want %v (%v)", tt.name, got, result.Score, tt.held, result.Reasons)
+		}
+	}
+
+	if !rules.Held(Result{Score: rules.Threshold}) || rules.Held(Result{Score: rules.Threshold - 1}) {
+		t.Errorf("Held does not hold at exactly the threshold of %d", rules.Threshold)
+	}
+}
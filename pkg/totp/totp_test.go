@@ -0,0 +1,138 @@
+package totp
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 key of RFC 6238 appendix B, base32 encoded.
+var rfcSecret = encoding.EncodeToString([]byte("12345678901234567890"))
+
+// The RFC 6238 SHA-1 test vectors. The RFC gives 8 digits, authenticator
+// apps show the last 6.
+var rfcVectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "287082"},          // 94287082
+	{1111111109, "081804"},  // 07081804
+	{1111111111, "050471"},  // 14050471
+	{1234567890, "005924"},  // 89005924
+	{2000000000, "279037"},  // 69279037
+	{20000000000, "353130"}, // 65353130
+}
+
+func TestCode(t *testing.T) {
+	for _, v := range rfcVectors {
+		got, err := Code(rfcSecret, Step(time.Unix(v.unix, 0)))
+		if err != nil || got != v.code {
+			t.Errorf("Code at T=%d = %q, %v, want %q", v.unix, got, err, v.code)
+		}
+	}
+}
+
+func TestCodeSecretFormat(t *testing.T) {
+	step := Step(time.Unix(59, 0))
+	for _, secret := range []string{strings.ToLower(rfcSecret), " " + rfcSecret + "\n"} {
+		if got, err := Code(secret, step); err != nil || got != "287082" {
+			t.Errorf("Code(%q) = %q, %v, want 287082", secret, got, err)
+		}
+	}
+	if _, err := Code("not base32!", step); err == nil {
+		t.Error("Code accepted an invalid secret")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, v := range rfcVectors {
+		now := time.Unix(v.unix, 0)
+		step, ok := Validate(rfcSecret, v.code, now)
+		if !ok || step != Step(now) {
+			t.Errorf("Validate(%q) at T=%d = %d, %v, want step %d", v.code, v.unix, step, ok, Step(now))
+		}
+	}
+
+	now := time.Unix(1234567890, 0)
+	for _, code := range []string{"005 924", " 005924 "} {
+		if _, ok := Validate(rfcSecret, code, now); !ok {
+			t.Errorf("Validate(%q) refused a code with spaces", code)
+		}
+	}
+	for _, code := range []string{"", "005925", "05924", "0059240", "abcdef"} {
+		if _, ok := Validate(rfcSecret, code, now); ok {
+			t.Errorf("Validate(%q) accepted a wrong code", code)
+		}
+	}
+}
+
+// Codes from one period either side of now are accepted, to allow for clock
+// drift, and report the step they belong to.
+func TestValidateSkew(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	current := Step(now)
+
+	for offset := int64(-3); offset <= 3; offset++ {
+		code, err := Code(rfcSecret, current+offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		step, ok := Validate(rfcSecret, code, now)
+		if want := offset >= -Skew && offset <= Skew; ok != want {
+			t.Errorf("code for step %+d: accepted = %v, want %v", offset, ok, want)
+			continue
+		}
+		if ok && step != current+offset {
+			t.Errorf("code for step %+d matched step %d, want %d", offset, step, current+offset)
+		}
+	}
+}
+
+// A code keeps matching the same step while it is valid, which is what lets
+// callers refuse it a second time with models.UseTOTPStep.
+func TestValidateSameStepForReusedCode(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	code, err := Code(rfcSecret, Step(now))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, ok := Validate(rfcSecret, code, now)
+	if !ok {
+		t.Fatal("code refused")
+	}
+	again, ok := Validate(rfcSecret, code, now.Add(Period))
+	if !ok || again != first {
+		t.Errorf("code used a period later matched step %d, %v, want %d", again, ok, first)
+	}
+}
+
+func TestURI(t *testing.T) {
+	u, err := url.Parse(URI("Forum", "alice@example.com", rfcSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/Forum:alice@example.com" {
+		t.Errorf("URI = %s, want otpauth://totp/Forum:alice@example.com", u)
+	}
+
+	query := u.Query()
+	for key, want := range map[string]string{"secret": rfcSecret, "issuer": "Forum", "algorithm": "SHA1", "digits": "6", "period": "30"} {
+		if got := query.Get(key); got != want {
+			t.Errorf("URI %s = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := encoding.DecodeString(secret)
+	if err != nil || len(key) != 20 {
+		t.Errorf("secret %q decodes to %d bytes, %v, want 20", secret, len(key), err)
+	}
+}
@@ -0,0 +1,65 @@
+package uploads
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"path"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ErrUnsupportedDocument is returned for files that are neither an accepted
+// image nor an accepted document.
+var ErrUnsupportedDocument = errors.New("unsupported document type")
+
+// documentTypes is the allowlist of documents that can be attached to posts,
+// by the extension they are stored with.
+var documentTypes = map[string]string{
+	".pdf": "application/pdf",
+	".txt": "text/plain; charset=utf-8",
+}
+
+// IsDocument reports whether the upload name is a document rather than an
+// image.
+func IsDocument(name string) bool {
+	_, ok := documentTypes[path.Ext(name)]
+	return ok
+}
+
+// SniffDocument returns the extension for a document from its content, or
+// ErrUnsupportedDocument if it is not on the allowlist. Plain text must be
+// valid UTF-8 and not look like HTML, SVG or XML to a browser.
+func SniffDocument(data []byte) (string, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte("%PDF-")):
+		return ".pdf", nil
+	case len(data) > 0 && utf8.Valid(data) && bytes.IndexByte(data, 0) < 0 &&
+		strings.HasPrefix(http.DetectContentType(data), "text/plain"):
+		return ".txt", nil
+	}
+	return "", ErrUnsupportedDocument
+}
+
+// CleanFileName makes a file name supplied by the browser safe to show and to
+// offer as a download name, with the extension the document is stored with.
+func CleanFileName(name, extension string) string {
+	// Browsers may send a full path
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == '"' || r == '/' {
+			return -1
+		}
+		return r
+	}, name)
+
+	name = strings.TrimSpace(strings.TrimSuffix(name, path.Ext(name)))
+	if name == "" || name == "." {
+		name = "attachment"
+	}
+	if runes := []rune(name); len(runes) > 100 {
+		name = string(runes[:100])
+	}
+	return name + extension
+}
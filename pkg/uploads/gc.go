@@ -0,0 +1,136 @@
+package uploads
+
+import (
+	"context"
+	"database/sql"
+	"path"
+	"strings"
+	"time"
+
+	"forum/pkg/blobstore"
+	"forum/pkg/models"
+)
+
+// DefaultGrace is how long an upload is kept after its last post goes away,
+// or after it was uploaded without a post being made.
+const DefaultGrace = 24 * time.Hour
+
+// Track records an upload so that it is counted and eventually collected
+// once no post uses it. It must be called before the upload is stored, see
+// collectUpload.
+func Track(db *sql.DB, name string, data []byte) error {
+	return models.TrackUpload(db, models.Upload{
+		Hash:     strings.TrimSuffix(name, path.Ext(name)),
+		URL:      URL(name),
+		Size:     int64(len(data)),
+		MimeType: ContentType(name),
+	})
+}
+
+// Report describes what a collection did, or would do in a dry run.
+type Report struct {
+	// Untracked are uploads found in the store with no uploads row, such as
+	// those stored before uploads were tracked. They are tracked from now on
+	// and only collected once their grace period has passed.
+	Untracked []models.Upload
+	// Collected are the unreferenced uploads past their grace period.
+	Collected []models.Upload
+	// Bytes is the size of the collected originals.
+	Bytes int64
+}
+
+// Collect deletes uploads that no post has used for at least grace, along
+// with their variants. With dryRun nothing is changed.
+func Collect(db *sql.DB, store blobstore.BlobStore, grace time.Duration, dryRun bool) (Report, error) {
+	var report Report
+
+	err := store.List(context.Background(), "", func(key string) error {
+		name := path.Base(key)
+		if !ValidName(name) || key != Key(name) || strings.Contains(name, "-") {
+			// Variants go with their original
+			return nil
+		}
+
+		hash := strings.TrimSuffix(name, path.Ext(name))
+		tracked, err := models.IsUploadTracked(db, hash)
+		if err != nil || tracked {
+			return err
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+		defer cancel()
+
+		info, err := store.Stat(ctx, key)
+		if err != nil {
+			return err
+		}
+
+		upload := models.Upload{
+			Hash:     hash,
+			URL:      URL(name),
+			Size:     info.Size,
+			MimeType: ContentType(name),
+		}
+		if upload.RefCount, err = models.CountUploadReferences(db, upload.URL); err != nil {
+			return err
+		}
+		report.Untracked = append(report.Untracked, upload)
+
+		if dryRun {
+			return nil
+		}
+		return models.TrackUpload(db, upload)
+	})
+	if err != nil {
+		return report, err
+	}
+
+	unreferenced, err := models.GetUnreferencedUploads(db, grace)
+	if err != nil {
+		return report, err
+	}
+
+	for _, upload := range unreferenced {
+		if dryRun {
+			report.Collected = append(report.Collected, upload)
+			report.Bytes += upload.Size
+			continue
+		}
+
+		deleted, err := collectUpload(db, store, upload, grace)
+		if err != nil {
+			return report, err
+		}
+		if !deleted {
+			// A post started using it in the meantime, or it was uploaded again
+			continue
+		}
+
+		report.Collected = append(report.Collected, upload)
+		report.Bytes += upload.Size
+	}
+
+	return report, nil
+}
+
+// collectUpload deletes an unreferenced upload and its variants. The files
+// are deleted while the uploads row is locked, before its deletion commits,
+// so storing the same upload again, which tracks it first, either stops the
+// collection or waits for it to finish.
+func collectUpload(db *sql.DB, store blobstore.BlobStore, upload models.Upload, grace time.Duration) (bool, error) {
+	return models.DeleteUnreferencedUpload(db, upload.Hash, grace, func(url string, variants []models.ImageVariant) error {
+		keys := []string{Key(path.Base(url))}
+		for _, v := range variants {
+			keys = append(keys, Key(path.Base(v.URL)))
+		}
+		for _, key := range keys {
+			ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+			err := store.Delete(ctx, key)
+			cancel()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
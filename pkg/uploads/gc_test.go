@@ -0,0 +1,211 @@
+package uploads
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"forum/logger"
+	"forum/pkg/blobstore"
+	"forum/pkg/models"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const testGrace = 24 * time.Hour
+
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	if logger.ErrorLogger == nil {
+		logger.InfoLogger = log.New(io.Discard, "", 0)
+		logger.ErrorLogger = log.New(os.Stderr, "ERROR: ", 0)
+	}
+
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "forum.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	schema, err := os.ReadFile("../models/sqlite/schema.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(string(schema)); err != nil {
+		t.Fatalf("creating schema: %v", err)
+	}
+	return db
+}
+
+// storeUpload stores an image the way the create form does, tracking it
+// before putting it in the store, and returns its name.
+func storeUpload(t *testing.T, db *sql.DB, store blobstore.BlobStore, data []byte) string {
+	t.Helper()
+
+	name := Name(data, ".jpg")
+	if err := Track(db, name, data); err != nil {
+		t.Fatal(err)
+	}
+	if err := Put(store, name, data); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+// unreferencedSince backdates when an upload lost its last post.
+func unreferencedSince(t *testing.T, db *sql.DB, name string, d time.Duration) {
+	t.Helper()
+
+	_, err := db.Exec("UPDATE uploads SET unreferenced_at = datetime('now', ?) WHERE url = ?",
+		fmt.Sprintf("-%d seconds", int64(d/time.Second)), URL(name))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func stored(t *testing.T, store blobstore.BlobStore, name string) bool {
+	t.Helper()
+
+	_, err := store.Stat(context.Background(), Key(name))
+	if errors.Is(err, blobstore.ErrNotFound) {
+		return false
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return true
+}
+
+func TestCollect(t *testing.T) {
+	db := openTestDB(t)
+	store := blobstore.NewFS(t.TempDir())
+
+	old := storeUpload(t, db, store, []byte("unused for two days"))
+	unreferencedSince(t, db, old, 48*time.Hour)
+	variant := strings.TrimSuffix(old, ".jpg") + "-320.webp"
+	if err := Put(store, variant, []byte("variant")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO image_variants (image_url, name, url, width, height) VALUES (?, '320', ?, 320, 240)", URL(old), URL(variant)); err != nil {
+		t.Fatal(err)
+	}
+
+	recent := storeUpload(t, db, store, []byte("uploaded a moment ago"))
+
+	report, err := Collect(db, store, testGrace, false)
+	if err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	if len(report.Collected) != 1 || report.Collected[0].URL != URL(old) {
+		t.Errorf("collected %+v, want only %s", report.Collected, old)
+	}
+	if stored(t, store, old) || stored(t, store, variant) {
+		t.Error("the old upload or its variant is still stored")
+	}
+	if !stored(t, store, recent) {
+		t.Error("the upload within its grace period was deleted")
+	}
+}
+
+// An upload stored again after the collection looked for unreferenced
+// uploads gets a new grace period and is kept.
+func TestCollectSkipsUploadStoredAgain(t *testing.T) {
+	db := openTestDB(t)
+	store := blobstore.NewFS(t.TempDir())
+
+	data := []byte("stored again before collection")
+	name := storeUpload(t, db, store, data)
+	unreferencedSince(t, db, name, 48*time.Hour)
+
+	unreferenced, err := models.GetUnreferencedUploads(db, testGrace)
+	if err != nil || len(unreferenced) != 1 {
+		t.Fatalf("GetUnreferencedUploads = %v, %v, want the upload", unreferenced, err)
+	}
+
+	storeUpload(t, db, store, data)
+
+	deleted, err := collectUpload(db, store, unreferenced[0], testGrace)
+	if err != nil {
+		t.Fatalf("collectUpload: %v", err)
+	}
+	if deleted || !stored(t, store, name) {
+		t.Error("an upload stored again was collected")
+	}
+}
+
+// deleteHookStore runs onDelete before deleting a blob.
+type deleteHookStore struct {
+	blobstore.BlobStore
+	onDelete func()
+}
+
+func (s deleteHookStore) Delete(ctx context.Context, key string) error {
+	s.onDelete()
+	return s.BlobStore.Delete(ctx, key)
+}
+
+// An upload stored again while the collection is deleting the files waits
+// for it, so the new files are put in the store after the old ones are gone.
+func TestCollectWhileStoredAgain(t *testing.T) {
+	db := openTestDB(t)
+	fs := blobstore.NewFS(t.TempDir())
+
+	data := []byte("stored again during collection")
+	name := storeUpload(t, db, fs, data)
+	unreferencedSince(t, db, name, 48*time.Hour)
+
+	unreferenced, err := models.GetUnreferencedUploads(db, testGrace)
+	if err != nil || len(unreferenced) != 1 {
+		t.Fatalf("GetUnreferencedUploads = %v, %v, want the upload", unreferenced, err)
+	}
+
+	var once sync.Once
+	done := make(chan struct{})
+	store := deleteHookStore{BlobStore: fs, onDelete: func() {
+		once.Do(func() {
+			go func() {
+				defer close(done)
+				if err := Track(db, name, data); err != nil {
+					t.Error(err)
+					return
+				}
+				if err := Put(fs, name, data); err != nil {
+					t.Error(err)
+				}
+			}()
+
+			// Tracking waits for the collection to commit
+			select {
+			case <-done:
+				t.Error("the upload was stored again during the collection")
+			case <-time.After(200 * time.Millisecond):
+			}
+		})
+	}}
+
+	deleted, err := collectUpload(db, store, unreferenced[0], testGrace)
+	if err != nil {
+		t.Fatalf("collectUpload: %v", err)
+	}
+	if !deleted {
+		t.Fatal("the upload was not collected")
+	}
+
+	<-done
+	if !stored(t, fs, name) {
+		t.Error("the upload stored again was deleted by the collection")
+	}
+	if tracked, err := models.IsUploadTracked(db, strings.TrimSuffix(name, ".jpg")); err != nil || !tracked {
+		t.Errorf("IsUploadTracked = %v, %v, want true", tracked, err)
+	}
+}
@@ -0,0 +1,53 @@
+package uploads
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var sizeUnits = []struct {
+	suffix string
+	bytes  int64
+}{
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"B", 1},
+}
+
+// ParseSize reads a size such as "100MB", "1.5GB" or "4096".
+func ParseSize(s string) (int64, error) {
+	value := strings.ToUpper(strings.TrimSpace(s))
+	multiplier := int64(1)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(value, unit.suffix) {
+			value = strings.TrimSpace(strings.TrimSuffix(value, unit.suffix))
+			multiplier = unit.bytes
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || n < 0 || math.IsNaN(n) || math.IsInf(n, 0) {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+
+	// float64(math.MaxInt64) rounds up to 2^63, which doesn't fit
+	bytes := n * float64(multiplier)
+	if bytes >= float64(math.MaxInt64) {
+		return 0, fmt.Errorf("size %q is too large", s)
+	}
+	return int64(bytes), nil
+}
+
+// FormatSize writes a size the way people read it, e.g. "2.4 MB".
+func FormatSize(n int64) string {
+	for _, unit := range sizeUnits {
+		if n >= unit.bytes && unit.bytes > 1 {
+			return strconv.FormatFloat(float64(n)/float64(unit.bytes), 'f', 1, 64) + " " + unit.suffix
+		}
+	}
+	return strconv.FormatInt(n, 10) + " B"
+}
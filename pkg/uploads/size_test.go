@@ -0,0 +1,73 @@
+package uploads
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+	}{
+		{"4096", 4096},
+		{"0", 0},
+		{"512B", 512},
+		{"10KB", 10 << 10},
+		{"100MB", 100 << 20},
+		{"1.5GB", 3 << 29},
+		{"100mb", 100 << 20},
+		{"2Kb", 2 << 10},
+		{"  100MB  ", 100 << 20},
+		{"100 MB", 100 << 20},
+		{"8000000000GB", 8000000000 << 30},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseSize(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseSize(%q) = %d, %v, want %d", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseSizeRejects(t *testing.T) {
+	for _, in := range []string{
+		"",
+		"MB",
+		"abc",
+		"-1",
+		"-1MB",
+		"NaN",
+		"nanMB",
+		"Inf",
+		"+Inf",
+		"-Inf",
+		"infGB",
+		"1e300",
+		"1e300GB",
+		"9223372036854775807",
+		"9000000000GB",
+		"10TB",
+	} {
+		if got, err := ParseSize(in); err == nil {
+			t.Errorf("ParseSize(%q) = %d, want an error", in, got)
+		}
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	tests := []struct {
+		in   int64
+		want string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1 << 10, "1.0 KB"},
+		{5 << 19, "2.5 MB"},
+		{1 << 30, "1.0 GB"},
+	}
+
+	for _, tt := range tests {
+		if got := FormatSize(tt.in); got != tt.want {
+			t.Errorf("FormatSize(%d) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
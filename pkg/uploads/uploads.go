@@ -0,0 +1,147 @@
+// Package uploads stores post attachments: images with their resized
+// variants, and documents.
+package uploads
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"forum/pkg/blobstore"
+	"forum/pkg/images"
+	"forum/pkg/models"
+)
+
+const (
+	// URLPrefix is where uploads are served from.
+	URLPrefix = "/uploads/"
+	// PostDir holds post images, both in blob store keys and in URLs.
+	PostDir = "post"
+	// FileDir holds documents.
+	FileDir = "file"
+
+	// legacyPrefix is where images were stored before uploads moved out of
+	// ui/static.
+	legacyPrefix = "static/img/uploads/post/"
+)
+
+// fileName matches the names given to uploads and their variants.
+var fileName = regexp.MustCompile(`^[0-9a-f]{64}((-[a-z]+)?\.(jpg|png|gif|webp)|\.(pdf|txt))$`)
+
+// ValidName reports whether name could be an upload or a variant.
+func ValidName(name string) bool {
+	return fileName.MatchString(name)
+}
+
+// Name returns the file name for an image with the given content.
+func Name(data []byte, extension string) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]) + extension
+}
+
+// Dir returns the directory an upload is kept in, which depends on whether it
+// is an image or a document.
+func Dir(name string) string {
+	if IsDocument(name) {
+		return FileDir
+	}
+	return PostDir
+}
+
+// URL returns the path, relative to the site root, that an upload is served
+// from. This is what posts.image_url and post_attachments.url hold.
+func URL(name string) string {
+	return strings.TrimPrefix(URLPrefix, "/") + Dir(name) + "/" + name
+}
+
+// ContentType returns the MIME type an upload is served with.
+func ContentType(name string) string {
+	extension := path.Ext(name)
+	if contentType, ok := documentTypes[extension]; ok {
+		return contentType
+	}
+	return images.ContentType(extension)
+}
+
+// Key returns the blob store key of an upload.
+func Key(name string) string {
+	return Dir(name) + "/" + name
+}
+
+// storeTimeout bounds a single blob store operation.
+const storeTimeout = time.Minute
+
+// Put stores an upload or variant under its name.
+func Put(store blobstore.BlobStore, name string, data []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	return store.Put(ctx, Key(name), data, ContentType(name))
+}
+
+// Read returns the content of the image behind an image URL, either from the
+// store or, for older posts, from ui/static. ok is false if the URL is not an
+// uploaded image at all.
+func Read(store blobstore.BlobStore, imageURL string) (data []byte, ok bool, err error) {
+	name := path.Base(imageURL)
+
+	switch {
+	case imageURL == URL(name) && ValidName(name):
+		ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+		defer cancel()
+
+		r, _, err := store.Get(ctx, Key(name))
+		if err != nil {
+			return nil, true, err
+		}
+		defer r.Close()
+
+		data, err := io.ReadAll(r)
+		return data, true, err
+
+	case imageURL == legacyPrefix+name:
+		data, err := os.ReadFile(filepath.Join("ui", "static", "img", "uploads", "post", name))
+		return data, true, err
+	}
+	return nil, false, nil
+}
+
+// SaveVariants makes the resized variants of an image, puts them in the store
+// and records them against imageURL. data is the original image.
+func SaveVariants(db *sql.DB, store blobstore.BlobStore, imageURL string, data []byte) ([]models.ImageVariant, error) {
+	variants, err := images.MakeVariants(data, images.DefaultLimits)
+	if err != nil {
+		return nil, err
+	}
+
+	base := Name(data, "")
+	var saved []models.ImageVariant
+	for _, v := range variants {
+		name := base + "-" + v.Name + images.VariantExtension
+		if err := Put(store, name, v.Data); err != nil {
+			return nil, err
+		}
+
+		saved = append(saved, models.ImageVariant{
+			ImageURL: imageURL,
+			Name:     v.Name,
+			URL:      URL(name),
+			Width:    v.Width,
+			Height:   v.Height,
+		})
+	}
+
+	if err := models.SaveImageVariants(db, imageURL, saved); err != nil {
+		return nil, err
+	}
+
+	return saved, nil
+}
@@ -0,0 +1,128 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// COSE algorithm identifiers (RFC 9053) that we accept for credentials.
+const (
+	AlgES256 = -7
+	AlgEdDSA = -8
+	AlgRS256 = -257
+)
+
+// SupportedAlgorithms is sent to the browser in order of preference.
+var SupportedAlgorithms = []int{AlgES256, AlgEdDSA, AlgRS256}
+
+// COSE key parameters
+const (
+	coseKeyType   = 1
+	coseAlgorithm = 3
+	coseCurve     = -1
+	coseX         = -2
+	coseY         = -3
+	coseRSAN      = -1
+	coseRSAE      = -2
+
+	coseKeyTypeOKP = 1
+	coseKeyTypeEC2 = 2
+	coseKeyTypeRSA = 3
+
+	coseCurveP256    = 1
+	coseCurveEd25519 = 6
+)
+
+var ErrInvalidSignature = errors.New("webauthn: signature is invalid")
+
+// publicKey is a credential public key decoded from its COSE encoding.
+type publicKey struct {
+	algorithm int64
+	key       crypto.PublicKey
+}
+
+func parseCOSEKey(data []byte) (publicKey, error) {
+	v, _, err := decodeCBOR(data)
+	if err != nil {
+		return publicKey{}, err
+	}
+
+	m, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return publicKey{}, errors.New("webauthn: public key is not a map")
+	}
+
+	kty, _ := m[int64(coseKeyType)].(int64)
+	alg, _ := m[int64(coseAlgorithm)].(int64)
+
+	switch {
+	case kty == coseKeyTypeEC2 && alg == AlgES256:
+		crv, _ := m[int64(coseCurve)].(int64)
+		x, _ := m[int64(coseX)].([]byte)
+		y, _ := m[int64(coseY)].([]byte)
+		if crv != coseCurveP256 || len(x) != 32 || len(y) != 32 {
+			return publicKey{}, errors.New("webauthn: invalid P-256 key")
+		}
+
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return publicKey{}, errors.New("webauthn: P-256 point is not on the curve")
+		}
+		return publicKey{algorithm: alg, key: key}, nil
+
+	case kty == coseKeyTypeOKP && alg == AlgEdDSA:
+		crv, _ := m[int64(coseCurve)].(int64)
+		x, _ := m[int64(coseX)].([]byte)
+		if crv != coseCurveEd25519 || len(x) != ed25519.PublicKeySize {
+			return publicKey{}, errors.New("webauthn: invalid Ed25519 key")
+		}
+		return publicKey{algorithm: alg, key: ed25519.PublicKey(x)}, nil
+
+	case kty == coseKeyTypeRSA && alg == AlgRS256:
+		n, _ := m[int64(coseRSAN)].([]byte)
+		e, _ := m[int64(coseRSAE)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return publicKey{}, errors.New("webauthn: invalid RSA key")
+		}
+
+		exponent := 0
+		for _, b := range e {
+			exponent = exponent<<8 | int(b)
+		}
+		return publicKey{algorithm: alg, key: &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exponent}}, nil
+
+	default:
+		return publicKey{}, fmt.Errorf("webauthn: unsupported key type %d with algorithm %d", kty, alg)
+	}
+}
+
+// verify checks signature over message with the credential's algorithm.
+func (k publicKey) verify(message, signature []byte) error {
+	switch key := k.key.(type) {
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(message)
+		if !ecdsa.VerifyASN1(key, digest[:], signature) {
+			return ErrInvalidSignature
+		}
+	case ed25519.PublicKey:
+		if !ed25519.Verify(key, message, signature) {
+			return ErrInvalidSignature
+		}
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(message)
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+			return ErrInvalidSignature
+		}
+	default:
+		return errors.New("webauthn: unsupported public key")
+	}
+
+	return nil
+}
@@ -0,0 +1,400 @@
+// Package webauthn implements the server side of WebAuthn registration and
+// assertion ceremonies for passkey sign-in.
+//
+// Attestation statements are not verified: we ask browsers for "none"
+// attestation and only care that the credential works, not which
+// authenticator model created it.
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Authenticator data flags
+const (
+	flagUserPresent      = 0x01
+	flagUserVerified     = 0x04
+	flagAttestedCredData = 0x40
+)
+
+type Config struct {
+	RPID    string
+	RPName  string
+	Origin  string
+	Timeout time.Duration
+}
+
+// Credential is what gets stored for a user after a successful registration.
+type Credential struct {
+	ID         []byte
+	PublicKey  []byte
+	SignCount  uint32
+	Transports []string
+}
+
+type relyingParty struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type userEntity struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type credentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+type CredentialDescriptor struct {
+	Type       string   `json:"type"`
+	ID         string   `json:"id"`
+	Transports []string `json:"transports,omitempty"`
+}
+
+type authenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	RequireResident  bool   `json:"requireResidentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// CreationOptions is sent to navigator.credentials.create(). Binary values
+// are base64url encoded and decoded by the browser script.
+type CreationOptions struct {
+	Challenge              string                 `json:"challenge"`
+	RP                     relyingParty           `json:"rp"`
+	User                   userEntity             `json:"user"`
+	PubKeyCredParams       []credentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection authenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+// RequestOptions is sent to navigator.credentials.get().
+type RequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	RPID             string                 `json:"rpId"`
+	Timeout          int64                  `json:"timeout"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+type RegistrationResponse struct {
+	ID       string `json:"id"`
+	RawID    string `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string   `json:"clientDataJSON"`
+		AttestationObject string   `json:"attestationObject"`
+		Transports        []string `json:"transports"`
+	} `json:"response"`
+}
+
+type AssertionResponse struct {
+	ID       string `json:"id"`
+	RawID    string `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AuthenticatorData string `json:"authenticatorData"`
+		Signature         string `json:"signature"`
+		UserHandle        string `json:"userHandle"`
+	} `json:"response"`
+}
+
+type clientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+type authenticatorData struct {
+	rpIDHash     []byte
+	flags        byte
+	signCount    uint32
+	credentialID []byte
+	publicKey    []byte
+}
+
+// NewChallenge returns 32 random bytes for a single ceremony.
+func NewChallenge() ([]byte, error) {
+	challenge := make([]byte, 32)
+	if _, err := rand.Read(challenge); err != nil {
+		return nil, fmt.Errorf("webauthn: failed to generate challenge: %v", err)
+	}
+	return challenge, nil
+}
+
+// EncodeID base64url encodes binary values for JSON and for storage.
+func EncodeID(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// DecodeID accepts base64url with or without padding.
+func DecodeID(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+func (c Config) timeoutMillis() int64 {
+	if c.Timeout == 0 {
+		return 5 * 60 * 1000
+	}
+	return c.Timeout.Milliseconds()
+}
+
+// CreationOptions builds the options for registering a new passkey. Existing
+// credentials are excluded so the same authenticator isn't registered twice.
+func (c Config) CreationOptions(challenge []byte, userID, name, displayName string, exclude []CredentialDescriptor) CreationOptions {
+	params := make([]credentialParameter, len(SupportedAlgorithms))
+	for i, alg := range SupportedAlgorithms {
+		params[i] = credentialParameter{Type: "public-key", Alg: alg}
+	}
+
+	if exclude == nil {
+		exclude = []CredentialDescriptor{}
+	}
+
+	return CreationOptions{
+		Challenge:          EncodeID(challenge),
+		RP:                 relyingParty{ID: c.RPID, Name: c.RPName},
+		User:               userEntity{ID: EncodeID([]byte(userID)), Name: name, DisplayName: displayName},
+		PubKeyCredParams:   params,
+		Timeout:            c.timeoutMillis(),
+		ExcludeCredentials: exclude,
+		AuthenticatorSelection: authenticatorSelection{
+			ResidentKey:      "required",
+			RequireResident:  true,
+			UserVerification: "required",
+		},
+		Attestation: "none",
+	}
+}
+
+// RequestOptions builds the options for signing in. The allow list is empty so
+// the browser offers every passkey it has for this site.
+func (c Config) RequestOptions(challenge []byte) RequestOptions {
+	return RequestOptions{
+		Challenge:        EncodeID(challenge),
+		RPID:             c.RPID,
+		Timeout:          c.timeoutMillis(),
+		AllowCredentials: []CredentialDescriptor{},
+		UserVerification: "required",
+	}
+}
+
+func (c Config) verifyClientData(raw []byte, ceremony string, challenge []byte) error {
+	var cd clientData
+	if err := json.Unmarshal(raw, &cd); err != nil {
+		return fmt.Errorf("webauthn: invalid client data: %v", err)
+	}
+
+	if cd.Type != ceremony {
+		return fmt.Errorf("webauthn: unexpected ceremony type %q", cd.Type)
+	}
+
+	got, err := DecodeID(cd.Challenge)
+	if err != nil || subtle.ConstantTimeCompare(got, challenge) != 1 {
+		return errors.New("webauthn: challenge does not match")
+	}
+
+	if cd.Origin != c.Origin {
+		return fmt.Errorf("webauthn: unexpected origin %q", cd.Origin)
+	}
+
+	if cd.CrossOrigin {
+		return errors.New("webauthn: cross-origin requests are not allowed")
+	}
+
+	return nil
+}
+
+func (c Config) verifyAuthenticatorData(ad authenticatorData) error {
+	rpIDHash := sha256.Sum256([]byte(c.RPID))
+	if !bytes.Equal(ad.rpIDHash, rpIDHash[:]) {
+		return errors.New("webauthn: relying party ID does not match")
+	}
+
+	if ad.flags&flagUserPresent == 0 {
+		return errors.New("webauthn: user was not present")
+	}
+
+	if ad.flags&flagUserVerified == 0 {
+		return errors.New("webauthn: user was not verified")
+	}
+
+	return nil
+}
+
+func parseAuthenticatorData(data []byte) (authenticatorData, error) {
+	if len(data) < 37 {
+		return authenticatorData{}, errors.New("webauthn: authenticator data is too short")
+	}
+
+	ad := authenticatorData{
+		rpIDHash:  data[:32],
+		flags:     data[32],
+		signCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+
+	if ad.flags&flagAttestedCredData == 0 {
+		return ad, nil
+	}
+
+	rest := data[37:]
+	if len(rest) < 18 {
+		return authenticatorData{}, errors.New("webauthn: attested credential data is too short")
+	}
+
+	// Skip the 16 byte AAGUID
+	idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if idLen == 0 || idLen > 1023 || len(rest) < idLen {
+		return authenticatorData{}, errors.New("webauthn: invalid credential ID length")
+	}
+	ad.credentialID = rest[:idLen]
+	rest = rest[idLen:]
+
+	_, n, err := decodeCBOR(rest)
+	if err != nil {
+		return authenticatorData{}, fmt.Errorf("webauthn: invalid credential public key: %v", err)
+	}
+	ad.publicKey = rest[:n]
+
+	return ad, nil
+}
+
+// VerifyRegistration checks the browser's response to CreationOptions and
+// returns the new credential.
+func (c Config) VerifyRegistration(challenge []byte, resp RegistrationResponse) (Credential, error) {
+	if resp.Type != "public-key" {
+		return Credential{}, errors.New("webauthn: unexpected credential type")
+	}
+
+	clientDataJSON, err := DecodeID(resp.Response.ClientDataJSON)
+	if err != nil {
+		return Credential{}, errors.New("webauthn: invalid client data encoding")
+	}
+
+	if err := c.verifyClientData(clientDataJSON, "webauthn.create", challenge); err != nil {
+		return Credential{}, err
+	}
+
+	rawAttestation, err := DecodeID(resp.Response.AttestationObject)
+	if err != nil {
+		return Credential{}, errors.New("webauthn: invalid attestation object encoding")
+	}
+
+	v, _, err := decodeCBOR(rawAttestation)
+	if err != nil {
+		return Credential{}, fmt.Errorf("webauthn: invalid attestation object: %v", err)
+	}
+
+	attestation, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return Credential{}, errors.New("webauthn: attestation object is not a map")
+	}
+
+	rawAuthData, ok := attestation["authData"].([]byte)
+	if !ok {
+		return Credential{}, errors.New("webauthn: attestation object has no authenticator data")
+	}
+
+	ad, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return Credential{}, err
+	}
+
+	if err := c.verifyAuthenticatorData(ad); err != nil {
+		return Credential{}, err
+	}
+
+	if ad.credentialID == nil {
+		return Credential{}, errors.New("webauthn: no credential in authenticator data")
+	}
+
+	rawID, err := DecodeID(resp.RawID)
+	if err != nil || !bytes.Equal(rawID, ad.credentialID) {
+		return Credential{}, errors.New("webauthn: credential ID does not match")
+	}
+
+	// Make sure we can use the key before storing it
+	if _, err := parseCOSEKey(ad.publicKey); err != nil {
+		return Credential{}, err
+	}
+
+	return Credential{
+		ID:         ad.credentialID,
+		PublicKey:  ad.publicKey,
+		SignCount:  ad.signCount,
+		Transports: resp.Response.Transports,
+	}, nil
+}
+
+// VerifyAssertion checks a sign-in response against the stored credential and
+// returns the authenticator's new signature counter.
+func (c Config) VerifyAssertion(challenge []byte, resp AssertionResponse, credential Credential) (uint32, error) {
+	if resp.Type != "public-key" {
+		return 0, errors.New("webauthn: unexpected credential type")
+	}
+
+	clientDataJSON, err := DecodeID(resp.Response.ClientDataJSON)
+	if err != nil {
+		return 0, errors.New("webauthn: invalid client data encoding")
+	}
+
+	if err := c.verifyClientData(clientDataJSON, "webauthn.get", challenge); err != nil {
+		return 0, err
+	}
+
+	rawAuthData, err := DecodeID(resp.Response.AuthenticatorData)
+	if err != nil {
+		return 0, errors.New("webauthn: invalid authenticator data encoding")
+	}
+
+	ad, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := c.verifyAuthenticatorData(ad); err != nil {
+		return 0, err
+	}
+
+	signature, err := DecodeID(resp.Response.Signature)
+	if err != nil {
+		return 0, errors.New("webauthn: invalid signature encoding")
+	}
+
+	key, err := parseCOSEKey(credential.PublicKey)
+	if err != nil {
+		return 0, err
+	}
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	message := append(append([]byte{}, rawAuthData...), clientDataHash[:]...)
+	if err := key.verify(message, signature); err != nil {
+		return 0, err
+	}
+
+	// A counter that doesn't increase means the authenticator may have been
+	// cloned. Authenticators that don't keep a counter always send zero.
+	if (ad.signCount != 0 || credential.SignCount != 0) && ad.signCount <= credential.SignCount {
+		return 0, errors.New("webauthn: signature counter did not increase")
+	}
+
+	return ad.signCount, nil
+}
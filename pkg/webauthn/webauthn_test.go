@@ -0,0 +1,301 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+var testConfig = Config{
+	RPID:   "localhost",
+	RPName: "Forum",
+	Origin: "https://localhost:10443",
+}
+
+// softAuthenticator is an ES256 authenticator in memory, standing in for a
+// security key or platform authenticator.
+type softAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	signCount    uint32
+	flags        byte
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		t.Fatal(err)
+	}
+
+	return &softAuthenticator{key: key, credentialID: id, flags: flagUserPresent | flagUserVerified}
+}
+
+// register answers navigator.credentials.create() from origin.
+func (a *softAuthenticator) register(t *testing.T, origin string, challenge []byte) RegistrationResponse {
+	t.Helper()
+
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	a.key.X.FillBytes(x)
+	a.key.Y.FillBytes(y)
+	coseKey := cborMap(
+		cborInt(coseKeyType), cborInt(coseKeyTypeEC2),
+		cborInt(coseAlgorithm), cborInt(AlgES256),
+		cborInt(coseCurve), cborInt(coseCurveP256),
+		cborInt(coseX), cborBytes(x),
+		cborInt(coseY), cborBytes(y),
+	)
+
+	attested := make([]byte, 18)
+	binary.BigEndian.PutUint16(attested[16:], uint16(len(a.credentialID)))
+	attested = append(append(attested, a.credentialID...), coseKey...)
+	authData := append(a.authData(testConfig.RPID, flagAttestedCredData), attested...)
+
+	attestation := cborMap(
+		cborText("fmt"), cborText("none"),
+		cborText("attStmt"), cborMap(),
+		cborText("authData"), cborBytes(authData),
+	)
+
+	var resp RegistrationResponse
+	resp.ID = EncodeID(a.credentialID)
+	resp.RawID = EncodeID(a.credentialID)
+	resp.Type = "public-key"
+	resp.Response.ClientDataJSON = EncodeID(testClientData(t, "webauthn.create", origin, challenge))
+	resp.Response.AttestationObject = EncodeID(attestation)
+	resp.Response.Transports = []string{"internal"}
+	return resp
+}
+
+// assert answers navigator.credentials.get() from origin, counting the
+// signature.
+func (a *softAuthenticator) assert(t *testing.T, origin string, challenge []byte) AssertionResponse {
+	t.Helper()
+
+	a.signCount++
+	authData := a.authData(testConfig.RPID, 0)
+	clientDataJSON := testClientData(t, "webauthn.get", origin, challenge)
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var resp AssertionResponse
+	resp.ID = EncodeID(a.credentialID)
+	resp.RawID = EncodeID(a.credentialID)
+	resp.Type = "public-key"
+	resp.Response.ClientDataJSON = EncodeID(clientDataJSON)
+	resp.Response.AuthenticatorData = EncodeID(authData)
+	resp.Response.Signature = EncodeID(signature)
+	return resp
+}
+
+func (a *softAuthenticator) authData(rpID string, extraFlags byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	data := append(rpIDHash[:], a.flags|extraFlags, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(data[33:], a.signCount)
+	return data
+}
+
+func testClientData(t *testing.T, ceremony, origin string, challenge []byte) []byte {
+	t.Helper()
+
+	data, err := json.Marshal(clientData{Type: ceremony, Challenge: EncodeID(challenge), Origin: origin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func testChallenge(t *testing.T) []byte {
+	t.Helper()
+
+	challenge, err := NewChallenge()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return challenge
+}
+
+// registered returns an authenticator and the credential the server stored
+// for it.
+func registered(t *testing.T) (*softAuthenticator, Credential) {
+	t.Helper()
+
+	a := newSoftAuthenticator(t)
+	challenge := testChallenge(t)
+	credential, err := testConfig.VerifyRegistration(challenge, a.register(t, testConfig.Origin, challenge))
+	if err != nil {
+		t.Fatalf("VerifyRegistration: %v", err)
+	}
+	return a, credential
+}
+
+func TestVerifyRegistration(t *testing.T) {
+	a, credential := registered(t)
+
+	if !bytes.Equal(credential.ID, a.credentialID) {
+		t.Errorf("credential ID = %x, want %x", credential.ID, a.credentialID)
+	}
+	if credential.SignCount != 0 {
+		t.Errorf("sign count = %d, want 0", credential.SignCount)
+	}
+	if len(credential.Transports) != 1 || credential.Transports[0] != "internal" {
+		t.Errorf("transports = %v, want [internal]", credential.Transports)
+	}
+	if _, err := parseCOSEKey(credential.PublicKey); err != nil {
+		t.Errorf("stored public key: %v", err)
+	}
+}
+
+func TestVerifyRegistrationRejects(t *testing.T) {
+	a := newSoftAuthenticator(t)
+	challenge := testChallenge(t)
+
+	if _, err := testConfig.VerifyRegistration(challenge, a.register(t, "https://evil.example", challenge)); err == nil {
+		t.Error("registration from another origin was accepted")
+	}
+
+	if _, err := testConfig.VerifyRegistration(testChallenge(t), a.register(t, testConfig.Origin, challenge)); err == nil {
+		t.Error("registration for another challenge was accepted")
+	}
+
+	resp := a.register(t, testConfig.Origin, challenge)
+	resp.RawID = EncodeID([]byte("another credential"))
+	if _, err := testConfig.VerifyRegistration(challenge, resp); err == nil {
+		t.Error("registration with a mismatched credential ID was accepted")
+	}
+}
+
+func TestVerifyAssertion(t *testing.T) {
+	a, credential := registered(t)
+
+	for want := uint32(1); want <= 2; want++ {
+		challenge := testChallenge(t)
+		count, err := testConfig.VerifyAssertion(challenge, a.assert(t, testConfig.Origin, challenge), credential)
+		if err != nil {
+			t.Fatalf("VerifyAssertion: %v", err)
+		}
+		if count != want {
+			t.Fatalf("sign count = %d, want %d", count, want)
+		}
+		credential.SignCount = count
+	}
+}
+
+func TestVerifyAssertionRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		want   string
+		mangle func(t *testing.T, a *softAuthenticator, challenge []byte, credential *Credential) AssertionResponse
+	}{
+		{"wrong origin", "origin", func(t *testing.T, a *softAuthenticator, challenge []byte, _ *Credential) AssertionResponse {
+			return a.assert(t, "https://evil.example", challenge)
+		}},
+		{"wrong challenge", "challenge", func(t *testing.T, a *softAuthenticator, _ []byte, _ *Credential) AssertionResponse {
+			return a.assert(t, testConfig.Origin, testChallenge(t))
+		}},
+		{"wrong ceremony", "ceremony", func(t *testing.T, a *softAuthenticator, challenge []byte, _ *Credential) AssertionResponse {
+			resp := a.assert(t, testConfig.Origin, challenge)
+			resp.Response.ClientDataJSON = EncodeID(testClientData(t, "webauthn.create", testConfig.Origin, challenge))
+			return resp
+		}},
+		{"bad signature", "signature is invalid", func(t *testing.T, a *softAuthenticator, challenge []byte, _ *Credential) AssertionResponse {
+			other := newSoftAuthenticator(t)
+			other.credentialID = a.credentialID
+			return other.assert(t, testConfig.Origin, challenge)
+		}},
+		{"tampered authenticator data", "signature is invalid", func(t *testing.T, a *softAuthenticator, challenge []byte, _ *Credential) AssertionResponse {
+			resp := a.assert(t, testConfig.Origin, challenge)
+			authData, _ := DecodeID(resp.Response.AuthenticatorData)
+			authData[36]++
+			resp.Response.AuthenticatorData = EncodeID(authData)
+			return resp
+		}},
+		{"counter regression", "counter", func(t *testing.T, a *softAuthenticator, challenge []byte, credential *Credential) AssertionResponse {
+			credential.SignCount = 5
+			return a.assert(t, testConfig.Origin, challenge)
+		}},
+		{"user not verified", "not verified", func(t *testing.T, a *softAuthenticator, challenge []byte, _ *Credential) AssertionResponse {
+			a.flags = flagUserPresent
+			return a.assert(t, testConfig.Origin, challenge)
+		}},
+		{"other relying party", "relying party", func(t *testing.T, a *softAuthenticator, challenge []byte, _ *Credential) AssertionResponse {
+			resp := a.assert(t, testConfig.Origin, challenge)
+			authData, _ := DecodeID(resp.Response.AuthenticatorData)
+			rpIDHash := sha256.Sum256([]byte("evil.example"))
+			copy(authData, rpIDHash[:])
+			resp.Response.AuthenticatorData = EncodeID(authData)
+			return resp
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, credential := registered(t)
+			challenge := testChallenge(t)
+			resp := tt.mangle(t, a, challenge, &credential)
+			_, err := testConfig.VerifyAssertion(challenge, resp, credential)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("VerifyAssertion error = %v, want one about %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// A minimal CBOR encoder for the authenticator's output.
+
+func cborHead(major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return []byte{major<<5 | byte(n)}
+	case n <= 0xff:
+		return []byte{major<<5 | 24, byte(n)}
+	case n <= 0xffff:
+		return []byte{major<<5 | 25, byte(n >> 8), byte(n)}
+	default:
+		b := []byte{major<<5 | 26, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(b[1:], uint32(n))
+		return b
+	}
+}
+
+func cborInt(v int64) []byte {
+	if v < 0 {
+		return cborHead(1, uint64(-1-v))
+	}
+	return cborHead(0, uint64(v))
+}
+
+func cborBytes(b []byte) []byte {
+	return append(cborHead(2, uint64(len(b))), b...)
+}
+
+func cborText(s string) []byte {
+	return append(cborHead(3, uint64(len(s))), s...)
+}
+
+// cborMap encodes alternating keys and values.
+func cborMap(items ...[]byte) []byte {
+	m := cborHead(5, uint64(len(items)/2))
+	for _, item := range items {
+		m = append(m, item...)
+	}
+	return m
+}
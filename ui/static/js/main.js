@@ -0,0 +1,212 @@
+// Passkeys: the server sends and expects binary values as base64url strings,
+// the WebAuthn browser API works with ArrayBuffers.
+(function () {
+    const csrfMeta = document.querySelector("meta[name='csrf-token']");
+    const csrfToken = csrfMeta ? csrfMeta.content : "";
+
+    function toBuffer(value) {
+        const base64 = value.replace(/-/g, "+").replace(/_/g, "/");
+        const binary = atob(base64 + "=".repeat((4 - base64.length % 4) % 4));
+        return Uint8Array.from(binary, (c) => c.charCodeAt(0)).buffer;
+    }
+
+    function fromBuffer(buffer) {
+        const bytes = new Uint8Array(buffer);
+        let binary = "";
+        bytes.forEach((b) => { binary += String.fromCharCode(b); });
+        return btoa(binary).replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, "");
+    }
+
+    async function postJSON(url, body) {
+        const response = await fetch(url, {
+            method: "POST",
+            credentials: "same-origin",
+            headers: { "Content-Type": "application/json", "X-CSRF-Token": csrfToken },
+            body: JSON.stringify(body || {}),
+        });
+        const data = await response.json().catch(() => ({}));
+        if (!response.ok) {
+            throw new Error(data.error || "Something went wrong, please try again");
+        }
+        return data;
+    }
+
+    function showError(message) {
+        const el = document.getElementById("passkey-error");
+        if (el) {
+            el.textContent = message;
+            el.hidden = false;
+        }
+    }
+
+    function unsupported() {
+        if (window.PublicKeyCredential) {
+            return false;
+        }
+        showError("This browser does not support passkeys.");
+        return true;
+    }
+
+    const registerButton = document.getElementById("passkey-register");
+    if (registerButton) {
+        registerButton.addEventListener("click", async () => {
+            if (unsupported()) {
+                return;
+            }
+            try {
+                const { publicKey } = await postJSON("/user/settings/passkeys/options");
+                publicKey.challenge = toBuffer(publicKey.challenge);
+                publicKey.user.id = toBuffer(publicKey.user.id);
+                publicKey.excludeCredentials = (publicKey.excludeCredentials || []).map((c) => ({ ...c, id: toBuffer(c.id) }));
+
+                const credential = await navigator.credentials.create({ publicKey });
+                const result = await postJSON("/user/settings/passkeys/register", {
+                    name: document.getElementById("passkey-name").value,
+                    credential: {
+                        id: credential.id,
+                        rawId: fromBuffer(credential.rawId),
+                        type: credential.type,
+                        response: {
+                            clientDataJSON: fromBuffer(credential.response.clientDataJSON),
+                            attestationObject: fromBuffer(credential.response.attestationObject),
+                            transports: credential.response.getTransports ? credential.response.getTransports() : [],
+                        },
+                    },
+                });
+                window.location = result.redirect;
+            } catch (err) {
+                showError(err.message);
+            }
+        });
+    }
+
+    const loginButton = document.getElementById("passkey-login");
+    if (loginButton) {
+        loginButton.addEventListener("click", async () => {
+            if (unsupported()) {
+                return;
+            }
+            try {
+                const { publicKey } = await postJSON("/user/login/passkey/options");
+                publicKey.challenge = toBuffer(publicKey.challenge);
+                publicKey.allowCredentials = (publicKey.allowCredentials || []).map((c) => ({ ...c, id: toBuffer(c.id) }));
+
+                const credential = await navigator.credentials.get({ publicKey });
+                const result = await postJSON("/user/login/passkey", {
+                    id: credential.id,
+                    rawId: fromBuffer(credential.rawId),
+                    type: credential.type,
+                    response: {
+                        clientDataJSON: fromBuffer(credential.response.clientDataJSON),
+                        authenticatorData: fromBuffer(credential.response.authenticatorData),
+                        signature: fromBuffer(credential.response.signature),
+                        userHandle: credential.response.userHandle ? fromBuffer(credential.response.userHandle) : "",
+                    },
+                });
+                window.location = result.redirect;
+            } catch (err) {
+                showError(err.message);
+            }
+        });
+    }
+})();
+
+// Markdown preview: shows the rendered form of what is typed in the post and
+// comment forms. The server sanitizes the HTML, as it does for saved content.
+(function () {
+    const csrfMeta = document.querySelector("meta[name='csrf-token']");
+    const csrfToken = csrfMeta ? csrfMeta.content : "";
+
+    async function render(content) {
+        const response = await fetch("/post/preview", {
+            method: "POST",
+            credentials: "same-origin",
+            headers: { "Content-Type": "application/json", "X-CSRF-Token": csrfToken },
+            body: JSON.stringify({ content }),
+        });
+        if (!response.ok) {
+            throw new Error("Preview failed");
+        }
+        return (await response.json()).html;
+    }
+
+    document.querySelectorAll("textarea[data-markdown-preview]").forEach((textarea) => {
+        const preview = textarea.parentElement.querySelector(".markdown-preview");
+        if (!preview) {
+            return;
+        }
+
+        let timer;
+        let latest = 0;
+        const update = () => {
+            const content = textarea.value;
+            const request = ++latest;
+            if (content.trim() === "") {
+                preview.hidden = true;
+                preview.innerHTML = "";
+                return;
+            }
+            render(content).then((html) => {
+                // an older request must not overwrite a newer preview
+                if (request === latest) {
+                    preview.innerHTML = html;
+                    preview.hidden = false;
+                }
+            }).catch(() => {});
+        };
+
+        textarea.addEventListener("input", () => {
+            clearTimeout(timer);
+            timer = setTimeout(update, 300);
+        });
+        update();
+    });
+})();
+
+// Tag autocomplete: suggests existing tags for the one being typed in the
+// create form's comma separated tags field, through its datalist.
+(function () {
+    document.querySelectorAll("input[data-tag-suggest]").forEach((input) => {
+        const list = input.list;
+        if (!list) {
+            return;
+        }
+
+        let timer;
+        let latest = 0;
+        const update = async () => {
+            // Only the tag after the last comma is completed, the ones
+            // before it are kept in front of each suggestion
+            const value = input.value;
+            const cut = value.lastIndexOf(",") + 1;
+            const before = value.slice(0, cut);
+            const typed = value.slice(cut).trim();
+            const request = ++latest;
+            if (typed === "") {
+                list.replaceChildren();
+                return;
+            }
+
+            try {
+                const response = await fetch("/tags/suggest?q=" + encodeURIComponent(typed), { credentials: "same-origin" });
+                if (!response.ok || request !== latest) {
+                    return;
+                }
+                const suggestions = await response.json();
+                list.replaceChildren(...suggestions.map((tag) => {
+                    const option = document.createElement("option");
+                    option.value = (before ? before.trimEnd() + " " : "") + tag.name;
+                    option.label = tag.name + " (" + tag.posts + ")";
+                    return option;
+                }));
+            } catch (err) {
+                // suggestions are optional
+            }
+        };
+
+        input.addEventListener("input", () => {
+            clearTimeout(timer);
+            timer = setTimeout(update, 200);
+        });
+    });
+})();
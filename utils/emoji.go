@@ -0,0 +1,167 @@
+package utils
+
+import "unicode"
+
+// extendedPictographic is the Extended_Pictographic property of Unicode's
+// emoji-data.txt, the characters emoji are built from.
+var extendedPictographic = &unicode.RangeTable{
+	LatinOffset: 1,
+	R16: []unicode.Range16{
+		{0x00A9, 0x00AE, 5},
+		{0x203C, 0x2049, 13},
+		{0x2122, 0x2139, 23},
+		{0x2194, 0x2199, 1},
+		{0x21A9, 0x21AA, 1},
+		{0x231A, 0x231B, 1},
+		{0x2328, 0x2388, 96},
+		{0x23CF, 0x23CF, 1},
+		{0x23E9, 0x23F3, 1},
+		{0x23F8, 0x23FA, 1},
+		{0x24C2, 0x24C2, 1},
+		{0x25AA, 0x25AB, 1},
+		{0x25B6, 0x25C0, 10},
+		{0x25FB, 0x25FE, 1},
+		{0x2600, 0x2605, 1},
+		{0x2607, 0x2612, 1},
+		{0x2614, 0x2685, 1},
+		{0x2690, 0x2705, 1},
+		{0x2708, 0x2712, 1},
+		{0x2714, 0x2716, 2},
+		{0x271D, 0x2721, 4},
+		{0x2728, 0x2728, 1},
+		{0x2733, 0x2734, 1},
+		{0x2744, 0x2747, 3},
+		{0x274C, 0x274E, 2},
+		{0x2753, 0x2755, 1},
+		{0x2757, 0x2757, 1},
+		{0x2763, 0x2767, 1},
+		{0x2795, 0x2797, 1},
+		{0x27A1, 0x27B0, 15},
+		{0x27BF, 0x27BF, 1},
+		{0x2934, 0x2935, 1},
+		{0x2B05, 0x2B07, 1},
+		{0x2B1B, 0x2B1C, 1},
+		{0x2B50, 0x2B55, 5},
+		{0x3030, 0x303D, 13},
+		{0x3297, 0x3299, 2},
+	},
+	R32: []unicode.Range32{
+		{0x1F000, 0x1F0FF, 1},
+		{0x1F10D, 0x1F10F, 1},
+		{0x1F12F, 0x1F12F, 1},
+		{0x1F16C, 0x1F171, 1},
+		{0x1F17E, 0x1F17F, 1},
+		{0x1F18E, 0x1F18E, 1},
+		{0x1F191, 0x1F19A, 1},
+		{0x1F1AD, 0x1F1E5, 1},
+		{0x1F201, 0x1F20F, 1},
+		{0x1F21A, 0x1F21A, 1},
+		{0x1F22F, 0x1F22F, 1},
+		{0x1F232, 0x1F23A, 1},
+		{0x1F23C, 0x1F23F, 1},
+		{0x1F249, 0x1F3FA, 1},
+		{0x1F400, 0x1F53D, 1},
+		{0x1F546, 0x1F64F, 1},
+		{0x1F680, 0x1F6FF, 1},
+		{0x1F774, 0x1F77F, 1},
+		{0x1F7D5, 0x1F7FF, 1},
+		{0x1F80C, 0x1F80F, 1},
+		{0x1F848, 0x1F84F, 1},
+		{0x1F85A, 0x1F85F, 1},
+		{0x1F888, 0x1F88F, 1},
+		{0x1F8AE, 0x1F8FF, 1},
+		{0x1F90C, 0x1F93A, 1},
+		{0x1F93C, 0x1F945, 1},
+		{0x1F947, 0x1FAFF, 1},
+		{0x1FC00, 0x1FFFD, 1},
+	},
+}
+
+// emojiPresentation are the pictographs below U+1F000 that show as emoji by
+// default, from the Emoji_Presentation property. The others, such as "©" or
+// "↔", are text unless a variation selector or skin tone asks for the emoji.
+// Nearly all pictographs from U+1F000 on are emoji by default.
+var emojiPresentation = &unicode.RangeTable{
+	R16: []unicode.Range16{
+		{0x231A, 0x231B, 1},
+		{0x23E9, 0x23EC, 1},
+		{0x23F0, 0x23F3, 3},
+		{0x25FD, 0x25FE, 1},
+		{0x2614, 0x2615, 1},
+		{0x2648, 0x2653, 1},
+		{0x267F, 0x2693, 20},
+		{0x26A1, 0x26A1, 1},
+		{0x26AA, 0x26AB, 1},
+		{0x26BD, 0x26BE, 1},
+		{0x26C4, 0x26C5, 1},
+		{0x26CE, 0x26D4, 6},
+		{0x26EA, 0x26EA, 1},
+		{0x26F2, 0x26F3, 1},
+		{0x26F5, 0x26FA, 5},
+		{0x26FD, 0x26FD, 1},
+		{0x2705, 0x2705, 1},
+		{0x270A, 0x270B, 1},
+		{0x2728, 0x2728, 1},
+		{0x274C, 0x274E, 2},
+		{0x2753, 0x2755, 1},
+		{0x2757, 0x2757, 1},
+		{0x2795, 0x2797, 1},
+		{0x27B0, 0x27BF, 15},
+		{0x2B1B, 0x2B1C, 1},
+		{0x2B50, 0x2B55, 5},
+	},
+}
+
+const (
+	zeroWidthJoiner = '\u200D'
+	emojiVariation  = '\uFE0F'
+)
+
+func isRegionalIndicator(r rune) bool { return r >= 0x1F1E6 && r <= 0x1F1FF }
+func isSkinTone(r rune) bool          { return r >= 0x1F3FB && r <= 0x1F3FF }
+
+// isTag reports whether r is one of the tag characters that spell out a
+// subdivision flag such as England's after a black flag.
+func isTag(r rune) bool { return r >= 0xE0020 && r <= 0xE007F }
+
+// IsEmoji reports whether s is a single emoji: a flag made of two regional
+// indicators, or pictographs joined with zero width joiners, each followed by
+// any skin tone, variation selector or tag characters. Arrows such as "→",
+// symbols such as "©" without the emoji variation selector, letters and
+// combining marks are not emoji.
+func IsEmoji(s string) bool {
+	runes := []rune(s)
+	if len(runes) == 2 && isRegionalIndicator(runes[0]) && isRegionalIndicator(runes[1]) {
+		return true
+	}
+
+	i := 0
+	for {
+		if i >= len(runes) || !unicode.Is(extendedPictographic, runes[i]) {
+			return false
+		}
+		base := runes[i]
+		i++
+
+		emoji := base >= 0x1F000 || unicode.Is(emojiPresentation, base)
+		for ; i < len(runes); i++ {
+			r := runes[i]
+			if r == emojiVariation || isSkinTone(r) {
+				emoji = true
+			} else if !isTag(r) {
+				break
+			}
+		}
+		if !emoji {
+			return false
+		}
+
+		if i == len(runes) {
+			return true
+		}
+		if runes[i] != zeroWidthJoiner {
+			return false
+		}
+		i++
+	}
+}
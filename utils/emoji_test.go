@@ -0,0 +1,47 @@
+package utils
+
+import "testing"
+
+func TestIsEmoji(t *testing.T) {
+	emoji := []string{
+		"👍", "😂", "🎉", "🚀",
+		"❤\uFE0F",     // heart with the emoji variation selector
+		"©\uFE0F",     // copyright sign with the emoji variation selector
+		"⚡", "✅", "⭐", // emoji by default below U+1F000
+		"👍🏽",              // skin tone
+		"☝🏻",              // text by default, emoji with a skin tone
+		"🇫🇷",              // flag
+		"👨\u200D👩\u200D👧", // family joined with ZWJ
+		"🏳\uFE0F\u200D🌈",  // rainbow flag
+		"🧑🏿\u200D🚀",       // astronaut with a skin tone
+		"🏴\U000E0067\U000E0062\U000E0065\U000E006E\U000E0067\U000E007F", // England
+	}
+	for _, s := range emoji {
+		if !IsEmoji(s) {
+			t.Errorf("IsEmoji(%q) = false, want true", s)
+		}
+	}
+
+	notEmoji := []string{
+		"", "like", "a", "1", " ",
+		"→", "←", "©", "®", "¬", "™", "↔", "§", "€", "…",
+		"\u0301",  // combining acute accent
+		"👍\u0301", // emoji followed by a combining mark
+		"é", "日", "ا",
+		"\uFE0F",  // a variation selector alone
+		"\u200D",  // a joiner alone
+		"👍\u200D", // a trailing joiner
+		"\u200D👍", // a leading joiner
+		"👍👍",      // two emoji
+		"👍 ",      // a trailing space
+		"🇫",       // half a flag
+		"🇫🇷🇩",     // a flag and a half
+		"🏽",       // a skin tone alone
+		"👍like",
+	}
+	for _, s := range notEmoji {
+		if IsEmoji(s) {
+			t.Errorf("IsEmoji(%q) = true, want false", s)
+		}
+	}
+}
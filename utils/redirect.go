@@ -0,0 +1,32 @@
+package utils
+
+import (
+	"net/url"
+	"strings"
+	"unicode"
+)
+
+// LocalRedirect returns next if it is a path on this site, so forms can send
+// the user back where they came from, and fallback otherwise. Browsers drop
+// tabs and newlines and read backslashes as slashes, so paths with any of
+// those, escaped or not, could turn into //host and are refused.
+func LocalRedirect(next, fallback string) string {
+	if hasControlOrBackslash(next) {
+		return fallback
+	}
+
+	u, err := url.Parse(next)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Opaque != "" {
+		return fallback
+	}
+	if !strings.HasPrefix(u.Path, "/") || strings.HasPrefix(u.Path, "//") || hasControlOrBackslash(u.Path) {
+		return fallback
+	}
+	return next
+}
+
+func hasControlOrBackslash(s string) bool {
+	return strings.IndexFunc(s, func(r rune) bool {
+		return r == '\\' || unicode.IsControl(r)
+	}) >= 0
+}
@@ -0,0 +1,35 @@
+package utils
+
+import "testing"
+
+func TestLocalRedirect(t *testing.T) {
+	const fallback = "/fallback"
+
+	tests := []struct {
+		next string
+		want string
+	}{
+		{"/post?id=1", "/post?id=1"},
+		{"/user/profile/saved#top", "/user/profile/saved#top"},
+		{"/", "/"},
+		{"", fallback},
+		{"//x", fallback},
+		{"/\\x", fallback},
+		{"/\t/x", fallback},
+		{"/%09/x", fallback},
+		{"/%0a/x", fallback},
+		{"/%5c/x", fallback},
+		{"/%2F/x", fallback},
+		{"https://x", fallback},
+		{"javascript:alert(1)", fallback},
+		{"post?id=1", fallback},
+		{"../post?id=1", fallback},
+		{"x/y", fallback},
+	}
+
+	for _, tt := range tests {
+		if got := LocalRedirect(tt.next, fallback); got != tt.want {
+			t.Errorf("LocalRedirect(%q) = %q, want %q", tt.next, got, tt.want)
+		}
+	}
+}